@@ -8,13 +8,19 @@ import (
 	llmModel "agent_study/pkg/llm_core/model"
 	"agent_study/pkg/tools"
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
 )
 
 const defaultConfigPath = "conf/phase4/app.yaml"
@@ -22,7 +28,7 @@ const defaultConfigPath = "conf/phase4/app.yaml"
 const maxStepOutputChars = 300
 
 type agentRunner interface {
-	Run(ctx context.Context, task string) (*agent.State, error)
+	Run(ctx context.Context, sessionID string, task string) (*agent.State, error)
 }
 
 type stepCallbackSetter interface {
@@ -39,6 +45,33 @@ type costProvider interface {
 	TotalCostUSD() float64
 }
 
+// runResumer/runLister 对应检查点能力；只有配置了 SQLite 的 Agent 才会真正可用。
+type runResumer interface {
+	Resume(ctx context.Context, runID string, options agent.ResumeOptions) (*agent.State, error)
+}
+
+type runLister interface {
+	ListRuns(ctx context.Context, limit int) ([]agent.RunSummary, error)
+}
+
+// runForker 从已持久化运行的某一步分叉出新的运行，供 `/fork` 调试使用。
+type runForker interface {
+	Fork(ctx context.Context, runID string, options agent.ForkOptions) (*agent.State, error)
+}
+
+// trajectoryLoader 从检查点读取运行轨迹，供 `/export` 导出训练与排查数据。
+type trajectoryLoader interface {
+	LoadTrajectory(ctx context.Context, runID string) (*agent.Trajectory, error)
+}
+
+// sessionManager 对应会话能力；*agent.Agent 通过其 MemoryManager 提供。
+type sessionManager interface {
+	CreateSession(ctx context.Context, username string, title string) (*agent.Session, error)
+	ListSessions(ctx context.Context, username string) ([]agent.Session, error)
+}
+
+const defaultRunListLimit = 10
+
 func main() {
 	ctx := context.Background()
 	cfg, err := loadConfig(defaultConfigPath)
@@ -82,27 +115,98 @@ func newRunner(cfg *config.Config) (*agent.Agent, error) {
 		return nil, fmt.Errorf("config is nil")
 	}
 
-	var memoryOptions *agent.MemoryOptions
+	var dbConn *gorm.DB
+	var checkpoints *agent.CheckpointStore
 	if cfg.Sqlite.Name != "" {
 		databaseCfg := cfg.Sqlite
-		dbConn, err := db.InitSqlite(&databaseCfg)
+		var err error
+		dbConn, err = db.InitSqlite(&databaseCfg)
 		if err != nil {
 			return nil, fmt.Errorf("init sqlite: %w", err)
 		}
-		memoryOptions = &agent.MemoryOptions{DB: dbConn}
+		checkpoints, err = agent.NewCheckpointStore(dbConn)
+		if err != nil {
+			return nil, fmt.Errorf("init checkpoints: %w", err)
+		}
+	}
+
+	var runner *agent.Agent
+	var err error
+	if cfg.Agent.Spec.File != "" {
+		runner, err = newSpecRunner(cfg, dbConn, checkpoints)
+	} else {
+		runner, err = newConfigRunner(cfg, dbConn, checkpoints)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 有持久化长期记忆时才需要让 agent 能响应“忘记 X”。
+	if dbConn != nil {
+		if runner.Tools == nil {
+			runner.Tools = tools.NewRegistry()
+		}
+		if err := runner.Tools.Register(agent.NewForgetMemoryTool(runner.Memory)); err != nil {
+			return nil, err
+		}
+	}
+	return runner, nil
+}
+
+// newSpecRunner 从 agent.spec 指向的声明式定义中构造指定名称的 agent，未引用具名
+// provider 时使用 llmProvider。
+func newSpecRunner(cfg *config.Config, dbConn *gorm.DB, checkpoints *agent.CheckpointStore) (*agent.Agent, error) {
+	specs, err := config.LoadAgentSpecs(cfg.Agent.Spec.File)
+	if err != nil {
+		return nil, err
 	}
+	factory := &agent.SpecFactory{Specs: specs, Provider: &cfg.LLM, DB: dbConn, Checkpoints: checkpoints}
+	return factory.Build(cfg.Agent.Spec.Name)
+}
 
+func newConfigRunner(cfg *config.Config, dbConn *gorm.DB, checkpoints *agent.CheckpointStore) (*agent.Agent, error) {
 	buildinTools, _ := tools.NewBuiltinTools(tools.BuiltinOptions{})
 	toolsReg := tools.NewRegistry()
 	_ = toolsReg.Register(buildinTools...)
 
+	limits := cfg.Agent.Limits
+	maxBudgetUSD := limits.MaxBudgetUSD
+	if maxBudgetUSD <= 0 {
+		maxBudgetUSD = 2
+	}
+	var downshift *agent.DownshiftOptions
+	if pricing := limits.Downshift.Cost.Pricing(); limits.Downshift.Model != "" && pricing != nil {
+		downshift = &agent.DownshiftOptions{Model: limits.Downshift.Model, Pricing: *pricing}
+	}
+
+	guardrails, err := agent.GuardrailsFromConfig(cfg.Agent.Guardrails)
+	if err != nil {
+		return nil, err
+	}
+	systemPrompt, err := agent.SystemPromptFromConfig(context.Background(), cfg.Agent.SystemPrompt, dbConn)
+	if err != nil {
+		return nil, err
+	}
+
 	return agent.NewAgent(agent.NewAgentOptions{
 		Provider:      &cfg.LLM,
-		MemoryOptions: memoryOptions,
+		MemoryOptions: agent.MemoryOptionsFromConfig(cfg.Memory, dbConn),
+		Checkpoints:   checkpoints,
+		Reflection:    agent.ReflectionFromConfig(cfg.Agent.Reflection),
+		Downshift:     downshift,
+		LoopDetection: agent.LoopDetectionFromConfig(cfg.Agent.LoopDetection),
+		Routing:       agent.RoutingFromConfig(cfg.Agent.Routing),
+		ToolSelection: agent.ToolSelectionFromConfig(cfg.Agent.ToolSelection),
+		Guardrails:    guardrails,
+		SystemPrompt:  systemPrompt,
 		Tools:         toolsReg,
 		Config: agent.Config{
-			MaxSteps:     8,
-			MaxBudgetUSD: 2,
+			MaxSteps:        8,
+			MaxBudgetUSD:    maxBudgetUSD,
+			Mode:            agent.Mode(cfg.Agent.Mode),
+			MaxReplans:      cfg.Agent.MaxReplans,
+			MaxDuration:     time.Duration(limits.MaxDurationSeconds) * time.Second,
+			MaxTokens:       limits.MaxTokens,
+			MaxOutputTokens: limits.MaxOutputTokens,
 		},
 	})
 }
@@ -119,12 +223,34 @@ func runREPL(ctx context.Context, in io.Reader, out io.Writer, runner agentRunne
 	streamingEnabled := false
 	_, streamingEnabled = runner.(stepCallbackSetter)
 	reader := bufio.NewReader(in)
-	_, _ = fmt.Fprintln(out, "Agent ready. Type your question, or `exit` to quit.")
+	readLine := func() (string, error) { return reader.ReadString('\n') }
+	// 交互终端下改由后台 goroutine 读取输入，运行期间输入的行用于中断和纠偏；
+	// 管道输入保持逐行顺序执行。
+	var lines <-chan replLine
+	if isTerminal(in) {
+		lines = readLines(reader)
+		readLine = func() (string, error) {
+			line, ok := <-lines
+			if !ok {
+				return "", io.EOF
+			}
+			return line.text, line.err
+		}
+	}
+	// sessionID 为空时使用 Agent 的进程内默认会话，可通过 `/session` 切换到持久化会话。
+	sessionID := ""
+	// promptVars 是 `/var` 设置的模板变量，随 ctx 交给之后的每次运行。
+	promptVars := make(map[string]string)
+	ctx = agent.WithPromptVars(ctx, promptVars)
+	_, _ = fmt.Fprintln(out, "Agent ready. Type your question, `/sessions`, `/session new|<id>`, `/runs`, `/resume <run-id> [max-steps]`, `/fork <run-id> <step> [options]`, `/export <format> <file> [run-id]`, `/memory`, `/var [name [value]]`, or `exit` to quit.")
+	if lines != nil {
+		_, _ = fmt.Fprintln(out, "While a run is in progress, press Enter to pause it and type a correction.")
+	}
 	_, _ = fmt.Fprintf(out, "Model: %s\n", runnerModelName(runner))
 	_, _ = fmt.Fprintln(out, "----------------------------------------------------------------------------------------")
 	for {
 		_, _ = fmt.Fprint(out, "> ")
-		line, err := reader.ReadString('\n')
+		line, err := readLine()
 		if err != nil {
 			if err == io.EOF {
 				input := strings.TrimSpace(line)
@@ -134,7 +260,9 @@ func runREPL(ctx context.Context, in io.Reader, out io.Writer, runner agentRunne
 				if shouldExit(input) {
 					return nil
 				}
-				state, runErr := runner.Run(ctx, input)
+				state, runErr := runControlled(ctx, out, nil, func(ctx context.Context) (*agent.State, error) {
+					return runner.Run(ctx, sessionID, input)
+				})
 				printRunResult(out, state, runErr, streamingEnabled)
 				return nil
 			}
@@ -148,13 +276,394 @@ func runREPL(ctx context.Context, in io.Reader, out io.Writer, runner agentRunne
 		if shouldExit(input) {
 			return nil
 		}
+		if handleCommand(ctx, out, runner, input, streamingEnabled, &sessionID, promptVars) {
+			continue
+		}
 
-		state, runErr := runner.Run(ctx, input)
+		state, runErr := runControlled(ctx, out, lines, func(ctx context.Context) (*agent.State, error) {
+			return runner.Run(ctx, sessionID, input)
+		})
 		printRunResult(out, state, runErr, streamingEnabled)
 		_, _ = fmt.Fprintf(out, "Cost: $%.4f\n", runnerTotalCost(runner))
 	}
 }
 
+// replLine 是后台读取到的一行输入；err 非空时表示输入结束或读取失败。
+type replLine struct {
+	text string
+	err  error
+}
+
+// readLines 在后台逐行读取输入，读到错误（包括 EOF）后发送该错误并关闭通道。
+func readLines(reader *bufio.Reader) <-chan replLine {
+	lines := make(chan replLine)
+	go func() {
+		defer close(lines)
+		for {
+			text, err := reader.ReadString('\n')
+			lines <- replLine{text: text, err: err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+func isTerminal(in io.Reader) bool {
+	file, ok := in.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+const interruptHelp = "Paused after the current step. Type a correction to steer the run, `/resume`, `/cancel-tool` or `/stop`."
+
+// runControlled 在后台执行一次运行，同时消费 lines 里的输入来干预它：回车暂停，
+// 普通文本作为纠偏消息注入并继续，`/cancel-tool` 只取消当前工具，`/stop` 结束运行。
+// lines 为 nil 时直接执行 run。
+func runControlled(ctx context.Context, out io.Writer, lines <-chan replLine, run func(ctx context.Context) (*agent.State, error)) (*agent.State, error) {
+	if lines == nil {
+		return run(ctx)
+	}
+	controller := agent.NewRunController()
+	runCtx, stop := context.WithCancel(agent.WithRunController(ctx, controller))
+	defer stop()
+
+	type result struct {
+		state *agent.State
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		state, err := run(runCtx)
+		done <- result{state: state, err: err}
+	}()
+
+	for {
+		select {
+		case result := <-done:
+			return result.state, result.err
+		case line, ok := <-lines:
+			if !ok || line.err != nil {
+				// 输入已经结束，只等运行完成。
+				lines = nil
+				continue
+			}
+			handleInterrupt(out, controller, stop, strings.TrimSpace(line.text))
+		}
+	}
+}
+
+func handleInterrupt(out io.Writer, controller *agent.RunController, stop context.CancelFunc, input string) {
+	switch input {
+	case "", "/pause":
+		controller.Pause()
+		_, _ = fmt.Fprintln(out, interruptHelp)
+	case "/resume":
+		controller.Resume()
+		_, _ = fmt.Fprintln(out, "Resumed.")
+	case "/cancel-tool":
+		if controller.CancelTool() {
+			_, _ = fmt.Fprintln(out, "Cancelled the running tool.")
+		} else {
+			_, _ = fmt.Fprintln(out, "No tool is running.")
+		}
+	case "/stop":
+		stop()
+		_, _ = fmt.Fprintln(out, "Stopping the run.")
+	default:
+		controller.Inject(input)
+		controller.Resume()
+		_, _ = fmt.Fprintln(out, "Correction queued for the next step.")
+	}
+}
+
+// handleCommand 处理以 `/` 开头的 REPL 命令；返回 false 表示输入应当作为普通任务执行。
+func handleCommand(ctx context.Context, out io.Writer, runner agentRunner, input string, streamingEnabled bool, sessionID *string, promptVars map[string]string) bool {
+	fields := strings.Fields(input)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return false
+	}
+
+	switch fields[0] {
+	case "/sessions":
+		sessions := runnerSessions(runner)
+		if sessions == nil {
+			_, _ = fmt.Fprintln(out, "Sessions are not available for this runner.")
+			return true
+		}
+		list, err := sessions.ListSessions(ctx, "")
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "List sessions error: %v\n", err)
+			return true
+		}
+		printSessions(out, list, *sessionID)
+	case "/session":
+		sessions := runnerSessions(runner)
+		if sessions == nil {
+			_, _ = fmt.Fprintln(out, "Sessions are not available for this runner.")
+			return true
+		}
+		if len(fields) < 2 {
+			_, _ = fmt.Fprintln(out, "Usage: /session new [title] | /session <session-id>")
+			return true
+		}
+		if fields[1] == "new" {
+			session, err := sessions.CreateSession(ctx, "", strings.Join(fields[2:], " "))
+			if err != nil {
+				_, _ = fmt.Fprintf(out, "Create session error: %v\n", err)
+				return true
+			}
+			*sessionID = session.ID
+		} else {
+			*sessionID = fields[1]
+		}
+		_, _ = fmt.Fprintf(out, "Session: %s\n", *sessionID)
+	case "/runs":
+		lister, ok := runner.(runLister)
+		if !ok {
+			_, _ = fmt.Fprintln(out, "Runs are not available: checkpoints are not configured.")
+			return true
+		}
+		runs, err := lister.ListRuns(ctx, defaultRunListLimit)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "List runs error: %v\n", err)
+			return true
+		}
+		printRuns(out, runs)
+	case "/resume":
+		resumer, ok := runner.(runResumer)
+		if !ok {
+			_, _ = fmt.Fprintln(out, "Resume is not available: checkpoints are not configured.")
+			return true
+		}
+		if len(fields) < 2 {
+			_, _ = fmt.Fprintln(out, "Usage: /resume <run-id> [max-steps]")
+			return true
+		}
+		options := agent.ResumeOptions{}
+		if len(fields) > 2 {
+			maxSteps, err := strconv.Atoi(fields[2])
+			if err != nil || maxSteps <= 0 {
+				_, _ = fmt.Fprintf(out, "Invalid max-steps: %s\n", fields[2])
+				return true
+			}
+			options.MaxSteps = maxSteps
+		}
+		state, runErr := resumer.Resume(ctx, fields[1], options)
+		printRunResult(out, state, runErr, streamingEnabled)
+		_, _ = fmt.Fprintf(out, "Cost: $%.4f\n", runnerTotalCost(runner))
+	case "/var":
+		handleVarCommand(out, promptVars, fields[1:])
+	case "/fork":
+		handleForkCommand(ctx, out, runner, fields[1:], streamingEnabled, sessionID)
+	case "/export":
+		handleExportCommand(ctx, out, runner, fields[1:])
+	case "/memory":
+		handleMemoryCommand(ctx, out, runnerMemory(runner), fields[1:], *sessionID)
+	default:
+		_, _ = fmt.Fprintf(out, "Unknown command: %s\n", fields[0])
+	}
+	return true
+}
+
+// handleVarCommand 查看或修改 system prompt 模板变量：不带参数列出全部，只给名称时删除，
+// 名称后的文本作为变量值。
+func handleVarCommand(out io.Writer, promptVars map[string]string, args []string) {
+	switch len(args) {
+	case 0:
+		if len(promptVars) == 0 {
+			_, _ = fmt.Fprintln(out, "No prompt variables set.")
+			return
+		}
+		for _, name := range slices.Sorted(maps.Keys(promptVars)) {
+			_, _ = fmt.Fprintf(out, "%s = %s\n", name, promptVars[name])
+		}
+	case 1:
+		delete(promptVars, args[0])
+		_, _ = fmt.Fprintf(out, "Unset %s\n", args[0])
+	default:
+		promptVars[args[0]] = strings.Join(args[1:], " ")
+		_, _ = fmt.Fprintf(out, "Set %s = %s\n", args[0], promptVars[args[0]])
+	}
+}
+
+const forkUsage = "Usage: /fork <run-id> <step> [--replay] [--max-steps N] [--tool <call-id> <result...> | --message <index> <content...> | --system <prompt...>]"
+
+// handleForkCommand 从运行的第 step 步分叉并继续执行；--tool、--message、--system 会吃掉
+// 之后的全部文本，因此只能出现一个且必须放在最后。分叉运行在新会话里进行，完成后 REPL
+// 切换到这个会话。
+func handleForkCommand(ctx context.Context, out io.Writer, runner agentRunner, args []string, streamingEnabled bool, sessionID *string) {
+	forker, ok := runner.(runForker)
+	if !ok {
+		_, _ = fmt.Fprintln(out, "Fork is not available: checkpoints are not configured.")
+		return
+	}
+	if len(args) < 2 {
+		_, _ = fmt.Fprintln(out, forkUsage)
+		return
+	}
+	step, err := strconv.Atoi(args[1])
+	if err != nil || step < 0 {
+		_, _ = fmt.Fprintf(out, "Invalid step: %s\n", args[1])
+		return
+	}
+	options := agent.ForkOptions{Step: step}
+	for i := 2; i < len(args); i++ {
+		switch args[i] {
+		case "--replay":
+			options.Replay = true
+			continue
+		case "--max-steps":
+			if i+1 >= len(args) {
+				_, _ = fmt.Fprintln(out, forkUsage)
+				return
+			}
+			maxSteps, err := strconv.Atoi(args[i+1])
+			if err != nil || maxSteps <= 0 {
+				_, _ = fmt.Fprintf(out, "Invalid max-steps: %s\n", args[i+1])
+				return
+			}
+			options.MaxSteps = maxSteps
+			i++
+			continue
+		case "--system":
+			options.System = []llmModel.Message{{Role: llmModel.RoleSystem, Content: strings.Join(args[i+1:], " ")}}
+		case "--tool":
+			if i+1 >= len(args) {
+				_, _ = fmt.Fprintln(out, forkUsage)
+				return
+			}
+			options.ToolResults = map[string]string{args[i+1]: strings.Join(args[i+2:], " ")}
+		case "--message":
+			if i+1 >= len(args) {
+				_, _ = fmt.Fprintln(out, forkUsage)
+				return
+			}
+			index, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				_, _ = fmt.Fprintf(out, "Invalid message index: %s\n", args[i+1])
+				return
+			}
+			options.Messages = map[int]string{index: strings.Join(args[i+2:], " ")}
+		default:
+			_, _ = fmt.Fprintln(out, forkUsage)
+			return
+		}
+		break
+	}
+
+	state, runErr := forker.Fork(ctx, args[0], options)
+	printRunResult(out, state, runErr, streamingEnabled)
+	if state != nil {
+		*sessionID = state.SessionID
+		_, _ = fmt.Fprintf(out, "Forked run: %s  Session: %s\n", state.RunID, state.SessionID)
+	}
+	_, _ = fmt.Fprintf(out, "Cost: $%.4f\n", runnerTotalCost(runner))
+}
+
+// handleExportCommand 把一次已持久化的运行导出为 openai/gemini JSONL 或 Markdown；
+// 未指定 run-id 时导出最近一次运行。导出内容总是经过密钥脱敏。
+func handleExportCommand(ctx context.Context, out io.Writer, runner agentRunner, args []string) {
+	loader, ok := runner.(trajectoryLoader)
+	lister, canList := runner.(runLister)
+	if !ok || !canList {
+		_, _ = fmt.Fprintln(out, "Export is not available: checkpoints are not configured.")
+		return
+	}
+	options := agent.ExportOptions{Redact: true}
+	var positional []string
+	for _, arg := range args {
+		if arg == "--drop-reasoning" {
+			options.DropReasoning = true
+			continue
+		}
+		positional = append(positional, arg)
+	}
+	if len(positional) < 2 || len(positional) > 3 {
+		_, _ = fmt.Fprintln(out, "Usage: /export <openai|gemini|markdown> <file> [run-id] [--drop-reasoning]")
+		return
+	}
+
+	runID := ""
+	if len(positional) == 3 {
+		runID = positional[2]
+	} else {
+		runs, err := lister.ListRuns(ctx, 1)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "List runs error: %v\n", err)
+			return
+		}
+		if len(runs) == 0 {
+			_, _ = fmt.Fprintln(out, "No runs recorded.")
+			return
+		}
+		runID = runs[0].ID
+	}
+	trajectory, err := loader.LoadTrajectory(ctx, runID)
+	if err != nil {
+		_, _ = fmt.Fprintf(out, "Export error: %v\n", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := agent.ExportTrajectories(&buf, agent.ExportFormat(positional[0]), options, trajectory); err != nil {
+		_, _ = fmt.Fprintf(out, "Export error: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(positional[1], buf.Bytes(), 0o644); err != nil {
+		_, _ = fmt.Fprintf(out, "Export error: %v\n", err)
+		return
+	}
+	_, _ = fmt.Fprintf(out, "Exported run %s to %s\n", runID, positional[1])
+}
+
+func runnerSessions(runner agentRunner) sessionManager {
+	if manager, ok := runner.(sessionManager); ok {
+		return manager
+	}
+	if concrete, ok := runner.(*agent.Agent); ok && concrete.Memory != nil {
+		return concrete.Memory
+	}
+	return nil
+}
+
+func printSessions(out io.Writer, sessions []agent.Session, current string) {
+	if len(sessions) == 0 {
+		_, _ = fmt.Fprintln(out, "No sessions.")
+		return
+	}
+	for _, session := range sessions {
+		marker := " "
+		if session.ID == current {
+			marker = "*"
+		}
+		_, _ = fmt.Fprintf(out, "%s %s  user=%s  updated=%s  %s\n",
+			marker, session.ID, session.Username,
+			session.UpdatedAt.Local().Format("2006-01-02 15:04:05"),
+			session.Title)
+	}
+}
+
+func printRuns(out io.Writer, runs []agent.RunSummary) {
+	if len(runs) == 0 {
+		_, _ = fmt.Fprintln(out, "No runs recorded.")
+		return
+	}
+	for _, run := range runs {
+		_, _ = fmt.Fprintf(out, "%s  %-10s steps=%d/%d  updated=%s  %s\n",
+			run.ID, run.Status, run.StepIndex, run.MaxSteps,
+			run.UpdatedAt.Local().Format("2006-01-02 15:04:05"),
+			truncateForTerminal(run.Task))
+		if run.ParentRunID != "" {
+			_, _ = fmt.Fprintf(out, "    forked from %s@%d\n", run.ParentRunID, run.ForkStep)
+		}
+	}
+}
+
 func shouldExit(input string) bool {
 	switch strings.ToLower(strings.TrimSpace(input)) {
 	case "exit", "quit":
@@ -174,26 +683,105 @@ func printRunResult(out io.Writer, state *agent.State, err error, stepsAlreadyPr
 			printStep(out, agent.StepEvent{Index: i + 1, Step: step})
 		}
 	}
+	for _, event := range state.Guardrails {
+		source := string(event.Stage)
+		if event.ToolName != "" {
+			source += " " + event.ToolName
+		}
+		_, _ = fmt.Fprintf(out, "Guardrail %s: %s %s - %s\n", event.Guardrail, event.Action, source, truncateForTerminal(event.Reason))
+	}
+	switch state.StopReason {
+	case "":
+	case agent.StopReasonGuardrail:
+		_, _ = fmt.Fprintln(out, "Stopped early: blocked by guardrail")
+	default:
+		_, _ = fmt.Fprintf(out, "Stopped early: %s limit reached\n", state.StopReason)
+	}
 	_, _ = fmt.Fprintf(out, "Final Answer:\n%s\n", strings.TrimSpace(state.FinalAnswer))
+	if stats := state.Stats; stats.Steps > 0 {
+		_, _ = fmt.Fprintf(out, "Run: %d steps, %d tool calls, %d tokens, $%.4f, llm %s, tools %s, total %s\n",
+			stats.Steps, stats.ToolCalls, stats.Usage.TotalTokens, stats.Cost.TotalCostUSD,
+			formatLatency(stats.LLMLatency), formatLatency(stats.ToolLatency), formatLatency(stats.Duration))
+	}
+}
+
+// formatStepMetrics 把单步的模型、token、费用与耗时压成一行；没有任何记录时返回空串。
+func formatStepMetrics(metrics agent.StepMetrics) string {
+	if metrics.Model == "" && metrics.Usage.TotalTokens == 0 && metrics.LLMLatency == 0 && len(metrics.ToolCalls) == 0 {
+		return ""
+	}
+	line := fmt.Sprintf("Usage: %d prompt (%d cached) / %d completion tokens, $%.4f, llm %s",
+		metrics.Usage.PromptTokens, metrics.Usage.CachedPromptTokens, metrics.Usage.CompletionTokens,
+		metrics.Cost.TotalCostUSD, formatLatency(metrics.LLMLatency))
+	if metrics.Model != "" {
+		model := metrics.Model
+		if metrics.Tier != "" {
+			model += " " + metrics.Tier + ":" + metrics.RouteReason
+		}
+		line = "[" + model + "] " + line
+	}
+	for _, call := range metrics.ToolCalls {
+		line += fmt.Sprintf(", %s %s", call.Name, formatLatency(call.Latency))
+	}
+	return line
+}
+
+func formatLatency(latency time.Duration) string {
+	if latency < time.Millisecond {
+		return latency.String()
+	}
+	return latency.Round(time.Millisecond).String()
 }
 
 func printStep(out io.Writer, event agent.StepEvent) {
-	_, _ = fmt.Fprintf(out, "Step %d:\n", event.Index)
+	if event.Agent != "" {
+		// 子 agent 的 step 标出来源和所属的父 step，避免与顶层轨迹混在一起。
+		_, _ = fmt.Fprintf(out, "%s[%s] Step %d (in step %d):\n", strings.Repeat("  ", event.Depth-1), event.Agent, event.Index, event.ParentIndex)
+	} else if event.Step.Agent != "" {
+		_, _ = fmt.Fprintf(out, "Step %d (%s):\n", event.Index, event.Step.Agent)
+	} else {
+		_, _ = fmt.Fprintf(out, "Step %d:\n", event.Index)
+	}
+	for _, message := range event.Step.Injected {
+		_, _ = fmt.Fprintf(out, "Injected: %s\n", truncateForTerminal(message))
+	}
 	if event.Step.Thought != "" {
 		_, _ = fmt.Fprintf(out, "Thought: %s\n", truncateForTerminal(event.Step.Thought))
 	}
 	if reasoning := formatReasoningItems(event.Step.ReasoningItems); reasoning != "" {
 		_, _ = fmt.Fprintf(out, "%s", reasoning)
 	}
+	if tools := event.Step.Metrics.SelectedTools; len(tools) > 0 {
+		_, _ = fmt.Fprintf(out, "Tools offered: %s\n", strings.Join(tools, ", "))
+	}
 	_, _ = fmt.Fprintf(out, "Action: %s\n", event.Step.Action.Kind)
 	if len(event.Step.Action.ToolCalls) > 0 {
 		for _, call := range event.Step.Action.ToolCalls {
 			_, _ = fmt.Fprintf(out, "Tool: %s %s\n", call.Name, truncateForTerminal(call.Arguments))
 		}
 	}
+	if handoff := event.Step.Action.Handoff; handoff != nil {
+		_, _ = fmt.Fprintf(out, "Handoff: %s -> %s %s\n", handoff.From, handoff.To, truncateForTerminal(handoff.Note))
+	}
 	if event.Step.Observation != "" {
 		_, _ = fmt.Fprintf(out, "Observation: %s\n", truncateForTerminal(event.Step.Observation))
 	}
+	if loop := event.Step.Loop; loop != nil {
+		_, _ = fmt.Fprintf(out, "Loop detected (%s, %s): %s\n", loop.Kind, loop.Policy, loop.Message)
+	}
+	if event.Step.Verdict != "" {
+		_, _ = fmt.Fprintf(out, "Critic: %s", event.Step.Verdict)
+		if event.Step.Critique != "" {
+			_, _ = fmt.Fprintf(out, " - %s", truncateForTerminal(event.Step.Critique))
+		}
+		_, _ = fmt.Fprintln(out)
+	}
+	if usage := formatStepMetrics(event.Step.Metrics); usage != "" {
+		_, _ = fmt.Fprintln(out, usage)
+	}
+	if event.Plan != nil {
+		_, _ = fmt.Fprintf(out, "Plan (%d/%d, revision %d):\n%s\n", event.Progress.Done, event.Progress.Total, event.Plan.Revision, event.Plan.Checklist())
+	}
 	_, _ = fmt.Fprintln(out, "\n----------------------------------------------------------------------------------------")
 }
 
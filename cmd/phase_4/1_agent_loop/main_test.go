@@ -2,11 +2,21 @@ package main
 
 import (
 	"agent_study/internal/agent"
+	"agent_study/internal/config"
 	llmModel "agent_study/pkg/llm_core/model"
+	sharedTypes "agent_study/pkg/types"
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
 )
 
 func TestPrintStep_IncludesReasoningItems(t *testing.T) {
@@ -93,9 +103,12 @@ type fakeRunner struct {
 	callback agent.StepCallback
 	model    string
 	cost     float64
+
+	sessionIDs []string
 }
 
-func (f *fakeRunner) Run(ctx context.Context, task string) (*agent.State, error) {
+func (f *fakeRunner) Run(ctx context.Context, sessionID string, task string) (*agent.State, error) {
+	f.sessionIDs = append(f.sessionIDs, sessionID)
 	if f.callback != nil && f.state != nil {
 		for i, step := range f.state.Steps {
 			f.callback(agent.StepEvent{Index: i + 1, Step: step})
@@ -115,3 +128,454 @@ func (f *fakeRunner) ModelName() string {
 func (f *fakeRunner) TotalCostUSD() float64 {
 	return f.cost
 }
+
+func TestRunREPL_ResumeAndRunsCommands(t *testing.T) {
+	var out bytes.Buffer
+	runner := &fakeResumableRunner{
+		fakeRunner: fakeRunner{state: &agent.State{FinalAnswer: "resumed answer"}},
+		runs: []agent.RunSummary{{
+			ID:        "run-1",
+			Task:      "查一下上海天气",
+			Status:    agent.RunStatusMaxSteps,
+			StepIndex: 8,
+			MaxSteps:  8,
+		}},
+	}
+
+	err := runREPL(context.Background(), strings.NewReader("/runs\n/resume run-1 12\nexit\n"), &out, runner)
+	if err != nil {
+		t.Fatalf("runREPL() error = %v", err)
+	}
+
+	printed := out.String()
+	if !strings.Contains(printed, "run-1") || !strings.Contains(printed, "max_steps") {
+		t.Fatalf("runREPL output missing run listing: %q", printed)
+	}
+	if runner.resumedID != "run-1" || runner.resumeOptions.MaxSteps != 12 {
+		t.Fatalf("Resume() called with %q %#v, want run-1 with MaxSteps=12", runner.resumedID, runner.resumeOptions)
+	}
+	if !strings.Contains(printed, "Final Answer:\nresumed answer") {
+		t.Fatalf("runREPL output missing resumed final answer: %q", printed)
+	}
+}
+
+func TestRunREPL_ForkCommandParsesStepAndEdits(t *testing.T) {
+	var out bytes.Buffer
+	runner := &fakeForkRunner{fakeResumableRunner: fakeResumableRunner{
+		fakeRunner: fakeRunner{state: &agent.State{RunID: "run-2", SessionID: "session-2", FinalAnswer: "forked answer"}},
+		runs:       []agent.RunSummary{{ID: "run-2", Status: agent.RunStatusCompleted, ParentRunID: "run-1", ForkStep: 6}},
+	}}
+
+	input := "/fork run-1 6 --replay --max-steps 9 --tool call_2 {\"condition\": \"rainy\"}\n/runs\nexit\n"
+	if err := runREPL(context.Background(), strings.NewReader(input), &out, runner); err != nil {
+		t.Fatalf("runREPL() error = %v", err)
+	}
+
+	options := runner.forkOptions
+	if runner.forkedID != "run-1" || options.Step != 6 || !options.Replay || options.MaxSteps != 9 {
+		t.Fatalf("Fork() called with %q %#v, want run-1 step 6 with replay and MaxSteps=9", runner.forkedID, options)
+	}
+	if options.ToolResults["call_2"] != `{"condition": "rainy"}` {
+		t.Fatalf("Fork() tool results = %#v, want edited call_2", options.ToolResults)
+	}
+	printed := out.String()
+	if !strings.Contains(printed, "Final Answer:\nforked answer") || !strings.Contains(printed, "Forked run: run-2") {
+		t.Fatalf("runREPL output missing forked result: %q", printed)
+	}
+	if !strings.Contains(printed, "forked from run-1@6") {
+		t.Fatalf("runREPL output missing run lineage: %q", printed)
+	}
+}
+
+func TestRunREPL_VarCommandSetsListsAndUnsetsPromptVariables(t *testing.T) {
+	var out bytes.Buffer
+	runner := &fakeRunner{state: &agent.State{FinalAnswer: "ok"}}
+
+	input := "/var project atlas v2\n/var owner ops\n/var owner\n/var\nexit\n"
+	if err := runREPL(context.Background(), strings.NewReader(input), &out, runner); err != nil {
+		t.Fatalf("runREPL() error = %v", err)
+	}
+
+	printed := out.String()
+	if !strings.Contains(printed, "Set project = atlas v2") || !strings.Contains(printed, "Unset owner") {
+		t.Fatalf("runREPL output missing variable updates: %q", printed)
+	}
+	if !strings.HasSuffix(printed, "> Unset owner\n> project = atlas v2\n> ") {
+		t.Fatalf("runREPL output = %q, want only project listed", printed)
+	}
+}
+
+type fakeForkRunner struct {
+	fakeResumableRunner
+	forkedID    string
+	forkOptions agent.ForkOptions
+}
+
+func (f *fakeForkRunner) Fork(ctx context.Context, runID string, options agent.ForkOptions) (*agent.State, error) {
+	f.forkedID = runID
+	f.forkOptions = options
+	return f.state, f.err
+}
+
+type fakeResumableRunner struct {
+	fakeRunner
+	runs          []agent.RunSummary
+	resumedID     string
+	resumeOptions agent.ResumeOptions
+}
+
+func (f *fakeResumableRunner) Resume(ctx context.Context, runID string, options agent.ResumeOptions) (*agent.State, error) {
+	f.resumedID = runID
+	f.resumeOptions = options
+	return f.state, f.err
+}
+
+func (f *fakeResumableRunner) ListRuns(ctx context.Context, limit int) ([]agent.RunSummary, error) {
+	return f.runs, nil
+}
+
+type fakeExportRunner struct {
+	fakeResumableRunner
+	loadedIDs []string
+}
+
+func (f *fakeExportRunner) LoadTrajectory(ctx context.Context, runID string) (*agent.Trajectory, error) {
+	f.loadedIDs = append(f.loadedIDs, runID)
+	return &agent.Trajectory{
+		RunID: runID,
+		Task:  "查一下上海天气",
+		Messages: []llmModel.Message{
+			{Role: llmModel.RoleUser, Content: "查一下上海天气，api_key=secret-value"},
+			{Role: llmModel.RoleAssistant, Content: "上海晴", Reasoning: "直接回答"},
+		},
+	}, nil
+}
+
+func TestRunREPL_ExportCommandWritesRedactedTrajectory(t *testing.T) {
+	dir := t.TempDir()
+	latest := filepath.Join(dir, "latest.jsonl")
+	transcript := filepath.Join(dir, "run.md")
+	runner := &fakeExportRunner{fakeResumableRunner: fakeResumableRunner{runs: []agent.RunSummary{{ID: "run-9"}}}}
+
+	var out bytes.Buffer
+	input := fmt.Sprintf("/export openai %s\n/export markdown %s run-1 --drop-reasoning\n/export csv %s\n/export\nexit\n", latest, transcript, latest)
+	if err := runREPL(context.Background(), strings.NewReader(input), &out, runner); err != nil {
+		t.Fatalf("runREPL() error = %v", err)
+	}
+
+	if strings.Join(runner.loadedIDs, ",") != "run-9,run-1,run-9" {
+		t.Fatalf("loaded runs = %v, want latest run when id is omitted", runner.loadedIDs)
+	}
+	raw, err := os.ReadFile(latest)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if strings.Contains(string(raw), "secret-value") || !strings.Contains(string(raw), `<think>\n直接回答\n</think>\n上海晴`) {
+		t.Fatalf("openai export = %s, want redacted line with reasoning kept", raw)
+	}
+	raw, err = os.ReadFile(transcript)
+	if err != nil || strings.Contains(string(raw), "Thinking") || !strings.Contains(string(raw), "## Assistant\n\n上海晴") {
+		t.Fatalf("markdown export = %q, %v, want transcript without reasoning", raw, err)
+	}
+	printed := out.String()
+	for _, want := range []string{"Exported run run-9 to " + latest, "Exported run run-1 to " + transcript, "unsupported trajectory export format", "Usage: /export"} {
+		if !strings.Contains(printed, want) {
+			t.Fatalf("output missing %q: %q", want, printed)
+		}
+	}
+}
+
+func TestRunREPL_SessionCommandSwitchesRunSession(t *testing.T) {
+	var out bytes.Buffer
+	runner := &fakeSessionRunner{fakeRunner: fakeRunner{state: &agent.State{FinalAnswer: "ok"}}}
+
+	err := runREPL(context.Background(), strings.NewReader("first\n/session new demo\nsecond\n/session other-id\nthird\n/sessions\nexit\n"), &out, runner)
+	if err != nil {
+		t.Fatalf("runREPL() error = %v", err)
+	}
+
+	want := []string{"", "session-1", "other-id"}
+	if strings.Join(runner.sessionIDs, ",") != strings.Join(want, ",") {
+		t.Fatalf("Run() session ids = %#v, want %#v", runner.sessionIDs, want)
+	}
+	if !strings.Contains(out.String(), "session-1") || !strings.Contains(out.String(), "demo") {
+		t.Fatalf("runREPL output missing session listing: %q", out.String())
+	}
+}
+
+type fakeSessionRunner struct {
+	fakeRunner
+	sessions []agent.Session
+}
+
+func (f *fakeSessionRunner) CreateSession(ctx context.Context, username string, title string) (*agent.Session, error) {
+	session := agent.Session{ID: fmt.Sprintf("session-%d", len(f.sessions)+1), Title: title}
+	f.sessions = append(f.sessions, session)
+	return &session, nil
+}
+
+func (f *fakeSessionRunner) ListSessions(ctx context.Context, username string) ([]agent.Session, error) {
+	return f.sessions, nil
+}
+
+func TestRunREPL_MemoryCommandsManageCurrentUser(t *testing.T) {
+	database, err := gorm.Open(sqlite.Open("file:repl_memory?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+	memory, err := agent.NewMemoryManager(agent.MemoryOptions{DB: database, Username: "alice", Items: agent.MemoryItemOptions{Enabled: true}})
+	if err != nil {
+		t.Fatalf("NewMemoryManager() error = %v", err)
+	}
+	runner := &fakeMemoryRunner{fakeRunner: fakeRunner{state: &agent.State{FinalAnswer: "ok"}}, MemoryManager: memory}
+	exportPath := filepath.Join(t.TempDir(), "memory.json")
+
+	input := strings.Join([]string{
+		"/memory add likes green tea",
+		"/memory add lives in Shanghai",
+		"/memory edit 1 likes oolong tea",
+		"/memory summary alice is a gopher",
+		"/memory forget Shanghai",
+		"/memory export " + exportPath,
+		"/memory clear",
+		"/memory import " + exportPath,
+		"/memory",
+		"/memory audit 1",
+		"exit",
+	}, "\n")
+	var out bytes.Buffer
+	if err := runREPL(context.Background(), strings.NewReader(input), &out, runner); err != nil {
+		t.Fatalf("runREPL() error = %v", err)
+	}
+
+	printed := out.String()
+	for _, want := range []string{"Forgot #2 lives in Shanghai", "Exported 1 memories", "Imported 1 memories", "alice is a gopher", "likes oolong tea", "update  #1       by=user"} {
+		if !strings.Contains(printed, want) {
+			t.Fatalf("runREPL output missing %q: %q", want, printed)
+		}
+	}
+	if strings.Contains(printed, "] importance=0.50 used=0 run=-  lives in Shanghai") {
+		t.Fatalf("forgotten memory should not be listed: %q", printed)
+	}
+}
+
+type fakeMemoryRunner struct {
+	fakeRunner
+	*agent.MemoryManager
+}
+
+func TestPrintStep_ShowsPlanChecklist(t *testing.T) {
+	var out bytes.Buffer
+	plan := &agent.Plan{Items: []agent.PlanItem{
+		{ID: 1, Description: "查询天气", Status: agent.PlanItemDone},
+		{ID: 2, Description: "给出最终回答", Status: agent.PlanItemInProgress},
+	}}
+
+	printStep(&out, agent.StepEvent{Index: 2, Step: agent.Step{Action: agent.Action{Kind: agent.ActionKindFinish}}, Plan: plan, Progress: plan.Progress()})
+
+	printed := out.String()
+	for _, want := range []string{"Plan (1/2, revision 0):", "[x] 1. 查询天气", "[>] 2. 给出最终回答"} {
+		if !strings.Contains(printed, want) {
+			t.Fatalf("printStep output missing %q: %q", want, printed)
+		}
+	}
+}
+
+func TestPrintStep_ShowsCriticVerdict(t *testing.T) {
+	var out bytes.Buffer
+
+	printStep(&out, agent.StepEvent{Index: 1, Step: agent.Step{
+		Action:   agent.Action{Kind: agent.ActionKindFinish, Answer: "下雨"},
+		Verdict:  agent.VerdictRevise,
+		Critique: "与工具观察矛盾",
+	}})
+
+	if !strings.Contains(out.String(), "Critic: revise - 与工具观察矛盾") {
+		t.Fatalf("printStep output missing critic verdict: %q", out.String())
+	}
+}
+
+func TestPrintStep_LabelsSubAgentSteps(t *testing.T) {
+	var out bytes.Buffer
+
+	printStep(&out, agent.StepEvent{Index: 2, Agent: "researcher", Depth: 1, ParentIndex: 3, Step: agent.Step{
+		Action: agent.Action{Kind: agent.ActionKindFinish, Answer: "done"},
+	}})
+
+	if !strings.HasPrefix(out.String(), "[researcher] Step 2 (in step 3):") {
+		t.Fatalf("printStep output missing sub-agent label: %q", out.String())
+	}
+}
+
+func TestPrintStep_ShowsHandoffAgent(t *testing.T) {
+	var out bytes.Buffer
+
+	printStep(&out, agent.StepEvent{Index: 1, Step: agent.Step{
+		Agent:  "triage",
+		Action: agent.Action{Kind: agent.ActionKindHandoff, Handoff: &agent.Handoff{From: "triage", To: "coder", Note: "fix it"}},
+	}})
+
+	if !strings.HasPrefix(out.String(), "Step 1 (triage):") || !strings.Contains(out.String(), "Handoff: triage -> coder fix it") {
+		t.Fatalf("printStep output missing handoff details: %q", out.String())
+	}
+}
+
+func TestPrintStep_ShowsPerStepUsageAndRunSummary(t *testing.T) {
+	var out bytes.Buffer
+
+	printStep(&out, agent.StepEvent{Index: 1, Step: agent.Step{
+		Action: agent.Action{Kind: agent.ActionKindToolCalls},
+		Metrics: agent.StepMetrics{
+			Model:      "gpt-test",
+			Usage:      llmModel.TokenUsage{PromptTokens: 100, CachedPromptTokens: 40, CompletionTokens: 10, TotalTokens: 110},
+			Cost:       sharedTypes.CostBreakdown{TotalCostUSD: 0.0123},
+			LLMLatency: 1200 * time.Millisecond,
+			ToolCalls:  []agent.ToolCallMetrics{{Name: "lookup_weather", Latency: 35 * time.Millisecond}},
+		},
+	}})
+	if !strings.Contains(out.String(), "[gpt-test] Usage: 100 prompt (40 cached) / 10 completion tokens, $0.0123, llm 1.2s, lookup_weather 35ms") {
+		t.Fatalf("printStep output missing step usage: %q", out.String())
+	}
+
+	out.Reset()
+	printStep(&out, agent.StepEvent{Index: 2, Step: agent.Step{
+		Action:  agent.Action{Kind: agent.ActionKindFinish, Answer: "done"},
+		Metrics: agent.StepMetrics{Model: "gpt-mini", Tier: "cheap", RouteReason: "rule 1", LLMLatency: time.Second, SelectedTools: []string{"read_file", "search_tools"}},
+	}})
+	if !strings.Contains(out.String(), "Tools offered: read_file, search_tools\n") || !strings.Contains(out.String(), "[gpt-mini cheap:rule 1] Usage:") {
+		t.Fatalf("printStep output missing routed tier: %q", out.String())
+	}
+
+	out.Reset()
+	printRunResult(&out, &agent.State{FinalAnswer: "done", Stats: agent.RunStats{Steps: 2, ToolCalls: 1, Usage: llmModel.TokenUsage{TotalTokens: 300}, Cost: sharedTypes.CostBreakdown{TotalCostUSD: 0.5}}}, nil, true)
+	if !strings.Contains(out.String(), "Run: 2 steps, 1 tool calls, 300 tokens, $0.5000") {
+		t.Fatalf("printRunResult output missing run summary: %q", out.String())
+	}
+
+	out.Reset()
+	printRunResult(&out, &agent.State{FinalAnswer: "progress", StopReason: agent.StopReasonBudget}, nil, true)
+	if !strings.Contains(out.String(), "Stopped early: budget limit reached\nFinal Answer:\nprogress") {
+		t.Fatalf("printRunResult output missing run summary: %q", out.String())
+	}
+}
+
+func TestRunControlled_PausesInjectsAndStops(t *testing.T) {
+	var out bytes.Buffer
+	lines := make(chan replLine)
+	controllers := make(chan *agent.RunController, 1)
+	resultCh := make(chan error, 1)
+	go func() {
+		_, err := runControlled(context.Background(), &out, lines, func(ctx context.Context) (*agent.State, error) {
+			controllers <- agent.RunControllerFrom(ctx)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		resultCh <- err
+	}()
+
+	controller := <-controllers
+	if controller == nil {
+		t.Fatal("run context should carry a RunController")
+	}
+	lines <- replLine{text: "\n"}
+	lines <- replLine{text: "/cancel-tool\n"}
+	if !controller.Paused() {
+		t.Fatal("empty line should pause the run")
+	}
+	lines <- replLine{text: "用摄氏度\n"}
+	lines <- replLine{text: "/stop\n"}
+	if controller.Paused() {
+		t.Fatal("a correction should resume the paused run")
+	}
+	if err := <-resultCh; err != context.Canceled {
+		t.Fatalf("runControlled() error = %v, want context.Canceled after /stop", err)
+	}
+
+	for _, want := range []string{"Paused after the current step", "No tool is running.", "Correction queued for the next step.", "Stopping the run."} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("output missing %q: %q", want, out.String())
+		}
+	}
+}
+
+func TestPrintStep_ShowsInjectedMessages(t *testing.T) {
+	var out bytes.Buffer
+
+	printStep(&out, agent.StepEvent{Index: 2, Step: agent.Step{Injected: []string{"用摄氏度"}, Action: agent.Action{Kind: agent.ActionKindFinish}}})
+
+	if !strings.Contains(out.String(), "Injected: 用摄氏度") {
+		t.Fatalf("printStep output missing injected message: %q", out.String())
+	}
+}
+
+func TestPrintStep_ShowsLoopDetection(t *testing.T) {
+	var out bytes.Buffer
+
+	printStep(&out, agent.StepEvent{Index: 3, Step: agent.Step{
+		Action: agent.Action{Kind: agent.ActionKindToolCalls},
+		Loop:   &agent.LoopDetection{Kind: agent.LoopKindExact, Policy: agent.LoopPolicyWarn, Message: "lookup_weather 以相同参数调用了 3 次且结果相同"},
+	}})
+
+	if !strings.Contains(out.String(), "Loop detected (exact, warn): lookup_weather 以相同参数调用了 3 次且结果相同") {
+		t.Fatalf("printStep output missing loop detection: %q", out.String())
+	}
+}
+
+func TestNewGuardrailsAndPrintGuardrailEvents(t *testing.T) {
+	guardrails, err := agent.GuardrailsFromConfig(config.GuardrailsConfig{Injection: true, DenyPatterns: []string{`secret`}, MaxObservationChars: 100, Classifier: config.GuardrailClassifierConfig{Enabled: true, Stages: []string{"output"}}})
+	if err != nil {
+		t.Fatalf("GuardrailsFromConfig() error = %v", err)
+	}
+	if len(guardrails) != 4 {
+		t.Fatalf("guardrails = %d, want injection, deny list, observation length and classifier", len(guardrails))
+	}
+	if _, err := agent.GuardrailsFromConfig(config.GuardrailsConfig{DenyPatterns: []string{`(`}}); err == nil {
+		t.Fatal("GuardrailsFromConfig() should reject invalid patterns")
+	}
+
+	var out bytes.Buffer
+	printRunResult(&out, &agent.State{
+		FinalAnswer: "请求已被安全策略拦截",
+		StopReason:  agent.StopReasonGuardrail,
+		Guardrails:  []agent.GuardrailEvent{{Guardrail: "injection", Stage: agent.GuardrailStageObservation, ToolName: "fetch_url", Action: agent.GuardrailBlock, Reason: "命中禁用内容"}},
+	}, nil, true)
+	if !strings.Contains(out.String(), "Guardrail injection: block observation fetch_url - 命中禁用内容\nStopped early: blocked by guardrail") {
+		t.Fatalf("printRunResult output missing guardrail events: %q", out.String())
+	}
+}
+
+func TestNewRunner_BuildsAgentFromSpec(t *testing.T) {
+	dir := t.TempDir()
+	specPath := filepath.Join(dir, "agents.yaml")
+	spec := `agents:
+  - name: reader
+    systemPrompt: only read files
+    tools:
+      - name: read_file
+    limits:
+      maxSteps: 3
+`
+	if err := os.WriteFile(specPath, []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		LLM:   config.LLMProvider{BaseProvider: config.BaseProvider{Model: "gpt-5.4", BaseUrl: "https://api.openai.com/v1", Typ: "openai", Key: "test-key"}},
+		Agent: config.AgentConfig{Spec: config.AgentSpecRef{File: specPath, Name: "reader"}},
+	}
+	runner, err := newRunner(cfg)
+	if err != nil {
+		t.Fatalf("newRunner() error = %v", err)
+	}
+	if runner.Config.MaxSteps != 3 || len(runner.System) != 1 || runner.System[0].Content != "only read files" {
+		t.Fatalf("runner = config %#v system %#v, want spec values", runner.Config, runner.System)
+	}
+	if tools := runner.Tools.List(); len(tools) != 1 || tools[0].Name != "read_file" {
+		t.Fatalf("runner tools = %#v, want only read_file", tools)
+	}
+
+	cfg.Agent.Spec.Name = "missing"
+	if _, err := newRunner(cfg); !errors.Is(err, config.ErrInvalidAgentSpec) {
+		t.Fatalf("newRunner() error = %v, want ErrInvalidAgentSpec", err)
+	}
+}
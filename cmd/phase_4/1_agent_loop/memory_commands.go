@@ -0,0 +1,287 @@
+package main
+
+import (
+	"agent_study/internal/agent"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// memoryAdmin 对应长期记忆管理能力；*agent.Agent 通过其 MemoryManager 提供。
+type memoryAdmin interface {
+	SessionUsername(ctx context.Context, sessionID string) (string, error)
+	SearchMemoryItems(ctx context.Context, username string, query string, limit int) ([]agent.MemoryItem, error)
+	AddMemoryItem(ctx context.Context, username string, item agent.MemoryItem) (*agent.MemoryItem, error)
+	UpdateMemoryItem(ctx context.Context, username string, id uint, patch agent.MemoryItemPatch) (*agent.MemoryItem, error)
+	ForgetMemoryItems(ctx context.Context, username string, ids ...uint) (int, error)
+	ForgetMemories(ctx context.Context, username string, query string) (*agent.ForgetResult, error)
+	LongTermSummaryFor(ctx context.Context, username string) (string, error)
+	SetLongTermSummary(ctx context.Context, username string, summary string) error
+	ClearMemories(ctx context.Context, username string) (int, error)
+	ExportMemories(ctx context.Context, username string) (*agent.MemoryExport, error)
+	ImportMemories(ctx context.Context, username string, export agent.MemoryExport, replace bool) (int, error)
+	MemoryAuditLog(ctx context.Context, username string, itemID *uint, limit int) ([]agent.MemoryAuditEntry, error)
+}
+
+const (
+	defaultAuditListLimit = 20
+	memoryCommandUsage    = "Usage: /memory [list] | search <query> | add <text> | edit <id> <text> | delete <id> | forget <text> | summary [text|clear] | clear | export <file> | import <file> [replace] | audit [id]"
+)
+
+func runnerMemory(runner agentRunner) memoryAdmin {
+	if admin, ok := runner.(memoryAdmin); ok {
+		return admin
+	}
+	if concrete, ok := runner.(*agent.Agent); ok && concrete.Memory != nil {
+		return concrete.Memory
+	}
+	return nil
+}
+
+// handleMemoryCommand 处理 `/memory` 子命令，操作对象是当前会话所属用户的长期记忆。
+func handleMemoryCommand(ctx context.Context, out io.Writer, memory memoryAdmin, args []string, sessionID string) {
+	if memory == nil {
+		_, _ = fmt.Fprintln(out, "Memory management is not available for this runner.")
+		return
+	}
+	username, err := memory.SessionUsername(ctx, sessionID)
+	if err != nil {
+		_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+		return
+	}
+	// REPL 里的修改都记作用户直接操作，便于和 agent 运行产生的变更区分。
+	ctx = agent.WithMemoryAuditSource(ctx, agent.MemoryAuditSource{Actor: agent.MemoryActorUser, SessionID: sessionID})
+
+	sub := "list"
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	rest := ""
+	if len(args) > 1 {
+		rest = strings.Join(args[1:], " ")
+	}
+
+	switch sub {
+	case "list", "search":
+		if sub == "search" && rest == "" {
+			_, _ = fmt.Fprintln(out, "Usage: /memory search <query>")
+			return
+		}
+		if sub == "list" {
+			summary, err := memory.LongTermSummaryFor(ctx, username)
+			if err != nil {
+				_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+				return
+			}
+			printMemorySummary(out, summary)
+		}
+		items, err := memory.SearchMemoryItems(ctx, username, rest, 0)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+			return
+		}
+		printMemoryItems(out, items)
+	case "add":
+		if rest == "" {
+			_, _ = fmt.Fprintln(out, "Usage: /memory add <text>")
+			return
+		}
+		item, err := memory.AddMemoryItem(ctx, username, agent.MemoryItem{Text: rest})
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+			return
+		}
+		_, _ = fmt.Fprintf(out, "Saved memory #%d.\n", item.ID)
+	case "edit":
+		if len(args) < 3 {
+			_, _ = fmt.Fprintln(out, "Usage: /memory edit <id> <text>")
+			return
+		}
+		id, ok := parseMemoryID(out, args[1])
+		if !ok {
+			return
+		}
+		text := strings.Join(args[2:], " ")
+		if _, err := memory.UpdateMemoryItem(ctx, username, id, agent.MemoryItemPatch{Text: &text}); err != nil {
+			_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+			return
+		}
+		_, _ = fmt.Fprintf(out, "Updated memory #%d.\n", id)
+	case "delete":
+		if len(args) < 2 {
+			_, _ = fmt.Fprintln(out, "Usage: /memory delete <id>")
+			return
+		}
+		id, ok := parseMemoryID(out, args[1])
+		if !ok {
+			return
+		}
+		deleted, err := memory.ForgetMemoryItems(ctx, username, id)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+			return
+		}
+		_, _ = fmt.Fprintf(out, "Deleted %d memory.\n", deleted)
+	case "forget":
+		if rest == "" {
+			_, _ = fmt.Fprintln(out, "Usage: /memory forget <text>")
+			return
+		}
+		result, err := memory.ForgetMemories(ctx, username, rest)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+			return
+		}
+		if result.Empty() {
+			_, _ = fmt.Fprintln(out, "No matching memory.")
+			return
+		}
+		for _, item := range result.Items {
+			_, _ = fmt.Fprintf(out, "Forgot #%d %s\n", item.ID, item.Text)
+		}
+		for _, line := range result.SummaryLines {
+			_, _ = fmt.Fprintf(out, "Forgot summary line: %s\n", line)
+		}
+	case "summary":
+		if rest == "" {
+			summary, err := memory.LongTermSummaryFor(ctx, username)
+			if err != nil {
+				_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+				return
+			}
+			printMemorySummary(out, summary)
+			return
+		}
+		if rest == "clear" {
+			rest = ""
+		}
+		if err := memory.SetLongTermSummary(ctx, username, rest); err != nil {
+			_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+			return
+		}
+		_, _ = fmt.Fprintln(out, "Summary updated.")
+	case "clear":
+		deleted, err := memory.ClearMemories(ctx, username)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+			return
+		}
+		_, _ = fmt.Fprintf(out, "Cleared summary and %d memories for %s.\n", deleted, username)
+	case "export":
+		if len(args) < 2 {
+			_, _ = fmt.Fprintln(out, "Usage: /memory export <file>")
+			return
+		}
+		export, err := memory.ExportMemories(ctx, username)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+			return
+		}
+		raw, err := json.MarshalIndent(export, "", "  ")
+		if err == nil {
+			err = os.WriteFile(args[1], raw, 0o644)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "Export error: %v\n", err)
+			return
+		}
+		_, _ = fmt.Fprintf(out, "Exported %d memories to %s.\n", len(export.Items), args[1])
+	case "import":
+		if len(args) < 2 {
+			_, _ = fmt.Fprintln(out, "Usage: /memory import <file> [replace]")
+			return
+		}
+		var export agent.MemoryExport
+		raw, err := os.ReadFile(args[1])
+		if err == nil {
+			err = json.Unmarshal(raw, &export)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "Import error: %v\n", err)
+			return
+		}
+		replace := len(args) > 2 && args[2] == "replace"
+		imported, err := memory.ImportMemories(ctx, username, export, replace)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "Import error: %v\n", err)
+			return
+		}
+		_, _ = fmt.Fprintf(out, "Imported %d memories.\n", imported)
+	case "audit":
+		var itemID *uint
+		if len(args) > 1 {
+			id, ok := parseMemoryID(out, args[1])
+			if !ok {
+				return
+			}
+			itemID = &id
+		}
+		entries, err := memory.MemoryAuditLog(ctx, username, itemID, defaultAuditListLimit)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "Memory error: %v\n", err)
+			return
+		}
+		printMemoryAudits(out, entries)
+	default:
+		_, _ = fmt.Fprintln(out, memoryCommandUsage)
+	}
+}
+
+func parseMemoryID(out io.Writer, raw string) (uint, bool) {
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil || id == 0 {
+		_, _ = fmt.Fprintf(out, "Invalid memory id: %s\n", raw)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func printMemorySummary(out io.Writer, summary string) {
+	if strings.TrimSpace(summary) == "" {
+		_, _ = fmt.Fprintln(out, "Summary: (empty)")
+		return
+	}
+	_, _ = fmt.Fprintf(out, "Summary:\n%s\n", summary)
+}
+
+func printMemoryItems(out io.Writer, items []agent.MemoryItem) {
+	if len(items) == 0 {
+		_, _ = fmt.Fprintln(out, "No memories.")
+		return
+	}
+	for _, item := range items {
+		_, _ = fmt.Fprintf(out, "#%d [%s] importance=%.2f used=%d run=%s  %s\n",
+			item.ID, item.Type, item.Importance, item.UseCount, displayRunID(item.SourceRunID), truncateForTerminal(item.Text))
+	}
+}
+
+func printMemoryAudits(out io.Writer, entries []agent.MemoryAuditEntry) {
+	if len(entries) == 0 {
+		_, _ = fmt.Fprintln(out, "No memory changes recorded.")
+		return
+	}
+	for _, entry := range entries {
+		target := "summary"
+		if entry.ItemID > 0 {
+			target = fmt.Sprintf("#%d", entry.ItemID)
+		}
+		change := entry.After
+		if entry.Action == agent.MemoryActionDelete {
+			change = entry.Before
+		}
+		_, _ = fmt.Fprintf(out, "%s  %-7s %-8s by=%s run=%s  %s\n",
+			entry.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+			entry.Action, target, entry.Actor, displayRunID(entry.RunID), truncateForTerminal(change))
+	}
+}
+
+func displayRunID(runID string) string {
+	if runID == "" {
+		return "-"
+	}
+	return runID
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"agent_study/internal/agent"
+	"agent_study/internal/config"
+	"agent_study/internal/eval"
+	"agent_study/pkg/tools"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultConfigPath = "conf/phase4/app.yaml"
+	defaultSuitePath  = "conf/phase4/eval/smoke.yaml"
+	defaultMaxSteps   = 8
+)
+
+// errRegression 表示评测本身成功但出现了相对基线的回归，main 以非零状态退出。
+var errRegression = errors.New("eval regressions found")
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("agent_eval", flag.ContinueOnError)
+	flags.SetOutput(out)
+	configPath := flags.String("config", defaultConfigPath, "agent 配置文件")
+	suitePath := flags.String("suite", defaultSuitePath, "评测套件（.yaml/.yml/.jsonl）")
+	jsonPath := flags.String("json", "", "JSON 报告输出路径，可作为下次评测的 -baseline")
+	markdownPath := flags.String("md", "", "Markdown 报告输出路径，为空时打印到标准输出")
+	baselinePath := flags.String("baseline", "", "用于对比的历史 JSON 报告")
+	replayOnly := flags.Bool("replay", false, "只使用录制响应离线运行，不连接真实模型")
+	label := flags.String("label", "", "本次评测的标识，默认使用模型名")
+	judgeModel := flags.String("judge-model", "", "rubric 评审模型，为空时沿用 llmProvider.model")
+	timeout := flags.Duration("timeout", 0, "单个用例的运行上限")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	suite, err := eval.LoadSuite(*suitePath)
+	if err != nil {
+		return err
+	}
+	var baseline *eval.Report
+	if *baselinePath != "" {
+		if baseline, err = eval.LoadReport(*baselinePath); err != nil {
+			return err
+		}
+	}
+
+	runner := &eval.Runner{
+		NewAgent:   newAgentFactory(cfg),
+		ReplayOnly: *replayOnly,
+		Timeout:    *timeout,
+		Label:      *label,
+		OnResult: func(result eval.Result) {
+			status := "PASS"
+			if !result.Passed {
+				status = "FAIL"
+			}
+			fmt.Fprintf(out, "[%s] %s (%d steps, %dms)\n", status, result.ID, result.Steps, result.Latency.Milliseconds())
+		},
+	}
+	if runner.Label == "" {
+		runner.Label = cfg.LLM.ModelName()
+	}
+	// 离线回放时不调用真实模型，rubric 检查记为跳过。
+	if !*replayOnly {
+		judge, err := agent.NewAgent(agent.NewAgentOptions{Provider: &cfg.LLM, Model: *judgeModel})
+		if err != nil {
+			return fmt.Errorf("init judge: %w", err)
+		}
+		runner.Judge = &eval.Judge{LLM: judge.LLM, Model: judge.Model}
+	}
+
+	report, err := runner.Run(ctx, suite)
+	if err != nil {
+		return err
+	}
+	var comparison *eval.Comparison
+	if baseline != nil {
+		compared := eval.Compare(baseline, report)
+		comparison = &compared
+	}
+
+	if *jsonPath != "" {
+		if err := writeFile(*jsonPath, func(w io.Writer) error { return report.WriteJSON(w) }); err != nil {
+			return err
+		}
+	}
+	if *markdownPath != "" {
+		err = writeFile(*markdownPath, func(w io.Writer) error { return report.WriteMarkdown(w, comparison) })
+	} else {
+		fmt.Fprintln(out)
+		err = report.WriteMarkdown(out, comparison)
+	}
+	if err != nil {
+		return err
+	}
+	if comparison != nil && comparison.HasRegressions() {
+		return fmt.Errorf("%w: %v", errRegression, comparison.Regressions)
+	}
+	return nil
+}
+
+// newAgentFactory 按 agent 配置为每个用例组装 Agent，内置文件工具以用例工作区为根。
+// 评测关注 prompt、工具与策略本身，因此不启用 SQLite 记忆、检查点和 guardrail。
+func newAgentFactory(cfg *config.Config) eval.AgentFactory {
+	return func(env eval.CaseEnv) (*agent.Agent, error) {
+		builtinTools, err := tools.NewBuiltinTools(tools.BuiltinOptions{RootDir: env.Workspace})
+		if err != nil {
+			return nil, err
+		}
+		toolsReg := tools.NewRegistry()
+		if err := toolsReg.Register(builtinTools...); err != nil {
+			return nil, err
+		}
+
+		limits := cfg.Agent.Limits
+		return agent.NewAgent(agent.NewAgentOptions{
+			LLM:           env.LLM,
+			Provider:      &cfg.LLM,
+			Reflection:    agent.ReflectionFromConfig(cfg.Agent.Reflection),
+			LoopDetection: agent.LoopDetectionFromConfig(cfg.Agent.LoopDetection),
+			Routing:       agent.RoutingFromConfig(cfg.Agent.Routing),
+			ToolSelection: agent.ToolSelectionFromConfig(cfg.Agent.ToolSelection),
+			Tools:         toolsReg,
+			Config: agent.Config{
+				MaxSteps:        defaultMaxSteps,
+				MaxBudgetUSD:    limits.MaxBudgetUSD,
+				Mode:            agent.Mode(cfg.Agent.Mode),
+				MaxReplans:      cfg.Agent.MaxReplans,
+				MaxDuration:     time.Duration(limits.MaxDurationSeconds) * time.Second,
+				MaxTokens:       limits.MaxTokens,
+				MaxOutputTokens: limits.MaxOutputTokens,
+			},
+		})
+	}
+}
+
+func loadConfig(path string) (*config.Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config.Config{}
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(raw))), cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"agent_study/internal/eval"
+)
+
+const testConfig = `
+agent:
+  loopDetection:
+    enabled: true
+llmProvider:
+  model: test-model
+  type: openai
+  cost:
+    input: 1
+    output: 2
+`
+
+func TestRunReplaysSmokeSuiteAndWritesReports(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "app.yaml")
+	if err := os.WriteFile(configPath, []byte(testConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jsonPath := filepath.Join(dir, "report.json")
+	markdownPath := filepath.Join(dir, "report.md")
+
+	var out bytes.Buffer
+	err := run(context.Background(), []string{
+		"-config", configPath,
+		"-suite", filepath.Join("..", "..", "..", defaultSuitePath),
+		"-replay",
+		"-json", jsonPath,
+		"-md", markdownPath,
+	}, &out)
+	if err != nil {
+		t.Fatalf("run() error = %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "[PASS] read-todo (2 steps") || !strings.Contains(out.String(), "[PASS] write-summary (3 steps") {
+		t.Fatalf("progress = %q, want both smoke cases passed", out.String())
+	}
+
+	report, err := eval.LoadReport(jsonPath)
+	if err != nil {
+		t.Fatalf("LoadReport() error = %v", err)
+	}
+	if report.Label != "test-model" || report.Summary.PassRate != 1 || report.Summary.TotalCostUSD <= 0 {
+		t.Fatalf("report = %#v, want labelled full pass with cost from configured pricing", report)
+	}
+	if rubric := report.Results[1].Checks[len(report.Results[1].Checks)-1]; rubric.Name != eval.CheckRubric || !rubric.Skipped {
+		t.Fatalf("rubric check = %#v, want skipped in replay mode", rubric)
+	}
+	markdown, err := os.ReadFile(markdownPath)
+	if err != nil || !strings.Contains(string(markdown), "# Eval: smoke (test-model)") {
+		t.Fatalf("markdown = %q, %v, want report header", markdown, err)
+	}
+}
+
+func TestRunFailsOnRegressionAgainstBaseline(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "app.yaml")
+	suitePath := filepath.Join(dir, "suite.jsonl")
+	baselinePath := filepath.Join(dir, "baseline.json")
+	suite := `{"id":"math","task":"1+1","expect":{"answer":"2"},"replay":[{"content":"3"}]}`
+	baseline := `{"suite":"suite","label":"v1","summary":{"cases":1,"passed":1,"pass_rate":1},"results":[{"id":"math","passed":true}]}`
+	for path, content := range map[string]string{configPath: testConfig, suitePath: suite, baselinePath: baseline} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	err := run(context.Background(), []string{"-config", configPath, "-suite", suitePath, "-replay", "-baseline", baselinePath}, &out)
+	if !errors.Is(err, errRegression) {
+		t.Fatalf("run() error = %v, want errRegression", err)
+	}
+	if !strings.Contains(out.String(), "## Compared with v1") || !strings.Contains(out.String(), "- Regressions: math") {
+		t.Fatalf("output = %q, want comparison printed", out.String())
+	}
+}
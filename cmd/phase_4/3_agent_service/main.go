@@ -0,0 +1,26 @@
+package main
+
+import (
+	"agent_study/internal/app/phase_4_agent_service"
+	"agent_study/internal/config"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+func main() {
+	// 读取配置文件，与 phase 4 CLI 一样展开其中的环境变量
+	f, err := os.ReadFile("conf/phase4/app.yaml")
+	if err != nil {
+		panic(err)
+	}
+
+	cfg := &config.Config{}
+	err = yaml.Unmarshal([]byte(os.ExpandEnv(string(f))), cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	// 启动服务
+	phase_4_agent_service.Serve(cfg)
+}
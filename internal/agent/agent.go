@@ -6,6 +6,7 @@ import (
 	openaiClient "agent_study/pkg/llm_core/client/openai"
 	openaiOfficialClient "agent_study/pkg/llm_core/client/openai_official"
 	llmModel "agent_study/pkg/llm_core/model"
+	llmTools "agent_study/pkg/llm_core/tools"
 	"agent_study/pkg/tools"
 	sharedTypes "agent_study/pkg/types"
 	"errors"
@@ -37,6 +38,26 @@ type NewAgentOptions struct {
 	Config Config
 	// StepCallback 会在每个 step 完成后被调用，供外部消费实时轨迹。
 	StepCallback StepCallback
+	// Checkpoints 是可选的运行检查点存储；提供后 Run 会逐步落库并支持 Resume。
+	Checkpoints *CheckpointStore
+	// Reflection 是可选的最终回答审查配置；critic 未指定客户端/模型时复用 Agent 的。
+	Reflection *ReflectionOptions
+	// Downshift 是可选的降级模型；预估下一次调用会超出剩余预算时改用它。
+	Downshift *DownshiftOptions
+	// Routing 是可选的按 step 模型路由；档位与分类模型未指定客户端时复用 Agent 的。
+	Routing *RoutingOptions
+	// TokenCounter 是可选的 prompt token 计数器，用于调用前的预算与 token 上限预估。
+	TokenCounter *llmTools.TokenCounter
+	// LoopDetection 是可选的重复调用检测配置。
+	LoopDetection *LoopDetectionOptions
+	// Guardrails 是可选的内容检查列表；其中的 *LLMGuardrail 未指定客户端/模型/费用跟踪器时复用 Agent 的。
+	Guardrails []Guardrail
+	// Hooks 是可选的生命周期中间件，按顺序执行；NewAgent 会做一次防御性拷贝。
+	Hooks []Hook
+	// ToolSelection 是可选的工具检索配置，适合注册了大量（例如多个 MCP server 的）工具时使用。
+	ToolSelection *ToolSelectionOptions
+	// SystemPrompt 是可选的模板化 system prompt，渲染结果排在 System 之后。
+	SystemPrompt *SystemPromptOptions
 }
 
 // NewAgent 根据显式依赖和可选配置构造一个可运行的 Agent。
@@ -45,12 +66,12 @@ type NewAgentOptions struct {
 //   - LLM 或 Provider 二选一至少提供一个
 //
 // 可选参数：
-//   - Model、System、Tools、Memory、MemoryOptions、Cost、Provider、Config
+//   - Model、System、Tools、Memory、MemoryOptions、Cost、Provider、Config、Checkpoints、Reflection、Downshift、Routing、TokenCounter、LoopDetection、Guardrails、Hooks、ToolSelection、SystemPrompt
 //
 // 初始化规则：
 //   - 未提供 LLM 但提供了 Provider 时，会根据 Provider.Type() 自动构造 LLM client
 //   - Model 为空时，会优先使用 Provider.ModelName() 作为默认模型名
-//   - 未提供 Memory 时，使用 MemoryOptions 创建默认 MemoryManager
+//   - 未提供 Memory 时，使用 MemoryOptions 创建默认 MemoryManager；LLM 压缩器和记忆抽取器未指定客户端/模型时复用 Agent 的
 //   - 未提供 Cost 且 Provider 暴露了价格配置时，按 Config.MaxBudgetUSD 尝试创建 CostTracker；价格非法会直接报错
 //   - 显式传入的 Memory 和 Cost 优先级最高，不会被自动创建逻辑覆盖
 func NewAgent(options NewAgentOptions) (*Agent, error) {
@@ -66,12 +87,49 @@ func NewAgent(options NewAgentOptions) (*Agent, error) {
 		return nil, ErrAgentLLMRequired
 	}
 
+	switch options.Config.Mode {
+	case "", ModeReAct, ModePlanExecute:
+	default:
+		return nil, fmt.Errorf("unsupported agent mode: %s", options.Config.Mode)
+	}
+	if options.LoopDetection != nil {
+		switch options.LoopDetection.Policy {
+		case "", LoopPolicyWarn, LoopPolicyFinish, LoopPolicyAbort:
+		default:
+			return nil, fmt.Errorf("unsupported loop policy: %s", options.LoopDetection.Policy)
+		}
+	}
+
+	if err := validateRouting(options.Routing); err != nil {
+		return nil, err
+	}
+
+	model := strings.TrimSpace(options.Model)
+	if model == "" && options.Provider != nil {
+		model = strings.TrimSpace(options.Provider.ModelName())
+	}
+
 	memory := options.Memory
 	if memory == nil {
 		memoryOptions := MemoryOptions{}
 		if options.MemoryOptions != nil {
 			memoryOptions = *options.MemoryOptions
 		}
+		// LLM 压缩器默认复用 Agent 自己的客户端与模型，调用方只需声明 Mode 即可。
+		if memoryOptions.Compression.LLM == nil {
+			memoryOptions.Compression.LLM = llm
+		}
+		if strings.TrimSpace(memoryOptions.Compression.Model) == "" {
+			memoryOptions.Compression.Model = model
+		}
+		if memoryOptions.Items.Enabled && memoryOptions.Items.Extractor == nil {
+			if memoryOptions.Items.LLM == nil {
+				memoryOptions.Items.LLM = llm
+			}
+			if strings.TrimSpace(memoryOptions.Items.Model) == "" {
+				memoryOptions.Items.Model = model
+			}
+		}
 
 		var err error
 		memory, err = NewMemoryManager(memoryOptions)
@@ -95,20 +153,56 @@ func NewAgent(options NewAgentOptions) (*Agent, error) {
 		}
 	}
 
-	model := strings.TrimSpace(options.Model)
-	if model == "" && options.Provider != nil {
-		model = strings.TrimSpace(options.Provider.ModelName())
+	var reflection *ReflectionOptions
+	if options.Reflection != nil {
+		cloned := *options.Reflection
+		if cloned.LLM == nil {
+			cloned.LLM = llm
+		}
+		if strings.TrimSpace(cloned.Model) == "" {
+			cloned.Model = model
+		}
+		reflection = &cloned
+	}
+
+	guardrails := make([]Guardrail, 0, len(options.Guardrails))
+	for _, guardrail := range options.Guardrails {
+		if classifier, ok := guardrail.(*LLMGuardrail); ok {
+			cloned := *classifier
+			if cloned.LLM == nil {
+				cloned.LLM = llm
+			}
+			if strings.TrimSpace(cloned.Model) == "" {
+				cloned.Model = model
+			}
+			if cloned.Cost == nil {
+				cloned.Cost = cost
+			}
+			guardrail = &cloned
+		}
+		guardrails = append(guardrails, guardrail)
 	}
 
 	return &Agent{
-		System:       cloneMessages(options.System),
-		LLM:          llm,
-		Model:        model,
-		Tools:        options.Tools,
-		Memory:       memory,
-		Cost:         cost,
-		Config:       options.Config,
-		StepCallback: options.StepCallback,
+		System:        cloneMessages(options.System),
+		LLM:           llm,
+		Model:         model,
+		Tools:         options.Tools,
+		Memory:        memory,
+		Cost:          cost,
+		Config:        options.Config,
+		StepCallback:  options.StepCallback,
+		Checkpoints:   options.Checkpoints,
+		Reflection:    reflection,
+		Downshift:     options.Downshift,
+		Routing:       options.Routing,
+		TokenCounter:  options.TokenCounter,
+		LoopDetection: options.LoopDetection,
+		Guardrails:    guardrails,
+		Hooks:         append([]Hook(nil), options.Hooks...),
+		ToolSelection: options.ToolSelection,
+		SystemPrompt:  options.SystemPrompt,
+		toolIndexes:   &toolIndexCache{},
 	}, nil
 }
 
@@ -131,6 +225,50 @@ func newLLMClientFromProvider(provider internalConfig.Provider) (llmModel.LlmCli
 	}
 }
 
+// Clone 复制出一个可与原 Agent 并发运行的实例，供服务端为每次运行准备独立的事件回调
+// 与预算：LLM、工具、记忆与检查点存储仍然共享，费用跟踪器按相同价格和预算重新创建，
+// StepCallback 置空。
+func (a *Agent) Clone() *Agent {
+	return a.CloneWithBudget(0)
+}
+
+// CloneWithBudget 与 Clone 相同，maxBudgetUSD 大于 0 时新实例改用这一预算，用于按任务
+// 设定预算的后台任务。
+func (a *Agent) CloneWithBudget(maxBudgetUSD float64) *Agent {
+	if a == nil {
+		return nil
+	}
+	run := *a
+	run.System = cloneMessages(a.System)
+	run.StepCallback = nil
+	run.useCostTracker(newChildCostTracker(a.Cost, nil, a.Config.MaxBudgetUSD))
+	if maxBudgetUSD > 0 {
+		run.Config.MaxBudgetUSD = maxBudgetUSD
+		if run.Cost != nil {
+			run.Cost.maxBudgetUSD = maxBudgetUSD
+		}
+	}
+	run.Hooks = append([]Hook(nil), a.Hooks...)
+	return &run
+}
+
+// useCostTracker 把 Agent 的费用跟踪器换成 cost；复用原跟踪器的分类 guardrail 复制一份
+// 改为计入 cost，原 Agent 的 guardrail 不受影响。
+func (a *Agent) useCostTracker(cost *CostTracker) {
+	previous := a.Cost
+	a.Cost = cost
+	guardrails := make([]Guardrail, 0, len(a.Guardrails))
+	for _, guardrail := range a.Guardrails {
+		if classifier, ok := guardrail.(*LLMGuardrail); ok && classifier.Cost == previous {
+			cloned := *classifier
+			cloned.Cost = cost
+			guardrail = &cloned
+		}
+		guardrails = append(guardrails, guardrail)
+	}
+	a.Guardrails = guardrails
+}
+
 func (a *Agent) SetStepCallback(callback StepCallback) {
 	if a == nil {
 		return
@@ -181,6 +181,49 @@ func TestNewAgentPrefersExplicitMemoryAndCostTracker(t *testing.T) {
 	}
 }
 
+func TestCloneGivesEachRunItsOwnCostTrackerAndCallback(t *testing.T) {
+	tracker, err := NewCostTracker(sharedTypes.ModelPricing{
+		Input:  sharedTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: sharedTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}, 3)
+	if err != nil {
+		t.Fatalf("NewCostTracker() error = %v", err)
+	}
+	base, err := NewAgent(NewAgentOptions{
+		LLM:          &fakeLlmClient{},
+		Cost:         tracker,
+		Guardrails:   []Guardrail{&LLMGuardrail{}},
+		StepCallback: func(StepEvent) {},
+	})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+
+	run := base.Clone()
+	if run.Memory != base.Memory || run.LLM != base.LLM {
+		t.Fatal("Clone() should share memory and llm with the base agent")
+	}
+	if run.StepCallback != nil {
+		t.Fatal("Clone().StepCallback != nil, want cleared callback")
+	}
+	if run.Cost == nil || run.Cost == base.Cost || run.Cost.maxBudgetUSD != 3 {
+		t.Fatalf("Clone().Cost = %#v, want a fresh tracker with the same budget", run.Cost)
+	}
+	if _, err := run.Cost.AddUsage(llmModel.TokenUsage{PromptTokens: 1000}); err != nil {
+		t.Fatalf("AddUsage() error = %v", err)
+	}
+	if base.Cost.Totals().Cost.TotalCostUSD != 0 {
+		t.Fatalf("base cost = %v, want runs priced separately", base.Cost.Totals().Cost.TotalCostUSD)
+	}
+	if guardrail := run.Guardrails[0].(*LLMGuardrail); guardrail.Cost != run.Cost {
+		t.Fatal("Clone() guardrail should charge the cloned cost tracker")
+	}
+
+	if budgeted := base.CloneWithBudget(0.5); budgeted.Cost.maxBudgetUSD != 0.5 || budgeted.Config.MaxBudgetUSD != 0.5 || base.Cost.maxBudgetUSD != 3 {
+		t.Fatalf("CloneWithBudget() budget = %v, want 0.5 without touching the base", budgeted.Cost.maxBudgetUSD)
+	}
+}
+
 func TestPlanUsesAgentModelAsRequestDefault(t *testing.T) {
 	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "done"}}}
 	agent, err := NewAgent(NewAgentOptions{
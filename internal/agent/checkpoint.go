@@ -0,0 +1,311 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	phase4migrate "agent_study/internal/migrate/phase4"
+	"agent_study/internal/model"
+	llmModel "agent_study/pkg/llm_core/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RunStatus 描述一次运行在检查点里记录的生命周期状态。
+type RunStatus string
+
+const (
+	RunStatusRunning   RunStatus = "running"
+	RunStatusCompleted RunStatus = "completed"
+	RunStatusFailed    RunStatus = "failed"
+	RunStatusMaxSteps  RunStatus = "max_steps"
+	// RunStatusLimited 表示运行因预算、token 或时长上限提前结束；放宽上限后仍可恢复。
+	RunStatusLimited RunStatus = "limited"
+)
+
+var (
+	ErrCheckpointDisabled = errors.New("agent checkpoint store is not configured")
+	ErrRunNotFound        = errors.New("agent run not found")
+	ErrRunNotResumable    = errors.New("agent run is not resumable")
+	ErrStepNotFound       = errors.New("agent run step snapshot not found")
+)
+
+// ResumeOptions 控制恢复运行时允许覆盖的执行参数。
+type ResumeOptions struct {
+	// MaxSteps 大于 0 时覆盖检查点里保存的总步数上限，用于给触达上限的运行“续命”。
+	MaxSteps int
+}
+
+// RunSummary 是运行记录对外暴露的只读视图，不包含体积较大的快照内容。
+type RunSummary struct {
+	ID          string
+	Username    string
+	Task        string
+	Status      RunStatus
+	StepIndex   int
+	MaxSteps    int
+	FinalAnswer string
+	Error       string
+	// ParentRunID 与 ForkStep 记录分叉来源：该运行从 ParentRunID 的第 ForkStep 步之后分叉，
+	// 非分叉运行时为零值。
+	ParentRunID string
+	ForkStep    int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Checkpoint 是一次运行在某个 step 结束后的完整快照。
+type Checkpoint struct {
+	RunSummary
+	State  State
+	Memory []llmModel.Message
+	Cost   *CostTotals
+}
+
+// CheckpointStore 把运行检查点持久化到 SQLite，供 Resume 和 CLI 查询使用。
+type CheckpointStore struct {
+	db *gorm.DB
+}
+
+// NewCheckpointStore 会在首次使用前补齐 phase 4 依赖的表结构。
+func NewCheckpointStore(db *gorm.DB) (*CheckpointStore, error) {
+	if err := phase4migrate.BootstrapWithDB(db, phase4migrate.CurrentVersion); err != nil {
+		return nil, err
+	}
+	return &CheckpointStore{db: db}, nil
+}
+
+// Save 以运行 ID 为主键覆盖写入最新快照；CreatedAt 只在首次写入时生成。同一 step 的
+// 逐步快照只在第一次写入：step 完成时的快照是干净的分叉点，之后同一序号上的失败或
+// 提前结束写入可能带着未完成 step 的消息，不应覆盖它。
+func (s *CheckpointStore) Save(ctx context.Context, checkpoint Checkpoint) error {
+	if s == nil || s.db == nil {
+		return ErrCheckpointDisabled
+	}
+	if checkpoint.ID == "" {
+		return fmt.Errorf("checkpoint run id is empty")
+	}
+
+	stateJSON, err := json.Marshal(checkpoint.State)
+	if err != nil {
+		return fmt.Errorf("encode checkpoint state: %w", err)
+	}
+	memoryJSON, err := json.Marshal(checkpoint.Memory)
+	if err != nil {
+		return fmt.Errorf("encode checkpoint memory: %w", err)
+	}
+	costJSON := ""
+	if checkpoint.Cost != nil {
+		raw, err := json.Marshal(checkpoint.Cost)
+		if err != nil {
+			return fmt.Errorf("encode checkpoint cost: %w", err)
+		}
+		costJSON = string(raw)
+	}
+
+	record := &model.AgentRun{
+		ID:          checkpoint.ID,
+		Username:    normalizeUsername(checkpoint.Username),
+		Task:        checkpoint.Task,
+		Status:      string(checkpoint.Status),
+		StepIndex:   checkpoint.StepIndex,
+		MaxSteps:    checkpoint.MaxSteps,
+		FinalAnswer: checkpoint.FinalAnswer,
+		Error:       checkpoint.Error,
+		StateJSON:   string(stateJSON),
+		MemoryJSON:  string(memoryJSON),
+		CostJSON:    costJSON,
+		ParentRunID: checkpoint.ParentRunID,
+		ForkStep:    checkpoint.ForkStep,
+	}
+	step := &model.AgentRunStep{
+		RunID:      checkpoint.ID,
+		StepIndex:  checkpoint.StepIndex,
+		StateJSON:  record.StateJSON,
+		MemoryJSON: record.MemoryJSON,
+		CostJSON:   costJSON,
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 用 Find 而不是 First 探测是否存在，避免首次写入时 GORM 打出 record not found 日志。
+		var existing []model.AgentRun
+		if err := tx.Select("id", "created_at").Where("id = ?", record.ID).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		if len(existing) == 0 {
+			if err := tx.Create(record).Error; err != nil {
+				return err
+			}
+		} else {
+			record.CreatedAt = existing[0].CreatedAt
+			if err := tx.Save(record).Error; err != nil {
+				return err
+			}
+		}
+
+		var steps []model.AgentRunStep
+		if err := tx.Select("id").Where("run_id = ? AND step_index = ?", step.RunID, step.StepIndex).Limit(1).Find(&steps).Error; err != nil {
+			return err
+		}
+		if len(steps) > 0 {
+			return nil
+		}
+		return tx.Create(step).Error
+	})
+}
+
+// Load 读取运行的最新快照。
+func (s *CheckpointStore) Load(ctx context.Context, runID string) (*Checkpoint, error) {
+	if s == nil || s.db == nil {
+		return nil, ErrCheckpointDisabled
+	}
+
+	record := &model.AgentRun{}
+	if err := s.db.WithContext(ctx).Where("id = ?", runID).First(record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+		}
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{RunSummary: runSummaryFromRecord(record)}
+	if err := decodeSnapshot(checkpoint, record.StateJSON, record.MemoryJSON, record.CostJSON); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// LoadStep 读取运行在第 step 步完成时的快照；step 为 0 表示用户任务已写入会话、第一步
+// 尚未开始。RunSummary 仍是运行的最新摘要，State、Memory 与 Cost 来自该步快照。
+func (s *CheckpointStore) LoadStep(ctx context.Context, runID string, step int) (*Checkpoint, error) {
+	if s == nil || s.db == nil {
+		return nil, ErrCheckpointDisabled
+	}
+
+	var runs []model.AgentRun
+	if err := s.db.WithContext(ctx).Where("id = ?", runID).Limit(1).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+	}
+	var steps []model.AgentRunStep
+	if err := s.db.WithContext(ctx).Where("run_id = ? AND step_index = ?", runID, step).Limit(1).Find(&steps).Error; err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("%w: run %s step %d", ErrStepNotFound, runID, step)
+	}
+
+	checkpoint := &Checkpoint{RunSummary: runSummaryFromRecord(&runs[0])}
+	if err := decodeSnapshot(checkpoint, steps[0].StateJSON, steps[0].MemoryJSON, steps[0].CostJSON); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// Lineage 沿分叉来源向上追溯，按从最早的祖先到 runID 本身的顺序返回运行摘要。
+func (s *CheckpointStore) Lineage(ctx context.Context, runID string) ([]RunSummary, error) {
+	if s == nil || s.db == nil {
+		return nil, ErrCheckpointDisabled
+	}
+
+	var lineage []RunSummary
+	seen := make(map[string]bool)
+	for id := runID; id != "" && !seen[id]; {
+		seen[id] = true
+		var records []model.AgentRun
+		if err := s.db.WithContext(ctx).Omit("state_json", "memory_json", "cost_json").Where("id = ?", id).Limit(1).Find(&records).Error; err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			if id == runID {
+				return nil, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+			}
+			// 来源运行已被清理时血缘到此为止。
+			break
+		}
+		lineage = append(lineage, runSummaryFromRecord(&records[0]))
+		id = records[0].ParentRunID
+	}
+	slices.Reverse(lineage)
+	return lineage, nil
+}
+
+func decodeSnapshot(checkpoint *Checkpoint, stateJSON string, memoryJSON string, costJSON string) error {
+	if stateJSON != "" {
+		if err := json.Unmarshal([]byte(stateJSON), &checkpoint.State); err != nil {
+			return fmt.Errorf("decode checkpoint state: %w", err)
+		}
+	}
+	if memoryJSON != "" {
+		if err := json.Unmarshal([]byte(memoryJSON), &checkpoint.Memory); err != nil {
+			return fmt.Errorf("decode checkpoint memory: %w", err)
+		}
+	}
+	if costJSON != "" {
+		checkpoint.Cost = &CostTotals{}
+		if err := json.Unmarshal([]byte(costJSON), checkpoint.Cost); err != nil {
+			return fmt.Errorf("decode checkpoint cost: %w", err)
+		}
+	}
+	return nil
+}
+
+// List 按更新时间倒序返回运行摘要；limit <= 0 时不限制数量。
+func (s *CheckpointStore) List(ctx context.Context, limit int) ([]RunSummary, error) {
+	if s == nil || s.db == nil {
+		return nil, ErrCheckpointDisabled
+	}
+
+	query := s.db.WithContext(ctx).
+		Select("id", "username", "task", "status", "step_index", "max_steps", "final_answer", "error", "parent_run_id", "fork_step", "created_at", "updated_at").
+		Order("updated_at DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var records []model.AgentRun
+	if err := query.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	summaries := make([]RunSummary, 0, len(records))
+	for i := range records {
+		summaries = append(summaries, runSummaryFromRecord(&records[i]))
+	}
+	return summaries, nil
+}
+
+func runSummaryFromRecord(record *model.AgentRun) RunSummary {
+	return RunSummary{
+		ID:          record.ID,
+		Username:    record.Username,
+		Task:        record.Task,
+		Status:      RunStatus(record.Status),
+		StepIndex:   record.StepIndex,
+		MaxSteps:    record.MaxSteps,
+		FinalAnswer: record.FinalAnswer,
+		Error:       record.Error,
+		ParentRunID: record.ParentRunID,
+		ForkStep:    record.ForkStep,
+		CreatedAt:   record.CreatedAt,
+		UpdatedAt:   record.UpdatedAt,
+	}
+}
+
+func newRunID() string {
+	return uuid.NewString()
+}
+
+// ListRuns 返回最近的运行摘要，供 CLI 等上层展示可恢复的运行。
+func (a *Agent) ListRuns(ctx context.Context, limit int) ([]RunSummary, error) {
+	if a == nil || a.Checkpoints == nil {
+		return nil, ErrCheckpointDisabled
+	}
+	return a.Checkpoints.List(ctx, limit)
+}
@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	"agent_study/pkg/tools"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestRunPersistsCheckpointAfterEachStep(t *testing.T) {
+	store, err := NewCheckpointStore(newBareTestMemoryDB(t))
+	if err != nil {
+		t.Fatalf("NewCheckpointStore() error = %v", err)
+	}
+
+	var saved []RunSummary
+	agent := &Agent{
+		LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{
+			{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{"city":"Shanghai"}`}}},
+			{Content: "Shanghai is sunny."},
+		}},
+		Tools:       newWeatherRegistry(t),
+		Config:      Config{MaxSteps: 4},
+		Checkpoints: store,
+	}
+	agent.StepCallback = func(event StepEvent) {
+		checkpoint, err := store.Load(context.Background(), event.RunID)
+		if err != nil {
+			t.Fatalf("Load() during step %d error = %v", event.Index, err)
+		}
+		saved = append(saved, checkpoint.RunSummary)
+	}
+
+	state, err := agent.Run(context.Background(), "", "weather?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.RunID == "" {
+		t.Fatalf("state.RunID should be generated when checkpoints are enabled")
+	}
+	if len(saved) != 2 {
+		t.Fatalf("checkpoints observed = %d, want 2", len(saved))
+	}
+	if saved[0].Status != RunStatusRunning || saved[0].StepIndex != 1 {
+		t.Fatalf("first checkpoint = %#v, want running at step 1", saved[0])
+	}
+	if saved[1].Status != RunStatusCompleted || saved[1].FinalAnswer != "Shanghai is sunny." {
+		t.Fatalf("final checkpoint = %#v, want completed with final answer", saved[1])
+	}
+
+	runs, err := store.List(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(runs) != 1 || runs[0].ID != state.RunID || runs[0].CreatedAt.IsZero() {
+		t.Fatalf("List() = %#v, want the single completed run with timestamps", runs)
+	}
+}
+
+func TestResumeContinuesFromLastCompletedStepWithRaisedMaxSteps(t *testing.T) {
+	store, err := NewCheckpointStore(newBareTestMemoryDB(t))
+	if err != nil {
+		t.Fatalf("NewCheckpointStore() error = %v", err)
+	}
+	tracker, err := NewCostTracker(toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}, 0)
+	if err != nil {
+		t.Fatalf("NewCostTracker() error = %v", err)
+	}
+
+	first := &Agent{
+		LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{{
+			ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{"city":"Shanghai"}`}},
+			Usage:     llmModel.TokenUsage{PromptTokens: 10, CompletionTokens: 5},
+		}}},
+		Tools:       newWeatherRegistry(t),
+		Cost:        tracker,
+		Config:      Config{MaxSteps: 1},
+		Checkpoints: store,
+	}
+	_, err = first.Run(context.Background(), "", "weather?")
+	if err == nil || !strings.Contains(err.Error(), "max steps") {
+		t.Fatalf("Run() error = %v, want max steps error", err)
+	}
+	runs, err := store.List(context.Background(), 1)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("List() = %#v, %v, want one run", runs, err)
+	}
+	if runs[0].Status != RunStatusMaxSteps {
+		t.Fatalf("run status = %q, want %q", runs[0].Status, RunStatusMaxSteps)
+	}
+
+	// 模拟进程重启：新的 Agent 只共享检查点存储，记忆和费用都从快照恢复。
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{
+		Content: "Shanghai is sunny.",
+		Usage:   llmModel.TokenUsage{PromptTokens: 10, CompletionTokens: 5},
+	}}}
+	restoredTracker, _ := NewCostTracker(toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}, 0)
+	second := &Agent{
+		LLM:         llm,
+		Tools:       newWeatherRegistry(t),
+		Cost:        restoredTracker,
+		Config:      Config{MaxSteps: 1},
+		Checkpoints: store,
+	}
+	state, err := second.Resume(context.Background(), runs[0].ID, ResumeOptions{MaxSteps: 3})
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if state.FinalAnswer != "Shanghai is sunny." || len(state.Steps) != 2 {
+		t.Fatalf("resumed state = %#v, want two steps and final answer", state)
+	}
+	if len(llm.requests) != 1 {
+		t.Fatalf("llm request count = %d, want 1", len(llm.requests))
+	}
+	// 恢复后的请求应当带着第一步的 user/assistant/tool 三条消息继续规划。
+	if got := len(llm.requests[0].Messages); got != 3 {
+		t.Fatalf("resumed request messages = %d, want 3", got)
+	}
+	if got := restoredTracker.Totals().Usage.PromptTokens; got != 20 {
+		t.Fatalf("restored prompt tokens = %d, want 20", got)
+	}
+
+	if _, err := second.Resume(context.Background(), runs[0].ID, ResumeOptions{}); !errors.Is(err, ErrRunNotResumable) {
+		t.Fatalf("Resume() on completed run error = %v, want ErrRunNotResumable", err)
+	}
+}
+
+type failingToolHook struct {
+	BaseHook
+	callID string
+}
+
+func (h failingToolHook) BeforeToolCall(_ context.Context, event *ToolHookEvent) error {
+	if event.Call.ID == h.callID {
+		return errors.New("denied")
+	}
+	return nil
+}
+
+func TestResumeRestoresLastCompletedStepAfterMidStepFailure(t *testing.T) {
+	store, err := NewCheckpointStore(newBareTestMemoryDB(t))
+	if err != nil {
+		t.Fatalf("NewCheckpointStore() error = %v", err)
+	}
+	first := &Agent{
+		LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{
+			{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{}`}}},
+			{ToolCalls: []toolTypes.ToolCall{{ID: "call_2", Name: "lookup_weather", Arguments: `{}`}}},
+		}},
+		Tools:       newWeatherRegistry(t),
+		Hooks:       []Hook{failingToolHook{callID: "call_2"}},
+		Checkpoints: store,
+	}
+	if _, err := first.Run(context.Background(), "", "weather?"); err == nil {
+		t.Fatalf("Run() error = nil, want hook failure during step 2")
+	}
+	runs, err := store.List(context.Background(), 1)
+	if err != nil || len(runs) != 1 || runs[0].Status != RunStatusFailed {
+		t.Fatalf("List() = %#v, %v, want one failed run", runs, err)
+	}
+	// 失败时写入的运行记录里，第二步的工具调用没有对应结果。
+	latest, err := store.Load(context.Background(), runs[0].ID)
+	if err != nil || len(latest.Memory[len(latest.Memory)-1].ToolCalls) == 0 {
+		t.Fatalf("latest memory = %#v, %v, want dangling tool call", latest.Memory, err)
+	}
+
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "Shanghai is sunny."}}}
+	second := &Agent{LLM: llm, Tools: newWeatherRegistry(t), Checkpoints: store}
+	state, err := second.Resume(context.Background(), runs[0].ID, ResumeOptions{})
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if state.FinalAnswer != "Shanghai is sunny." || len(state.Steps) != 2 {
+		t.Fatalf("resumed state = %#v, want completed step 1 plus the new answer", state)
+	}
+	messages := llm.requests[0].Messages
+	if last := messages[len(messages)-1]; last.Role != llmModel.RoleTool || last.ToolCallId != "call_1" {
+		t.Fatalf("resumed request messages = %#v, want memory as of step 1", messages)
+	}
+}
+
+func TestResumeRequiresCheckpointStore(t *testing.T) {
+	agent := &Agent{LLM: &fakeLlmClient{}}
+	if _, err := agent.Resume(context.Background(), "missing", ResumeOptions{}); !errors.Is(err, ErrCheckpointDisabled) {
+		t.Fatalf("Resume() error = %v, want ErrCheckpointDisabled", err)
+	}
+}
+
+func newWeatherRegistry(t *testing.T) *tools.Registry {
+	t.Helper()
+
+	registry := tools.NewRegistry()
+	if err := registry.Register(tools.Tool{
+		Name:        "lookup_weather",
+		Description: "lookup weather by city",
+		Parameters:  toolTypes.JSONSchema{Type: "object"},
+		Handler: func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+			return `{"condition":"sunny"}`, nil
+		},
+	}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	return registry
+}
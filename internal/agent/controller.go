@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var ErrToolCancelled = errors.New("tool execution cancelled by user")
+
+// RunController 让调用方在运行期间干预 agent：在 step 之间暂停/继续、注入新的用户
+// 消息（下一次规划时可见）、只取消正在执行的工具调用。通过 WithRunController 随
+// ctx 传给 Run/Resume；所有方法都可以在其他 goroutine 里并发调用。
+type RunController struct {
+	mu         sync.Mutex
+	paused     bool
+	resumed    chan struct{}
+	injected   []string
+	cancelTool context.CancelCauseFunc
+}
+
+func NewRunController() *RunController {
+	return &RunController{}
+}
+
+type runControllerKey struct{}
+
+func WithRunController(ctx context.Context, controller *RunController) context.Context {
+	return context.WithValue(ctx, runControllerKey{}, controller)
+}
+
+// RunControllerFrom 返回 ctx 携带的控制器，没有时返回 nil。
+func RunControllerFrom(ctx context.Context) *RunController {
+	controller, _ := ctx.Value(runControllerKey{}).(*RunController)
+	return controller
+}
+
+// Pause 让运行在当前 step 结束后停下，直到 Resume 或 ctx 取消。
+func (c *RunController) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		c.paused = true
+		c.resumed = make(chan struct{})
+	}
+}
+
+func (c *RunController) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		c.paused = false
+		close(c.resumed)
+	}
+}
+
+func (c *RunController) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// Inject 追加一条用户消息，下一个 step 规划前写入会话，用于在运行中纠偏。
+func (c *RunController) Inject(message string) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.injected = append(c.injected, message)
+}
+
+// CancelTool 只取消正在执行的工具调用，运行本身继续；当前没有工具在执行时返回 false。
+func (c *RunController) CancelTool() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancelTool == nil {
+		return false
+	}
+	c.cancelTool(ErrToolCancelled)
+	return true
+}
+
+// wait 在暂停状态下阻塞到 Resume；ctx 取消时返回取消原因。
+func (c *RunController) wait(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	if !c.paused {
+		c.mu.Unlock()
+		return nil
+	}
+	resumed := c.resumed
+	c.mu.Unlock()
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// drain 取走所有待注入的消息。
+func (c *RunController) drain() []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	injected := c.injected
+	c.injected = nil
+	return injected
+}
+
+// pending 报告是否有尚未写入会话的注入消息。
+func (c *RunController) pending() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.injected) > 0
+}
+
+// toolContext 为一次工具调用派生可被 CancelTool 取消的 ctx；release 必须在调用结束后执行。
+func (c *RunController) toolContext(ctx context.Context) (context.Context, func()) {
+	if c == nil {
+		return ctx, func() {}
+	}
+	toolCtx, cancel := context.WithCancelCause(ctx)
+	c.mu.Lock()
+	c.cancelTool = cancel
+	c.mu.Unlock()
+	return toolCtx, func() {
+		c.mu.Lock()
+		c.cancelTool = nil
+		c.mu.Unlock()
+		cancel(nil)
+	}
+}
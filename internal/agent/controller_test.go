@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	"agent_study/pkg/tools"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestRunControllerPausesAndInjectsSteering(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "22°C"}}}
+	agent := &Agent{LLM: llm}
+	controller := NewRunController()
+	controller.Pause()
+
+	done := make(chan *State)
+	go func() {
+		state, err := agent.Run(WithRunController(context.Background(), controller), "", "上海气温")
+		if err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+		done <- state
+	}()
+	controller.Inject("请用摄氏度回答")
+	controller.Resume()
+	state := <-done
+
+	if len(state.Steps[0].Injected) != 1 || state.Steps[0].Injected[0] != "请用摄氏度回答" {
+		t.Fatalf("step = %#v, want injected message recorded", state.Steps[0])
+	}
+	messages := llm.requests[0].Messages
+	if last := messages[len(messages)-1]; last.Role != llmModel.RoleUser || last.Content != "请用摄氏度回答" {
+		t.Fatalf("first request last message = %#v, want injected steering", last)
+	}
+}
+
+func TestRunControllerCancelsOnlyCurrentTool(t *testing.T) {
+	started := make(chan struct{})
+	registry := tools.NewRegistry()
+	if err := registry.Register(tools.Tool{Name: "crawl", Handler: func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	}}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "crawl", Arguments: `{}`}}},
+		{Content: "已跳过抓取"},
+	}}
+	agent := &Agent{LLM: llm, Tools: registry}
+	controller := NewRunController()
+	if controller.CancelTool() {
+		t.Fatal("CancelTool() = true with no tool running")
+	}
+
+	go func() {
+		<-started
+		controller.CancelTool()
+	}()
+	state, err := agent.Run(WithRunController(context.Background(), controller), "", "抓取网站")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.FinalAnswer != "已跳过抓取" || !strings.Contains(state.Steps[0].Observation, ErrToolCancelled.Error()) {
+		t.Fatalf("state = %#v, want run to continue after tool cancellation", state)
+	}
+	if toolMessage := llm.requests[1].Messages[2]; toolMessage.Role != llmModel.RoleTool || toolMessage.ToolCallId != "call_1" {
+		t.Fatalf("second request = %#v, want cancellation reported as tool result", llm.requests[1].Messages)
+	}
+}
+
+func TestRunControllerStaysWithParentDuringSubAgentTool(t *testing.T) {
+	started := make(chan struct{})
+	childTools := tools.NewRegistry()
+	if err := childTools.Register(tools.Tool{Name: "crawl", Handler: func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	}}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	child := &Agent{LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "child_1", Name: "crawl", Arguments: `{}`}}},
+	}}, Tools: childTools}
+	subAgent, err := NewSubAgentTool(child, SubAgentToolOptions{Name: "researcher"})
+	if err != nil {
+		t.Fatalf("NewSubAgentTool() error = %v", err)
+	}
+	registry := tools.NewRegistry()
+	if err := registry.Register(subAgent); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "researcher", Arguments: `{"task":"抓取网站"}`}}},
+		{Content: "已改为只总结首页"},
+	}}
+	agent := &Agent{LLM: llm, Tools: registry}
+	controller := NewRunController()
+
+	cancelled := make(chan bool)
+	go func() {
+		<-started
+		controller.Inject("只总结首页即可")
+		cancelled <- controller.CancelTool()
+	}()
+	state, err := agent.Run(WithRunController(context.Background(), controller), "", "调研这个网站")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !<-cancelled {
+		t.Fatal("CancelTool() = false while the sub-agent tool was running")
+	}
+	if !strings.Contains(state.Steps[0].Observation, ErrToolCancelled.Error()) || state.Steps[0].ToolFailed {
+		t.Fatalf("first step = %#v, want the whole sub-agent call cancelled", state.Steps[0])
+	}
+	if len(state.Steps[1].Injected) != 1 || state.Steps[1].Injected[0] != "只总结首页即可" {
+		t.Fatalf("second step = %#v, want injected message delivered to the parent", state.Steps[1])
+	}
+	messages := llm.requests[1].Messages
+	if last := messages[len(messages)-1]; last.Role != llmModel.RoleUser || last.Content != "只总结首页即可" {
+		t.Fatalf("second request last message = %#v, want injected steering", last)
+	}
+}
@@ -22,6 +22,8 @@ type CostTracker struct {
 	maxBudgetUSD float64
 	totalUsage   llmModel.TokenUsage
 	totalCost    sharedTypes.CostBreakdown
+	// parent 非空时（子 agent 的 tracker），每次累计都会同步计入父 tracker。
+	parent *CostTracker
 }
 
 // CostTotals 表示当前累计用量与累计费用的一份只读快照。
@@ -74,7 +76,13 @@ func CalculateUsageCost(usage llmModel.TokenUsage, pricing sharedTypes.ModelPric
 
 // AddUsage 在返回单次请求费用拆分的同时，也会把累计用量和累计费用一并更新。
 func (c *CostTracker) AddUsage(usage llmModel.TokenUsage) (sharedTypes.CostBreakdown, error) {
-	breakdown, err := CalculateUsageCost(usage, c.pricing)
+	return c.AddUsageWithPricing(usage, c.pricing)
+}
+
+// AddUsageWithPricing 与 AddUsage 相同，但按调用方给出的价格计费，用于同一次运行里
+// 临时改用其他模型（例如预算不足时降级）的请求。
+func (c *CostTracker) AddUsageWithPricing(usage llmModel.TokenUsage, pricing sharedTypes.ModelPricing) (sharedTypes.CostBreakdown, error) {
+	breakdown, err := CalculateUsageCost(usage, pricing)
 	if err != nil {
 		return sharedTypes.CostBreakdown{}, err
 	}
@@ -83,15 +91,22 @@ func (c *CostTracker) AddUsage(usage llmModel.TokenUsage) (sharedTypes.CostBreak
 		return sharedTypes.CostBreakdown{}, err
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	if err := c.add(normalizedUsage, breakdown); err != nil {
+		return breakdown, err
+	}
+	return breakdown, nil
+}
 
+// add 把已经计算好的单次用量与费用计入 totals，再逐级计入父 tracker；任一级超出
+// 预算都会返回 ErrBudgetExceeded。
+func (c *CostTracker) add(usage llmModel.TokenUsage, breakdown sharedTypes.CostBreakdown) error {
+	c.mu.Lock()
 	// 即使本次累计后超预算，也先把 totals 写进去，再返回 ErrBudgetExceeded，
 	// 这样调用方仍然能看到触发超限时的精确累计状态。
-	c.totalUsage.PromptTokens += normalizedUsage.PromptTokens
-	c.totalUsage.CachedPromptTokens += normalizedUsage.CachedPromptTokens
-	c.totalUsage.CompletionTokens += normalizedUsage.CompletionTokens
-	c.totalUsage.TotalTokens += normalizedUsage.TotalTokens
+	c.totalUsage.PromptTokens += usage.PromptTokens
+	c.totalUsage.CachedPromptTokens += usage.CachedPromptTokens
+	c.totalUsage.CompletionTokens += usage.CompletionTokens
+	c.totalUsage.TotalTokens += usage.TotalTokens
 
 	c.totalCost.UncachedPromptTokens += breakdown.UncachedPromptTokens
 	c.totalCost.CachedPromptTokens += breakdown.CachedPromptTokens
@@ -100,12 +115,34 @@ func (c *CostTracker) AddUsage(usage llmModel.TokenUsage) (sharedTypes.CostBreak
 	c.totalCost.CachedInputCostUSD += breakdown.CachedInputCostUSD
 	c.totalCost.OutputCostUSD += breakdown.OutputCostUSD
 	c.totalCost.TotalCostUSD += breakdown.TotalCostUSD
+	overBudget := c.maxBudgetUSD > 0 && c.totalCost.TotalCostUSD > c.maxBudgetUSD
+	c.mu.Unlock()
 
-	if c.maxBudgetUSD > 0 && c.totalCost.TotalCostUSD > c.maxBudgetUSD {
-		return breakdown, ErrBudgetExceeded
+	if c.parent != nil {
+		if err := c.parent.add(usage, breakdown); err != nil {
+			return err
+		}
+	}
+	if overBudget {
+		return ErrBudgetExceeded
 	}
+	return nil
+}
 
-	return breakdown, nil
+// newChildCostTracker 为子 agent 的一次运行创建独立 tracker：价格沿用子 agent 自己的
+// 配置（没有时退回父 tracker 的价格），预算单独计算，用量同时汇总到父 tracker。
+func newChildCostTracker(template *CostTracker, parent *CostTracker, maxBudgetUSD float64) *CostTracker {
+	source := template
+	if source == nil {
+		source = parent
+	}
+	if source == nil {
+		return nil
+	}
+	if template != nil {
+		maxBudgetUSD = template.maxBudgetUSD
+	}
+	return &CostTracker{pricing: source.pricing, maxBudgetUSD: maxBudgetUSD, parent: parent}
 }
 
 func (c *CostTracker) Totals() CostTotals {
@@ -117,6 +154,14 @@ func (c *CostTracker) Totals() CostTotals {
 	}
 }
 
+// Restore 用检查点里保存的累计值覆盖当前 totals，使恢复后的运行继续沿用原预算。
+func (c *CostTracker) Restore(totals CostTotals) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.totalUsage = totals.Usage
+	c.totalCost = totals.Cost
+}
+
 func (c *CostTracker) OverBudget() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -202,3 +247,18 @@ func normalizeUsage(usage llmModel.TokenUsage) (llmModel.TokenUsage, error) {
 	}
 	return usage, nil
 }
+
+// availableBudgetUSD 返回本 tracker 及所有上级 tracker 中最紧的剩余预算；ok 为 false
+// 表示整条链上都没有设置预算。
+func (c *CostTracker) availableBudgetUSD() (float64, bool) {
+	remaining, limited := 0.0, false
+	for tracker := c; tracker != nil; tracker = tracker.parent {
+		if tracker.maxBudgetUSD <= 0 {
+			continue
+		}
+		if left := tracker.RemainingBudgetUSD(); !limited || left < remaining {
+			remaining, limited = left, true
+		}
+	}
+	return remaining, limited
+}
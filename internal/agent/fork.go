@@ -0,0 +1,277 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	llmModel "agent_study/pkg/llm_core/model"
+)
+
+var ErrInvalidForkEdit = errors.New("invalid fork edit")
+
+// ForkOptions 描述从哪一步分叉，以及分叉前对快照做的修改。
+type ForkOptions struct {
+	// Step 是保留的 step 数：分叉运行从来源运行第 Step 步完成时的快照继续，0 表示只保留
+	// 用户任务。
+	Step int
+	// SessionID 非空时把快照里的消息写入这个已有会话（覆盖原有消息）；为空时为来源运行的
+	// 用户新建一个会话，来源会话保持不变。
+	SessionID string
+	// Messages 按下标改写快照里的会话消息内容，下标与 Checkpoint.Memory 一致。
+	Messages map[int]string
+	// ToolResults 按工具调用 ID 改写工具结果，并同步更新对应 step 的观察；原调用失败、
+	// 没有结果时会补上一条工具消息。
+	ToolResults map[string]string
+	// System 非空时替换分叉运行使用的静态 system prompt（Agent.System），SystemPrompt 模板
+	// 仍照常渲染。它不会写进检查点，之后 Resume 这次分叉运行时仍使用 Agent 自己的 System。
+	System []llmModel.Message
+	// MaxSteps 大于 0 时覆盖来源运行的总步数上限。
+	MaxSteps int
+	// Replay 为 true 时先按来源运行记录的规划回复与工具结果依次回放，这两类调用不会真正
+	// 发出；记录用完后（或来源运行在此之后没有更多 step）转为实时执行。回放只覆盖规划与
+	// 工具调用：计划生成、路由分类、critic 审查、LLMGuardrail、工具检索的 embedding 以及
+	// 运行结束后的记忆抽取仍会实时调用各自的模型并计费。
+	Replay bool
+}
+
+// Fork 读取运行 runID 在第 options.Step 步完成时的快照，按 options 修改后以新的运行 ID
+// 继续执行；新运行通过 ParentRunID 与 ForkStep 记录来源，来源运行本身不受影响。
+func (a *Agent) Fork(ctx context.Context, runID string, options ForkOptions) (*State, error) {
+	if a == nil {
+		return nil, fmt.Errorf("agent is nil")
+	}
+	if a.Checkpoints == nil {
+		return nil, ErrCheckpointDisabled
+	}
+	if err := a.ensureMemory(); err != nil {
+		return nil, err
+	}
+
+	checkpoint, err := a.Checkpoints.LoadStep(ctx, runID, options.Step)
+	if err != nil {
+		return nil, err
+	}
+	state := checkpoint.State
+	memory := cloneMessages(checkpoint.Memory)
+	if err := applyMessageEdits(memory, options.Messages); err != nil {
+		return nil, err
+	}
+	if memory, err = applyToolResultEdits(&state, memory, options.ToolResults); err != nil {
+		return nil, err
+	}
+
+	run := *a
+	run.Hooks = append([]Hook(nil), a.Hooks...)
+	// 分叉运行使用自己的费用跟踪器，恢复来源快照的累计值不会覆盖当前 Agent 的花费。
+	run.useCostTracker(newChildCostTracker(a.Cost, nil, a.Config.MaxBudgetUSD))
+	if options.System != nil {
+		run.System = cloneMessages(options.System)
+	}
+	if options.Replay {
+		source, err := a.Checkpoints.Load(ctx, runID)
+		if err != nil {
+			return nil, err
+		}
+		// 回放排在所有 hook 之前，录制的回复与工具结果不会再被缓存类 hook 替换。
+		run.Hooks = append([]Hook{newReplayHook(source, options.Step)}, run.Hooks...)
+	}
+
+	sessionID := options.SessionID
+	if sessionID == "" {
+		session, err := a.Memory.CreateSession(ctx, checkpoint.Username, fmt.Sprintf("%s (fork@%d)", checkpoint.Task, options.Step))
+		if err != nil {
+			return nil, err
+		}
+		sessionID = session.ID
+	} else if _, err := a.Memory.SessionUsername(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	if err := a.Memory.ReplaceSessionMessages(ctx, sessionID, memory); err != nil {
+		return nil, err
+	}
+	if run.Cost != nil && checkpoint.Cost != nil {
+		run.Cost.Restore(*checkpoint.Cost)
+	}
+
+	maxSteps := checkpoint.MaxSteps
+	if options.MaxSteps > 0 {
+		maxSteps = options.MaxSteps
+	}
+	if maxSteps <= 0 {
+		maxSteps = a.maxSteps()
+	}
+
+	state.RunID = newRunID()
+	state.ParentRunID = runID
+	state.ForkStep = options.Step
+	state.SessionID = sessionID
+	state.StepIndex = len(state.Steps)
+	state.FinalAnswer = ""
+	state.StopReason = ""
+	applyPromptVars(ctx, &state)
+	// 分叉点的快照先落库，分叉运行在第一步之前失败时也能查到来源并再次分叉。
+	if err := run.saveCheckpoint(ctx, &state, maxSteps, RunStatusRunning, nil); err != nil {
+		return nil, err
+	}
+	result, err := run.runLoop(ctx, &state, maxSteps)
+	return run.finishRun(ctx, &state, result, err)
+}
+
+// RunLineage 返回从最早的祖先到 runID 的分叉链。
+func (a *Agent) RunLineage(ctx context.Context, runID string) ([]RunSummary, error) {
+	if a == nil || a.Checkpoints == nil {
+		return nil, ErrCheckpointDisabled
+	}
+	return a.Checkpoints.Lineage(ctx, runID)
+}
+
+func applyMessageEdits(memory []llmModel.Message, edits map[int]string) error {
+	for index, content := range edits {
+		if index < 0 || index >= len(memory) {
+			return fmt.Errorf("%w: message index %d out of range [0, %d)", ErrInvalidForkEdit, index, len(memory))
+		}
+		memory[index].Content = content
+	}
+	return nil
+}
+
+// applyToolResultEdits 改写工具消息并重建所在 step 的观察。调用原本没有结果（工具执行
+// 失败）时，新结果插到发起调用的 assistant 消息及其后已有工具消息之后。
+func applyToolResultEdits(state *State, memory []llmModel.Message, edits map[string]string) ([]llmModel.Message, error) {
+	for callID, content := range edits {
+		assistant := -1
+		replaced := false
+		for i := range memory {
+			if memory[i].Role == llmModel.RoleTool && memory[i].ToolCallId == callID {
+				memory[i].Content = content
+				replaced = true
+				break
+			}
+			for _, call := range memory[i].ToolCalls {
+				if call.ID == callID {
+					assistant = i
+				}
+			}
+		}
+		if replaced {
+			continue
+		}
+		if assistant < 0 {
+			return nil, fmt.Errorf("%w: tool call %s not found", ErrInvalidForkEdit, callID)
+		}
+		insert := assistant + 1
+		for insert < len(memory) && memory[insert].Role == llmModel.RoleTool {
+			insert++
+		}
+		memory = append(memory[:insert], append([]llmModel.Message{{Role: llmModel.RoleTool, Content: content, ToolCallId: callID}}, memory[insert:]...)...)
+	}
+	if len(edits) == 0 {
+		return memory, nil
+	}
+
+	results := toolResults(memory)
+	state.Steps = append([]Step(nil), state.Steps...)
+	for i := range state.Steps {
+		step := &state.Steps[i]
+		edited := false
+		for _, call := range step.Action.ToolCalls {
+			if _, ok := edits[call.ID]; ok {
+				edited = true
+			}
+		}
+		if !edited {
+			continue
+		}
+		observations := make([]string, 0, len(step.Action.ToolCalls))
+		step.ToolFailed = false
+		for _, call := range step.Action.ToolCalls {
+			result, ok := results[call.ID]
+			if !ok {
+				step.ToolFailed = true
+				continue
+			}
+			observations = append(observations, fmt.Sprintf("%s => %s", call.Name, result))
+		}
+		step.Observation = strings.Join(observations, "\n")
+	}
+	return memory, nil
+}
+
+func toolResults(memory []llmModel.Message) map[string]string {
+	results := make(map[string]string)
+	for _, message := range memory {
+		if message.Role == llmModel.RoleTool && message.ToolCallId != "" {
+			results[message.ToolCallId] = message.Content
+		}
+	}
+	return results
+}
+
+// replayHook 按顺序回放来源运行记录的模型回复，并用记录的结果短路同 ID 的工具调用，
+// 让分叉运行在不访问模型与工具的情况下重现来源运行。
+type replayHook struct {
+	BaseHook
+
+	mu      sync.Mutex
+	steps   []Step
+	next    int
+	results map[string]string
+	// failures 是记录中执行失败的调用，回放时以同样的错误结束。
+	failures map[string]string
+}
+
+func newReplayHook(source *Checkpoint, from int) *replayHook {
+	hook := &replayHook{results: toolResults(source.Memory), failures: make(map[string]string)}
+	if from < len(source.State.Steps) {
+		hook.steps = source.State.Steps[from:]
+	}
+	for _, step := range hook.steps {
+		if !step.ToolFailed {
+			continue
+		}
+		for _, call := range step.Action.ToolCalls {
+			if _, ok := hook.results[call.ID]; !ok {
+				hook.failures[call.ID] = strings.TrimPrefix(step.Observation, fmt.Sprintf("execute tool %s: ", call.Name))
+				break
+			}
+		}
+	}
+	return hook
+}
+
+func (h *replayHook) BeforePlan(_ context.Context, event *PlanHookEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.next >= len(h.steps) {
+		return nil
+	}
+	step := h.steps[h.next]
+	h.next++
+	response := llmModel.ChatResponse{
+		Reasoning:      step.Thought,
+		ReasoningItems: step.ReasoningItems,
+		Usage:          step.Metrics.Usage,
+	}
+	if step.Action.Kind == ActionKindFinish {
+		response.Content = step.Action.Answer
+	} else {
+		response.ToolCalls = step.Action.ToolCalls
+	}
+	event.Response = &response
+	return nil
+}
+
+func (h *replayHook) BeforeToolCall(_ context.Context, event *ToolHookEvent) error {
+	if result, ok := h.results[event.Call.ID]; ok {
+		event.Respond(result)
+		return nil
+	}
+	if message, ok := h.failures[event.Call.ID]; ok {
+		event.Err = errors.New(message)
+		event.Handled = true
+	}
+	return nil
+}
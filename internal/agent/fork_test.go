@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	"agent_study/pkg/tools"
+	toolTypes "agent_study/pkg/types"
+)
+
+func runWeatherForkSource(t *testing.T, store *CheckpointStore) *State {
+	t.Helper()
+
+	source := &Agent{
+		LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{
+			{Reasoning: "check the weather", ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{"city":"Shanghai"}`}}},
+			{Content: "Shanghai is sunny."},
+		}},
+		Tools:       newWeatherRegistry(t),
+		Config:      Config{MaxSteps: 4},
+		Checkpoints: store,
+	}
+	state, err := source.Run(context.Background(), "", "weather?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	return state
+}
+
+func TestForkContinuesFromStepWithEditedToolResultAndSystem(t *testing.T) {
+	store, err := NewCheckpointStore(newBareTestMemoryDB(t))
+	if err != nil {
+		t.Fatalf("NewCheckpointStore() error = %v", err)
+	}
+	source := runWeatherForkSource(t, store)
+
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "Shanghai is rainy."}}}
+	agent := &Agent{LLM: llm, Tools: newWeatherRegistry(t), Config: Config{MaxSteps: 4}, Checkpoints: store}
+	forked, err := agent.Fork(context.Background(), source.RunID, ForkOptions{
+		Step:        1,
+		ToolResults: map[string]string{"call_1": `{"condition":"rainy"}`},
+		System:      []llmModel.Message{{Role: llmModel.RoleSystem, Content: "Answer in one sentence."}},
+	})
+	if err != nil {
+		t.Fatalf("Fork() error = %v", err)
+	}
+	if forked.RunID == source.RunID || forked.ParentRunID != source.RunID || forked.ForkStep != 1 {
+		t.Fatalf("forked run = %q parent %q@%d, want new run forked from %q@1", forked.RunID, forked.ParentRunID, forked.ForkStep, source.RunID)
+	}
+	if forked.FinalAnswer != "Shanghai is rainy." || len(forked.Steps) != 2 || !strings.Contains(forked.Steps[0].Observation, "rainy") {
+		t.Fatalf("forked state = %#v, want the kept step with edited observation and a new answer", forked)
+	}
+	// 分叉后的请求使用新的 system prompt，并带着改写后的工具结果继续规划。
+	messages := llm.requests[0].Messages
+	if messages[0].Content != "Answer in one sentence." || messages[len(messages)-1].Content != `{"condition":"rainy"}` {
+		t.Fatalf("forked request messages = %#v, want overridden system and edited tool result", messages)
+	}
+
+	lineage, err := agent.RunLineage(context.Background(), forked.RunID)
+	if err != nil {
+		t.Fatalf("RunLineage() error = %v", err)
+	}
+	if len(lineage) != 2 || lineage[0].ID != source.RunID || lineage[1].ID != forked.RunID || lineage[1].Status != RunStatusCompleted {
+		t.Fatalf("lineage = %#v, want source then completed fork", lineage)
+	}
+	original, err := store.Load(context.Background(), source.RunID)
+	if err != nil || original.FinalAnswer != "Shanghai is sunny." {
+		t.Fatalf("source run = %#v, %v, want it unchanged", original, err)
+	}
+
+	if _, err := agent.Fork(context.Background(), source.RunID, ForkOptions{Step: 5}); !errors.Is(err, ErrStepNotFound) {
+		t.Fatalf("Fork() past the last step error = %v, want ErrStepNotFound", err)
+	}
+	if _, err := agent.Fork(context.Background(), source.RunID, ForkOptions{Step: 1, ToolResults: map[string]string{"missing": "x"}}); !errors.Is(err, ErrInvalidForkEdit) {
+		t.Fatalf("Fork() with unknown tool call error = %v, want ErrInvalidForkEdit", err)
+	}
+}
+
+func TestForkReplayReproducesRecordedRunWithoutModelOrTools(t *testing.T) {
+	store, err := NewCheckpointStore(newBareTestMemoryDB(t))
+	if err != nil {
+		t.Fatalf("NewCheckpointStore() error = %v", err)
+	}
+	source := runWeatherForkSource(t, store)
+
+	toolCalls := 0
+	registry := tools.NewRegistry()
+	if err := registry.Register(tools.Tool{
+		Name:       "lookup_weather",
+		Parameters: toolTypes.JSONSchema{Type: "object"},
+		Handler: func(context.Context, map[string]interface{}) (string, error) {
+			toolCalls++
+			return `{"condition":"snowy"}`, nil
+		},
+	}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	llm := &fakeLlmClient{}
+	agent := &Agent{LLM: llm, Tools: registry, Config: Config{MaxSteps: 4}, Checkpoints: store}
+
+	replayed, err := agent.Fork(context.Background(), source.RunID, ForkOptions{Replay: true})
+	if err != nil {
+		t.Fatalf("Fork() replay error = %v", err)
+	}
+	if len(llm.requests) != 0 || toolCalls != 0 {
+		t.Fatalf("llm requests = %d, tool calls = %d, want everything replayed", len(llm.requests), toolCalls)
+	}
+	if replayed.FinalAnswer != source.FinalAnswer || len(replayed.Steps) != len(source.Steps) {
+		t.Fatalf("replayed state = %#v, want the recorded answer and steps", replayed)
+	}
+	if replayed.Steps[0].Thought != "check the weather" || replayed.Steps[0].Observation != source.Steps[0].Observation {
+		t.Fatalf("replayed first step = %#v, want recorded thought and observation", replayed.Steps[0])
+	}
+}
+
+func TestForkKeepsItsOwnCostTracker(t *testing.T) {
+	store, err := NewCheckpointStore(newBareTestMemoryDB(t))
+	if err != nil {
+		t.Fatalf("NewCheckpointStore() error = %v", err)
+	}
+	pricing := toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}
+	sourceCost, err := NewCostTracker(pricing, 0)
+	if err != nil {
+		t.Fatalf("NewCostTracker() error = %v", err)
+	}
+	source := &Agent{
+		LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{
+			{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{}`}}, Usage: llmModel.TokenUsage{PromptTokens: 1000}},
+			{Content: "Shanghai is sunny.", Usage: llmModel.TokenUsage{PromptTokens: 1000}},
+		}},
+		Tools:       newWeatherRegistry(t),
+		Cost:        sourceCost,
+		Checkpoints: store,
+	}
+	state, err := source.Run(context.Background(), "", "weather?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	liveCost, err := NewCostTracker(pricing, 0)
+	if err != nil {
+		t.Fatalf("NewCostTracker() error = %v", err)
+	}
+	if _, err := liveCost.AddUsage(llmModel.TokenUsage{PromptTokens: 5000}); err != nil {
+		t.Fatalf("AddUsage() error = %v", err)
+	}
+	classifier := &LLMGuardrail{LLM: &fakeLlmClient{}, Cost: liveCost, Stages: []GuardrailStage{GuardrailStageInput}}
+	agent := &Agent{
+		LLM:         &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "Shanghai is rainy.", Usage: llmModel.TokenUsage{PromptTokens: 1000}}}},
+		Tools:       newWeatherRegistry(t),
+		Cost:        liveCost,
+		Guardrails:  []Guardrail{classifier},
+		Checkpoints: store,
+	}
+	forked, err := agent.Fork(context.Background(), state.RunID, ForkOptions{Step: 1})
+	if err != nil {
+		t.Fatalf("Fork() error = %v", err)
+	}
+	if got := liveCost.Totals().Usage.PromptTokens; got != 5000 {
+		t.Fatalf("live prompt tokens = %d, want the calling agent's totals untouched", got)
+	}
+	if agent.Guardrails[0] != classifier || classifier.Cost != liveCost {
+		t.Fatalf("guardrails = %#v, want the calling agent's guardrails untouched", agent.Guardrails)
+	}
+	checkpoint, err := store.Load(context.Background(), forked.RunID)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if checkpoint.Cost == nil || checkpoint.Cost.Usage.PromptTokens != 2000 {
+		t.Fatalf("fork cost = %#v, want source totals at step 1 plus the fork's own call", checkpoint.Cost)
+	}
+}
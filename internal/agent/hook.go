@@ -0,0 +1,285 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"agent_study/internal/log"
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+// Hook 是挂在 agent 生命周期上的中间件。多个 hook 按 Agent.Hooks 的顺序执行，前一个
+// 对事件的修改对后一个可见；Before*/After* 返回错误会让运行以该错误结束。
+// 嵌入 BaseHook 后只需实现关心的阶段。
+type Hook interface {
+	// BeforeInput 在用户任务或运行中注入的消息写入会话前执行，可改写 event.Content，
+	// 用 Block 拦截；拦截后后续 hook 的 BeforeInput 不再执行。
+	BeforeInput(ctx context.Context, event *InputHookEvent) error
+	// BeforePlan 在每次规划调用模型前执行，可改写 event.Request；设置 event.Response
+	// 会跳过模型调用并把它当作模型回复（例如回放缓存），后续 hook 的 BeforePlan 不再执行。
+	BeforePlan(ctx context.Context, event *PlanHookEvent) error
+	// AfterPlan 在模型回复后、解析动作前执行，可改写 event.Response。
+	AfterPlan(ctx context.Context, event *PlanHookEvent) error
+	// BeforeToolCall 在每次工具执行前执行，可改写 event.Arguments，用 Respond 以缓存结果
+	// 短路，或用 Veto 拒绝调用；短路或拒绝后后续 hook 的 BeforeToolCall 不再执行。
+	BeforeToolCall(ctx context.Context, event *ToolHookEvent) error
+	// AfterToolCall 在工具执行（或被短路）后执行，可改写 event.Result 与 event.Err。
+	AfterToolCall(ctx context.Context, event *ToolHookEvent) error
+	// BeforeAnswer 在最终回答通过输出校验与审查、写入会话和检查点之前执行，可改写
+	// event.Answer，用 Block 拦截；拦截后后续 hook 的 BeforeAnswer 不再执行。
+	BeforeAnswer(ctx context.Context, event *AnswerHookEvent) error
+	// OnFinish 在运行正常返回 State 时执行（包括达到上限提前结束、被 guardrail 拦截与交接），
+	// 可以修改 state.FinalAnswer。
+	OnFinish(ctx context.Context, state *State)
+	// OnError 在运行以错误结束时执行，state 是出错时的运行状态。
+	OnError(ctx context.Context, state *State, err error)
+}
+
+// BaseHook 是所有阶段都直接放行的 Hook，供具体实现嵌入。
+type BaseHook struct{}
+
+func (BaseHook) BeforeInput(context.Context, *InputHookEvent) error   { return nil }
+func (BaseHook) BeforePlan(context.Context, *PlanHookEvent) error     { return nil }
+func (BaseHook) AfterPlan(context.Context, *PlanHookEvent) error      { return nil }
+func (BaseHook) BeforeToolCall(context.Context, *ToolHookEvent) error { return nil }
+func (BaseHook) AfterToolCall(context.Context, *ToolHookEvent) error  { return nil }
+func (BaseHook) BeforeAnswer(context.Context, *AnswerHookEvent) error { return nil }
+func (BaseHook) OnFinish(context.Context, *State)                     {}
+func (BaseHook) OnError(context.Context, *State, error)               {}
+
+// InputHookEvent 是一条即将写入会话的用户输入。
+type InputHookEvent struct {
+	State   *State
+	Content string
+	// Injected 表示消息是运行中通过 RunController 注入的，否则是本次运行的用户任务。
+	Injected bool
+	// Blocked 是拦截原因，非空时这条输入不写入会话，也不会发给模型。
+	Blocked string
+}
+
+// Block 拦截这条输入：用户任务被拦截时运行以拦截说明结束，注入的消息直接丢弃。
+func (e *InputHookEvent) Block(reason string) {
+	e.Blocked = reason
+}
+
+// PlanHookEvent 是一次规划调用；Response 在 AfterPlan 中总是非空。
+type PlanHookEvent struct {
+	State    *State
+	Request  *llmModel.ChatRequest
+	Response *llmModel.ChatResponse
+}
+
+// ToolHookEvent 是一次工具调用。Call 是模型给出的原始调用（已写入会话，不受 hook 修改
+// 影响），实际执行使用 Arguments。
+type ToolHookEvent struct {
+	State     *State
+	Call      toolTypes.ToolCall
+	Arguments map[string]interface{}
+	// Result 与 Err 是工具输出；BeforeToolCall 中只在短路时设置。
+	Result string
+	Err    error
+	// Handled 表示调用已被短路，不会真正执行工具。
+	Handled bool
+	// Vetoed 是拒绝原因，非空时 Handled 也为 true。
+	Vetoed  string
+	Latency time.Duration
+}
+
+// AnswerHookEvent 是即将被接受的最终回答。
+type AnswerHookEvent struct {
+	State  *State
+	Answer string
+	// Blocked 是拦截原因，非空时运行以拦截说明代替回答结束。
+	Blocked string
+}
+
+// Block 拦截这次回答，原文不会写入会话或返回给调用方。
+func (e *AnswerHookEvent) Block(reason string) {
+	e.Blocked = reason
+}
+
+// HookError 是 hook 返回的错误。工具执行失败会作为观察交还模型，hook 失败则总是结束运行。
+type HookError struct {
+	Stage string
+	Err   error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook: %v", e.Stage, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+const toolVetoedTmpl = `工具 %s 的调用被拒绝：%s`
+
+// Respond 用 result 作为工具输出短路这次调用。
+func (e *ToolHookEvent) Respond(result string) {
+	e.Result = result
+	e.Err = nil
+	e.Handled = true
+}
+
+// Veto 拒绝这次调用；拒绝说明作为工具输出交还模型，运行继续。
+func (e *ToolHookEvent) Veto(reason string) {
+	e.Respond(fmt.Sprintf(toolVetoedTmpl, e.Call.Name, reason))
+	e.Vetoed = reason
+}
+
+// hooks 返回运行时生效的 hook 链：配置了 Guardrails 时在 Agent.Hooks 之后追加
+// GuardrailHook，使 guardrail 检查的是其它 hook 处理之后的内容。
+func (a *Agent) hooks() []Hook {
+	if len(a.Guardrails) == 0 {
+		return a.Hooks
+	}
+	hooks := make([]Hook, 0, len(a.Hooks)+1)
+	hooks = append(hooks, a.Hooks...)
+	return append(hooks, &GuardrailHook{Guardrails: a.Guardrails})
+}
+
+func (a *Agent) hookBeforeInput(ctx context.Context, event *InputHookEvent) error {
+	for _, hook := range a.hooks() {
+		if err := hook.BeforeInput(ctx, event); err != nil {
+			return &HookError{Stage: "before_input", Err: err}
+		}
+		if event.Blocked != "" {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (a *Agent) hookBeforePlan(ctx context.Context, event *PlanHookEvent) error {
+	for _, hook := range a.hooks() {
+		if err := hook.BeforePlan(ctx, event); err != nil {
+			return &HookError{Stage: "before_plan", Err: err}
+		}
+		if event.Response != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (a *Agent) hookAfterPlan(ctx context.Context, event *PlanHookEvent) error {
+	for _, hook := range a.hooks() {
+		if err := hook.AfterPlan(ctx, event); err != nil {
+			return &HookError{Stage: "after_plan", Err: err}
+		}
+	}
+	return nil
+}
+
+func (a *Agent) hookBeforeToolCall(ctx context.Context, event *ToolHookEvent) error {
+	for _, hook := range a.hooks() {
+		if err := hook.BeforeToolCall(ctx, event); err != nil {
+			return &HookError{Stage: "before_tool_call", Err: err}
+		}
+		if event.Handled {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (a *Agent) hookAfterToolCall(ctx context.Context, event *ToolHookEvent) error {
+	for _, hook := range a.hooks() {
+		if err := hook.AfterToolCall(ctx, event); err != nil {
+			return &HookError{Stage: "after_tool_call", Err: err}
+		}
+	}
+	return nil
+}
+
+func (a *Agent) hookBeforeAnswer(ctx context.Context, event *AnswerHookEvent) error {
+	for _, hook := range a.hooks() {
+		if err := hook.BeforeAnswer(ctx, event); err != nil {
+			return &HookError{Stage: "before_answer", Err: err}
+		}
+		if event.Blocked != "" {
+			return nil
+		}
+	}
+	return nil
+}
+
+// finishRun 在运行结束时依次通知 hook，并原样返回运行结果。
+func (a *Agent) finishRun(ctx context.Context, state *State, result *State, err error) (*State, error) {
+	hooks := a.hooks()
+	if len(hooks) == 0 {
+		return result, err
+	}
+	// 运行可能因 ctx 取消或超时结束，hook 仍需要能完成收尾。
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		for _, hook := range hooks {
+			hook.OnError(ctx, state, err)
+		}
+		return nil, err
+	}
+	for _, hook := range hooks {
+		hook.OnFinish(ctx, result)
+	}
+	return result, nil
+}
+
+// LoggingHook 把规划、工具调用与运行结果写入日志。
+type LoggingHook struct {
+	BaseHook
+}
+
+func (LoggingHook) AfterPlan(_ context.Context, event *PlanHookEvent) error {
+	log.Infof("agent plan: model=%s tool_calls=%d input_tokens=%d output_tokens=%d", event.Request.Model, len(event.Response.ToolCalls), event.Response.Usage.PromptTokens, event.Response.Usage.CompletionTokens)
+	return nil
+}
+
+func (LoggingHook) AfterToolCall(_ context.Context, event *ToolHookEvent) error {
+	switch {
+	case event.Vetoed != "":
+		log.Infof("agent tool %s vetoed: %s", event.Call.Name, event.Vetoed)
+	case event.Err != nil:
+		log.Warnf("agent tool %s failed after %s: %v", event.Call.Name, event.Latency, event.Err)
+	default:
+		log.Infof("agent tool %s finished in %s (cached=%t)", event.Call.Name, event.Latency, event.Handled)
+	}
+	return nil
+}
+
+func (LoggingHook) OnFinish(_ context.Context, state *State) {
+	log.Infof("agent run %s finished after %d steps, stop reason %q", state.RunID, state.StepIndex, state.StopReason)
+}
+
+func (LoggingHook) OnError(_ context.Context, state *State, err error) {
+	log.Warnf("agent run %s failed at step %d: %v", state.RunID, state.StepIndex, err)
+}
+
+// ToolApprover 决定是否允许一次工具调用；拒绝时 reason 会交还给模型。
+type ToolApprover func(ctx context.Context, call toolTypes.ToolCall, arguments map[string]interface{}) (approved bool, reason string, err error)
+
+// ToolApprovalHook 在 Tools 列出的工具执行前征求 Approve 的同意，Tools 为空时检查所有工具。
+type ToolApprovalHook struct {
+	BaseHook
+	Tools   []string
+	Approve ToolApprover
+}
+
+func (h *ToolApprovalHook) BeforeToolCall(ctx context.Context, event *ToolHookEvent) error {
+	if h.Approve == nil || (len(h.Tools) > 0 && !slices.Contains(h.Tools, event.Call.Name)) {
+		return nil
+	}
+	approved, reason, err := h.Approve(ctx, event.Call, event.Arguments)
+	if err != nil {
+		return err
+	}
+	if !approved {
+		if reason == "" {
+			reason = "未获批准"
+		}
+		event.Veto(reason)
+	}
+	return nil
+}
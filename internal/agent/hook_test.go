@@ -0,0 +1,284 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	"agent_study/pkg/tools"
+	toolTypes "agent_study/pkg/types"
+)
+
+// recordingHook 记录每个阶段的调用顺序，并按需改写事件。
+type recordingHook struct {
+	BaseHook
+	name       string
+	calls      *[]string
+	beforePlan func(*PlanHookEvent)
+	beforeTool func(*ToolHookEvent)
+	afterTool  func(*ToolHookEvent)
+	finished   *State
+	failed     error
+}
+
+func (h *recordingHook) BeforePlan(_ context.Context, event *PlanHookEvent) error {
+	*h.calls = append(*h.calls, h.name+".before_plan")
+	if h.beforePlan != nil {
+		h.beforePlan(event)
+	}
+	return nil
+}
+
+func (h *recordingHook) AfterPlan(_ context.Context, event *PlanHookEvent) error {
+	*h.calls = append(*h.calls, h.name+".after_plan")
+	return nil
+}
+
+func (h *recordingHook) BeforeToolCall(_ context.Context, event *ToolHookEvent) error {
+	*h.calls = append(*h.calls, h.name+".before_tool")
+	if h.beforeTool != nil {
+		h.beforeTool(event)
+	}
+	return nil
+}
+
+func (h *recordingHook) AfterToolCall(_ context.Context, event *ToolHookEvent) error {
+	*h.calls = append(*h.calls, h.name+".after_tool")
+	if h.afterTool != nil {
+		h.afterTool(event)
+	}
+	return nil
+}
+
+func (h *recordingHook) OnFinish(_ context.Context, state *State) {
+	*h.calls = append(*h.calls, h.name+".finish")
+	h.finished = state
+}
+
+func (h *recordingHook) OnError(_ context.Context, _ *State, err error) {
+	*h.calls = append(*h.calls, h.name+".error")
+	h.failed = err
+}
+
+func newHookTestAgent(t *testing.T, llm *fakeLlmClient, registry *tools.Registry, hooks ...Hook) *Agent {
+	t.Helper()
+	agent, err := NewAgent(NewAgentOptions{LLM: llm, Model: "test-model", Tools: registry, Hooks: hooks})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+	return agent
+}
+
+func TestHooksRunInOrderAndRewriteRequestsAndToolArguments(t *testing.T) {
+	var seenCity string
+	registry := tools.NewRegistry()
+	if err := registry.Register(tools.Tool{
+		Name:       "lookup_weather",
+		Parameters: toolTypes.JSONSchema{Type: "object"},
+		Handler: func(_ context.Context, arguments map[string]interface{}) (string, error) {
+			seenCity, _ = arguments["city"].(string)
+			return "sunny", nil
+		},
+	}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{"city":"Paris"}`}}},
+		{Content: "done"},
+	}}
+
+	var calls []string
+	first := &recordingHook{name: "first", calls: &calls, beforePlan: func(event *PlanHookEvent) {
+		event.Request.Model = "rewritten-model"
+	}, beforeTool: func(event *ToolHookEvent) {
+		event.Arguments["city"] = "Tokyo"
+	}}
+	second := &recordingHook{name: "second", calls: &calls, afterTool: func(event *ToolHookEvent) {
+		event.Result = strings.ToUpper(event.Result)
+	}}
+	agent := newHookTestAgent(t, llm, registry, first, second)
+
+	state, err := agent.Run(context.Background(), "", "weather?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if llm.requests[0].Model != "rewritten-model" {
+		t.Fatalf("request model = %q, want rewritten by BeforePlan", llm.requests[0].Model)
+	}
+	if seenCity != "Tokyo" {
+		t.Fatalf("tool city = %q, want rewritten by BeforeToolCall", seenCity)
+	}
+	if state.Steps[0].Observation != "lookup_weather => SUNNY" {
+		t.Fatalf("observation = %q, want result rewritten by AfterToolCall", state.Steps[0].Observation)
+	}
+	want := []string{
+		"first.before_plan", "second.before_plan", "first.after_plan", "second.after_plan",
+		"first.before_tool", "second.before_tool", "first.after_tool", "second.after_tool",
+		"first.before_plan", "second.before_plan", "first.after_plan", "second.after_plan",
+		"first.finish", "second.finish",
+	}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("hook calls = %v, want %v", calls, want)
+	}
+	if second.finished != state {
+		t.Fatal("OnFinish did not receive the returned state")
+	}
+}
+
+func TestHooksShortCircuitPlanAndToolCalls(t *testing.T) {
+	executed := 0
+	registry := tools.NewRegistry()
+	if err := registry.Register(tools.Tool{
+		Name:       "lookup_weather",
+		Parameters: toolTypes.JSONSchema{Type: "object"},
+		Handler: func(context.Context, map[string]interface{}) (string, error) {
+			executed++
+			return "sunny", nil
+		},
+	}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{
+			{ID: "call_1", Name: "lookup_weather", Arguments: `{}`},
+			{ID: "call_2", Name: "lookup_weather", Arguments: `{}`},
+		}},
+	}}
+
+	var calls []string
+	cache := &recordingHook{name: "cache", calls: &calls, beforePlan: func(event *PlanHookEvent) {
+		// 第二次规划直接返回缓存的回答，不再调用模型。
+		if len(event.State.Steps) > 0 {
+			event.Response = &llmModel.ChatResponse{Content: "cached answer"}
+		}
+	}, beforeTool: func(event *ToolHookEvent) {
+		if event.Call.ID == "call_1" {
+			event.Respond("cached weather")
+		}
+	}}
+	approval := &ToolApprovalHook{Tools: []string{"lookup_weather"}, Approve: func(_ context.Context, call toolTypes.ToolCall, _ map[string]interface{}) (bool, string, error) {
+		return false, "needs review", nil
+	}}
+	after := &recordingHook{name: "after", calls: &calls}
+	agent := newHookTestAgent(t, llm, registry, cache, approval, after)
+
+	state, err := agent.Run(context.Background(), "", "weather?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if executed != 0 {
+		t.Fatalf("tool executed %d times, want cached and vetoed calls to skip execution", executed)
+	}
+	if len(llm.requests) != 1 || state.FinalAnswer != "cached answer" {
+		t.Fatalf("requests = %d final = %q, want second plan served by BeforePlan", len(llm.requests), state.FinalAnswer)
+	}
+	wantObservation := "lookup_weather => cached weather\nlookup_weather => 工具 lookup_weather 的调用被拒绝：needs review"
+	if state.Steps[0].Observation != wantObservation {
+		t.Fatalf("observation = %q, want %q", state.Steps[0].Observation, wantObservation)
+	}
+	// 短路后后续 hook 的 BeforeToolCall 不再执行，AfterToolCall 仍全部执行。
+	if slices.Contains(calls, "after.before_tool") {
+		t.Fatalf("hook calls = %v, want no after.before_tool after short circuit", calls)
+	}
+	if got := strings.Count(strings.Join(calls, ","), "after.after_tool"); got != 2 {
+		t.Fatalf("after.after_tool called %d times, want 2", got)
+	}
+}
+
+func TestHooksReceiveRunErrors(t *testing.T) {
+	llm := &fakeLlmClient{chatErr: errors.New("llm down")}
+	var calls []string
+	hook := &recordingHook{name: "hook", calls: &calls}
+	agent := newHookTestAgent(t, llm, nil, hook)
+
+	_, err := agent.Run(context.Background(), "", "hello")
+	if err == nil {
+		t.Fatal("Run() error = nil, want llm error")
+	}
+	if !errors.Is(hook.failed, err) || strings.Join(calls, ",") != "hook.before_plan,hook.error" {
+		t.Fatalf("hook calls = %v failed = %v, want OnError with run error", calls, hook.failed)
+	}
+}
+
+type failingHook struct {
+	BaseHook
+}
+
+func (failingHook) BeforeToolCall(context.Context, *ToolHookEvent) error {
+	return errors.New("denied")
+}
+
+func TestHookErrorsEndTheRun(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{}`}}},
+	}}
+	agent := newHookTestAgent(t, llm, newWeatherRegistry(t), failingHook{})
+
+	_, err := agent.Run(context.Background(), "", "weather?")
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) || hookErr.Stage != "before_tool_call" || err.Error() != "before_tool_call hook: denied" {
+		t.Fatalf("Run() error = %v, want before_tool_call HookError", err)
+	}
+}
+
+// policyHook 不借助 Guardrails，直接在输入与最终回答阶段实现一条简单的内容策略。
+type policyHook struct {
+	BaseHook
+	agent *Agent
+	// sessionAtAnswer 是 BeforeAnswer 执行时会话里的消息。
+	sessionAtAnswer []llmModel.Message
+}
+
+func (h *policyHook) BeforeInput(_ context.Context, event *InputHookEvent) error {
+	event.Content = strings.ReplaceAll(event.Content, "hunter2", "***")
+	return nil
+}
+
+func (h *policyHook) BeforeAnswer(ctx context.Context, event *AnswerHookEvent) error {
+	h.sessionAtAnswer, _ = h.agent.Memory.SessionMessages(ctx, event.State.SessionID)
+	if strings.Contains(event.Answer, "secret") {
+		event.Block("leaks a secret")
+	}
+	return nil
+}
+
+func TestHooksGuardInputAndFinalAnswerBeforeTheyAreSaved(t *testing.T) {
+	store, err := NewCheckpointStore(newBareTestMemoryDB(t))
+	if err != nil {
+		t.Fatalf("NewCheckpointStore() error = %v", err)
+	}
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "the secret is hunter2"}}}
+	hook := &policyHook{}
+	agent := newHookTestAgent(t, llm, nil, hook)
+	agent.Checkpoints = store
+	hook.agent = agent
+
+	state, err := agent.Run(context.Background(), "", "my password is hunter2")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.Task != "my password is ***" || llm.requests[0].Messages[len(llm.requests[0].Messages)-1].Content != "my password is ***" {
+		t.Fatalf("task = %q, want rewritten before reaching the model", state.Task)
+	}
+	if state.StopReason != StopReasonGuardrail || !strings.Contains(state.FinalAnswer, "leaks a secret") {
+		t.Fatalf("state = %#v, want answer blocked by hook", state)
+	}
+	if len(hook.sessionAtAnswer) != 1 {
+		t.Fatalf("session at BeforeAnswer = %#v, want only the task before the answer is saved", hook.sessionAtAnswer)
+	}
+	checkpoint, err := store.Load(context.Background(), state.RunID)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	for _, message := range checkpoint.Memory {
+		if strings.Contains(message.Content, "secret is") {
+			t.Fatalf("checkpoint memory = %#v, want blocked answer never saved", checkpoint.Memory)
+		}
+	}
+	if last := checkpoint.Memory[len(checkpoint.Memory)-1]; last.Content != state.FinalAnswer {
+		t.Fatalf("last saved message = %#v, want the block notice", last)
+	}
+}
@@ -0,0 +1,198 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	llmTools "agent_study/pkg/llm_core/tools"
+	sharedTypes "agent_study/pkg/types"
+)
+
+var (
+	ErrTokenLimitExceeded = errors.New("agent token limit exceeded")
+	ErrDurationExceeded   = errors.New("agent duration limit exceeded")
+)
+
+// StopReason 说明运行因哪一项上限提前结束；正常完成时为空。
+type StopReason string
+
+const (
+	StopReasonBudget   StopReason = "budget"
+	StopReasonTokens   StopReason = "tokens"
+	StopReasonDuration StopReason = "duration"
+)
+
+// DownshiftOptions 描述预算不足时改用的更便宜模型：下一次调用按主模型预估会超出
+// 剩余预算、按它预估却不会时，这一次调用改用它。
+type DownshiftOptions struct {
+	// LLM 为空时复用 Agent 自己的客户端，即同一 provider 下换一个模型名。
+	LLM     llmModel.LlmClient
+	Model   string
+	Pricing sharedTypes.ModelPricing
+}
+
+const limitAnswerTmpl = `已达到%s，任务提前结束。以下是目前的进展：
+%s`
+
+const maxLimitProgressChars = 200
+
+var limitDescriptions = map[StopReason]string{
+	StopReasonBudget:   "费用预算上限",
+	StopReasonTokens:   "单次运行的 token 上限",
+	StopReasonDuration: "运行时长上限",
+	StopReasonReplans:  "计划重新规划次数上限",
+}
+
+// preflight 在发出规划调用前按最坏情况（预估 prompt token + MaxOutputTokens）检查
+// token 上限与剩余预算；tier 非空时先换成路由选中的模型，所选模型超预算时尝试降级。
+// 返回本次调用应使用的客户端，以及不同于 CostTracker 默认价格时的计费价格（否则为 nil）。
+func (a *Agent) preflight(state *State, request *llmModel.ChatRequest, tier *ModelTier) (llmModel.LlmClient, *sharedTypes.ModelPricing, error) {
+	llm := a.LLM
+	var pricing *sharedTypes.ModelPricing
+	if tier != nil {
+		request.Model = tier.Model
+		if tier.LLM != nil {
+			llm = tier.LLM
+		}
+		pricing = tier.Pricing
+	}
+	promptTokens := a.estimatePromptTokens(request)
+	outputTokens := a.Config.MaxOutputTokens
+	if a.Config.MaxTokens > 0 && state != nil {
+		if used := state.Stats.Usage.TotalTokens; used+promptTokens+outputTokens > a.Config.MaxTokens {
+			return nil, nil, fmt.Errorf("%w: %d used, next call may need %d of %d", ErrTokenLimitExceeded, used, promptTokens+outputTokens, a.Config.MaxTokens)
+		}
+	}
+	if a.Cost == nil {
+		return llm, pricing, nil
+	}
+	remaining, limited := a.Cost.availableBudgetUSD()
+	if !limited {
+		return llm, pricing, nil
+	}
+
+	callPricing := a.Cost.pricing
+	if pricing != nil {
+		callPricing = *pricing
+	}
+	estimate := estimateCallCost(promptTokens, outputTokens, callPricing)
+	if estimate <= remaining {
+		return llm, pricing, nil
+	}
+	if downshift := a.Downshift; downshift != nil && estimateCallCost(promptTokens, outputTokens, downshift.Pricing) <= remaining {
+		llm := downshift.LLM
+		if llm == nil {
+			llm = a.LLM
+		}
+		request.Model = downshift.Model
+		return llm, &downshift.Pricing, nil
+	}
+	return nil, nil, fmt.Errorf("%w: next call estimated at $%.4f, $%.4f remaining", ErrBudgetExceeded, estimate, remaining)
+}
+
+// estimatePromptTokens 用 TokenCounter 估算请求的 prompt token：消息正文、推理、
+// 工具调用参数以及工具声明都计入，未配置计数器时按 rune 近似。
+func (a *Agent) estimatePromptTokens(request *llmModel.ChatRequest) int64 {
+	counter := a.TokenCounter
+	if counter == nil {
+		counter, _ = llmTools.NewTokenCounter(llmTools.CountModeRune, "")
+	}
+	texts := make([]string, 0, len(request.Messages)+1)
+	for _, message := range request.Messages {
+		text := message.Content + message.Reasoning
+		for _, call := range message.ToolCalls {
+			text += call.Name + call.Arguments
+		}
+		texts = append(texts, text)
+	}
+	if len(request.Tools) > 0 {
+		if encoded, err := json.Marshal(request.Tools); err == nil {
+			texts = append(texts, string(encoded))
+		}
+	}
+	if request.ResponseFormat != nil {
+		if encoded, err := json.Marshal(request.ResponseFormat.Schema); err == nil {
+			texts = append(texts, string(encoded))
+		}
+	}
+	return int64(counter.CountMessages(texts))
+}
+
+func estimateCallCost(promptTokens int64, outputTokens int64, pricing sharedTypes.ModelPricing) float64 {
+	return calculateTokenPrice(promptTokens, pricing.Input) + calculateTokenPrice(outputTokens, pricing.Output)
+}
+
+// withDurationLimit 按 MaxDuration 扣除恢复前已用时长后给 ctx 加上截止时间，超时的
+// cause 是 ErrDurationExceeded，便于和调用方自己的取消区分。
+func (a *Agent) withDurationLimit(ctx context.Context, state *State) (context.Context, context.CancelFunc) {
+	if a.Config.MaxDuration <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, a.Config.MaxDuration-state.Stats.Duration, ErrDurationExceeded)
+}
+
+// limitReason 判断 err 是否由运行上限引起。
+func limitReason(ctx context.Context, err error) (StopReason, bool) {
+	switch {
+	case errors.Is(err, ErrBudgetExceeded):
+		return StopReasonBudget, true
+	case errors.Is(err, ErrTokenLimitExceeded):
+		return StopReasonTokens, true
+	case errors.Is(err, ErrReplanLimitExceeded):
+		return StopReasonReplans, true
+	case errors.Is(err, ErrDurationExceeded), errors.Is(context.Cause(ctx), ErrDurationExceeded):
+		return StopReasonDuration, true
+	}
+	return "", false
+}
+
+// checkLimits 在每一步开始前检查时长与 token 上限。
+func (a *Agent) checkLimits(ctx context.Context, state *State) error {
+	if errors.Is(context.Cause(ctx), ErrDurationExceeded) {
+		return ErrDurationExceeded
+	}
+	if a.Config.MaxTokens > 0 && state.Stats.Usage.TotalTokens >= a.Config.MaxTokens {
+		return fmt.Errorf("%w: %d of %d used", ErrTokenLimitExceeded, state.Stats.Usage.TotalTokens, a.Config.MaxTokens)
+	}
+	return nil
+}
+
+// stopAtLimit 在触发上限时体面地结束运行：用已有进展拼出最终回答（不再调用模型，
+// 避免继续花费），检查点记为 RunStatusLimited，调用方拿到的是正常的 State。
+func (a *Agent) stopAtLimit(ctx context.Context, state *State, maxSteps int, reason StopReason, cause error) (*State, error) {
+	// 时长上限触发时 ctx 已经过期，收尾写入不应再受它影响。
+	ctx = context.WithoutCancel(ctx)
+	state.StopReason = reason
+	state.FinalAnswer = fmt.Sprintf(limitAnswerTmpl, limitDescriptions[reason], limitProgress(state))
+	if err := a.remember(ctx, state, llmModel.Message{Role: llmModel.RoleAssistant, Content: state.FinalAnswer}); err != nil {
+		return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+	}
+	if err := a.saveCheckpoint(ctx, state, maxSteps, RunStatusLimited, cause); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// limitProgress 把计划清单和已完成 step 的观察/候选回答整理成进展列表。
+func limitProgress(state *State) string {
+	lines := make([]string, 0, len(state.Steps)+1)
+	if checklist := state.Plan.Checklist(); checklist != "" {
+		lines = append(lines, checklist)
+	}
+	for i, step := range state.Steps {
+		switch {
+		case step.Observation != "":
+			lines = append(lines, fmt.Sprintf("- 第 %d 步：%s", i+1, limitSummary(compactWhitespace(step.Observation), maxLimitProgressChars)))
+		case step.Action.Answer != "":
+			lines = append(lines, fmt.Sprintf("- 第 %d 步候选回答：%s", i+1, limitSummary(compactWhitespace(step.Action.Answer), maxLimitProgressChars)))
+		}
+	}
+	if len(lines) == 0 {
+		return "- 尚未取得可用的中间结果。"
+	}
+	return strings.Join(lines, "\n")
+}
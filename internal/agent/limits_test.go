@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	"agent_study/pkg/tools"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestPreflightDownshiftsToCheaperModelWithinBudget(t *testing.T) {
+	tracker, err := NewCostTracker(toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}, 0.5)
+	if err != nil {
+		t.Fatalf("NewCostTracker() error = %v", err)
+	}
+	main := &fakeLlmClient{}
+	cheap := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "ok", Usage: llmModel.TokenUsage{PromptTokens: 100, CompletionTokens: 100}}}}
+	agent := &Agent{
+		LLM:    main,
+		Model:  "big",
+		Cost:   tracker,
+		Config: Config{MaxOutputTokens: 1000},
+		Downshift: &DownshiftOptions{LLM: cheap, Model: "small", Pricing: toolTypes.ModelPricing{
+			Input:  toolTypes.TokenPrice{AmountUSD: 0.1, PerTokens: 1000},
+			Output: toolTypes.TokenPrice{AmountUSD: 0.1, PerTokens: 1000},
+		}},
+	}
+
+	state, err := agent.Run(context.Background(), "", "task")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.FinalAnswer != "ok" || state.StopReason != "" || len(main.requests) != 0 {
+		t.Fatalf("state = %#v, main requests = %d, want answer from downshifted model", state, len(main.requests))
+	}
+	if request := cheap.requests[0]; request.Model != "small" || request.MaxTokens != 1000 {
+		t.Fatalf("request = %#v, want downshifted model with output cap", request)
+	}
+	if metrics := state.Steps[0].Metrics; metrics.Model != "small" || metrics.Cost.TotalCostUSD > 0.0201 {
+		t.Fatalf("metrics = %#v, want cost at downshift pricing", metrics)
+	}
+}
+
+func TestRunStopsGracefullyAtTokenAndDurationLimits(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{}`}}, Usage: llmModel.TokenUsage{PromptTokens: 480, CompletionTokens: 40}},
+	}}
+	agent := &Agent{LLM: llm, Tools: newWeatherRegistry(t), Config: Config{MaxTokens: 500}}
+
+	state, err := agent.Run(context.Background(), "", "weather?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.StopReason != StopReasonTokens || !strings.Contains(state.FinalAnswer, `第 1 步：lookup_weather => {"condition":"sunny"}`) {
+		t.Fatalf("state = %#v, want token stop summarizing the tool result", state)
+	}
+	messages, _ := agent.Memory.SessionMessages(context.Background(), "")
+	if last := messages[len(messages)-1]; last.Role != llmModel.RoleAssistant || last.Content != state.FinalAnswer {
+		t.Fatalf("last message = %#v, want summary remembered", last)
+	}
+
+	registry := tools.NewRegistry()
+	if err := registry.Register(tools.Tool{Name: "slow", Handler: func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	slow := &Agent{
+		LLM:    &fakeLlmClient{responses: []llmModel.ChatResponse{{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "slow", Arguments: `{}`}}}}},
+		Tools:  registry,
+		Config: Config{MaxDuration: 20 * time.Millisecond},
+	}
+	state, err = slow.Run(context.Background(), "", "wait")
+	if err != nil || state.StopReason != StopReasonDuration || !strings.Contains(state.FinalAnswer, "运行时长上限") {
+		t.Fatalf("Run() = %#v, %v, want duration stop", state, err)
+	}
+}
@@ -1,76 +1,460 @@
 package agent
 
 import (
+	"agent_study/internal/log"
 	llmModel "agent_study/pkg/llm_core/model"
 	toolTypes "agent_study/pkg/types"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
-func (a *Agent) Run(ctx context.Context, task string) (*State, error) {
+const defaultMaxSteps = 32
+
+// Run 在指定会话里执行一次任务；sessionID 为空时使用进程内默认会话，否则会话必须
+// 已通过 MemoryManager.CreateSession 创建。
+func (a *Agent) Run(ctx context.Context, sessionID string, task string) (*State, error) {
+	return a.run(ctx, sessionID, task, nil)
+}
+
+// run 是 Run 与 RunTyped 的实现；output 非空时最终回答必须满足它描述的 schema。
+func (a *Agent) run(ctx context.Context, sessionID string, task string, output *outputSpec) (*State, error) {
 	if a == nil {
 		return nil, fmt.Errorf("agent is nil")
 	}
-	if a.Memory == nil {
-		memory, err := NewMemoryManager(MemoryOptions{})
-		if err != nil {
-			return nil, err
+	if err := a.ensureMemory(); err != nil {
+		return nil, err
+	}
+	if _, err := a.Memory.SessionUsername(ctx, sessionID); err != nil {
+		return nil, err
+	}
+
+	history, err := a.Memory.SessionMessages(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{SessionID: sessionID, MemoryOffset: len(history), Task: task, output: output}
+	applyPromptVars(ctx, state)
+	if a.Checkpoints != nil {
+		state.RunID = newRunID()
+	}
+	result, err := a.startRun(ctx, state)
+	return a.finishRun(ctx, state, result, err)
+}
+
+// startRun 经 BeforeInput hook 检查并记录用户任务后进入主循环。
+func (a *Agent) startRun(ctx context.Context, state *State) (*State, error) {
+	input := &InputHookEvent{State: state, Content: state.Task}
+	if err := a.hookBeforeInput(ctx, input); err != nil {
+		if reason, ok := limitReason(ctx, err); ok {
+			return a.stopAtLimit(ctx, state, a.maxSteps(), reason, err)
 		}
-		a.Memory = memory
+		return nil, err
 	}
+	if input.Blocked != "" {
+		// 被拦截的任务不写入会话，也不会发给模型。
+		state.FinalAnswer = fmt.Sprintf(guardrailInputBlockedTmpl, input.Blocked)
+		state.StopReason = StopReasonGuardrail
+		return state, nil
+	}
+	state.Task = input.Content
+	if err := a.remember(ctx, state, llmModel.Message{Role: llmModel.RoleUser, Content: state.Task}); err != nil {
+		return nil, err
+	}
+	// 第一步开始前先落一次检查点，作为 Fork 回到第 0 步（只保留用户任务）的快照。
+	if err := a.saveCheckpoint(ctx, state, a.maxSteps(), RunStatusRunning, nil); err != nil {
+		return nil, err
+	}
+
+	return a.runLoop(ctx, state, a.maxSteps())
+}
 
-	state := &State{Task: task}
-	a.Memory.AddMessage(llmModel.Message{Role: llmModel.RoleUser, Content: task})
+// Resume 从检查点里最后一个完成的 step 继续执行；State、短期记忆和累计费用都会先
+// 恢复成落库时的快照，再进入与 Run 相同的主循环。
+func (a *Agent) Resume(ctx context.Context, runID string, options ResumeOptions) (*State, error) {
+	if a == nil {
+		return nil, fmt.Errorf("agent is nil")
+	}
+	if a.Checkpoints == nil {
+		return nil, ErrCheckpointDisabled
+	}
+	if err := a.ensureMemory(); err != nil {
+		return nil, err
+	}
 
-	maxSteps := a.Config.MaxSteps
+	checkpoint, err := a.Checkpoints.Load(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint.Status == RunStatusCompleted {
+		return nil, fmt.Errorf("%w: run %s already completed", ErrRunNotResumable, runID)
+	}
+
+	maxSteps := checkpoint.MaxSteps
+	if options.MaxSteps > 0 {
+		maxSteps = options.MaxSteps
+	}
 	if maxSteps <= 0 {
-		maxSteps = 32
+		maxSteps = a.maxSteps()
+	}
+
+	// 运行记录可能是中途失败时写入的，会话里会留下没有工具结果的调用；恢复总是从最后
+	// 一个完成的 step 的不可变快照开始。
+	snapshot, err := a.Checkpoints.LoadStep(ctx, runID, checkpoint.StepIndex)
+	if err != nil {
+		return nil, err
+	}
+	state := snapshot.State
+	state.RunID = checkpoint.ID
+	state.StepIndex = len(state.Steps)
+	applyPromptVars(ctx, &state)
+	if err := a.Memory.ReplaceSessionMessages(ctx, state.SessionID, snapshot.Memory); err != nil {
+		return nil, err
+	}
+	if a.Cost != nil && snapshot.Cost != nil {
+		a.Cost.Restore(*snapshot.Cost)
 	}
 
-	for range maxSteps {
-		action, thought, reasoningItems, err := a.Plan(ctx, state)
+	result, err := a.runLoop(ctx, &state, maxSteps)
+	return a.finishRun(ctx, &state, result, err)
+}
+
+// runLoop 是 Run 与 Resume 共用的主循环；maxSteps 表示整个运行（含恢复前已完成的
+// step）允许的总步数。
+func (a *Agent) runLoop(ctx context.Context, state *State, maxSteps int) (*State, error) {
+	// 运行期间产生的记忆变更（抽取、forget_memory 工具）都归属到这次运行。
+	ctx = WithMemoryAuditSource(ctx, MemoryAuditSource{Actor: MemoryActorAgent, RunID: state.RunID, SessionID: state.SessionID})
+	// 子 agent 工具通过 ctx 找到发起调用的运行，以便嵌套事件与汇总费用。
+	ctx = withParentRun(ctx, a, state)
+	ctx, cancel := a.withDurationLimit(ctx, state)
+	defer cancel()
+	// 上一次运行可能因上限提前结束，恢复后重新判断。
+	state.StopReason = ""
+	if a.Config.Mode == ModePlanExecute && state.Plan == nil {
+		plan, err := a.MakePlan(ctx, state)
 		if err != nil {
-			return nil, err
+			if reason, ok := limitReason(ctx, err); ok {
+				return a.stopAtLimit(ctx, state, maxSteps, reason, err)
+			}
+			return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, fmt.Errorf("make plan: %w", err))
+		}
+		state.Plan = plan
+	}
+	for state.StepIndex < maxSteps {
+		// 暂停发生在 step 之间；继续后先把运行中注入的用户消息写入会话，让这一步的规划能看到。
+		controller := RunControllerFrom(ctx)
+		if err := controller.wait(ctx); err != nil {
+			if reason, ok := limitReason(ctx, err); ok {
+				return a.stopAtLimit(ctx, state, maxSteps, reason, err)
+			}
+			return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+		}
+		injected, err := a.rememberInjected(ctx, state, controller.drain())
+		if err != nil {
+			if reason, ok := limitReason(ctx, err); ok {
+				return a.stopAtLimit(ctx, state, maxSteps, reason, err)
+			}
+			return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+		}
+		if err := a.checkLimits(ctx, state); err != nil {
+			reason, _ := limitReason(ctx, err)
+			return a.stopAtLimit(ctx, state, maxSteps, reason, err)
+		}
+		started := time.Now()
+		action, thought, reasoningItems, metrics, err := a.plan(ctx, state)
+		if err != nil {
+			if reason, ok := limitReason(ctx, err); ok {
+				return a.stopAtLimit(ctx, state, maxSteps, reason, err)
+			}
+			return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
 		}
 
-		trace := Step{Thought: thought, ReasoningItems: reasoningItems, Action: *action}
+		trace := Step{Agent: a.Name, Injected: injected, Thought: thought, ReasoningItems: reasoningItems, Action: *action, Metrics: metrics}
+		if current := state.Plan.Current(); current != nil {
+			trace.PlanItemID = current.ID
+		}
 		switch action.Kind {
 		case ActionKindToolCalls:
 			if len(action.ToolCalls) == 0 {
-				return nil, fmt.Errorf("tool_calls action missing tool calls")
+				return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, fmt.Errorf("tool_calls action missing tool calls"))
 			}
 			normalizedCalls := normalizeToolCalls(action.ToolCalls, state.StepIndex+1)
 			action.ToolCalls = normalizedCalls
 			trace.Action = *action
+			if call, ok := findHandoff(state, normalizedCalls); ok {
+				handedOff, err := a.handoff(ctx, state, &trace, call)
+				if err != nil {
+					return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+				}
+				if !handedOff {
+					// 交接目标不合法时把错误作为观察交还给当前 agent，继续迭代。
+					break
+				}
+				// 交接成功：记录这一步后把控制权交还给 Orchestrator。
+				trace = state.appendStep(trace, started)
+				if err := a.saveCheckpoint(ctx, state, maxSteps, RunStatusRunning, nil); err != nil {
+					return nil, err
+				}
+				a.emitStep(a.stepEvent(state, trace))
+				return state, nil
+			}
 			// 工具调用前先把 assistant 的 reasoning/reasoning items 写回短期记忆，
 			// 这样下一轮规划时 provider 可以按要求回放完整推理上下文。
-			a.Memory.AddMessage(llmModel.Message{Role: llmModel.RoleAssistant, Reasoning: thought, ReasoningItems: reasoningItems, ToolCalls: normalizedCalls})
-			observation, err := a.executeToolCalls(ctx, normalizedCalls)
+			if err := a.remember(ctx, state, llmModel.Message{Role: llmModel.RoleAssistant, Reasoning: thought, ReasoningItems: reasoningItems, ToolCalls: normalizedCalls}); err != nil {
+				return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+			}
+			observation, toolMetrics, err := a.executeToolCalls(ctx, state, normalizedCalls)
+			trace.Metrics.ToolCalls = toolMetrics
+			var hookErr *HookError
+			if errors.As(err, &hookErr) {
+				return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+			}
 			if err != nil {
 				trace.Observation = err.Error()
+				trace.ToolFailed = true
 			} else {
 				trace.Observation = observation
 			}
+			detection, err := a.detectRepetition(ctx, state, trace)
+			if err != nil {
+				return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+			}
+			trace.Loop = detection
+			if detection != nil && detection.Policy == LoopPolicyAbort {
+				// 先记录触发检测的这一步，事件与检查点里都能看到重复轨迹。
+				trace = state.appendStep(trace, started)
+				a.emitStep(a.stepEvent(state, trace))
+				return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, &LoopError{Detection: *detection})
+			}
 		case ActionKindFinish:
-			state.FinalAnswer = action.Answer
 			// 最终回答同样保留 reasoning 元信息，便于测试、追踪和后续兼容更多 provider。
-			a.Memory.AddMessage(llmModel.Message{Role: llmModel.RoleAssistant, Content: action.Answer, Reasoning: thought, ReasoningItems: reasoningItems})
-			state.Steps = append(state.Steps, trace)
-			state.StepIndex = len(state.Steps)
-			a.emitStep(StepEvent{Index: state.StepIndex, Step: trace})
+			// 回答只在不被接受（继续迭代）或经 BeforeAnswer hook 检查之后才写入会话。
+			answerMessage := llmModel.Message{Role: llmModel.RoleAssistant, Content: action.Answer, Reasoning: thought, ReasoningItems: reasoningItems}
+			if controller.pending() {
+				// 回答生成期间用户又注入了纠偏消息：不接受这次回答，带着新消息继续迭代。
+				if err := a.remember(ctx, state, answerMessage); err != nil {
+					return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+				}
+				break
+			}
+			if state.Plan != nil {
+				// 计划模式下 finish 只代表当前计划项结束，计划全部完成后才真正结束运行。
+				finished, err := a.advancePlan(ctx, state, action.Answer)
+				if err != nil {
+					state.appendStep(trace, started)
+					if reason, ok := limitReason(ctx, err); ok {
+						return a.stopAtLimit(ctx, state, maxSteps, reason, err)
+					}
+					return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+				}
+				if !finished {
+					if err := a.remember(ctx, state, answerMessage); err != nil {
+						return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+					}
+					break
+				}
+			}
+			feedback, err := a.checkOutput(ctx, state, &trace, action.Answer)
+			if err != nil {
+				return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+			}
+			if feedback == "" {
+				feedback, err = a.reflect(ctx, state, &trace, action.Answer)
+				if err != nil {
+					if reason, ok := limitReason(ctx, err); ok {
+						return a.stopAtLimit(ctx, state, maxSteps, reason, err)
+					}
+					return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+				}
+			}
+			if feedback != "" {
+				// 结构化回答未通过校验或被审查打回：回答和修正意见一起写入会话，记录这一步
+				// （含校验错误或审查意见）后继续迭代。
+				for _, message := range []llmModel.Message{answerMessage, {Role: llmModel.RoleUser, Content: feedback}} {
+					if err := a.remember(ctx, state, message); err != nil {
+						return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+					}
+				}
+				break
+			}
+			answer := &AnswerHookEvent{State: state, Answer: action.Answer}
+			if err := a.hookBeforeAnswer(ctx, answer); err != nil {
+				if reason, ok := limitReason(ctx, err); ok {
+					return a.stopAtLimit(ctx, state, maxSteps, reason, err)
+				}
+				return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+			}
+			if answer.Blocked != "" {
+				answer.Answer = fmt.Sprintf(guardrailOutputBlockedTmpl, answer.Blocked)
+				state.StopReason = StopReasonGuardrail
+			}
+			answerMessage.Content = answer.Answer
+			if err := a.remember(ctx, state, answerMessage); err != nil {
+				return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, err)
+			}
+			state.FinalAnswer = answer.Answer
+			trace = state.appendStep(trace, started)
+			if err := a.saveCheckpoint(ctx, state, maxSteps, RunStatusCompleted, nil); err != nil {
+				return nil, err
+			}
+			a.extractRunMemories(ctx, state)
+			a.emitStep(a.stepEvent(state, trace))
 			return state, nil
 		default:
-			return nil, fmt.Errorf("unsupported action kind: %s", action.Kind)
+			return nil, a.failRun(ctx, state, maxSteps, RunStatusFailed, fmt.Errorf("unsupported action kind: %s", action.Kind))
 		}
 
-		state.Steps = append(state.Steps, trace)
-		state.StepIndex = len(state.Steps)
-		a.emitStep(StepEvent{Index: state.StepIndex, Step: trace})
+		trace = state.appendStep(trace, started)
+		if err := a.saveCheckpoint(ctx, state, maxSteps, RunStatusRunning, nil); err != nil {
+			return nil, err
+		}
+		a.emitStep(a.stepEvent(state, trace))
+	}
+
+	return nil, a.failRun(ctx, state, maxSteps, RunStatusMaxSteps, fmt.Errorf("agent stopped after reaching max steps: %d", maxSteps))
+}
+
+// rememberInjected 让运行中注入的用户消息逐条经过 BeforeInput hook 后写入会话，返回
+// 实际写入的内容；被拦截的消息直接丢弃。
+func (a *Agent) rememberInjected(ctx context.Context, state *State, messages []string) ([]string, error) {
+	var injected []string
+	for _, message := range messages {
+		event := &InputHookEvent{State: state, Content: message, Injected: true}
+		if err := a.hookBeforeInput(ctx, event); err != nil {
+			return nil, err
+		}
+		if event.Blocked != "" {
+			continue
+		}
+		if err := a.remember(ctx, state, llmModel.Message{Role: llmModel.RoleUser, Content: event.Content}); err != nil {
+			return nil, err
+		}
+		injected = append(injected, event.Content)
 	}
+	return injected, nil
+}
 
-	return nil, fmt.Errorf("agent stopped after reaching max steps: %d", maxSteps)
+// extractRunMemories 在运行完成后从本次运行新增的消息里抽取事实级记忆，并顺带清理
+// 衰减到阈值以下的旧记忆；两者都只是锦上添花，失败只记录日志，不影响已经得到的最终回答。
+func (a *Agent) extractRunMemories(ctx context.Context, state *State) {
+	if !a.Memory.MemoryItemsEnabled() {
+		return
+	}
+	username, err := a.Memory.SessionUsername(ctx, state.SessionID)
+	if err != nil {
+		log.Warnf("extract memories: %v", err)
+		return
+	}
+	messages, err := a.Memory.SessionMessages(ctx, state.SessionID)
+	if err != nil {
+		log.Warnf("extract memories: %v", err)
+		return
+	}
+	if state.MemoryOffset > 0 && state.MemoryOffset <= len(messages) {
+		messages = messages[state.MemoryOffset:]
+	}
+	if _, err := a.Memory.ExtractMemories(ctx, username, state.RunID, state.SessionID, messages); err != nil {
+		log.Warnf("extract memories: %v", err)
+	}
+	if _, err := a.Memory.DecayMemories(ctx, username); err != nil {
+		log.Warnf("decay memories: %v", err)
+	}
+}
+
+func (a *Agent) ensureMemory() error {
+	if a.Memory != nil {
+		return nil
+	}
+	memory, err := NewMemoryManager(MemoryOptions{})
+	if err != nil {
+		return err
+	}
+	a.Memory = memory
+	return nil
+}
+
+// remember 把消息写入当前运行所属会话的短期记忆。
+func (a *Agent) remember(ctx context.Context, state *State, message llmModel.Message) error {
+	if err := a.Memory.AddSessionMessage(ctx, state.SessionID, message); err != nil {
+		return fmt.Errorf("append session message: %w", err)
+	}
+	return nil
+}
+
+func (a *Agent) maxSteps() int {
+	if a.Config.MaxSteps <= 0 {
+		return defaultMaxSteps
+	}
+	return a.Config.MaxSteps
+}
+
+// failRun 把失败原因写进检查点后原样返回错误；检查点本身写入失败时两者一起返回，
+// 避免调用方误以为运行仍可恢复。
+func (a *Agent) failRun(ctx context.Context, state *State, maxSteps int, status RunStatus, runErr error) error {
+	if err := a.saveCheckpoint(ctx, state, maxSteps, status, runErr); err != nil {
+		return errors.Join(runErr, err)
+	}
+	return runErr
+}
+
+func (a *Agent) saveCheckpoint(ctx context.Context, state *State, maxSteps int, status RunStatus, runErr error) error {
+	if a.Checkpoints == nil || state == nil || state.RunID == "" {
+		return nil
+	}
+
+	// 检查点读取会话数据时同样不受 ctx 取消影响，与下面的写入保持一致。
+	saveCtx := context.WithoutCancel(ctx)
+	username, err := a.Memory.SessionUsername(saveCtx, state.SessionID)
+	if err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	memory, err := a.Memory.SessionMessages(saveCtx, state.SessionID)
+	if err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+
+	checkpoint := Checkpoint{
+		RunSummary: RunSummary{
+			ID:          state.RunID,
+			Username:    username,
+			Task:        state.Task,
+			Status:      status,
+			StepIndex:   state.StepIndex,
+			MaxSteps:    maxSteps,
+			FinalAnswer: state.FinalAnswer,
+			ParentRunID: state.ParentRunID,
+			ForkStep:    state.ForkStep,
+		},
+		State:  *state,
+		Memory: memory,
+	}
+	if runErr != nil {
+		checkpoint.Error = runErr.Error()
+	}
+	if a.Cost != nil {
+		totals := a.Cost.Totals()
+		checkpoint.Cost = &totals
+	}
+	// 检查点写入不应被已经取消的 ctx 阻断，否则恰好在取消时失败的运行会丢掉最后状态。
+	if err := a.Checkpoints.Save(saveCtx, checkpoint); err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// stepEvent 组装对外发布的 step 事件；计划模式下附带计划快照与进度。
+func (a *Agent) stepEvent(state *State, step Step) StepEvent {
+	return StepEvent{
+		RunID:    state.RunID,
+		Index:    state.StepIndex,
+		Step:     step,
+		Plan:     state.Plan.Clone(),
+		Progress: state.Plan.Progress(),
+		Stats:    state.Stats,
+	}
 }
 
 func (a *Agent) emitStep(event StepEvent) {
@@ -80,33 +464,57 @@ func (a *Agent) emitStep(event StepEvent) {
 	a.StepCallback(event)
 }
 
-func (a *Agent) executeToolCalls(ctx context.Context, calls []toolTypes.ToolCall) (string, error) {
+// executeToolCalls 依次执行工具调用，返回合并后的观察和每次调用的耗时；出错时
+// 已完成调用的耗时仍会返回。
+func (a *Agent) executeToolCalls(ctx context.Context, state *State, calls []toolTypes.ToolCall) (string, []ToolCallMetrics, error) {
 	if a.Tools == nil {
-		return "", fmt.Errorf("tool registry is not configured")
+		return "", nil, fmt.Errorf("tool registry is not configured")
 	}
 
 	observations := make([]string, 0, len(calls))
+	metrics := make([]ToolCallMetrics, 0, len(calls))
 	for _, call := range calls {
 		arguments, err := decodeToolArguments(call.Arguments)
 		if err != nil {
-			return "", fmt.Errorf("decode tool arguments for %s: %w", call.Name, err)
+			return "", metrics, fmt.Errorf("decode tool arguments for %s: %w", call.Name, err)
 		}
 
-		callCtx := ctx
-		cancel := func() {}
-		if a.Config.ToolTimeout > 0 {
-			callCtx, cancel = context.WithTimeout(ctx, a.Config.ToolTimeout)
+		event := &ToolHookEvent{State: state, Call: call, Arguments: arguments}
+		if err := a.hookBeforeToolCall(ctx, event); err != nil {
+			return "", metrics, err
 		}
-		result, err := a.Tools.Execute(callCtx, call.Name, arguments)
-		cancel()
+		if !event.Handled {
+			callCtx, release := RunControllerFrom(ctx).toolContext(ctx)
+			cancel := func() {}
+			if a.Config.ToolTimeout > 0 {
+				callCtx, cancel = context.WithTimeout(callCtx, a.Config.ToolTimeout)
+			}
+			started := time.Now()
+			event.Result, event.Err = a.executeTool(callCtx, state, call.Name, event.Arguments)
+			cancelled := errors.Is(context.Cause(callCtx), ErrToolCancelled)
+			cancel()
+			release()
+			event.Latency = time.Since(started)
+			if cancelled {
+				// 用户只取消了这一次工具调用：把取消结果作为工具输出交还模型，继续后续调用。
+				event.Result, event.Err = ErrToolCancelled.Error(), nil
+			}
+		}
+		metrics = append(metrics, ToolCallMetrics{CallID: call.ID, Name: call.Name, Latency: event.Latency})
+		if err := a.hookAfterToolCall(ctx, event); err != nil {
+			return "", metrics, err
+		}
+		result, err := event.Result, event.Err
 		if err != nil {
-			return "", fmt.Errorf("execute tool %s: %w", call.Name, err)
+			return "", metrics, fmt.Errorf("execute tool %s: %w", call.Name, err)
 		}
 
-		a.Memory.AddMessage(llmModel.Message{Role: llmModel.RoleTool, Content: result, ToolCallId: call.ID})
+		if err := a.remember(ctx, state, llmModel.Message{Role: llmModel.RoleTool, Content: result, ToolCallId: call.ID}); err != nil {
+			return "", metrics, err
+		}
 		observations = append(observations, fmt.Sprintf("%s => %s", call.Name, result))
 	}
-	return strings.Join(observations, "\n"), nil
+	return strings.Join(observations, "\n"), metrics, nil
 }
 
 func decodeToolArguments(raw string) (map[string]interface{}, error) {
@@ -60,7 +60,7 @@ func TestRunExecutesToolCallsAndFinishes(t *testing.T) {
 		Config: Config{MaxSteps: 4},
 	}
 
-	state, err := agent.Run(context.Background(), "What is the weather in Shanghai?")
+	state, err := agent.Run(context.Background(), "", "What is the weather in Shanghai?")
 	if err != nil {
 		t.Fatalf("Run() error = %v", err)
 	}
@@ -173,7 +173,7 @@ func TestRunPersistsAssistantReasoningItemsForToolCalls(t *testing.T) {
 	}
 
 	agent := &Agent{LLM: llm, Tools: registry, Memory: memory, Config: Config{MaxSteps: 3}}
-	_, err = agent.Run(context.Background(), "check weather")
+	_, err = agent.Run(context.Background(), "", "check weather")
 	if err != nil {
 		t.Fatalf("Run() error = %v", err)
 	}
@@ -220,7 +220,7 @@ func TestRunNormalizesMissingToolCallID(t *testing.T) {
 
 	agent := &Agent{LLM: llm, Tools: registry, Memory: memory, Config: Config{MaxSteps: 3}}
 
-	state, err := agent.Run(context.Background(), "check weather")
+	state, err := agent.Run(context.Background(), "", "check weather")
 	if err != nil {
 		t.Fatalf("Run() error = %v", err)
 	}
@@ -279,7 +279,7 @@ func TestRunInvokesStepCallbackForEachCompletedStep(t *testing.T) {
 		},
 	}
 
-	state, err := agent.Run(context.Background(), "What is the weather in Shanghai?")
+	state, err := agent.Run(context.Background(), "", "What is the weather in Shanghai?")
 	if err != nil {
 		t.Fatalf("Run() error = %v", err)
 	}
@@ -336,7 +336,7 @@ func TestRunReturnsErrorWhenToolArgumentsAreInvalidJSON(t *testing.T) {
 		Config: Config{MaxSteps: 1},
 	}
 
-	_, err = agent.Run(context.Background(), "check weather")
+	_, err = agent.Run(context.Background(), "", "check weather")
 	if err == nil || !strings.Contains(err.Error(), "decode tool arguments") {
 		t.Fatalf("Run() error = %v, want decode tool arguments error", err)
 	}
@@ -369,13 +369,13 @@ func TestRunReturnsErrorWhenMaxStepsReached(t *testing.T) {
 		Config: Config{MaxSteps: 1},
 	}
 
-	_, err = agent.Run(context.Background(), "check weather")
+	_, err = agent.Run(context.Background(), "", "check weather")
 	if err == nil || !strings.Contains(err.Error(), "max steps") {
 		t.Fatalf("Run() error = %v, want max steps error", err)
 	}
 }
 
-func TestRunPropagatesBudgetExceededFromPlanner(t *testing.T) {
+func TestRunStopsGracefullyWhenNextCallWouldExceedBudget(t *testing.T) {
 	memory, err := NewMemoryManager(MemoryOptions{})
 	if err != nil {
 		t.Fatalf("NewMemoryManager() error = %v", err)
@@ -389,18 +389,25 @@ func TestRunPropagatesBudgetExceededFromPlanner(t *testing.T) {
 		t.Fatalf("NewCostTracker() error = %v", err)
 	}
 
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{
+		Content: "done",
+		Usage:   llmModel.TokenUsage{PromptTokens: 10, CompletionTokens: 10, TotalTokens: 20},
+	}}}
 	agent := &Agent{
-		LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{{
-			Content: "done",
-			Usage:   llmModel.TokenUsage{PromptTokens: 10, CompletionTokens: 10, TotalTokens: 20},
-		}}},
+		LLM:    llm,
 		Memory: memory,
 		Cost:   tracker,
 	}
 
-	_, err = agent.Run(context.Background(), "check weather")
-	if !errors.Is(err, ErrBudgetExceeded) {
-		t.Fatalf("Run() error = %v, want ErrBudgetExceeded", err)
+	state, err := agent.Run(context.Background(), "", "check weather")
+	if err != nil {
+		t.Fatalf("Run() error = %v, want graceful stop", err)
+	}
+	if state.StopReason != StopReasonBudget || !strings.Contains(state.FinalAnswer, "费用预算上限") {
+		t.Fatalf("state = %#v, want budget stop with progress summary", state)
+	}
+	if len(llm.requests) != 0 || tracker.Totals().Cost.TotalCostUSD != 0 {
+		t.Fatalf("requests = %d, cost = %v, want pre-flight refusal before spending", len(llm.requests), tracker.Totals().Cost.TotalCostUSD)
 	}
 }
 
@@ -3,6 +3,7 @@ package agent
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"sync"
 
@@ -28,25 +29,35 @@ type MemoryCompressor func(ctx context.Context, currentSummary string, session [
 
 // MemoryOptions 控制记忆能力是仅保存在进程内，还是额外持久化到配置的数据库中。
 type MemoryOptions struct {
-	DB              *gorm.DB
+	DB *gorm.DB
+	// Username 是默认会话（sessionID 为空）以及未显式指定用户的新会话所归属的用户。
 	Username        string
 	Compressor      MemoryCompressor
 	MaxSummaryChars int
+	// Compression 仅在 Compressor 为空时生效，用于按配置选择内置压缩器。
+	Compression MemoryCompressionOptions
+	// Sessions 可选；为空时若配置了 DB 则使用 SQLite 会话存储，否则使用进程内存储。
+	Sessions SessionStore
+	// Items 启用事实级长期记忆；启用时必须同时提供 DB。
+	Items MemoryItemOptions
 }
 
 // MemoryManager 负责维护短期消息的防御性拷贝，并在启用数据库时把压缩后的历史
-// 同步到长期存储。
+// 同步到长期存储。短期消息按会话隔离：sessionID 为空的默认会话只保存在进程内，
+// 具名会话交给 SessionStore；长期记忆则始终按用户名维度跨会话共享。
 type MemoryManager struct {
 	mu              sync.RWMutex
 	shortTerm       []llmModel.Message
+	username        string
+	sessions        SessionStore
 	compressor      MemoryCompressor
 	maxSummaryChars int
 	longTerm        *longTermMemoryStore
+	items           *memoryItemStore
 }
 
 type longTermMemoryStore struct {
-	db       *gorm.DB
-	username string
+	db *gorm.DB
 }
 
 // NewMemoryManager 会先补齐默认配置，只有在显式传入数据库句柄时才启用长期记忆。
@@ -58,14 +69,31 @@ func NewMemoryManager(options MemoryOptions) (*MemoryManager, error) {
 
 	compressor := options.Compressor
 	if compressor == nil {
-		compressor = newSimpleMemoryCompressor(maxSummaryChars)
+		var err error
+		compressor, err = newMemoryCompressor(options.Compression, maxSummaryChars)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	manager := &MemoryManager{
+		username:        normalizeUsername(options.Username),
+		sessions:        options.Sessions,
 		compressor:      compressor,
 		maxSummaryChars: maxSummaryChars,
 	}
 
+	if manager.sessions == nil && options.DB != nil {
+		store, err := NewSQLiteSessionStore(options.DB)
+		if err != nil {
+			return nil, err
+		}
+		manager.sessions = store
+	}
+	if manager.sessions == nil {
+		manager.sessions = NewInMemorySessionStore()
+	}
+
 	if options.DB != nil {
 		// MemoryManager 只依赖长期记忆表，因此这里走一个更窄的初始化路径，避免
 		// 为了单个能力把整套应用迁移流程都执行一遍。
@@ -73,18 +101,26 @@ func NewMemoryManager(options MemoryOptions) (*MemoryManager, error) {
 			return nil, err
 		}
 
-		store := &longTermMemoryStore{
-			db:       options.DB,
-			username: normalizeUsername(options.Username),
-		}
+		store := &longTermMemoryStore{db: options.DB}
 		// 先为当前用户补一条空记录，保证后续读取和刷入长期记忆时，无论是否已经
 		// 产生摘要，都能依赖这条稳定存在的记录。
-		if _, err := store.getOrCreate(); err != nil {
+		if _, err := store.getOrCreate(manager.username); err != nil {
 			return nil, err
 		}
 		manager.longTerm = store
 	}
 
+	if options.Items.Enabled {
+		if options.DB == nil {
+			return nil, fmt.Errorf("%w: memory items require a database", ErrMemoryItemsDisabled)
+		}
+		items, err := newMemoryItemStore(options.DB, options.Items)
+		if err != nil {
+			return nil, err
+		}
+		manager.items = items
+	}
+
 	return manager, nil
 }
 
@@ -109,13 +145,95 @@ func (m *MemoryManager) ClearShortTerm() {
 	m.shortTerm = nil
 }
 
+// ReplaceShortTerm 用给定快照整体替换短期记忆，主要用于从检查点恢复运行。
+func (m *MemoryManager) ReplaceShortTerm(messages []llmModel.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.shortTerm = cloneMessages(messages)
+}
+
+// Username 返回默认会话与长期记忆归属的用户名。
+func (m *MemoryManager) Username() string {
+	return m.username
+}
+
+// CreateSession 为指定用户新建一个会话；username 为空时归属到默认用户。
+func (m *MemoryManager) CreateSession(ctx context.Context, username string, title string) (*Session, error) {
+	if strings.TrimSpace(username) == "" {
+		username = m.username
+	}
+	return m.sessions.CreateSession(ctx, username, title)
+}
+
+// ListSessions 列出指定用户的会话；username 为空时列出全部会话。
+func (m *MemoryManager) ListSessions(ctx context.Context, username string) ([]Session, error) {
+	return m.sessions.ListSessions(ctx, username)
+}
+
+// LoadSession 返回包含完整消息历史的会话快照。
+func (m *MemoryManager) LoadSession(ctx context.Context, sessionID string) (*Session, error) {
+	return m.sessions.LoadSession(ctx, sessionID)
+}
+
+func (m *MemoryManager) DeleteSession(ctx context.Context, sessionID string) error {
+	return m.sessions.DeleteSession(ctx, sessionID)
+}
+
+// AddSessionMessage 把消息追加到指定会话；sessionID 为空时写入进程内默认会话。
+func (m *MemoryManager) AddSessionMessage(ctx context.Context, sessionID string, message llmModel.Message) error {
+	if sessionID == "" {
+		m.AddMessage(message)
+		return nil
+	}
+	return m.sessions.AppendMessages(ctx, sessionID, message)
+}
+
+// SessionMessages 返回指定会话的短期消息；sessionID 为空时返回默认会话。
+func (m *MemoryManager) SessionMessages(ctx context.Context, sessionID string) ([]llmModel.Message, error) {
+	if sessionID == "" {
+		return m.ShortTermMessages(), nil
+	}
+	session, err := m.sessions.LoadSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return session.Messages, nil
+}
+
+// ReplaceSessionMessages 整体替换指定会话的短期消息，主要用于检查点恢复和长期记忆刷入。
+func (m *MemoryManager) ReplaceSessionMessages(ctx context.Context, sessionID string, messages []llmModel.Message) error {
+	if sessionID == "" {
+		m.ReplaceShortTerm(messages)
+		return nil
+	}
+	return m.sessions.ReplaceMessages(ctx, sessionID, messages)
+}
+
+// SessionUsername 返回会话归属的用户名，长期记忆按这个用户名读取和写入。
+func (m *MemoryManager) SessionUsername(ctx context.Context, sessionID string) (string, error) {
+	if sessionID == "" {
+		return m.username, nil
+	}
+	session, err := m.sessions.LoadSession(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	return session.Username, nil
+}
+
 // LongTermSummary 返回当前持久化的长期摘要，同时不把底层存储实现暴露出去。
 func (m *MemoryManager) LongTermSummary(ctx context.Context) (string, error) {
+	return m.LongTermSummaryFor(ctx, m.username)
+}
+
+// LongTermSummaryFor 返回指定用户的长期摘要，供具名会话按归属用户读取。
+func (m *MemoryManager) LongTermSummaryFor(ctx context.Context, username string) (string, error) {
 	if m.longTerm == nil {
 		return "", ErrLongTermMemoryDisabled
 	}
 
-	record, err := m.longTerm.getOrCreateWithContext(ctx)
+	record, err := m.longTerm.getOrCreateWithContext(ctx, normalizeUsername(username))
 	if err != nil {
 		return "", err
 	}
@@ -132,7 +250,7 @@ func (m *MemoryManager) FlushShortTermToLongTerm(ctx context.Context) (string, e
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	record, err := m.longTerm.getOrCreateWithContext(ctx)
+	record, err := m.longTerm.getOrCreateWithContext(ctx, m.username)
 	if err != nil {
 		return "", err
 	}
@@ -148,7 +266,7 @@ func (m *MemoryManager) FlushShortTermToLongTerm(ctx context.Context) (string, e
 	if err != nil {
 		return "", err
 	}
-	if err := m.longTerm.saveSummary(ctx, summary); err != nil {
+	if err := m.longTerm.saveSummary(ctx, m.username, summary); err != nil {
 		return "", err
 	}
 
@@ -156,6 +274,45 @@ func (m *MemoryManager) FlushShortTermToLongTerm(ctx context.Context) (string, e
 	return summary, nil
 }
 
+// FlushSessionToLongTerm 把具名会话压缩进该会话所属用户的长期摘要，成功后清空会话
+// 消息；sessionID 为空时等价于 FlushShortTermToLongTerm。
+func (m *MemoryManager) FlushSessionToLongTerm(ctx context.Context, sessionID string) (string, error) {
+	if sessionID == "" {
+		return m.FlushShortTermToLongTerm(ctx)
+	}
+	if m.longTerm == nil {
+		return "", ErrLongTermMemoryDisabled
+	}
+
+	// 同一把锁串行化所有刷入操作，避免同一用户的两个会话并发刷入时互相覆盖摘要。
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, err := m.sessions.LoadSession(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	record, err := m.longTerm.getOrCreateWithContext(ctx, session.Username)
+	if err != nil {
+		return "", err
+	}
+	if len(session.Messages) == 0 {
+		return record.Summary, nil
+	}
+
+	summary, err := m.compressor(ctx, record.Summary, session.Messages)
+	if err != nil {
+		return "", err
+	}
+	if err := m.longTerm.saveSummary(ctx, session.Username, summary); err != nil {
+		return "", err
+	}
+	if err := m.sessions.ReplaceMessages(ctx, sessionID, nil); err != nil {
+		return "", err
+	}
+	return summary, nil
+}
+
 func normalizeUsername(username string) string {
 	trimmed := strings.TrimSpace(username)
 	if trimmed == "" {
@@ -164,6 +321,23 @@ func normalizeUsername(username string) string {
 	return trimmed
 }
 
+func newMemoryCompressor(options MemoryCompressionOptions, maxSummaryChars int) (MemoryCompressor, error) {
+	switch strings.ToLower(strings.TrimSpace(options.Mode)) {
+	case "", MemoryCompressionSimple:
+		return newSimpleMemoryCompressor(maxSummaryChars), nil
+	case MemoryCompressionLLM:
+		return NewLLMMemoryCompressor(LLMCompressorOptions{
+			LLM:              options.LLM,
+			Model:            options.Model,
+			MaxSummaryChars:  maxSummaryChars,
+			MaxSummaryTokens: options.MaxSummaryTokens,
+			TokenCounter:     options.TokenCounter,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported memory compression mode: %s", options.Mode)
+	}
+}
+
 func newSimpleMemoryCompressor(maxChars int) MemoryCompressor {
 	return func(_ context.Context, currentSummary string, session []llmModel.Message) (string, error) {
 		parts := make([]string, 0, len(session)+1)
@@ -294,12 +468,12 @@ func cloneMessage(message llmModel.Message) llmModel.Message {
 	return cloned
 }
 
-func (s *longTermMemoryStore) getOrCreate() (*model.LongTermMemory, error) {
-	return s.getOrCreateWithContext(context.Background())
+func (s *longTermMemoryStore) getOrCreate(username string) (*model.LongTermMemory, error) {
+	return s.getOrCreateWithContext(context.Background(), username)
 }
 
-func (s *longTermMemoryStore) getOrCreateWithContext(ctx context.Context) (*model.LongTermMemory, error) {
-	seed := &model.LongTermMemory{Username: s.username, Summary: ""}
+func (s *longTermMemoryStore) getOrCreateWithContext(ctx context.Context, username string) (*model.LongTermMemory, error) {
+	seed := &model.LongTermMemory{Username: username, Summary: ""}
 	// 先尝试插入，再通过冲突忽略吸收并发竞争，保证重复启动或并发请求最终都会
 	// 安全收敛到同一条用户记录。
 	if err := s.db.WithContext(ctx).
@@ -314,17 +488,26 @@ func (s *longTermMemoryStore) getOrCreateWithContext(ctx context.Context) (*mode
 	// 插入并忽略冲突的尝试结束后再查一遍，保证调用方拿到的始终是数据库里的最终记录，
 	// 不受“本次创建”还是“别的协程已提前创建”影响。
 	record := &model.LongTermMemory{}
-	if err := s.db.WithContext(ctx).Where("username = ?", s.username).First(record).Error; err != nil {
+	if err := s.db.WithContext(ctx).Where("username = ?", username).First(record).Error; err != nil {
 		return nil, err
 	}
 	return record, nil
 }
 
-func (s *longTermMemoryStore) saveSummary(ctx context.Context, summary string) error {
-	record, err := s.getOrCreateWithContext(ctx)
+func (s *longTermMemoryStore) saveSummary(ctx context.Context, username string, summary string) error {
+	record, err := s.getOrCreateWithContext(ctx, username)
 	if err != nil {
 		return err
 	}
+	if record.Summary == summary {
+		return nil
+	}
+	before := record.Summary
 	record.Summary = summary
-	return s.db.WithContext(ctx).Save(record).Error
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(record).Error; err != nil {
+			return err
+		}
+		return writeMemoryAudit(ctx, tx, username, 0, MemoryActionSummary, before, summary)
+	})
 }
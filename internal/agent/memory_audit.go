@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"agent_study/internal/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	MemoryActionCreate  = "create"
+	MemoryActionMerge   = "merge"
+	MemoryActionUpdate  = "update"
+	MemoryActionDelete  = "delete"
+	MemoryActionSummary = "summary"
+
+	// MemoryActorAgent 表示变更来自 agent 运行（记忆抽取、forget_memory 工具等）。
+	MemoryActorAgent = "agent"
+	// MemoryActorUser 表示变更来自用户通过 REPL 或 HTTP 接口的直接操作。
+	MemoryActorUser = "user"
+	// MemoryActorSystem 是未声明来源时的默认值，例如后台刷入与衰减清理。
+	MemoryActorSystem = "system"
+)
+
+// MemoryAuditSource 描述一次记忆变更的来源，随 ctx 传递给 MemoryManager。
+type MemoryAuditSource struct {
+	Actor     string
+	RunID     string
+	SessionID string
+}
+
+// MemoryAuditEntry 是一条记忆变更记录；ItemID 为 0 表示长期摘要的变更。
+type MemoryAuditEntry struct {
+	ID        uint      `json:"id"`
+	Username  string    `json:"username"`
+	ItemID    uint      `json:"item_id"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	RunID     string    `json:"run_id"`
+	SessionID string    `json:"session_id"`
+	Before    string    `json:"before"`
+	After     string    `json:"after"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type memoryAuditSourceKey struct{}
+
+// WithMemoryAuditSource 返回携带变更来源的 ctx；之后经由该 ctx 发生的记忆变更都会
+// 以这个来源写入审计记录。
+func WithMemoryAuditSource(ctx context.Context, source MemoryAuditSource) context.Context {
+	return context.WithValue(ctx, memoryAuditSourceKey{}, source)
+}
+
+// MemoryAuditSourceFrom 读取 ctx 上的变更来源；未设置时 Actor 为 system。
+func MemoryAuditSourceFrom(ctx context.Context) MemoryAuditSource {
+	source, _ := ctx.Value(memoryAuditSourceKey{}).(MemoryAuditSource)
+	if source.Actor == "" {
+		source.Actor = MemoryActorSystem
+	}
+	return source
+}
+
+// writeMemoryAudit 在调用方的事务里追加一条审计记录，保证记忆变更与审计同时成功或失败。
+func writeMemoryAudit(ctx context.Context, tx *gorm.DB, username string, itemID uint, action string, before string, after string) error {
+	source := MemoryAuditSourceFrom(ctx)
+	return tx.Create(&model.MemoryAudit{
+		Username:  username,
+		ItemID:    itemID,
+		Action:    action,
+		Actor:     source.Actor,
+		RunID:     source.RunID,
+		SessionID: source.SessionID,
+		Before:    before,
+		After:     after,
+	}).Error
+}
+
+// MemoryAuditLog 按时间倒序返回用户的记忆变更记录；itemID 不为 nil 时只返回该条目
+// （0 表示长期摘要）的记录，limit 不大于 0 时不限制条数。
+func (m *MemoryManager) MemoryAuditLog(ctx context.Context, username string, itemID *uint, limit int) ([]MemoryAuditEntry, error) {
+	db := m.memoryDB()
+	if db == nil {
+		return nil, ErrLongTermMemoryDisabled
+	}
+
+	query := db.WithContext(ctx).Where("username = ?", normalizeUsername(username))
+	if itemID != nil {
+		query = query.Where("item_id = ?", *itemID)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var records []model.MemoryAudit
+	if err := query.Order("id DESC").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	entries := make([]MemoryAuditEntry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, MemoryAuditEntry{
+			ID:        record.ID,
+			Username:  record.Username,
+			ItemID:    record.ItemID,
+			Action:    record.Action,
+			Actor:     record.Actor,
+			RunID:     record.RunID,
+			SessionID: record.SessionID,
+			Before:    record.Before,
+			After:     record.After,
+			CreatedAt: record.CreatedAt,
+		})
+	}
+	return entries, nil
+}
+
+// memoryDB 返回长期记忆所用的数据库句柄；未配置 DB 时返回 nil。
+func (m *MemoryManager) memoryDB() *gorm.DB {
+	if m.longTerm == nil {
+		return nil
+	}
+	return m.longTerm.db
+}
@@ -0,0 +1,239 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"agent_study/internal/log"
+	llmModel "agent_study/pkg/llm_core/model"
+	llmTools "agent_study/pkg/llm_core/tools"
+)
+
+const (
+	MemoryCompressionSimple = "simple"
+	MemoryCompressionLLM    = "llm"
+)
+
+var ErrMemoryCompressorLLMRequired = errors.New("llm memory compressor requires an llm client")
+
+// MemoryCompressionOptions 在未显式提供 MemoryOptions.Compressor 时选择内置压缩器。
+type MemoryCompressionOptions struct {
+	// Mode 为空或 simple 时使用拼接压缩器；llm 时使用 NewLLMMemoryCompressor。
+	Mode string
+	// LLM 与 Model 仅在 llm 模式下使用；NewAgent 会在它们为空时回填 Agent 自己的客户端和模型。
+	LLM   llmModel.LlmClient
+	Model string
+	// MaxSummaryTokens 是摘要的 token 上限；为 0 时按 MaxSummaryChars 估算。
+	MaxSummaryTokens int
+	// TokenCounter 可选；为空时使用 rune 近似计数，避免在离线环境下载词表。
+	TokenCounter *llmTools.TokenCounter
+}
+
+// LLMCompressorOptions 描述 LLM 压缩器的依赖。
+type LLMCompressorOptions struct {
+	LLM              llmModel.LlmClient
+	Model            string
+	MaxSummaryChars  int
+	MaxSummaryTokens int
+	TokenCounter     *llmTools.TokenCounter
+	// Fallback 在模型调用或结果解析失败时兜底；为空时使用简单拼接压缩器。
+	Fallback MemoryCompressor
+}
+
+// memoryProfile 是 LLM 压缩器要求模型输出的结构化用户画像。
+type memoryProfile struct {
+	Preferences []string `json:"preferences"`
+	Facts       []string `json:"facts"`
+	Projects    []string `json:"projects"`
+}
+
+const memoryCompressorPrompt = `你负责维护某个用户的长期记忆画像。请把“已有画像”和“本次会话”合并成一份新的画像：
+- preferences：用户的稳定偏好（语言、工具、风格等）
+- facts：关于用户或其环境、且未来仍然有用的事实
+- projects：用户正在进行的项目与当前进展
+规则：
+1. 只保留对未来对话有帮助的信息，忽略寒暄、一次性细节和工具调用的原始输出；
+2. 合并重复或同义条目；
+3. 新信息与旧信息冲突时以本次会话为准，删除被推翻的旧条目；
+4. 每条尽量简短，整体不超过 %d 个 token；
+5. 只输出 JSON：{"preferences":[],"facts":[],"projects":[]}，不要输出其他内容。`
+
+// NewLLMMemoryCompressor 返回一个基于任意 LlmClient 的压缩器：它让模型把已有摘要和
+// 本次会话合并成结构化画像，本地再做一次去重和 token 预算裁剪；任何一步失败都会
+// 回退到 Fallback，保证长期记忆刷入不会因为模型波动而中断。
+func NewLLMMemoryCompressor(options LLMCompressorOptions) (MemoryCompressor, error) {
+	if options.LLM == nil {
+		return nil, ErrMemoryCompressorLLMRequired
+	}
+	maxChars := options.MaxSummaryChars
+	if maxChars <= 0 {
+		maxChars = defaultSummaryChars
+	}
+	counter := options.TokenCounter
+	if counter == nil {
+		var err error
+		counter, err = llmTools.NewTokenCounter(llmTools.CountModeRune, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	maxTokens := options.MaxSummaryTokens
+	if maxTokens <= 0 {
+		// rune 计数器按 3/4 估算 token，这里沿用同一比例把字符上限换算成 token 预算。
+		maxTokens = maxChars * 3 / 4
+	}
+	fallback := options.Fallback
+	if fallback == nil {
+		fallback = newSimpleMemoryCompressor(maxChars)
+	}
+
+	return func(ctx context.Context, currentSummary string, session []llmModel.Message) (string, error) {
+		summary, err := compressWithLLM(ctx, options.LLM, options.Model, maxTokens, currentSummary, session)
+		if err != nil {
+			log.Warnf("llm memory compressor failed, falling back: %v", err)
+			return fallback(ctx, currentSummary, session)
+		}
+		return limitSummaryTokens(summary, maxTokens, maxChars, counter), nil
+	}, nil
+}
+
+func compressWithLLM(ctx context.Context, llm llmModel.LlmClient, model string, maxTokens int, currentSummary string, session []llmModel.Message) (string, error) {
+	transcript := make([]string, 0, len(session))
+	for _, message := range session {
+		if text := compactWhitespace(formatMemoryMessage(message)); text != "" {
+			transcript = append(transcript, text)
+		}
+	}
+
+	existing := strings.TrimSpace(currentSummary)
+	if existing == "" {
+		existing = "（空）"
+	}
+	response, err := llm.Chat(ctx, llmModel.ChatRequest{
+		Model: model,
+		Messages: []llmModel.Message{
+			{Role: llmModel.RoleSystem, Content: fmt.Sprintf(memoryCompressorPrompt, maxTokens)},
+			{Role: llmModel.RoleUser, Content: "已有画像：\n" + existing + "\n\n本次会话：\n" + strings.Join(transcript, "\n")},
+		},
+		// 输出上限比预算略宽，给 JSON 结构本身留出余量；超出部分由本地裁剪兜底。
+		MaxTokens: int64(maxTokens) * 2,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	profile, err := parseMemoryProfile(response.Content)
+	if err != nil {
+		return "", err
+	}
+	return renderMemoryProfile(profile), nil
+}
+
+func parseMemoryProfile(content string) (memoryProfile, error) {
+	_, answer := llmModel.SplitLeadingThinkBlock(content)
+	answer = strings.TrimSpace(answer)
+	// 兼容模型把 JSON 包在 ```json 代码块里的常见输出习惯。
+	if start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}"); start >= 0 && end > start {
+		answer = answer[start : end+1]
+	}
+
+	var profile memoryProfile
+	if err := json.Unmarshal([]byte(answer), &profile); err != nil {
+		return memoryProfile{}, fmt.Errorf("decode memory profile: %w", err)
+	}
+	return profile, nil
+}
+
+// renderMemoryProfile 把画像渲染成稳定的分节文本，空分节直接省略。
+func renderMemoryProfile(profile memoryProfile) string {
+	sections := []struct {
+		title string
+		items []string
+	}{
+		{title: "偏好", items: profile.Preferences},
+		{title: "事实", items: profile.Facts},
+		{title: "进行中的项目", items: profile.Projects},
+	}
+
+	var b strings.Builder
+	for _, section := range sections {
+		items := dedupeMemoryItems(section.items)
+		if len(items) == 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("## " + section.title + "\n")
+		for _, item := range items {
+			b.WriteString("- " + item + "\n")
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// dedupeMemoryItems 在模型合并之外再做一次忽略大小写与空白差异的精确去重。
+func dedupeMemoryItems(items []string) []string {
+	seen := make(map[string]struct{}, len(items))
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		text := compactWhitespace(item)
+		if text == "" {
+			continue
+		}
+		key := strings.ToLower(text)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = append(result, text)
+	}
+	return result
+}
+
+// limitSummaryTokens 优先按行从尾部裁掉条目，使摘要落在 token 预算内；只剩单行仍超限时
+// 再退回按 token 估算截断，保证结果既不超预算也不会切坏 UTF-8。
+func limitSummaryTokens(summary string, maxTokens int, maxChars int, counter *llmTools.TokenCounter) string {
+	if maxTokens <= 0 || counter.Count(summary) <= maxTokens {
+		return limitSummary(summary, maxChars)
+	}
+
+	lines := strings.Split(summary, "\n")
+	for len(lines) > 1 && counter.Count(strings.Join(lines, "\n")) > maxTokens {
+		lines = lines[:len(lines)-1]
+	}
+	// 裁掉条目后可能留下没有内容的分节标题，这里一并去掉。
+	for len(lines) > 0 && strings.HasPrefix(lines[len(lines)-1], "## ") {
+		lines = lines[:len(lines)-1]
+	}
+	trimmed := strings.Join(lines, "\n")
+	if trimmed == "" || counter.Count(trimmed) > maxTokens {
+		return truncateSummaryTokens(summary, maxTokens, maxChars, counter)
+	}
+	return limitSummary(trimmed, maxChars)
+}
+
+// truncateSummaryTokens 按字符截断对中文等字符密集的文本可能仍超出 token 预算，这里用
+// 与预算检查相同的计数器二分出不超预算的最长截断长度。
+func truncateSummaryTokens(summary string, maxTokens int, maxChars int, counter *llmTools.TokenCounter) string {
+	low, high := 0, utf8.RuneCountInString(summary)
+	if maxChars > 0 && high > maxChars {
+		high = maxChars
+	}
+	for low < high {
+		mid := (low + high + 1) / 2
+		if counter.Count(limitSummary(summary, mid)) <= maxTokens {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	if low == 0 {
+		return ""
+	}
+	return limitSummary(summary, low)
+}
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	llmTools "agent_study/pkg/llm_core/tools"
+)
+
+func TestLLMMemoryCompressorMergesProfileAndDeduplicates(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{
+		Content: "```json\n{\"preferences\":[\"喜欢 Go\",\"喜欢  go\"],\"facts\":[\"住在上海\"],\"projects\":[]}\n```",
+	}}}
+	compressor, err := NewLLMMemoryCompressor(LLMCompressorOptions{LLM: llm, Model: "cheap-model"})
+	if err != nil {
+		t.Fatalf("NewLLMMemoryCompressor() error = %v", err)
+	}
+
+	summary, err := compressor(context.Background(), "## 偏好\n- 喜欢 Go", []llmModel.Message{
+		{Role: llmModel.RoleUser, Content: "我搬到上海了"},
+	})
+	if err != nil {
+		t.Fatalf("compressor() error = %v", err)
+	}
+
+	want := "## 偏好\n- 喜欢 Go\n\n## 事实\n- 住在上海"
+	if summary != want {
+		t.Fatalf("summary = %q, want %q", summary, want)
+	}
+	request := llm.requests[0]
+	if request.Model != "cheap-model" {
+		t.Fatalf("request model = %q, want cheap-model", request.Model)
+	}
+	if !strings.Contains(request.Messages[1].Content, "喜欢 Go") || !strings.Contains(request.Messages[1].Content, "user: 我搬到上海了") {
+		t.Fatalf("request should carry existing summary and session transcript, got %q", request.Messages[1].Content)
+	}
+}
+
+func TestLLMMemoryCompressorFallsBackOnError(t *testing.T) {
+	for name, llm := range map[string]*fakeLlmClient{
+		"chat error":   {chatErr: errors.New("boom")},
+		"invalid json": {responses: []llmModel.ChatResponse{{Content: "not json"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			compressor, err := NewLLMMemoryCompressor(LLMCompressorOptions{LLM: llm})
+			if err != nil {
+				t.Fatalf("NewLLMMemoryCompressor() error = %v", err)
+			}
+			summary, err := compressor(context.Background(), "old", []llmModel.Message{{Role: llmModel.RoleUser, Content: "new"}})
+			if err != nil {
+				t.Fatalf("compressor() error = %v", err)
+			}
+			if summary != "old\nuser: new" {
+				t.Fatalf("summary = %q, want simple compressor fallback", summary)
+			}
+		})
+	}
+}
+
+func TestLLMMemoryCompressorRespectsTokenBudget(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{
+		Content: `{"preferences":["aaaaaaaaaaaaaaaaaaaa"],"facts":["bbbbbbbbbbbbbbbbbbbb","cccccccccccccccccccc"]}`,
+	}}}
+	compressor, err := NewLLMMemoryCompressor(LLMCompressorOptions{LLM: llm, MaxSummaryTokens: 30})
+	if err != nil {
+		t.Fatalf("NewLLMMemoryCompressor() error = %v", err)
+	}
+
+	summary, err := compressor(context.Background(), "", nil)
+	if err != nil {
+		t.Fatalf("compressor() error = %v", err)
+	}
+	if strings.Contains(summary, "cccc") {
+		t.Fatalf("summary = %q, want trailing items trimmed to fit the token budget", summary)
+	}
+	if !strings.Contains(summary, "aaaa") || strings.HasSuffix(summary, "## 事实") {
+		t.Fatalf("summary = %q, want leading items kept without dangling headings", summary)
+	}
+}
+
+func TestLLMMemoryCompressorTruncatesCJKByTokenBudget(t *testing.T) {
+	item := strings.Repeat("用户长期在上海从事后端开发", 20)
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{
+		Content: `{"facts":["` + item + `"]}`,
+	}}}
+	compressor, err := NewLLMMemoryCompressor(LLMCompressorOptions{LLM: llm, MaxSummaryTokens: 30})
+	if err != nil {
+		t.Fatalf("NewLLMMemoryCompressor() error = %v", err)
+	}
+
+	summary, err := compressor(context.Background(), "", nil)
+	if err != nil {
+		t.Fatalf("compressor() error = %v", err)
+	}
+	counter, _ := llmTools.NewTokenCounter(llmTools.CountModeRune, "")
+	if got := counter.Count(summary); got > 30 || got < 25 {
+		t.Fatalf("summary tokens = %d (%q), want close to but within the 30 token budget", got, summary)
+	}
+	if !utf8.ValidString(summary) || !strings.Contains(summary, "上海") {
+		t.Fatalf("summary = %q, want valid UTF-8 keeping the leading content", summary)
+	}
+}
+
+func TestNewAgentWiresLLMMemoryCompressionFromOptions(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: `{"facts":["likes tea"]}`}}}
+	agent, err := NewAgent(NewAgentOptions{
+		LLM:   llm,
+		Model: "main-model",
+		MemoryOptions: &MemoryOptions{
+			DB:          newTestMemoryDB(t),
+			Compression: MemoryCompressionOptions{Mode: MemoryCompressionLLM},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+
+	agent.Memory.AddMessage(llmModel.Message{Role: llmModel.RoleUser, Content: "I like tea"})
+	summary, err := agent.Memory.FlushShortTermToLongTerm(context.Background())
+	if err != nil {
+		t.Fatalf("FlushShortTermToLongTerm() error = %v", err)
+	}
+	if summary != "## 事实\n- likes tea" {
+		t.Fatalf("summary = %q, want llm-rendered profile", summary)
+	}
+	if llm.requests[0].Model != "main-model" {
+		t.Fatalf("compressor model = %q, want agent model fallback", llm.requests[0].Model)
+	}
+}
+
+func TestNewMemoryManagerRejectsUnknownCompressionMode(t *testing.T) {
+	if _, err := NewMemoryManager(MemoryOptions{Compression: MemoryCompressionOptions{Mode: "magic"}}); err == nil {
+		t.Fatalf("NewMemoryManager() error = nil, want unsupported mode error")
+	}
+}
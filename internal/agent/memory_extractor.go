@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	llmModel "agent_study/pkg/llm_core/model"
+)
+
+// LLMExtractorOptions 描述 LLM 记忆抽取器的依赖。
+type LLMExtractorOptions struct {
+	LLM   llmModel.LlmClient
+	Model string
+	// MaxItems 是单次抽取最多保留的条数，默认 8。
+	MaxItems int
+}
+
+const defaultExtractMaxItems = 8
+
+const memoryExtractorPrompt = `你负责从一段对话里抽取关于用户、值得长期记住的信息。每条记忆必须是独立、简短、可复用的一句话，并标注类型：
+- preference：用户的稳定偏好（语言、工具、风格等）
+- fact：关于用户或其环境、未来仍然有用的事实
+- project：用户正在进行的项目与当前进展
+importance 取 0 到 1，越可能在未来对话中用到越高。
+规则：
+1. 忽略寒暄、一次性细节和工具调用的原始输出；
+2. “已有记忆”里已经包含的信息不要重复输出；
+3. 最多输出 %d 条；没有值得记住的信息时输出空数组；
+4. 只输出 JSON：{"memories":[{"text":"","type":"fact","importance":0.5}]}，不要输出其他内容。`
+
+// NewLLMMemoryExtractor 返回一个让模型从会话中抽取离散记忆条目的抽取器。
+func NewLLMMemoryExtractor(options LLMExtractorOptions) (MemoryExtractor, error) {
+	if options.LLM == nil {
+		return nil, ErrMemoryCompressorLLMRequired
+	}
+	maxItems := options.MaxItems
+	if maxItems <= 0 {
+		maxItems = defaultExtractMaxItems
+	}
+
+	return func(ctx context.Context, existing []MemoryItem, session []llmModel.Message) ([]MemoryCandidate, error) {
+		transcript := make([]string, 0, len(session))
+		for _, message := range session {
+			if text := compactWhitespace(formatMemoryMessage(message)); text != "" {
+				transcript = append(transcript, text)
+			}
+		}
+		if len(transcript) == 0 {
+			return nil, nil
+		}
+
+		known := make([]string, 0, len(existing))
+		for _, item := range existing {
+			known = append(known, "- "+item.Text)
+		}
+		if len(known) == 0 {
+			known = append(known, "（空）")
+		}
+
+		response, err := options.LLM.Chat(ctx, llmModel.ChatRequest{
+			Model: options.Model,
+			Messages: []llmModel.Message{
+				{Role: llmModel.RoleSystem, Content: fmt.Sprintf(memoryExtractorPrompt, maxItems)},
+				{Role: llmModel.RoleUser, Content: "已有记忆：\n" + strings.Join(known, "\n") + "\n\n本次会话：\n" + strings.Join(transcript, "\n")},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		candidates, err := parseMemoryCandidates(response.Content)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) > maxItems {
+			candidates = candidates[:maxItems]
+		}
+		return candidates, nil
+	}, nil
+}
+
+func parseMemoryCandidates(content string) ([]MemoryCandidate, error) {
+	_, answer := llmModel.SplitLeadingThinkBlock(content)
+	answer = strings.TrimSpace(answer)
+	if start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}"); start >= 0 && end > start {
+		answer = answer[start : end+1]
+	}
+
+	var payload struct {
+		Memories []MemoryCandidate `json:"memories"`
+	}
+	if err := json.Unmarshal([]byte(answer), &payload); err != nil {
+		return nil, fmt.Errorf("decode memory candidates: %w", err)
+	}
+	return payload.Memories, nil
+}
@@ -0,0 +1,639 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	phase4migrate "agent_study/internal/migrate/phase4"
+	"agent_study/internal/model"
+	llmModel "agent_study/pkg/llm_core/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	MemoryItemTypePreference = "preference"
+	MemoryItemTypeFact       = "fact"
+	MemoryItemTypeProject    = "project"
+
+	defaultMemoryTopK          = 5
+	defaultMemoryHalfLife      = 30 * 24 * time.Hour
+	defaultMemoryPruneBelow    = 0.05
+	defaultMemoryImportance    = 0.5
+	memoryCandidateMultiplier  = 4
+	memoryRelevanceWeight      = 0.7
+	memoryImportanceWeight     = 0.3
+	memoryMinRelevanceToInject = 0.05
+)
+
+var (
+	ErrMemoryItemsDisabled = errors.New("memory items are not configured")
+	ErrMemoryItemNotFound  = errors.New("memory item not found")
+)
+
+// Embedder 把文本转换成向量；配置后记忆检索会结合向量相似度，否则只走 FTS5。
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// MemoryItem 是事实级长期记忆对外暴露的视图。
+type MemoryItem struct {
+	ID              uint      `json:"id"`
+	Username        string    `json:"username"`
+	Text            string    `json:"text"`
+	Type            string    `json:"type"`
+	SourceRunID     string    `json:"source_run_id"`
+	SourceSessionID string    `json:"source_session_id"`
+	Importance      float64   `json:"importance"`
+	UseCount        int       `json:"use_count"`
+	Embedding       []float32 `json:"embedding,omitempty"`
+	LastUsedAt      time.Time `json:"last_used_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	// Score 只在检索结果里有值，表示综合相关度、重要度与衰减后的得分。
+	Score float64 `json:"score,omitempty"`
+}
+
+// MemoryCandidate 是抽取器产出的待写入记忆。
+type MemoryCandidate struct {
+	Text       string  `json:"text"`
+	Type       string  `json:"type"`
+	Importance float64 `json:"importance"`
+}
+
+// MemoryItemPatch 描述对一条记忆的部分修改，nil 字段保持不变。
+type MemoryItemPatch struct {
+	Text       *string  `json:"text"`
+	Type       *string  `json:"type"`
+	Importance *float64 `json:"importance"`
+}
+
+// MemoryItemOptions 控制事实级长期记忆；启用后 BuildMessage 只注入与任务相关的 top-k
+// 条记忆，而不再注入整段摘要。需要 MemoryOptions.DB。
+type MemoryItemOptions struct {
+	Enabled bool
+	// TopK 是每次注入上下文的最大条数，默认 5。
+	TopK int
+	// Extractor 在每次运行结束后从本次运行的消息里抽取新记忆；为空且提供了 LLM 时
+	// 使用 NewLLMMemoryExtractor，两者都为空时不自动抽取。
+	Extractor MemoryExtractor
+	// LLM 与 Model 仅用于构造默认抽取器；NewAgent 会在它们为空时回填 Agent 自己的。
+	LLM   llmModel.LlmClient
+	Model string
+	// Embedder 可选；提供后新记忆会保存向量，检索时与 FTS5 结果合并打分。
+	Embedder Embedder
+	// HalfLife 是记忆重要度随“最近未被使用”而衰减的半衰期，默认 30 天。
+	HalfLife time.Duration
+	// PruneBelow 是 DecayMemories 清理记忆的衰减后重要度阈值，默认 0.05。
+	PruneBelow float64
+}
+
+// MemoryExtractor 从会话中抽取值得长期保存的记忆；existing 是该用户已有的记忆，便于去重。
+type MemoryExtractor func(ctx context.Context, existing []MemoryItem, session []llmModel.Message) ([]MemoryCandidate, error)
+
+type memoryItemStore struct {
+	db      *gorm.DB
+	options MemoryItemOptions
+	now     func() time.Time
+}
+
+func newMemoryItemStore(db *gorm.DB, options MemoryItemOptions) (*memoryItemStore, error) {
+	if err := phase4migrate.BootstrapWithDB(db, phase4migrate.CurrentVersion); err != nil {
+		return nil, err
+	}
+	if options.TopK <= 0 {
+		options.TopK = defaultMemoryTopK
+	}
+	if options.HalfLife <= 0 {
+		options.HalfLife = defaultMemoryHalfLife
+	}
+	if options.PruneBelow <= 0 {
+		options.PruneBelow = defaultMemoryPruneBelow
+	}
+	if options.Extractor == nil && options.LLM != nil {
+		extractor, err := NewLLMMemoryExtractor(LLMExtractorOptions{LLM: options.LLM, Model: options.Model})
+		if err != nil {
+			return nil, err
+		}
+		options.Extractor = extractor
+	}
+	return &memoryItemStore{db: db, options: options, now: time.Now}, nil
+}
+
+// add 写入一条记忆；同一用户下规范化文本相同的记忆只会提升重要度并刷新使用时间。
+func (s *memoryItemStore) add(ctx context.Context, username string, item MemoryItem) (*MemoryItem, error) {
+	text := compactWhitespace(item.Text)
+	if text == "" {
+		return nil, fmt.Errorf("memory item text is empty")
+	}
+	item.Text = text
+	item.Username = username
+	item.Type = normalizeMemoryItemType(item.Type)
+	item.Importance = clampImportance(item.Importance)
+
+	existing, err := s.findByText(ctx, username, text)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	if existing != nil {
+		existing.Importance = math.Max(existing.Importance, item.Importance)
+		existing.LastUsedAt = now
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(existing).Error; err != nil {
+				return err
+			}
+			return writeMemoryAudit(ctx, tx, username, existing.ID, MemoryActionMerge, existing.Text, text)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return memoryItemFromRecord(existing), nil
+	}
+
+	if s.options.Embedder != nil && len(item.Embedding) == 0 {
+		vectors, err := s.options.Embedder.Embed(ctx, []string{text})
+		if err == nil && len(vectors) == 1 {
+			item.Embedding = vectors[0]
+		}
+	}
+	record := memoryItemToRecord(item)
+	record.LastUsedAt = now
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		if err := insertMemoryItemTerms(tx, record); err != nil {
+			return err
+		}
+		return writeMemoryAudit(ctx, tx, username, record.ID, MemoryActionCreate, "", text)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return memoryItemFromRecord(record), nil
+}
+
+func (s *memoryItemStore) list(ctx context.Context, username string) ([]MemoryItem, error) {
+	var records []model.MemoryItem
+	if err := s.db.WithContext(ctx).Where("username = ?", username).Order("id ASC").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	items := make([]MemoryItem, 0, len(records))
+	for i := range records {
+		items = append(items, *memoryItemFromRecord(&records[i]))
+	}
+	return items, nil
+}
+
+func (s *memoryItemStore) delete(ctx context.Context, username string, ids ...uint) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	var deleted int
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var records []model.MemoryItem
+		if err := tx.Where("username = ? AND id IN ?", username, ids).Find(&records).Error; err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := tx.Delete(&model.MemoryItem{}, record.ID).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("DELETE FROM "+phase4migrate.MemoryItemFTSTable+" WHERE item_id = ?", record.ID).Error; err != nil {
+				return err
+			}
+			if err := writeMemoryAudit(ctx, tx, username, record.ID, MemoryActionDelete, record.Text, ""); err != nil {
+				return err
+			}
+		}
+		deleted = len(records)
+		return nil
+	})
+	return deleted, err
+}
+
+// update 修改一条记忆；文本变化时同步刷新 FTS5 索引和向量。
+func (s *memoryItemStore) update(ctx context.Context, username string, id uint, patch MemoryItemPatch) (*MemoryItem, error) {
+	var record model.MemoryItem
+	result := s.db.WithContext(ctx).Where("username = ? AND id = ?", username, id).Limit(1).Find(&record)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrMemoryItemNotFound, id)
+	}
+
+	before := record.Text
+	textChanged := false
+	if patch.Text != nil {
+		text := compactWhitespace(*patch.Text)
+		if text == "" {
+			return nil, fmt.Errorf("memory item text is empty")
+		}
+		textChanged = text != record.Text
+		record.Text = text
+	}
+	if patch.Type != nil {
+		record.Type = normalizeMemoryItemType(*patch.Type)
+	}
+	if patch.Importance != nil {
+		record.Importance = clampImportance(*patch.Importance)
+	}
+	if textChanged && s.options.Embedder != nil {
+		record.EmbeddingJSON = ""
+		if vectors, err := s.options.Embedder.Embed(ctx, []string{record.Text}); err == nil && len(vectors) == 1 {
+			raw, _ := json.Marshal(vectors[0])
+			record.EmbeddingJSON = string(raw)
+		}
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&record).Error; err != nil {
+			return err
+		}
+		if textChanged {
+			if err := tx.Exec("DELETE FROM "+phase4migrate.MemoryItemFTSTable+" WHERE item_id = ?", record.ID).Error; err != nil {
+				return err
+			}
+			if err := insertMemoryItemTerms(tx, &record); err != nil {
+				return err
+			}
+		}
+		return writeMemoryAudit(ctx, tx, username, record.ID, MemoryActionUpdate, before, record.Text)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return memoryItemFromRecord(&record), nil
+}
+
+// retrieve 先用 FTS5（以及可选的向量相似度）召回候选，再按相关度、重要度和衰减综合
+// 打分取 top-k；touch 为 true 时刷新被选中记忆的使用时间，让常用记忆不容易衰减。
+func (s *memoryItemStore) retrieve(ctx context.Context, username string, query string, topK int, touch bool) ([]MemoryItem, error) {
+	if topK <= 0 {
+		topK = s.options.TopK
+	}
+	relevance, err := s.lexicalRelevance(ctx, username, query, topK*memoryCandidateMultiplier)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.list(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if s.options.Embedder != nil && strings.TrimSpace(query) != "" {
+		if vectors, err := s.options.Embedder.Embed(ctx, []string{query}); err == nil && len(vectors) == 1 {
+			for _, item := range items {
+				if similarity := cosineSimilarity(vectors[0], item.Embedding); similarity > relevance[item.ID] {
+					relevance[item.ID] = similarity
+				}
+			}
+		}
+	}
+
+	now := s.now()
+	scored := make([]MemoryItem, 0, len(relevance))
+	for _, item := range items {
+		rel, ok := relevance[item.ID]
+		if !ok || rel < memoryMinRelevanceToInject {
+			continue
+		}
+		item.Score = memoryRelevanceWeight*rel + memoryImportanceWeight*s.decayedImportance(item, now)
+		scored = append(scored, item)
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score > scored[j].Score
+	})
+	if len(scored) > topK {
+		scored = scored[:topK]
+	}
+
+	if touch && len(scored) > 0 {
+		ids := make([]uint, 0, len(scored))
+		for _, item := range scored {
+			ids = append(ids, item.ID)
+		}
+		if err := s.db.WithContext(ctx).Model(&model.MemoryItem{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{"last_used_at": now, "use_count": gorm.Expr("use_count + 1")}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return scored, nil
+}
+
+// lexicalRelevance 返回 FTS5 命中的记忆及其归一化到 (0,1] 的相关度。
+func (s *memoryItemStore) lexicalRelevance(ctx context.Context, username string, query string, limit int) (map[uint]float64, error) {
+	relevance := make(map[uint]float64)
+	terms := memorySearchTerms(query)
+	if len(terms) == 0 {
+		return relevance, nil
+	}
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, quoteTerm(term))
+	}
+
+	type hit struct {
+		ItemID uint
+		Rank   float64
+	}
+	var hits []hit
+	err := s.db.WithContext(ctx).Raw(
+		"SELECT item_id, bm25("+phase4migrate.MemoryItemFTSTable+") AS rank FROM "+phase4migrate.MemoryItemFTSTable+
+			" WHERE "+phase4migrate.MemoryItemFTSTable+" MATCH ? AND username = ? ORDER BY rank LIMIT ?",
+		strings.Join(quoted, " OR "), username, limit,
+	).Scan(&hits).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(hits) == 0 {
+		return relevance, nil
+	}
+	// bm25 越小越相关且为负数；以最佳命中为 1 做归一化，便于和向量相似度、重要度加权。
+	best := -hits[0].Rank
+	for _, h := range hits {
+		if best <= 0 {
+			relevance[h.ItemID] = 1
+			continue
+		}
+		relevance[h.ItemID] = math.Max(-h.Rank/best, 0)
+	}
+	return relevance, nil
+}
+
+// decay 删除衰减后重要度低于阈值的记忆，返回删除条数。
+func (s *memoryItemStore) decay(ctx context.Context, username string) (int, error) {
+	items, err := s.list(ctx, username)
+	if err != nil {
+		return 0, err
+	}
+	now := s.now()
+	stale := make([]uint, 0)
+	for _, item := range items {
+		if s.decayedImportance(item, now) < s.options.PruneBelow {
+			stale = append(stale, item.ID)
+		}
+	}
+	return s.delete(ctx, username, stale...)
+}
+
+func (s *memoryItemStore) decayedImportance(item MemoryItem, now time.Time) float64 {
+	lastUsed := item.LastUsedAt
+	if lastUsed.IsZero() {
+		lastUsed = item.CreatedAt
+	}
+	age := now.Sub(lastUsed)
+	if age <= 0 {
+		return item.Importance
+	}
+	return item.Importance * math.Pow(0.5, float64(age)/float64(s.options.HalfLife))
+}
+
+func (s *memoryItemStore) findByText(ctx context.Context, username string, text string) (*model.MemoryItem, error) {
+	var records []model.MemoryItem
+	if err := s.db.WithContext(ctx).Where("username = ?", username).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	key := strings.ToLower(text)
+	for i := range records {
+		if strings.ToLower(compactWhitespace(records[i].Text)) == key {
+			return &records[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func insertMemoryItemTerms(tx *gorm.DB, record *model.MemoryItem) error {
+	return tx.Exec("INSERT INTO "+phase4migrate.MemoryItemFTSTable+" (terms, item_id, username) VALUES (?, ?, ?)",
+		strings.Join(memorySearchTerms(record.Text), " "), record.ID, record.Username).Error
+}
+
+// memorySearchTerms 把文本切成 FTS5 检索词：拉丁字母与数字按词切分，中日韩文字按
+// 相邻二元组切分，弥补 unicode61 分词器不会切分中文的问题。
+func memorySearchTerms(text string) []string {
+	terms := make([]string, 0)
+	seen := make(map[string]struct{})
+	add := func(term string) {
+		if term == "" {
+			return
+		}
+		if _, ok := seen[term]; ok {
+			return
+		}
+		seen[term] = struct{}{}
+		terms = append(terms, term)
+	}
+
+	var word []rune
+	var cjk []rune
+	flushWord := func() {
+		add(strings.ToLower(string(word)))
+		word = word[:0]
+	}
+	flushCJK := func() {
+		if len(cjk) == 1 {
+			add(string(cjk))
+		}
+		for i := 0; i+1 < len(cjk); i++ {
+			add(string(cjk[i : i+2]))
+		}
+		cjk = cjk[:0]
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return terms
+}
+
+func quoteTerm(term string) string {
+	return `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+}
+
+func cosineSimilarity(a []float32, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func normalizeMemoryItemType(itemType string) string {
+	switch strings.ToLower(strings.TrimSpace(itemType)) {
+	case MemoryItemTypePreference:
+		return MemoryItemTypePreference
+	case MemoryItemTypeProject:
+		return MemoryItemTypeProject
+	default:
+		return MemoryItemTypeFact
+	}
+}
+
+func clampImportance(importance float64) float64 {
+	if importance <= 0 {
+		return defaultMemoryImportance
+	}
+	return math.Min(importance, 1)
+}
+
+func memoryItemToRecord(item MemoryItem) *model.MemoryItem {
+	record := &model.MemoryItem{
+		ID:              item.ID,
+		Username:        item.Username,
+		Text:            item.Text,
+		Type:            item.Type,
+		SourceRunID:     item.SourceRunID,
+		SourceSessionID: item.SourceSessionID,
+		Importance:      item.Importance,
+		UseCount:        item.UseCount,
+		LastUsedAt:      item.LastUsedAt,
+	}
+	if len(item.Embedding) > 0 {
+		raw, _ := json.Marshal(item.Embedding)
+		record.EmbeddingJSON = string(raw)
+	}
+	return record
+}
+
+func memoryItemFromRecord(record *model.MemoryItem) *MemoryItem {
+	item := &MemoryItem{
+		ID:              record.ID,
+		Username:        record.Username,
+		Text:            record.Text,
+		Type:            record.Type,
+		SourceRunID:     record.SourceRunID,
+		SourceSessionID: record.SourceSessionID,
+		Importance:      record.Importance,
+		UseCount:        record.UseCount,
+		LastUsedAt:      record.LastUsedAt,
+		CreatedAt:       record.CreatedAt,
+		UpdatedAt:       record.UpdatedAt,
+	}
+	if record.EmbeddingJSON != "" {
+		_ = json.Unmarshal([]byte(record.EmbeddingJSON), &item.Embedding)
+	}
+	return item
+}
+
+// MemoryItemsEnabled 表示是否启用了事实级长期记忆。
+func (m *MemoryManager) MemoryItemsEnabled() bool {
+	return m != nil && m.items != nil
+}
+
+// AddMemoryItem 手动为用户写入一条记忆；与已有记忆文本相同时只会合并重要度。
+func (m *MemoryManager) AddMemoryItem(ctx context.Context, username string, item MemoryItem) (*MemoryItem, error) {
+	if m.items == nil {
+		return nil, ErrMemoryItemsDisabled
+	}
+	return m.items.add(ctx, normalizeUsername(username), item)
+}
+
+// ListMemoryItems 按写入顺序列出用户的全部记忆。
+func (m *MemoryManager) ListMemoryItems(ctx context.Context, username string) ([]MemoryItem, error) {
+	if m.items == nil {
+		return nil, ErrMemoryItemsDisabled
+	}
+	return m.items.list(ctx, normalizeUsername(username))
+}
+
+// ForgetMemoryItems 删除用户的指定记忆，返回实际删除条数。
+func (m *MemoryManager) ForgetMemoryItems(ctx context.Context, username string, ids ...uint) (int, error) {
+	if m.items == nil {
+		return 0, ErrMemoryItemsDisabled
+	}
+	return m.items.delete(ctx, normalizeUsername(username), ids...)
+}
+
+// RecallMemories 返回与 query 最相关的至多 topK 条记忆；topK 不大于 0 时使用配置值。
+func (m *MemoryManager) RecallMemories(ctx context.Context, username string, query string, topK int) ([]MemoryItem, error) {
+	if m.items == nil {
+		return nil, ErrMemoryItemsDisabled
+	}
+	return m.items.retrieve(ctx, normalizeUsername(username), query, topK, true)
+}
+
+// ExtractMemories 用配置的抽取器从 messages 里抽取新记忆并写入，返回写入或合并后的记忆。
+// 未配置抽取器时直接返回空结果。
+func (m *MemoryManager) ExtractMemories(ctx context.Context, username string, runID string, sessionID string, messages []llmModel.Message) ([]MemoryItem, error) {
+	if m.items == nil {
+		return nil, ErrMemoryItemsDisabled
+	}
+	if m.items.options.Extractor == nil || len(messages) == 0 {
+		return nil, nil
+	}
+
+	username = normalizeUsername(username)
+	existing, err := m.items.list(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := m.items.options.Extractor(ctx, existing, cloneMessages(messages))
+	if err != nil {
+		return nil, err
+	}
+
+	saved := make([]MemoryItem, 0, len(candidates))
+	for _, candidate := range candidates {
+		if compactWhitespace(candidate.Text) == "" {
+			continue
+		}
+		item, err := m.items.add(ctx, username, MemoryItem{
+			Text:            candidate.Text,
+			Type:            candidate.Type,
+			Importance:      candidate.Importance,
+			SourceRunID:     runID,
+			SourceSessionID: sessionID,
+		})
+		if err != nil {
+			return saved, err
+		}
+		saved = append(saved, *item)
+	}
+	return saved, nil
+}
+
+// DecayMemories 清理长期未被使用、衰减后重要度低于阈值的记忆，返回删除条数。
+func (m *MemoryManager) DecayMemories(ctx context.Context, username string) (int, error) {
+	if m.items == nil {
+		return 0, ErrMemoryItemsDisabled
+	}
+	return m.items.decay(ctx, normalizeUsername(username))
+}
+
+// renderMemoryItems 把检索结果渲染成注入上下文用的列表文本。
+func renderMemoryItems(items []MemoryItem) string {
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, fmt.Sprintf("- [%s] %s", item.Type, item.Text))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,268 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+func newTestItemMemory(t *testing.T, options MemoryItemOptions) *MemoryManager {
+	t.Helper()
+
+	options.Enabled = true
+	memory, err := NewMemoryManager(MemoryOptions{DB: newBareTestMemoryDB(t), Items: options})
+	if err != nil {
+		t.Fatalf("NewMemoryManager() error = %v", err)
+	}
+	return memory
+}
+
+func TestRecallMemoriesRanksRelevantItemsAndTouchesUsage(t *testing.T) {
+	memory := newTestItemMemory(t, MemoryItemOptions{TopK: 2})
+	ctx := context.Background()
+	for _, item := range []MemoryItem{
+		{Text: "用户喜欢用 Go 写后端服务", Type: MemoryItemTypePreference, Importance: 0.9},
+		{Text: "用户住在上海", Importance: 0.6},
+		{Text: "用户正在开发 agent 天气插件", Type: MemoryItemTypeProject},
+		{Text: "bob prefers vim"},
+	} {
+		username := "alice"
+		if strings.HasPrefix(item.Text, "bob") {
+			username = "bob"
+		}
+		if _, err := memory.AddMemoryItem(ctx, username, item); err != nil {
+			t.Fatalf("AddMemoryItem() error = %v", err)
+		}
+	}
+
+	items, err := memory.RecallMemories(ctx, "alice", "上海今天天气怎么样", 0)
+	if err != nil {
+		t.Fatalf("RecallMemories() error = %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("RecallMemories() = %#v, want top 2 items", items)
+	}
+	for _, item := range items {
+		if item.Username != "alice" || strings.Contains(item.Text, "Go") {
+			t.Fatalf("RecallMemories() = %#v, want only alice's related items", items)
+		}
+	}
+
+	all, err := memory.ListMemoryItems(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ListMemoryItems() error = %v", err)
+	}
+	for _, item := range all {
+		recalled := item.Text != "用户喜欢用 Go 写后端服务"
+		if recalled != (item.UseCount == 1) {
+			t.Fatalf("item %q use count = %d, want recall to touch only returned items", item.Text, item.UseCount)
+		}
+	}
+}
+
+func TestAddMemoryItemDeduplicatesNormalizedText(t *testing.T) {
+	memory := newTestItemMemory(t, MemoryItemOptions{})
+	ctx := context.Background()
+
+	if _, err := memory.AddMemoryItem(ctx, "alice", MemoryItem{Text: "Likes  tea", Importance: 0.3}); err != nil {
+		t.Fatalf("AddMemoryItem() error = %v", err)
+	}
+	merged, err := memory.AddMemoryItem(ctx, "alice", MemoryItem{Text: "likes tea", Importance: 0.8})
+	if err != nil {
+		t.Fatalf("AddMemoryItem() error = %v", err)
+	}
+	if merged.Importance != 0.8 {
+		t.Fatalf("merged importance = %v, want 0.8", merged.Importance)
+	}
+	if items, _ := memory.ListMemoryItems(ctx, "alice"); len(items) != 1 {
+		t.Fatalf("ListMemoryItems() = %#v, want a single merged item", items)
+	}
+
+	if deleted, err := memory.ForgetMemoryItems(ctx, "alice", merged.ID); err != nil || deleted != 1 {
+		t.Fatalf("ForgetMemoryItems() = %d, %v, want 1", deleted, err)
+	}
+	if items, _ := memory.RecallMemories(ctx, "alice", "tea", 0); len(items) != 0 {
+		t.Fatalf("RecallMemories() after forget = %#v, want none", items)
+	}
+}
+
+func TestDecayMemoriesPrunesStaleItems(t *testing.T) {
+	memory := newTestItemMemory(t, MemoryItemOptions{HalfLife: 24 * time.Hour, PruneBelow: 0.1})
+	ctx := context.Background()
+	now := time.Now()
+	memory.items.now = func() time.Time { return now.Add(-10 * 24 * time.Hour) }
+	if _, err := memory.AddMemoryItem(ctx, "alice", MemoryItem{Text: "old trivia", Importance: 0.5}); err != nil {
+		t.Fatalf("AddMemoryItem() error = %v", err)
+	}
+	memory.items.now = func() time.Time { return now }
+	if _, err := memory.AddMemoryItem(ctx, "alice", MemoryItem{Text: "fresh fact", Importance: 0.5}); err != nil {
+		t.Fatalf("AddMemoryItem() error = %v", err)
+	}
+
+	deleted, err := memory.DecayMemories(ctx, "alice")
+	if err != nil || deleted != 1 {
+		t.Fatalf("DecayMemories() = %d, %v, want 1 pruned", deleted, err)
+	}
+	items, _ := memory.ListMemoryItems(ctx, "alice")
+	if len(items) != 1 || items[0].Text != "fresh fact" {
+		t.Fatalf("ListMemoryItems() = %#v, want only the fresh item", items)
+	}
+}
+
+func TestRunPrunesDecayedMemoriesAfterExtraction(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{Content: "好的"},
+		{Content: `{"memories":[{"text":"用户住在上海","importance":0.8}]}`},
+	}}
+	agent, err := NewAgent(NewAgentOptions{
+		LLM: llm,
+		MemoryOptions: &MemoryOptions{DB: newBareTestMemoryDB(t), Items: MemoryItemOptions{
+			Enabled:    true,
+			HalfLife:   24 * time.Hour,
+			PruneBelow: 0.1,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+	ctx := context.Background()
+	now := time.Now()
+	agent.Memory.items.now = func() time.Time { return now.Add(-10 * 24 * time.Hour) }
+	if _, err := agent.Memory.AddMemoryItem(ctx, "", MemoryItem{Text: "old trivia", Importance: 0.5}); err != nil {
+		t.Fatalf("AddMemoryItem() error = %v", err)
+	}
+	agent.Memory.items.now = func() time.Time { return now }
+
+	if _, err := agent.Run(ctx, "", "我住在上海"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	items, err := agent.Memory.ListMemoryItems(ctx, "")
+	if err != nil || len(items) != 1 || items[0].Text != "用户住在上海" {
+		t.Fatalf("ListMemoryItems() = %#v, %v, want the stale item pruned after the run", items, err)
+	}
+}
+
+type fakeEmbedder map[string][]float32
+
+func (f fakeEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		vectors = append(vectors, f[text])
+	}
+	return vectors, nil
+}
+
+func TestRecallMemoriesUsesEmbeddingsWithoutLexicalOverlap(t *testing.T) {
+	memory := newTestItemMemory(t, MemoryItemOptions{Embedder: fakeEmbedder{
+		"owns a golden retriever": {1, 0},
+		"works night shifts":      {0, 1},
+		"what pet food to buy":    {0.9, 0.1},
+	}})
+	ctx := context.Background()
+	for _, text := range []string{"owns a golden retriever", "works night shifts"} {
+		if _, err := memory.AddMemoryItem(ctx, "alice", MemoryItem{Text: text}); err != nil {
+			t.Fatalf("AddMemoryItem() error = %v", err)
+		}
+	}
+
+	items, err := memory.RecallMemories(ctx, "alice", "what pet food to buy", 1)
+	if err != nil {
+		t.Fatalf("RecallMemories() error = %v", err)
+	}
+	if len(items) != 1 || items[0].Text != "owns a golden retriever" {
+		t.Fatalf("RecallMemories() = %#v, want the semantically closest item", items)
+	}
+}
+
+func TestRunExtractsMemoriesAndInjectsOnlyRelevantOnes(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{Content: "好的"},
+		{Content: `{"memories":[{"text":"用户住在上海","type":"fact","importance":0.8},{"text":"用户喜欢喝茶","type":"preference"}]}`},
+		{Content: "上海晴"},
+		{Content: `{"memories":[]}`},
+	}}
+	agent, err := NewAgent(NewAgentOptions{
+		LLM:           llm,
+		Model:         "main-model",
+		MemoryOptions: &MemoryOptions{DB: newBareTestMemoryDB(t), Items: MemoryItemOptions{Enabled: true}},
+	})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+
+	ctx := context.Background()
+	state, err := agent.Run(ctx, "", "我住在上海，平时爱喝茶")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	items, err := agent.Memory.ListMemoryItems(ctx, "")
+	if err != nil || len(items) != 2 {
+		t.Fatalf("ListMemoryItems() = %#v, %v, want 2 extracted items", items, err)
+	}
+	if items[0].SourceRunID != state.RunID || items[0].Importance != 0.8 || items[1].Importance != defaultMemoryImportance {
+		t.Fatalf("extracted items = %#v, want source run and importance defaults", items)
+	}
+
+	agent.Memory.ClearShortTerm()
+	if _, err := agent.Run(ctx, "", "上海天气"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	system := llm.requests[2].Messages[0]
+	if !strings.Contains(system.Content, "用户住在上海") || strings.Contains(system.Content, "喝茶") {
+		t.Fatalf("long-term block = %q, want only the relevant memory", system.Content)
+	}
+	extractRequest := llm.requests[3].Messages[1].Content
+	if strings.Contains(extractRequest, "爱喝茶") || !strings.Contains(extractRequest, "上海天气") {
+		t.Fatalf("extract request = %q, want only this run's messages", extractRequest)
+	}
+}
+
+func TestRunRecallsMemoriesOncePerRun(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{"city":"上海"}`}}},
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_2", Name: "lookup_weather", Arguments: `{"city":"上海"}`}}},
+		{Content: "上海晴"},
+		{Content: `{"memories":[]}`},
+	}}
+	agent, err := NewAgent(NewAgentOptions{
+		LLM:           llm,
+		Model:         "main-model",
+		Tools:         newWeatherRegistry(t),
+		MemoryOptions: &MemoryOptions{DB: newBareTestMemoryDB(t), Items: MemoryItemOptions{Enabled: true}},
+	})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := agent.Memory.AddMemoryItem(ctx, "", MemoryItem{Text: "用户住在上海"}); err != nil {
+		t.Fatalf("AddMemoryItem() error = %v", err)
+	}
+	if _, err := agent.Run(ctx, "", "上海天气"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if !strings.Contains(llm.requests[i].Messages[0].Content, "用户住在上海") {
+			t.Fatalf("request %d system = %q, want recalled memory on every step", i, llm.requests[i].Messages[0].Content)
+		}
+	}
+	items, err := agent.Memory.ListMemoryItems(ctx, "")
+	if err != nil || len(items) != 1 {
+		t.Fatalf("ListMemoryItems() = %#v, %v, want 1 item", items, err)
+	}
+	if items[0].UseCount != 1 {
+		t.Fatalf("use count = %d, want 1 per run regardless of step count", items[0].UseCount)
+	}
+}
+
+func TestNewMemoryManagerRejectsItemsWithoutDB(t *testing.T) {
+	_, err := NewMemoryManager(MemoryOptions{Items: MemoryItemOptions{Enabled: true}})
+	if !errors.Is(err, ErrMemoryItemsDisabled) {
+		t.Fatalf("NewMemoryManager() error = %v, want ErrMemoryItemsDisabled", err)
+	}
+}
@@ -0,0 +1,285 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	phase4migrate "agent_study/internal/migrate/phase4"
+)
+
+// MemoryExport 是用户长期记忆（摘要与记忆条目）的可移植快照，用于导出与导入。
+type MemoryExport struct {
+	Username   string       `json:"username"`
+	Summary    string       `json:"summary"`
+	Items      []MemoryItem `json:"items"`
+	ExportedAt time.Time    `json:"exported_at"`
+}
+
+// ForgetResult 描述一次“忘记”操作实际删除的内容。
+type ForgetResult struct {
+	Items        []MemoryItem `json:"items"`
+	SummaryLines []string     `json:"summary_lines"`
+}
+
+// Empty 表示这次操作没有命中任何记忆。
+func (r ForgetResult) Empty() bool {
+	return len(r.Items) == 0 && len(r.SummaryLines) == 0
+}
+
+// SearchMemoryItems 按相关度检索用户记忆，但不会刷新使用时间，适合管理界面浏览；
+// query 为空时按写入顺序返回全部记忆。
+func (m *MemoryManager) SearchMemoryItems(ctx context.Context, username string, query string, limit int) ([]MemoryItem, error) {
+	if m.items == nil {
+		return nil, ErrMemoryItemsDisabled
+	}
+	username = normalizeUsername(username)
+	if strings.TrimSpace(query) == "" {
+		items, err := m.items.list(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		if limit > 0 && len(items) > limit {
+			items = items[:limit]
+		}
+		return items, nil
+	}
+	return m.items.retrieve(ctx, username, query, limit, false)
+}
+
+// UpdateMemoryItem 修改用户的一条记忆，变更会写入审计记录。
+func (m *MemoryManager) UpdateMemoryItem(ctx context.Context, username string, id uint, patch MemoryItemPatch) (*MemoryItem, error) {
+	if m.items == nil {
+		return nil, ErrMemoryItemsDisabled
+	}
+	return m.items.update(ctx, normalizeUsername(username), id, patch)
+}
+
+// SetLongTermSummary 直接覆盖用户的长期摘要；summary 为空等价于清空摘要。
+func (m *MemoryManager) SetLongTermSummary(ctx context.Context, username string, summary string) error {
+	if m.longTerm == nil {
+		return ErrLongTermMemoryDisabled
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.longTerm.saveSummary(ctx, normalizeUsername(username), strings.TrimSpace(summary))
+}
+
+// ForgetMemories 删除与 query 匹配的记忆：记忆条目需要包含 query 的全部检索词，长期
+// 摘要里同样按行匹配并移除。用于响应用户“忘记 X”的要求。
+func (m *MemoryManager) ForgetMemories(ctx context.Context, username string, query string) (*ForgetResult, error) {
+	if m.longTerm == nil {
+		return nil, ErrLongTermMemoryDisabled
+	}
+	terms := memorySearchTerms(query)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("forget query is empty")
+	}
+	username = normalizeUsername(username)
+	result := &ForgetResult{}
+
+	if m.items != nil {
+		matched, err := m.items.matchAll(ctx, username, terms)
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]uint, 0, len(matched))
+		for _, item := range matched {
+			ids = append(ids, item.ID)
+		}
+		if _, err := m.items.delete(ctx, username, ids...); err != nil {
+			return nil, err
+		}
+		result.Items = matched
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, err := m.longTerm.getOrCreateWithContext(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	kept, removed := removeSummaryLines(record.Summary, terms)
+	if len(removed) > 0 {
+		if err := m.longTerm.saveSummary(ctx, username, kept); err != nil {
+			return nil, err
+		}
+	}
+	result.SummaryLines = removed
+	return result, nil
+}
+
+// ClearMemories 清空用户的全部长期记忆（摘要与记忆条目），返回删除的记忆条数。
+func (m *MemoryManager) ClearMemories(ctx context.Context, username string) (int, error) {
+	if m.longTerm == nil {
+		return 0, ErrLongTermMemoryDisabled
+	}
+	username = normalizeUsername(username)
+
+	deleted := 0
+	if m.items != nil {
+		items, err := m.items.list(ctx, username)
+		if err != nil {
+			return 0, err
+		}
+		ids := make([]uint, 0, len(items))
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		if deleted, err = m.items.delete(ctx, username, ids...); err != nil {
+			return 0, err
+		}
+	}
+	if err := m.SetLongTermSummary(ctx, username, ""); err != nil {
+		return deleted, err
+	}
+	return deleted, nil
+}
+
+// ExportMemories 导出用户的长期摘要与全部记忆条目。
+func (m *MemoryManager) ExportMemories(ctx context.Context, username string) (*MemoryExport, error) {
+	summary, err := m.LongTermSummaryFor(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	export := &MemoryExport{
+		Username:   normalizeUsername(username),
+		Summary:    summary,
+		Items:      []MemoryItem{},
+		ExportedAt: time.Now(),
+	}
+	if m.items != nil {
+		if export.Items, err = m.items.list(ctx, export.Username); err != nil {
+			return nil, err
+		}
+	}
+	return export, nil
+}
+
+// ImportMemories 把快照导入到 username 名下（与快照里的用户名无关），返回写入的记忆条数。
+// replace 为 true 时先清空已有记忆并用快照摘要覆盖；否则逐条合并，仅在已有摘要为空时
+// 采用快照摘要。
+func (m *MemoryManager) ImportMemories(ctx context.Context, username string, export MemoryExport, replace bool) (int, error) {
+	if m.longTerm == nil {
+		return 0, ErrLongTermMemoryDisabled
+	}
+	if len(export.Items) > 0 && m.items == nil {
+		return 0, ErrMemoryItemsDisabled
+	}
+	username = normalizeUsername(username)
+
+	if replace {
+		if _, err := m.ClearMemories(ctx, username); err != nil {
+			return 0, err
+		}
+	}
+	current, err := m.LongTermSummaryFor(ctx, username)
+	if err != nil {
+		return 0, err
+	}
+	if strings.TrimSpace(export.Summary) != "" && current == "" {
+		if err := m.SetLongTermSummary(ctx, username, export.Summary); err != nil {
+			return 0, err
+		}
+	}
+
+	imported := 0
+	for _, item := range export.Items {
+		item.ID = 0
+		if _, err := m.items.add(ctx, username, item); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// matchAll 返回文本同时包含全部检索词的记忆，供“忘记”这类需要精确命中的操作使用。
+func (s *memoryItemStore) matchAll(ctx context.Context, username string, terms []string) ([]MemoryItem, error) {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, quoteTerm(term))
+	}
+	var ids []uint
+	err := s.db.WithContext(ctx).Raw(
+		"SELECT item_id FROM "+phase4migrate.MemoryItemFTSTable+" WHERE "+phase4migrate.MemoryItemFTSTable+" MATCH ? AND username = ?",
+		strings.Join(quoted, " AND "), username,
+	).Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.list(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	matched := make(map[uint]struct{}, len(ids))
+	for _, id := range ids {
+		matched[id] = struct{}{}
+	}
+	result := make([]MemoryItem, 0, len(ids))
+	for _, item := range items {
+		if _, ok := matched[item.ID]; ok {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// removeSummaryLines 移除摘要中包含全部检索词的行，并顺带去掉因此变空的分节标题。
+func removeSummaryLines(summary string, terms []string) (string, []string) {
+	if summary == "" {
+		return summary, nil
+	}
+	lines := strings.Split(summary, "\n")
+	kept := make([]string, 0, len(lines))
+	removed := make([]string, 0)
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "## ") && containsAllTerms(memorySearchTerms(line), terms) {
+			removed = append(removed, line)
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if len(removed) == 0 {
+		return summary, nil
+	}
+
+	result := make([]string, 0, len(kept))
+	for i, line := range kept {
+		if strings.HasPrefix(line, "## ") && !sectionHasContent(kept[i+1:]) {
+			continue
+		}
+		result = append(result, line)
+	}
+	return strings.TrimSpace(strings.Join(result, "\n")), removed
+}
+
+func sectionHasContent(lines []string) bool {
+	for _, line := range lines {
+		if strings.HasPrefix(line, "## ") {
+			return false
+		}
+		if strings.TrimSpace(line) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAllTerms(have []string, want []string) bool {
+	set := make(map[string]struct{}, len(have))
+	for _, term := range have {
+		set[term] = struct{}{}
+	}
+	for _, term := range want {
+		if _, ok := set[term]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	"agent_study/pkg/tools"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestUpdateMemoryItemReindexesAndAudits(t *testing.T) {
+	memory := newTestItemMemory(t, MemoryItemOptions{})
+	ctx := WithMemoryAuditSource(context.Background(), MemoryAuditSource{Actor: MemoryActorUser})
+
+	item, err := memory.AddMemoryItem(ctx, "alice", MemoryItem{Text: "uses vim"})
+	if err != nil {
+		t.Fatalf("AddMemoryItem() error = %v", err)
+	}
+	text := "uses emacs"
+	if _, err := memory.UpdateMemoryItem(ctx, "alice", item.ID, MemoryItemPatch{Text: &text}); err != nil {
+		t.Fatalf("UpdateMemoryItem() error = %v", err)
+	}
+
+	if found, _ := memory.SearchMemoryItems(ctx, "alice", "vim", 0); len(found) != 0 {
+		t.Fatalf("SearchMemoryItems(vim) = %#v, want stale terms removed from index", found)
+	}
+	found, err := memory.SearchMemoryItems(ctx, "alice", "emacs", 0)
+	if err != nil || len(found) != 1 || found[0].UseCount != 0 {
+		t.Fatalf("SearchMemoryItems(emacs) = %#v, %v, want updated item without touching usage", found, err)
+	}
+
+	history, err := memory.MemoryAuditLog(ctx, "alice", &item.ID, 0)
+	if err != nil {
+		t.Fatalf("MemoryAuditLog() error = %v", err)
+	}
+	if len(history) != 2 || history[0].Action != MemoryActionUpdate || history[0].Before != "uses vim" || history[0].After != "uses emacs" {
+		t.Fatalf("history = %#v, want update on top of create", history)
+	}
+	if history[1].Action != MemoryActionCreate || history[1].Actor != MemoryActorUser {
+		t.Fatalf("history = %#v, want create by user", history)
+	}
+}
+
+func TestForgetMemoriesRemovesItemsAndSummaryLines(t *testing.T) {
+	memory := newTestItemMemory(t, MemoryItemOptions{})
+	ctx := context.Background()
+	for _, text := range []string{"用户住在上海浦东", "用户喜欢上海菜", "用户在北京工作"} {
+		if _, err := memory.AddMemoryItem(ctx, "alice", MemoryItem{Text: text}); err != nil {
+			t.Fatalf("AddMemoryItem() error = %v", err)
+		}
+	}
+	if err := memory.SetLongTermSummary(ctx, "alice", "## 事实\n- 住在上海浦东\n\n## 偏好\n- 喜欢喝茶"); err != nil {
+		t.Fatalf("SetLongTermSummary() error = %v", err)
+	}
+
+	result, err := memory.ForgetMemories(ctx, "alice", "住在上海")
+	if err != nil {
+		t.Fatalf("ForgetMemories() error = %v", err)
+	}
+	if len(result.Items) != 1 || result.Items[0].Text != "用户住在上海浦东" {
+		t.Fatalf("forgotten items = %#v, want only the matching item", result.Items)
+	}
+	if summary, _ := memory.LongTermSummaryFor(ctx, "alice"); summary != "## 偏好\n- 喜欢喝茶" {
+		t.Fatalf("summary = %q, want matching line and empty section removed", summary)
+	}
+	if items, _ := memory.ListMemoryItems(ctx, "alice"); len(items) != 2 {
+		t.Fatalf("ListMemoryItems() = %#v, want 2 remaining", items)
+	}
+}
+
+func TestExportImportMemoriesRoundTrip(t *testing.T) {
+	source := newTestItemMemory(t, MemoryItemOptions{})
+	ctx := context.Background()
+	if _, err := source.AddMemoryItem(ctx, "alice", MemoryItem{Text: "likes tea", Type: MemoryItemTypePreference, Importance: 0.9}); err != nil {
+		t.Fatalf("AddMemoryItem() error = %v", err)
+	}
+	if err := source.SetLongTermSummary(ctx, "alice", "alice summary"); err != nil {
+		t.Fatalf("SetLongTermSummary() error = %v", err)
+	}
+	export, err := source.ExportMemories(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ExportMemories() error = %v", err)
+	}
+
+	target, err := NewMemoryManager(MemoryOptions{DB: newBareTestMemoryDB(t), Items: MemoryItemOptions{Enabled: true}})
+	if err != nil {
+		t.Fatalf("NewMemoryManager() error = %v", err)
+	}
+	if _, err := target.AddMemoryItem(ctx, "bob", MemoryItem{Text: "stale"}); err != nil {
+		t.Fatalf("AddMemoryItem() error = %v", err)
+	}
+	imported, err := target.ImportMemories(ctx, "bob", *export, true)
+	if err != nil || imported != 1 {
+		t.Fatalf("ImportMemories() = %d, %v, want 1", imported, err)
+	}
+	items, _ := target.ListMemoryItems(ctx, "bob")
+	if len(items) != 1 || items[0].Text != "likes tea" || items[0].Type != MemoryItemTypePreference || items[0].Importance != 0.9 {
+		t.Fatalf("imported items = %#v, want exported item replacing stale one", items)
+	}
+	if summary, _ := target.LongTermSummaryFor(ctx, "bob"); summary != "alice summary" {
+		t.Fatalf("imported summary = %q", summary)
+	}
+}
+
+func TestForgetMemoryToolHonoursUserRequestWithinRun(t *testing.T) {
+	memory := newTestItemMemory(t, MemoryItemOptions{})
+	ctx := context.Background()
+	if _, err := memory.AddMemoryItem(ctx, "", MemoryItem{Text: "用户住在上海"}); err != nil {
+		t.Fatalf("AddMemoryItem() error = %v", err)
+	}
+
+	registry := tools.NewRegistry()
+	if err := registry.Register(NewForgetMemoryTool(memory)); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	checkpoints, err := NewCheckpointStore(memory.memoryDB())
+	if err != nil {
+		t.Fatalf("NewCheckpointStore() error = %v", err)
+	}
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: ForgetMemoryToolName, Arguments: `{"query":"上海"}`}}},
+		{Content: "好的，已经忘记了"},
+	}}
+	agent := &Agent{LLM: llm, Memory: memory, Tools: registry, Checkpoints: checkpoints}
+
+	state, err := agent.Run(ctx, "", "忘掉我住在上海这件事")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !strings.Contains(state.Steps[0].Observation, "forgot 1 memories") {
+		t.Fatalf("observation = %q, want forget result", state.Steps[0].Observation)
+	}
+	if items, _ := memory.ListMemoryItems(ctx, ""); len(items) != 0 {
+		t.Fatalf("ListMemoryItems() = %#v, want memory forgotten", items)
+	}
+	if system := llm.requests[0].Messages[0].Content; !strings.Contains(system, "用户住在上海") {
+		t.Fatalf("first request system = %q, want recalled memory", system)
+	}
+	if system := llm.requests[1].Messages[0].Content; strings.Contains(system, "用户住在上海") {
+		t.Fatalf("request after forget system = %q, want forgotten memory dropped from the run's recall", system)
+	}
+	audits, err := memory.MemoryAuditLog(ctx, "", nil, 1)
+	if err != nil || len(audits) != 1 {
+		t.Fatalf("MemoryAuditLog() = %#v, %v", audits, err)
+	}
+	if audits[0].Action != MemoryActionDelete || audits[0].Actor != MemoryActorAgent || audits[0].RunID != state.RunID {
+		t.Fatalf("audit = %#v, want delete attributed to run %s", audits[0], state.RunID)
+	}
+}
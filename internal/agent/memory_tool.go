@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"agent_study/pkg/tools"
+	toolTypes "agent_study/pkg/types"
+)
+
+const ForgetMemoryToolName = "forget_memory"
+
+// NewForgetMemoryTool 返回让 agent 响应用户“忘记 X”要求的工具；它删除当前会话所属
+// 用户的长期记忆中与 query 匹配的条目与摘要行，变更来源记为本次运行，并把删掉的条目
+// 移出本次运行已召回的记忆，后续步骤不会再看到它们。
+func NewForgetMemoryTool(memory *MemoryManager) tools.Tool {
+	return tools.Tool{
+		Name:        ForgetMemoryToolName,
+		Description: "Permanently forget long-term memories about the user that match the query. Call it when the user asks you to forget something; pass short keywords of the thing to forget.",
+		Source:      "builtin",
+		Parameters: toolTypes.JSONSchema{
+			Type: "object",
+			Properties: map[string]toolTypes.SchemaProperty{
+				"query": {Type: "string", Description: "Keywords of the memory to forget, e.g. 住在上海"},
+			},
+			Required: []string{"query"},
+		},
+		Handler: func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+			query, _ := arguments["query"].(string)
+			if strings.TrimSpace(query) == "" {
+				return "", fmt.Errorf("query is required")
+			}
+			username, err := memory.SessionUsername(ctx, MemoryAuditSourceFrom(ctx).SessionID)
+			if err != nil {
+				return "", err
+			}
+			result, err := memory.ForgetMemories(ctx, username, query)
+			if err != nil {
+				return "", err
+			}
+			if result.Empty() {
+				return "no matching memory found", nil
+			}
+			if run, ok := parentRunFrom(ctx); ok {
+				run.state.forgetRecalled(result.Items)
+			}
+			forgotten := make([]string, 0, len(result.Items)+len(result.SummaryLines))
+			for _, item := range result.Items {
+				forgotten = append(forgotten, item.Text)
+			}
+			forgotten = append(forgotten, result.SummaryLines...)
+			return fmt.Sprintf("forgot %d memories: %s", len(forgotten), strings.Join(forgotten, "; ")), nil
+		},
+	}
+}
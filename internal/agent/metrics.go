@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"time"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	sharedTypes "agent_study/pkg/types"
+)
+
+// ToolCallMetrics 记录单次工具调用的耗时。
+type ToolCallMetrics struct {
+	CallID  string        `json:"call_id"`
+	Name    string        `json:"name"`
+	Latency time.Duration `json:"latency"`
+}
+
+// StepMetrics 是单个 step 的用量、费用与耗时；用量与费用只统计该步的规划调用，
+// 计划生成、critic 审查等辅助调用只计入 CostTracker 的累计值。
+type StepMetrics struct {
+	Model string `json:"model,omitempty"`
+	// Tier 与 RouteReason 是配置了模型路由时选中的档位及依据（规则序号、classifier 或 default）。
+	Tier        string `json:"tier,omitempty"`
+	RouteReason string `json:"route_reason,omitempty"`
+	// SelectedTools 是开启工具检索时这一步携带给模型的工具。
+	SelectedTools []string            `json:"selected_tools,omitempty"`
+	Usage         llmModel.TokenUsage `json:"usage"`
+	// Cost 只在配置了 CostTracker 时计算。
+	Cost       sharedTypes.CostBreakdown `json:"cost"`
+	LLMLatency time.Duration             `json:"llm_latency"`
+	ToolCalls  []ToolCallMetrics         `json:"tool_calls,omitempty"`
+	// Critic 是 critic 审查该步候选回答的调用，未审查时为空；它的用量与费用不计入上面的
+	// Usage 与 Cost（那两项只描述规划调用），但会计入 RunStats。
+	Critic *CallMetrics `json:"critic,omitempty"`
+	// Duration 是整个 step 从规划到工具执行结束的耗时。
+	Duration time.Duration `json:"duration"`
+}
+
+// CallMetrics 记录规划调用之外的一次辅助模型调用的模型、用量、费用与耗时。
+type CallMetrics struct {
+	Model   string                    `json:"model,omitempty"`
+	Usage   llmModel.TokenUsage       `json:"usage"`
+	Cost    sharedTypes.CostBreakdown `json:"cost"`
+	Latency time.Duration             `json:"latency"`
+}
+
+// ToolLatency 返回该步所有工具调用耗时之和。
+func (m StepMetrics) ToolLatency() time.Duration {
+	var total time.Duration
+	for _, call := range m.ToolCalls {
+		total += call.Latency
+	}
+	return total
+}
+
+// RunStats 汇总一次运行里所有 step 的用量、费用与耗时。
+type RunStats struct {
+	Steps       int                       `json:"steps"`
+	ToolCalls   int                       `json:"tool_calls"`
+	Usage       llmModel.TokenUsage       `json:"usage"`
+	Cost        sharedTypes.CostBreakdown `json:"cost"`
+	LLMLatency  time.Duration             `json:"llm_latency"`
+	ToolLatency time.Duration             `json:"tool_latency"`
+	Duration    time.Duration             `json:"duration"`
+}
+
+func (s *RunStats) add(metrics StepMetrics) {
+	s.Steps++
+	s.ToolCalls += len(metrics.ToolCalls)
+	s.addUsage(metrics.Usage, metrics.Cost)
+	s.LLMLatency += metrics.LLMLatency
+	if critic := metrics.Critic; critic != nil {
+		s.addUsage(critic.Usage, critic.Cost)
+		s.LLMLatency += critic.Latency
+	}
+	s.ToolLatency += metrics.ToolLatency()
+	s.Duration += metrics.Duration
+}
+
+func (s *RunStats) addUsage(usage llmModel.TokenUsage, cost sharedTypes.CostBreakdown) {
+	s.Usage.PromptTokens += usage.PromptTokens
+	s.Usage.CachedPromptTokens += usage.CachedPromptTokens
+	s.Usage.CompletionTokens += usage.CompletionTokens
+	s.Usage.TotalTokens += usage.TotalTokens
+	s.Cost.UncachedPromptTokens += cost.UncachedPromptTokens
+	s.Cost.CachedPromptTokens += cost.CachedPromptTokens
+	s.Cost.CompletionTokens += cost.CompletionTokens
+	s.Cost.InputCostUSD += cost.InputCostUSD
+	s.Cost.CachedInputCostUSD += cost.CachedInputCostUSD
+	s.Cost.OutputCostUSD += cost.OutputCostUSD
+	s.Cost.TotalCostUSD += cost.TotalCostUSD
+}
+
+// appendStep 记录一个完成的 step：补上该步总耗时，推进 StepIndex 并累计运行汇总。
+func (s *State) appendStep(step Step, started time.Time) Step {
+	step.Metrics.Duration = time.Since(started)
+	s.Steps = append(s.Steps, step)
+	s.StepIndex = len(s.Steps)
+	s.Stats.add(step.Metrics)
+	return step
+}
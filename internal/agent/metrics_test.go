@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestRunRecordsPerStepMetricsAndRunStats(t *testing.T) {
+	tracker, err := NewCostTracker(toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 2, PerTokens: 1000},
+	}, 0)
+	if err != nil {
+		t.Fatalf("NewCostTracker() error = %v", err)
+	}
+	var events []StepEvent
+	agent := &Agent{
+		LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{
+			{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{}`}}, Usage: llmModel.TokenUsage{PromptTokens: 100, CompletionTokens: 10}},
+			{Content: "sunny", Usage: llmModel.TokenUsage{PromptTokens: 200, CachedPromptTokens: 50, CompletionTokens: 20}},
+		}},
+		Model:        "gpt-test",
+		Tools:        newWeatherRegistry(t),
+		Cost:         tracker,
+		StepCallback: func(event StepEvent) { events = append(events, event) },
+	}
+
+	state, err := agent.Run(context.Background(), "", "weather?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	first := state.Steps[0].Metrics
+	if first.Model != "gpt-test" || first.Usage.TotalTokens != 110 || math.Abs(first.Cost.TotalCostUSD-0.12) > 1e-9 {
+		t.Fatalf("first step metrics = %#v, want model, normalized usage and cost", first)
+	}
+	if len(first.ToolCalls) != 1 || first.ToolCalls[0].Name != "lookup_weather" || first.ToolCalls[0].CallID != "call_1" {
+		t.Fatalf("first step tool metrics = %#v", first.ToolCalls)
+	}
+	if first.Duration < first.LLMLatency+first.ToolLatency() {
+		t.Fatalf("step duration %v shorter than its parts", first.Duration)
+	}
+	if second := state.Steps[1].Metrics; second.Usage.CachedPromptTokens != 50 || len(second.ToolCalls) != 0 {
+		t.Fatalf("second step metrics = %#v", second)
+	}
+
+	stats := state.Stats
+	if stats.Steps != 2 || stats.ToolCalls != 1 || stats.Usage.PromptTokens != 300 {
+		t.Fatalf("stats = %#v, want totals over both steps", stats)
+	}
+	if stats.Cost.TotalCostUSD != tracker.Totals().Cost.TotalCostUSD {
+		t.Fatalf("stats cost = %v, tracker = %v", stats.Cost.TotalCostUSD, tracker.Totals().Cost.TotalCostUSD)
+	}
+	if events[0].Stats.Steps != 1 || events[1].Stats != stats {
+		t.Fatalf("event stats = %#v / %#v, want cumulative snapshots", events[0].Stats, events[1].Stats)
+	}
+}
@@ -0,0 +1,266 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+// HandoffToolName 是编排模式下暴露给模型的交接工具名。
+const HandoffToolName = "handoff"
+
+const defaultMaxHandoffs = 8
+
+var ErrUnknownAgent = errors.New("unknown agent")
+
+// Handoff 记录一次控制权交接及交接说明。
+type Handoff struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Note string `json:"note"`
+}
+
+// HandoffTarget 是当前 agent 可以交接的对象，Description 会写进交接工具的说明里。
+type HandoffTarget struct {
+	Name        string
+	Description string
+}
+
+// OrchestratedAgent 是参与编排的一个专职 agent；Handoffs 声明它可以把对话交给谁。
+type OrchestratedAgent struct {
+	Name        string
+	Description string
+	Agent       *Agent
+	Handoffs    []string
+}
+
+// OrchestratorOptions 描述一组共享同一份记忆、可以互相交接的 agent。
+type OrchestratorOptions struct {
+	Agents []OrchestratedAgent
+	// Entry 是接收用户任务的第一个 agent，为空时取 Agents[0]。
+	Entry string
+	// Memory 是所有 agent 共享的记忆，为空时创建进程内默认记忆。
+	Memory *MemoryManager
+	// Cost 是整个编排运行的总费用与总预算；各 agent 自己的预算仍单独生效。
+	Cost *CostTracker
+	// MaxHandoffs 是一次运行允许的交接次数，用尽后不再提供交接工具，默认 8。
+	MaxHandoffs int
+	// Checkpoints 非空时整个编排运行以一个运行 ID 落检查点，各 agent 自己的检查点存储不再使用。
+	Checkpoints  *CheckpointStore
+	StepCallback StepCallback
+}
+
+// Orchestrator 是多 agent 交接的监督者：按交接把控制权在 agent 之间转移，并负责
+// 交接次数与总预算。
+type Orchestrator struct {
+	agents       map[string]OrchestratedAgent
+	entry        string
+	memory       *MemoryManager
+	cost         *CostTracker
+	maxHandoffs  int
+	checkpoints  *CheckpointStore
+	StepCallback StepCallback
+}
+
+func NewOrchestrator(options OrchestratorOptions) (*Orchestrator, error) {
+	if len(options.Agents) == 0 {
+		return nil, fmt.Errorf("orchestrator requires at least one agent")
+	}
+	agents := make(map[string]OrchestratedAgent, len(options.Agents))
+	for _, member := range options.Agents {
+		member.Name = strings.TrimSpace(member.Name)
+		if member.Name == "" {
+			return nil, fmt.Errorf("orchestrated agent name is required")
+		}
+		if member.Agent == nil || member.Agent.LLM == nil {
+			return nil, fmt.Errorf("orchestrated agent %s: %w", member.Name, ErrAgentLLMRequired)
+		}
+		if _, ok := agents[member.Name]; ok {
+			return nil, fmt.Errorf("duplicate orchestrated agent %q", member.Name)
+		}
+		agents[member.Name] = member
+	}
+	for _, member := range agents {
+		for _, target := range member.Handoffs {
+			if _, ok := agents[target]; !ok {
+				return nil, fmt.Errorf("%w: %s declares handoff to %s", ErrUnknownAgent, member.Name, target)
+			}
+		}
+	}
+
+	entry := strings.TrimSpace(options.Entry)
+	if entry == "" {
+		entry = strings.TrimSpace(options.Agents[0].Name)
+	}
+	if _, ok := agents[entry]; !ok {
+		return nil, fmt.Errorf("%w: entry %s", ErrUnknownAgent, entry)
+	}
+
+	memory := options.Memory
+	if memory == nil {
+		var err error
+		memory, err = NewMemoryManager(MemoryOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	maxHandoffs := options.MaxHandoffs
+	if maxHandoffs <= 0 {
+		maxHandoffs = defaultMaxHandoffs
+	}
+
+	return &Orchestrator{
+		agents:       agents,
+		entry:        entry,
+		memory:       memory,
+		cost:         options.Cost,
+		maxHandoffs:  maxHandoffs,
+		checkpoints:  options.Checkpoints,
+		StepCallback: options.StepCallback,
+	}, nil
+}
+
+// Run 从入口 agent 开始处理任务，遇到交接就让目标 agent 在同一会话、同一 State 上
+// 继续，直到某个 agent 给出最终回答。State.Steps 按顺序记录所有 agent 的 step，
+// Step.Agent 标明出处。入口 agent 与单独运行时一样检查并记录任务、落第 0 步检查点；
+// 结束时由最后运行的 agent 通知它的 hook。
+func (o *Orchestrator) Run(ctx context.Context, sessionID string, task string) (*State, error) {
+	if _, err := o.memory.SessionUsername(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	history, err := o.memory.SessionMessages(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{SessionID: sessionID, MemoryOffset: len(history), Task: task, Agent: o.entry}
+	applyPromptVars(ctx, state)
+	if o.checkpoints != nil {
+		state.RunID = newRunID()
+	}
+
+	// 每个 agent 的费用 tracker 在整个编排运行内复用，它自己的预算跨多次交接累计。
+	trackers := make(map[string]*CostTracker, len(o.agents))
+	for first := true; ; first = false {
+		member := o.agents[state.Agent]
+		tracker, ok := trackers[member.Name]
+		if !ok {
+			tracker = newChildCostTracker(member.Agent.Cost, o.cost, member.Agent.Config.MaxBudgetUSD)
+			trackers[member.Name] = tracker
+		}
+
+		run := *member.Agent
+		run.Name = member.Name
+		run.Memory = o.memory
+		run.Cost = tracker
+		run.Checkpoints = o.checkpoints
+		run.StepCallback = o.StepCallback
+
+		state.handoffTargets = nil
+		if len(state.Handoffs) < o.maxHandoffs {
+			state.handoffTargets = o.handoffTargets(member)
+		}
+		handoffs := len(state.Handoffs)
+		var result *State
+		if first {
+			result, err = run.startRun(ctx, state)
+		} else {
+			result, err = run.runLoop(ctx, state, len(state.Steps)+run.maxSteps())
+		}
+		if err != nil {
+			return run.finishRun(ctx, state, nil, fmt.Errorf("agent %s: %w", member.Name, err))
+		}
+		if len(state.Handoffs) == handoffs {
+			return run.finishRun(ctx, state, result, nil)
+		}
+
+		// 交接后由目标 agent 重新开始，上一个 agent 的计划不再适用。
+		state.Agent = state.Handoffs[len(state.Handoffs)-1].To
+		state.Plan = nil
+	}
+}
+
+func (o *Orchestrator) handoffTargets(member OrchestratedAgent) []HandoffTarget {
+	targets := make([]HandoffTarget, 0, len(member.Handoffs))
+	for _, name := range member.Handoffs {
+		targets = append(targets, HandoffTarget{Name: name, Description: o.agents[name].Description})
+	}
+	return targets
+}
+
+// handoffTool 返回交接工具的声明，目标限定为当前 agent 声明过的对象。
+func handoffTool(targets []HandoffTarget) toolTypes.Tool {
+	names := make([]string, 0, len(targets))
+	lines := make([]string, 0, len(targets))
+	for _, target := range targets {
+		names = append(names, target.Name)
+		line := "- " + target.Name
+		if target.Description != "" {
+			line += ": " + target.Description
+		}
+		lines = append(lines, line)
+	}
+	return toolTypes.Tool{
+		Name:        HandoffToolName,
+		Description: "Transfer control of the conversation to another agent that is better suited to continue. The other agent sees the whole conversation plus your note. Available agents:\n" + strings.Join(lines, "\n"),
+		Parameters: toolTypes.JSONSchema{
+			Type: "object",
+			Properties: map[string]toolTypes.SchemaProperty{
+				"agent": {Type: "string", Description: "Name of the agent to hand off to", Enum: names},
+				"note":  {Type: "string", Description: "What has been done so far and what the next agent should do"},
+			},
+			Required: []string{"agent", "note"},
+		},
+	}
+}
+
+// findHandoff 在一批工具调用里查找交接调用；没有交接能力或没有交接调用时 ok 为 false。
+func findHandoff(state *State, calls []toolTypes.ToolCall) (toolTypes.ToolCall, bool) {
+	if len(state.handoffTargets) == 0 {
+		return toolTypes.ToolCall{}, false
+	}
+	for _, call := range calls {
+		if call.Name == HandoffToolName {
+			return call, true
+		}
+	}
+	return toolTypes.ToolCall{}, false
+}
+
+// handoff 处理一次交接调用：把调用和结果写回共享会话，合法时记入 State.Handoffs。
+// 同批的其他工具调用会被丢弃，交接后由目标 agent 决定是否重新调用。返回 false 表示
+// 交接被拒绝，原 agent 应继续执行。
+func (a *Agent) handoff(ctx context.Context, state *State, trace *Step, call toolTypes.ToolCall) (bool, error) {
+	trace.Action = Action{Kind: ActionKindHandoff, ToolCalls: []toolTypes.ToolCall{call}}
+	if err := a.remember(ctx, state, llmModel.Message{Role: llmModel.RoleAssistant, Reasoning: trace.Thought, ReasoningItems: trace.ReasoningItems, ToolCalls: trace.Action.ToolCalls}); err != nil {
+		return false, err
+	}
+
+	accepted := false
+	arguments, err := decodeToolArguments(call.Arguments)
+	if err != nil {
+		trace.Observation = fmt.Sprintf("invalid handoff arguments: %v", err)
+	} else {
+		target, _ := arguments["agent"].(string)
+		note, _ := arguments["note"].(string)
+		target = strings.TrimSpace(target)
+		trace.Observation = fmt.Sprintf("%v: cannot hand off to %q", ErrUnknownAgent, target)
+		for _, candidate := range state.handoffTargets {
+			if candidate.Name == target {
+				handoff := Handoff{From: a.Name, To: target, Note: strings.TrimSpace(note)}
+				trace.Action.Handoff = &handoff
+				trace.Observation = fmt.Sprintf("handed off from %s to %s: %s", handoff.From, handoff.To, handoff.Note)
+				state.Handoffs = append(state.Handoffs, handoff)
+				accepted = true
+				break
+			}
+		}
+	}
+	if err := a.remember(ctx, state, llmModel.Message{Role: llmModel.RoleTool, Content: trace.Observation, ToolCallId: call.ID}); err != nil {
+		return false, err
+	}
+	return accepted, nil
+}
@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestOrchestratorHandsOffBetweenAgentsSharingMemory(t *testing.T) {
+	triageLLM := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "h1", Name: HandoffToolName, Arguments: `{"agent":"coder","note":"需要修复 parser 的空指针"}`}}},
+	}}
+	coderLLM := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "c1", Name: "lookup_weather", Arguments: `{}`}}},
+		{ToolCalls: []toolTypes.ToolCall{{ID: "h2", Name: HandoffToolName, Arguments: `{"agent":"reviewer","note":"已修复，请审查"}`}}},
+	}}
+	reviewerLLM := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "修复正确，可以合并"}}}
+	var events []StepEvent
+	orchestrator, err := NewOrchestrator(OrchestratorOptions{
+		Agents: []OrchestratedAgent{
+			{Name: "triage", Agent: &Agent{LLM: triageLLM, System: []llmModel.Message{{Role: llmModel.RoleSystem, Content: "triage"}}}, Handoffs: []string{"coder"}},
+			{Name: "coder", Description: "writes code", Agent: &Agent{LLM: coderLLM, Tools: newWeatherRegistry(t)}, Handoffs: []string{"reviewer"}},
+			{Name: "reviewer", Description: "reviews changes", Agent: &Agent{LLM: reviewerLLM}},
+		},
+		StepCallback: func(event StepEvent) { events = append(events, event) },
+	})
+	if err != nil {
+		t.Fatalf("NewOrchestrator() error = %v", err)
+	}
+
+	state, err := orchestrator.Run(context.Background(), "", "parser 崩溃了")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.FinalAnswer != "修复正确，可以合并" || state.Agent != "reviewer" {
+		t.Fatalf("state = %#v, want reviewer answer", state)
+	}
+	want := []string{"triage", "coder", "coder", "reviewer"}
+	if len(state.Steps) != len(want) || len(events) != len(want) {
+		t.Fatalf("steps = %d, events = %d, want %d", len(state.Steps), len(events), len(want))
+	}
+	for i, name := range want {
+		if state.Steps[i].Agent != name || events[i].Step.Agent != name {
+			t.Fatalf("step %d agent = %q, want %q", i, state.Steps[i].Agent, name)
+		}
+	}
+	if len(state.Handoffs) != 2 || state.Handoffs[0] != (Handoff{From: "triage", To: "coder", Note: "需要修复 parser 的空指针"}) {
+		t.Fatalf("handoffs = %#v", state.Handoffs)
+	}
+	if state.Steps[0].Action.Kind != ActionKindHandoff || state.Steps[0].Action.Handoff.To != "coder" {
+		t.Fatalf("first step action = %#v, want handoff", state.Steps[0].Action)
+	}
+
+	tools := coderLLM.requests[0].Tools
+	if handoff := tools[len(tools)-1]; handoff.Name != HandoffToolName || !strings.Contains(handoff.Description, "reviewer: reviews changes") {
+		t.Fatalf("coder tools = %#v, want handoff tool listing reviewer", tools)
+	}
+	if len(reviewerLLM.requests[0].Tools) != 0 {
+		t.Fatalf("reviewer tools = %#v, want none without declared handoffs", reviewerLLM.requests[0].Tools)
+	}
+	// 共享记忆：reviewer 能看到原始任务、coder 的工具结果和交接说明。
+	transcript := reviewerLLM.requests[0].Messages
+	if transcript[0].Content != "parser 崩溃了" {
+		t.Fatalf("reviewer first message = %#v, want shared session", transcript[0])
+	}
+	if last := transcript[len(transcript)-1]; last.Role != llmModel.RoleTool || !strings.Contains(last.Content, "已修复，请审查") {
+		t.Fatalf("reviewer last message = %#v, want handoff note", last)
+	}
+}
+
+func TestOrchestratorEnforcesHandoffLimitAndTotalBudget(t *testing.T) {
+	ping := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "h1", Name: HandoffToolName, Arguments: `{"agent":"pong","note":"your turn"}`}}},
+		{Content: "ping done"},
+	}}
+	pong := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "h2", Name: HandoffToolName, Arguments: `{"agent":"nobody","note":"?"}`}}},
+		{ToolCalls: []toolTypes.ToolCall{{ID: "h3", Name: HandoffToolName, Arguments: `{"agent":"ping","note":"back"}`}}},
+	}}
+	orchestrator, err := NewOrchestrator(OrchestratorOptions{
+		Agents: []OrchestratedAgent{
+			{Name: "ping", Agent: &Agent{LLM: ping}, Handoffs: []string{"pong"}},
+			{Name: "pong", Agent: &Agent{LLM: pong}, Handoffs: []string{"ping"}},
+		},
+		MaxHandoffs: 2,
+	})
+	if err != nil {
+		t.Fatalf("NewOrchestrator() error = %v", err)
+	}
+	state, err := orchestrator.Run(context.Background(), "", "task")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.FinalAnswer != "ping done" || len(state.Handoffs) != 2 {
+		t.Fatalf("state = %#v, want two handoffs then answer", state)
+	}
+	if !strings.Contains(state.Steps[1].Observation, "cannot hand off") {
+		t.Fatalf("invalid handoff observation = %q", state.Steps[1].Observation)
+	}
+	if len(ping.requests[1].Tools) != 0 {
+		t.Fatalf("tools after limit = %#v, want handoff tool withdrawn", ping.requests[1].Tools)
+	}
+
+	total, _ := NewCostTracker(toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}, 0.5)
+	spender, err := NewOrchestrator(OrchestratorOptions{
+		Agents: []OrchestratedAgent{{Name: "solo", Agent: &Agent{LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{
+			{Content: "expensive", Usage: llmModel.TokenUsage{PromptTokens: 1000}},
+		}}}}},
+		Cost: total,
+	})
+	if err != nil {
+		t.Fatalf("NewOrchestrator() error = %v", err)
+	}
+	if state, err := spender.Run(context.Background(), "", "task"); err != nil || state.StopReason != StopReasonBudget {
+		t.Fatalf("Run() = %#v, %v, want graceful stop on total budget", state, err)
+	}
+
+	if _, err := NewOrchestrator(OrchestratorOptions{Agents: []OrchestratedAgent{{Name: "a", Agent: &Agent{LLM: ping}, Handoffs: []string{"b"}}}}); !errors.Is(err, ErrUnknownAgent) {
+		t.Fatalf("NewOrchestrator() error = %v, want unknown handoff target", err)
+	}
+}
+
+func TestOrchestratorRunsThroughTheAgentRunLifecycle(t *testing.T) {
+	redact, err := NewRegexGuardrail("redact", []string{`sk-\w+`}, RegexGuardrailOptions{Stages: []GuardrailStage{GuardrailStageInput}, Replacement: "***"})
+	if err != nil {
+		t.Fatalf("NewRegexGuardrail() error = %v", err)
+	}
+	checkpoints, err := NewCheckpointStore(newBareTestMemoryDB(t))
+	if err != nil {
+		t.Fatalf("NewCheckpointStore() error = %v", err)
+	}
+	var calls []string
+	triageHook := &recordingHook{name: "triage", calls: &calls}
+	coderHook := &recordingHook{name: "coder", calls: &calls}
+	orchestrator, err := NewOrchestrator(OrchestratorOptions{
+		Agents: []OrchestratedAgent{
+			{Name: "triage", Agent: &Agent{LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{
+				{ToolCalls: []toolTypes.ToolCall{{ID: "h1", Name: HandoffToolName, Arguments: `{"agent":"coder","note":"rotate the key"}`}}},
+			}}, Guardrails: []Guardrail{redact}, Hooks: []Hook{triageHook}}, Handoffs: []string{"coder"}},
+			{Name: "coder", Agent: &Agent{LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "rotated"}}}, Hooks: []Hook{coderHook}}},
+		},
+		Checkpoints: checkpoints,
+	})
+	if err != nil {
+		t.Fatalf("NewOrchestrator() error = %v", err)
+	}
+
+	state, err := orchestrator.Run(context.Background(), "", "my key sk-abc123 leaked")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.Task != "my key *** leaked" || len(state.Guardrails) != 1 {
+		t.Fatalf("state = %#v, want task redacted by the entry agent's input guardrail", state)
+	}
+	if coderHook.finished != state || triageHook.finished != nil {
+		t.Fatalf("finish hooks: coder = %v, triage = %v, want only the last agent notified", coderHook.finished, triageHook.finished)
+	}
+	initial, err := checkpoints.LoadStep(context.Background(), state.RunID, 0)
+	if err != nil {
+		t.Fatalf("LoadStep(0) error = %v", err)
+	}
+	if len(initial.Memory) != 1 || initial.Memory[0].Content != "my key *** leaked" {
+		t.Fatalf("initial checkpoint memory = %#v, want the redacted task only", initial.Memory)
+	}
+	if initial.Status != RunStatusCompleted || initial.FinalAnswer != "rotated" {
+		t.Fatalf("run summary = %#v, want completed orchestrated run", initial.RunSummary)
+	}
+}
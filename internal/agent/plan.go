@@ -0,0 +1,313 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	llmModel "agent_study/pkg/llm_core/model"
+)
+
+// Mode 决定 Agent 的执行策略。
+type Mode string
+
+const (
+	// ModeReAct 是默认模式：每一步直接让模型在工具调用与最终回答之间选择。
+	ModeReAct Mode = "react"
+	// ModePlanExecute 先生成显式计划，再按计划逐项用工具循环执行，失败时可重新规划。
+	ModePlanExecute Mode = "plan_execute"
+)
+
+type PlanItemStatus string
+
+const (
+	PlanItemPending    PlanItemStatus = "pending"
+	PlanItemInProgress PlanItemStatus = "in_progress"
+	PlanItemDone       PlanItemStatus = "done"
+	PlanItemFailed     PlanItemStatus = "failed"
+)
+
+const (
+	defaultMaxReplans = 2
+	// planStepFailedPrefix/planReplanPrefix 是执行阶段约定的回复前缀，模型用它们声明当前
+	// 计划项失败或需要根据新信息调整计划。
+	planStepFailedPrefix = "STEP_FAILED:"
+	planReplanPrefix     = "REPLAN:"
+)
+
+var (
+	ErrPlanInvalid = errors.New("invalid plan")
+	// ErrReplanLimitExceeded 表示计划需要再次调整但已用完 MaxReplans，运行按上限体面结束。
+	ErrReplanLimitExceeded = errors.New("plan revision limit reached")
+)
+
+// StopReasonReplans 表示计划重新规划次数用完，FinalAnswer 是已完成计划项的进展摘要。
+const StopReasonReplans StopReason = "replans"
+
+// PlanItem 是计划中的一项，带有可检查的完成标准和执行结果。
+type PlanItem struct {
+	ID              int            `json:"id"`
+	Description     string         `json:"description"`
+	SuccessCriteria string         `json:"success_criteria"`
+	Status          PlanItemStatus `json:"status"`
+	// Result 是该项完成时的结论，或失败/重新规划的原因。
+	Result string `json:"result,omitempty"`
+}
+
+// Plan 是 plan-and-execute 模式下的有序计划；Revision 记录重新规划的次数。
+type Plan struct {
+	Items    []PlanItem `json:"items"`
+	Revision int        `json:"revision"`
+}
+
+// PlanProgress 是计划的执行进度摘要，供 CLI 展示清单。
+type PlanProgress struct {
+	Done    int
+	Total   int
+	Current int
+}
+
+// Current 返回正在执行的计划项；计划已全部结束时返回 nil。
+func (p *Plan) Current() *PlanItem {
+	if p == nil {
+		return nil
+	}
+	for i := range p.Items {
+		if p.Items[i].Status == PlanItemInProgress || p.Items[i].Status == PlanItemPending {
+			return &p.Items[i]
+		}
+	}
+	return nil
+}
+
+func (p *Plan) Progress() PlanProgress {
+	progress := PlanProgress{}
+	if p == nil {
+		return progress
+	}
+	progress.Total = len(p.Items)
+	for _, item := range p.Items {
+		if item.Status == PlanItemDone {
+			progress.Done++
+		}
+	}
+	if current := p.Current(); current != nil {
+		progress.Current = current.ID
+	}
+	return progress
+}
+
+// Clone 返回计划的深拷贝，StepEvent 携带的是快照，避免回调方看到后续修改。
+func (p *Plan) Clone() *Plan {
+	if p == nil {
+		return nil
+	}
+	cloned := *p
+	cloned.Items = append([]PlanItem(nil), p.Items...)
+	return &cloned
+}
+
+// Checklist 把计划渲染成清单文本。
+func (p *Plan) Checklist() string {
+	if p == nil {
+		return ""
+	}
+	lines := make([]string, 0, len(p.Items))
+	for _, item := range p.Items {
+		mark := " "
+		switch item.Status {
+		case PlanItemDone:
+			mark = "x"
+		case PlanItemInProgress:
+			mark = ">"
+		case PlanItemFailed:
+			mark = "!"
+		}
+		line := fmt.Sprintf("[%s] %d. %s", mark, item.ID, item.Description)
+		if item.SuccessCriteria != "" {
+			line += "（完成标准：" + item.SuccessCriteria + "）"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+const plannerPrompt = `你是任务规划器。请把用户任务拆成有序、可执行的步骤，每一步写清楚要做什么以及可检查的完成标准。
+规则：
+1. 步骤数量尽量少，一般不超过 %d 步；
+2. 最后一步必须是“基于前面步骤的结果给出最终回答”；
+3. 只输出 JSON：{"steps":[{"description":"","success_criteria":""}]}，不要输出其他内容。`
+
+const replannerPrompt = `你是任务规划器。执行过程中出现了问题或新信息，需要调整剩余计划。
+请只输出尚未完成部分的新步骤（已完成的步骤会保留，不要重复），最后一步必须是“给出最终回答”。
+只输出 JSON：{"steps":[{"description":"","success_criteria":""}]}，不要输出其他内容。`
+
+const planExecutionTmpl = `你正在按计划执行任务。
+任务：%s
+计划：
+%s
+当前只执行第 %d 步：%s
+完成标准：%s
+可以调用工具。该步满足完成标准后，直接回复这一步的结论（最后一步请直接给出面向用户的最终回答）。
+若这一步无法完成，回复以 ` + planStepFailedPrefix + ` 开头并说明原因；若新信息表明计划需要调整，回复以 ` + planReplanPrefix + ` 开头并说明原因。`
+
+const maxPlanItems = 8
+
+// MakePlan 让模型为 state.Task 生成初始计划，并把第一项置为执行中。
+func (a *Agent) MakePlan(ctx context.Context, state *State) (*Plan, error) {
+	items, err := a.requestPlanItems(ctx, []llmModel.Message{
+		{Role: llmModel.RoleSystem, Content: fmt.Sprintf(plannerPrompt, maxPlanItems)},
+		{Role: llmModel.RoleUser, Content: state.Task},
+	})
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{}
+	plan.append(items)
+	plan.Items[0].Status = PlanItemInProgress
+	return plan, nil
+}
+
+// Replan 保留已完成的计划项，让模型根据失败原因或新信息重写剩余步骤。
+func (a *Agent) Replan(ctx context.Context, state *State, reason string) error {
+	if state.Plan == nil {
+		return fmt.Errorf("%w: no plan to revise", ErrPlanInvalid)
+	}
+	maxReplans := a.Config.MaxReplans
+	if maxReplans <= 0 {
+		maxReplans = defaultMaxReplans
+	}
+	if state.Plan.Revision >= maxReplans {
+		return fmt.Errorf("%w (%d): %s", ErrReplanLimitExceeded, maxReplans, reason)
+	}
+
+	request := "任务：" + state.Task + "\n\n当前计划：\n" + state.Plan.Checklist() + "\n\n调整原因：" + reason
+	items, err := a.requestPlanItems(ctx, []llmModel.Message{
+		{Role: llmModel.RoleSystem, Content: replannerPrompt},
+		{Role: llmModel.RoleUser, Content: request},
+	})
+	if err != nil {
+		return err
+	}
+
+	kept := make([]PlanItem, 0, len(state.Plan.Items))
+	for _, item := range state.Plan.Items {
+		if item.Status == PlanItemDone || item.Status == PlanItemFailed {
+			kept = append(kept, item)
+		}
+	}
+	state.Plan.Items = kept
+	state.Plan.Revision++
+	state.Plan.append(items)
+	if current := state.Plan.Current(); current != nil {
+		current.Status = PlanItemInProgress
+	}
+	return nil
+}
+
+// advancePlan 根据执行阶段的 finish 回复推进计划；返回 true 表示整个计划已完成，
+// answer 即最终回答。
+func (a *Agent) advancePlan(ctx context.Context, state *State, answer string) (bool, error) {
+	current := state.Plan.Current()
+	if current == nil {
+		return true, nil
+	}
+
+	trimmed := strings.TrimSpace(answer)
+	switch {
+	case strings.HasPrefix(trimmed, planStepFailedPrefix):
+		current.Status = PlanItemFailed
+		current.Result = strings.TrimSpace(strings.TrimPrefix(trimmed, planStepFailedPrefix))
+		return false, a.Replan(ctx, state, fmt.Sprintf("第 %d 步失败：%s", current.ID, current.Result))
+	case strings.HasPrefix(trimmed, planReplanPrefix):
+		current.Status = PlanItemPending
+		return false, a.Replan(ctx, state, strings.TrimSpace(strings.TrimPrefix(trimmed, planReplanPrefix)))
+	}
+
+	current.Status = PlanItemDone
+	current.Result = trimmed
+	next := state.Plan.Current()
+	if next == nil {
+		return true, nil
+	}
+	next.Status = PlanItemInProgress
+	return false, nil
+}
+
+// planContext 返回执行阶段注入的计划说明；非计划模式或计划已结束时返回空串。
+func planContext(state *State) string {
+	if state == nil || state.Plan == nil {
+		return ""
+	}
+	current := state.Plan.Current()
+	if current == nil {
+		return ""
+	}
+	criteria := current.SuccessCriteria
+	if criteria == "" {
+		criteria = "（未指定）"
+	}
+	return fmt.Sprintf(planExecutionTmpl, state.Task, state.Plan.Checklist(), current.ID, current.Description, criteria)
+}
+
+func (a *Agent) requestPlanItems(ctx context.Context, messages []llmModel.Message) ([]PlanItem, error) {
+	if a.LLM == nil {
+		return nil, fmt.Errorf("agent llm is not configured")
+	}
+	response, err := a.LLM.Chat(ctx, llmModel.ChatRequest{Model: a.Model, Messages: messages})
+	if err != nil {
+		return nil, err
+	}
+	if a.Cost != nil {
+		if _, err := a.Cost.AddUsage(response.Usage); err != nil {
+			return nil, err
+		}
+	}
+	return parsePlanItems(response.Content)
+}
+
+func parsePlanItems(content string) ([]PlanItem, error) {
+	_, answer := llmModel.SplitLeadingThinkBlock(content)
+	answer = strings.TrimSpace(answer)
+	if start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}"); start >= 0 && end > start {
+		answer = answer[start : end+1]
+	}
+
+	var payload struct {
+		Steps []PlanItem `json:"steps"`
+	}
+	if err := json.Unmarshal([]byte(answer), &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPlanInvalid, err)
+	}
+	items := make([]PlanItem, 0, len(payload.Steps))
+	for _, step := range payload.Steps {
+		if description := compactWhitespace(step.Description); description != "" {
+			items = append(items, PlanItem{Description: description, SuccessCriteria: compactWhitespace(step.SuccessCriteria)})
+		}
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: plan has no steps", ErrPlanInvalid)
+	}
+	if len(items) > maxPlanItems {
+		items = items[:maxPlanItems]
+	}
+	return items, nil
+}
+
+// append 追加计划项并按现有最大编号续号，重新规划后编号依旧唯一。
+func (p *Plan) append(items []PlanItem) {
+	nextID := 1
+	for _, item := range p.Items {
+		if item.ID >= nextID {
+			nextID = item.ID + 1
+		}
+	}
+	for _, item := range items {
+		item.ID = nextID
+		item.Status = PlanItemPending
+		p.Items = append(p.Items, item)
+		nextID++
+	}
+}
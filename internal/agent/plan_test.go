@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestPlanExecuteRunsEachPlanItemWithToolLoop(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{Content: "```json\n{\"steps\":[{\"description\":\"查询上海天气\",\"success_criteria\":\"拿到天气状况\"},{\"description\":\"给出最终回答\"}]}\n```"},
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{}`}}},
+		{Content: "上海晴"},
+		{Content: "上海今天晴，适合出门"},
+	}}
+	var events []StepEvent
+	agent := &Agent{
+		LLM:          llm,
+		Tools:        newWeatherRegistry(t),
+		Config:       Config{Mode: ModePlanExecute},
+		StepCallback: func(event StepEvent) { events = append(events, event) },
+	}
+
+	state, err := agent.Run(context.Background(), "", "上海今天适合出门吗")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.FinalAnswer != "上海今天晴，适合出门" {
+		t.Fatalf("FinalAnswer = %q", state.FinalAnswer)
+	}
+	for _, item := range state.Plan.Items {
+		if item.Status != PlanItemDone {
+			t.Fatalf("plan = %#v, want all items done", state.Plan)
+		}
+	}
+	if state.Plan.Items[0].Result != "上海晴" {
+		t.Fatalf("first item result = %q, want step conclusion", state.Plan.Items[0].Result)
+	}
+	if state.Steps[0].PlanItemID != 1 || state.Steps[2].PlanItemID != 2 {
+		t.Fatalf("steps = %#v, want plan item ids recorded", state.Steps)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("events = %d, want 3", len(events))
+	}
+	if events[1].Progress != (PlanProgress{Done: 1, Total: 2, Current: 2}) {
+		t.Fatalf("second event progress = %#v", events[1].Progress)
+	}
+	if events[0].Plan.Items[0].Status != PlanItemInProgress {
+		t.Fatalf("first event plan = %#v, want snapshot taken before later progress", events[0].Plan)
+	}
+
+	execution := llm.requests[1].Messages[0]
+	if execution.Role != llmModel.RoleSystem || !strings.Contains(execution.Content, "当前只执行第 1 步：查询上海天气") {
+		t.Fatalf("execution request should carry the plan context, got %#v", execution)
+	}
+}
+
+func TestPlanExecuteReplansAfterFailedStep(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{Content: `{"steps":[{"description":"读取配置文件"},{"description":"给出最终回答"}]}`},
+		{Content: "STEP_FAILED: 文件不存在"},
+		{Content: `{"steps":[{"description":"说明无法读取并给出建议"}]}`},
+		{Content: "配置文件不存在，请先创建"},
+	}}
+	agent := &Agent{LLM: llm, Config: Config{Mode: ModePlanExecute}}
+
+	state, err := agent.Run(context.Background(), "", "读一下配置")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	plan := state.Plan
+	if plan.Revision != 1 || len(plan.Items) != 2 {
+		t.Fatalf("plan = %#v, want failed item kept plus one new item", plan)
+	}
+	if plan.Items[0].Status != PlanItemFailed || plan.Items[0].Result != "文件不存在" {
+		t.Fatalf("first item = %#v, want failed with reason", plan.Items[0])
+	}
+	if plan.Items[1].ID != 2 || plan.Items[1].Status != PlanItemDone {
+		t.Fatalf("second item = %#v, want new item numbered after kept ones and done", plan.Items[1])
+	}
+	if !strings.Contains(llm.requests[2].Messages[1].Content, "第 1 步失败：文件不存在") {
+		t.Fatalf("replan request = %q, want failure reason", llm.requests[2].Messages[1].Content)
+	}
+}
+
+func TestPlanExecuteStopsAfterReplanLimit(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{Content: `{"steps":[{"description":"a"},{"description":"b"}]}`},
+		{Content: "a 的结论"},
+		{Content: "STEP_FAILED: x"},
+		{Content: `{"steps":[{"description":"c"}]}`},
+		{Content: "STEP_FAILED: y"},
+	}}
+	agent := &Agent{LLM: llm, Config: Config{Mode: ModePlanExecute, MaxReplans: 1}}
+
+	state, err := agent.Run(context.Background(), "", "task")
+	if err != nil {
+		t.Fatalf("Run() error = %v, want graceful stop", err)
+	}
+	if state.StopReason != StopReasonReplans {
+		t.Fatalf("stop reason = %q, want %q", state.StopReason, StopReasonReplans)
+	}
+	if len(state.Steps) != 3 || state.Plan.Items[0].Status != PlanItemDone {
+		t.Fatalf("state = %#v, want all executed steps and completed items kept", state)
+	}
+	if !strings.Contains(state.FinalAnswer, "[x] 1. a") || !strings.Contains(state.FinalAnswer, "a 的结论") {
+		t.Fatalf("final answer = %q, want progress with completed plan item", state.FinalAnswer)
+	}
+}
@@ -7,52 +7,132 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 )
 
 // Plan 除了返回动作和文本 thought，还会把 provider 返回的结构化 reasoning items
 // 一并交给上层，供记忆回放和 CLI 展示使用。
 func (a *Agent) Plan(ctx context.Context, state *State) (*Action, string, []llmModel.ReasoningItem, error) {
+	action, think, reasoningItems, _, err := a.plan(ctx, state)
+	return action, think, reasoningItems, err
+}
+
+// plan 是 Plan 的实现，额外返回本次规划调用的用量、费用、模型与耗时，供 step 记账。
+func (a *Agent) plan(ctx context.Context, state *State) (*Action, string, []llmModel.ReasoningItem, StepMetrics, error) {
 	if a == nil || a.LLM == nil {
-		return nil, "", nil, fmt.Errorf("agent llm is not configured")
+		return nil, "", nil, StepMetrics{}, fmt.Errorf("agent llm is not configured")
 	}
 
+	messages, err := a.buildMessages(ctx, state)
+	if err != nil {
+		return nil, "", nil, StepMetrics{}, err
+	}
 	request := llmModel.ChatRequest{
 		Model:    a.Model,
-		Messages: a.BuildMessage(ctx, state),
+		Messages: messages,
+	}
+	tools, selectedTools, err := a.selectTools(ctx, state, request.Messages)
+	if err != nil {
+		return nil, "", nil, StepMetrics{}, fmt.Errorf("select tools: %w", err)
 	}
-	if a.Tools != nil {
-		request.Tools = a.Tools.List()
+	request.Tools = tools
+	if state != nil && len(state.handoffTargets) > 0 {
+		request.Tools = append(request.Tools, handoffTool(state.handoffTargets))
 	}
-	if len(request.Tools) > 0 {
+	applyOutput(state, &request)
+	switch {
+	case request.ToolChoice.Type != "":
+	case len(request.Tools) > 0 && !forceFinish(state):
 		request.ToolChoice = toolTypes.ToolChoice{Type: toolTypes.ToolAuto}
-	} else {
+	default:
 		request.ToolChoice = toolTypes.ToolChoice{Type: toolTypes.ToolNone}
 	}
 
-	response, err := a.LLM.Chat(ctx, request)
+	request.MaxTokens = a.Config.MaxOutputTokens
+	route, err := a.route(ctx, state, &request)
 	if err != nil {
-		return nil, "", nil, err
+		return nil, "", nil, StepMetrics{}, err
+	}
+	llm, pricing, err := a.preflight(state, &request, route.tier)
+	if err != nil {
+		return nil, "", nil, StepMetrics{}, err
+	}
+
+	// hook 看到的是预算检查（含降级）之后、真正发出的请求。
+	event := &PlanHookEvent{State: state, Request: &request}
+	if err := a.hookBeforePlan(ctx, event); err != nil {
+		return nil, "", nil, StepMetrics{}, err
+	}
+	started := time.Now()
+	if event.Response == nil {
+		response, err := llm.Chat(ctx, request)
+		if err != nil {
+			return nil, "", nil, StepMetrics{}, err
+		}
+		event.Response = &response
+	}
+	latency := time.Since(started)
+	if err := a.hookAfterPlan(ctx, event); err != nil {
+		return nil, "", nil, StepMetrics{}, err
+	}
+	response := *event.Response
+	metrics := StepMetrics{Model: request.Model, Usage: response.Usage, LLMLatency: latency, SelectedTools: selectedTools}
+	if route.tier != nil && request.Model == route.tier.Model {
+		// 预算不足降级后不再记作路由选中的档位。
+		metrics.Tier, metrics.RouteReason = route.tier.Name, route.reason
+	}
+	if usage, err := normalizeUsage(response.Usage); err == nil {
+		metrics.Usage = usage
 	}
 	if a.Cost != nil {
-		if _, err := a.Cost.AddUsage(response.Usage); err != nil {
-			return nil, "", nil, err
+		var breakdown toolTypes.CostBreakdown
+		if pricing != nil {
+			breakdown, err = a.Cost.AddUsageWithPricing(response.Usage, *pricing)
+		} else {
+			breakdown, err = a.Cost.AddUsage(response.Usage)
+		}
+		metrics.Cost = breakdown
+		if err != nil {
+			return nil, "", nil, metrics, err
 		}
 	}
-	_ = state
 	action, think := ParseAction(response)
-	return &action, think, response.ReasoningItems, nil
+	finalAnswerAction(state, &action)
+	return &action, think, response.ReasoningItems, metrics, nil
 }
 
 const LtMemoryTmpl = `以下是该用户的长期记忆，若与本次任务无关，请勿参考此块内容
 %s
 `
 
+// BuildMessage 构造规划请求的消息列表；模板化 system prompt 渲染失败时记录日志并跳过
+// 模板，规划调用本身则会以该错误结束。
 func (a *Agent) BuildMessage(ctx context.Context, state *State) []llmModel.Message {
+	msgs, err := a.buildMessages(ctx, state)
+	if err != nil {
+		log.Warnf("build messages: %v", err)
+	}
+	return msgs
+}
+
+func (a *Agent) buildMessages(ctx context.Context, state *State) ([]llmModel.Message, error) {
 	var msgs []llmModel.Message
 	msgs = append(msgs, a.System...)
-	// 将之前的长期记忆拿出来
+	system, renderErr := a.renderSystemPrompts(ctx, state)
+	msgs = append(msgs, system...)
+	systemCount := len(msgs)
+	// 将之前的长期记忆拿出来；长期记忆按会话所属用户读取，同一用户的多个会话共享。
 	if a.Memory != nil {
-		long, err := a.Memory.LongTermSummary(ctx)
+		sessionID := ""
+		if state != nil {
+			sessionID = state.SessionID
+		}
+		username, err := a.Memory.SessionUsername(ctx, sessionID)
+		if err != nil {
+			log.Infof("resolve session user failed: %v", err)
+			username = a.Memory.Username()
+		}
+		long, err := a.longTermContext(ctx, state, username)
 		if errors.Is(err, ErrLongTermMemoryDisabled) || long == "" {
 			// no-op
 		} else if err != nil {
@@ -63,12 +143,70 @@ func (a *Agent) BuildMessage(ctx context.Context, state *State) []llmModel.Messa
 				Content: fmt.Sprintf(LtMemoryTmpl, long),
 			})
 		}
+		// 计划模式下把计划清单和当前步骤作为 system 提示注入，它随计划推进而变化，因此不写入记忆。
+		if plan := planContext(state); plan != "" {
+			msgs = append(msgs, llmModel.Message{Role: llmModel.RoleSystem, Content: plan})
+		}
+		// 类型化运行的输出格式要求同样按当前进度注入，不写入记忆。
+		if output := outputContext(state); output != "" {
+			msgs = append(msgs, llmModel.Message{Role: llmModel.RoleSystem, Content: output})
+		}
 		// 短期记忆拿出来 TODO：压缩短期上下文(滑动窗口，窗口可固定)
-		msgs = append(msgs, a.Memory.ShortTermMessages()...)
+		shortTerm, err := a.Memory.SessionMessages(ctx, sessionID)
+		if err != nil {
+			log.Infof("load session messages failed: %v", err)
+		}
+		msgs = append(msgs, shortTerm...)
 	}
 	// 边缘条件，漏传用户提示词场景
-	if len(msgs) == len(a.System) && state != nil && state.Task != "" {
+	if len(msgs) == systemCount && state != nil && state.Task != "" {
 		msgs = append(msgs, llmModel.Message{Role: llmModel.RoleUser, Content: state.Task})
 	}
-	return msgs
+	if renderErr != nil {
+		return msgs, fmt.Errorf("render system prompt: %w", renderErr)
+	}
+	return msgs, nil
+}
+
+// longTermContext 返回注入上下文的长期记忆：启用事实级记忆时只取与任务相关的 top-k
+// 条，否则退回整段摘要。事实级记忆每次运行只召回一次，之后的步骤复用缓存，
+// 这样 use_count 和 last_used_at 反映的是实际被用到的运行次数而不是步数。
+func (a *Agent) longTermContext(ctx context.Context, state *State, username string) (string, error) {
+	if !a.Memory.MemoryItemsEnabled() {
+		return a.Memory.LongTermSummaryFor(ctx, username)
+	}
+	if state != nil && state.recalled {
+		return renderMemoryItems(state.recalledItems), nil
+	}
+	query := ""
+	if state != nil {
+		query = state.Task
+	}
+	items, err := a.Memory.RecallMemories(ctx, username, query, 0)
+	if err != nil {
+		return "", err
+	}
+	if state != nil {
+		state.recalled = true
+		state.recalledItems = items
+	}
+	return renderMemoryItems(items), nil
+}
+
+// forgetRecalled 从本次运行的召回缓存里去掉已被删除的记忆，使后续步骤不再注入它们。
+func (s *State) forgetRecalled(forgotten []MemoryItem) {
+	if len(forgotten) == 0 || len(s.recalledItems) == 0 {
+		return
+	}
+	ids := make(map[uint]struct{}, len(forgotten))
+	for _, item := range forgotten {
+		ids[item.ID] = struct{}{}
+	}
+	kept := make([]MemoryItem, 0, len(s.recalledItems))
+	for _, item := range s.recalledItems {
+		if _, ok := ids[item.ID]; !ok {
+			kept = append(kept, item)
+		}
+	}
+	s.recalledItems = kept
 }
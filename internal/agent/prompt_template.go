@@ -0,0 +1,194 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"agent_study/internal/model"
+	llmModel "agent_study/pkg/llm_core/model"
+
+	"gorm.io/gorm"
+)
+
+var ErrPromptTemplateNotFound = errors.New("prompt template not found")
+
+// SystemPromptOptions 配置按 text/template 渲染的 system prompt。模板在每次规划构造请求
+// 时渲染，排在 Agent.System 的静态消息之后，因此日期、剩余预算等变量总是当前值。
+type SystemPromptOptions struct {
+	Templates []*PromptTemplate
+	// Workspace 是模板中 {{.Workspace}} 的值，为空时使用进程工作目录。
+	Workspace string
+	// Vars 是所有运行共享的自定义变量，同名时被 WithPromptVars 传入的运行变量覆盖。
+	Vars map[string]string
+	// Now 只用于测试固定时间，为空时使用 time.Now。
+	Now func() time.Time
+}
+
+// PromptTemplate 是一个已解析的 system prompt 模板。
+type PromptTemplate struct {
+	Name string
+	tmpl *template.Template
+}
+
+// PromptData 是渲染模板时的数据，模板里以 {{.Date}}、{{.Vars.project}} 等形式引用；
+// 不存在的自定义变量渲染为空字符串。
+type PromptData struct {
+	Now time.Time
+	// Date 与 Time 是 Now 按 2006-01-02 与 15:04 格式化的结果，Timezone 是时区名。
+	Date      string
+	Time      string
+	Timezone  string
+	OS        string
+	Arch      string
+	Workspace string
+	// Username 是当前会话所属用户，SessionID 与 Task 来自运行状态。
+	Username  string
+	SessionID string
+	Task      string
+	// Tools 是注册的全部工具名（按字母序），不受工具检索筛选影响。
+	Tools []string
+	// RemainingBudgetUSD 只在 HasBudget 为 true（设置了预算）时有意义。
+	RemainingBudgetUSD float64
+	HasBudget          bool
+	Vars               map[string]string
+}
+
+var promptTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"usd": func(amount float64) string {
+		return fmt.Sprintf("$%.4f", amount)
+	},
+}
+
+// ParsePromptTemplate 解析模板文本；除标准函数外还提供 join（拼接字符串切片）与 usd
+// （格式化金额）。
+func ParsePromptTemplate(name string, text string) (*PromptTemplate, error) {
+	tmpl, err := template.New(name).Funcs(promptTemplateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse prompt template %s: %w", name, err)
+	}
+	return &PromptTemplate{Name: name, tmpl: tmpl}, nil
+}
+
+// LoadPromptTemplateFile 从文件读取模板，模板名为文件名。
+func LoadPromptTemplateFile(path string) (*PromptTemplate, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read prompt template: %w", err)
+	}
+	return ParsePromptTemplate(filepath.Base(path), string(raw))
+}
+
+// LoadPromptTemplate 从 phase 1 的 prompts 表按名称读取模板；同名记录有多条时取最近
+// 更新的一条。
+func LoadPromptTemplate(ctx context.Context, db *gorm.DB, name string) (*PromptTemplate, error) {
+	if db == nil {
+		return nil, fmt.Errorf("prompt template %s: database is not configured", name)
+	}
+	var prompts []model.Prompt
+	if err := db.WithContext(ctx).Where("name = ?", name).Order("updated_at DESC, id DESC").Limit(1).Find(&prompts).Error; err != nil {
+		return nil, err
+	}
+	if len(prompts) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPromptTemplateNotFound, name)
+	}
+	return ParsePromptTemplate(name, prompts[0].Content)
+}
+
+// Render 用 data 渲染模板。
+func (t *PromptTemplate) Render(data PromptData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render prompt template %s: %w", t.Name, err)
+	}
+	return buf.String(), nil
+}
+
+type promptVarsKey struct{}
+
+// WithPromptVars 让 ctx 携带本次运行的自定义模板变量。Run、Resume 与 Fork 会把它们合并
+// 进 State.PromptVars 并随检查点保存，恢复的运行不必重新传入。
+func WithPromptVars(ctx context.Context, vars map[string]string) context.Context {
+	return context.WithValue(ctx, promptVarsKey{}, vars)
+}
+
+// applyPromptVars 把 ctx 携带的运行变量合并进 state，同名时覆盖已有值。
+func applyPromptVars(ctx context.Context, state *State) {
+	vars, _ := ctx.Value(promptVarsKey{}).(map[string]string)
+	if len(vars) == 0 {
+		return
+	}
+	merged := make(map[string]string, len(state.PromptVars)+len(vars))
+	maps.Copy(merged, state.PromptVars)
+	maps.Copy(merged, vars)
+	state.PromptVars = merged
+}
+
+// renderSystemPrompts 渲染 SystemPrompt 中的模板，每个模板产生一条 system 消息。
+func (a *Agent) renderSystemPrompts(ctx context.Context, state *State) ([]llmModel.Message, error) {
+	if a.SystemPrompt == nil || len(a.SystemPrompt.Templates) == 0 {
+		return nil, nil
+	}
+	data := a.promptData(ctx, state)
+	messages := make([]llmModel.Message, 0, len(a.SystemPrompt.Templates))
+	for _, tmpl := range a.SystemPrompt.Templates {
+		content, err := tmpl.Render(data)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, llmModel.Message{Role: llmModel.RoleSystem, Content: content})
+	}
+	return messages, nil
+}
+
+func (a *Agent) promptData(ctx context.Context, state *State) PromptData {
+	options := a.SystemPrompt
+	now := time.Now()
+	if options.Now != nil {
+		now = options.Now()
+	}
+	data := PromptData{
+		Now:       now,
+		Date:      now.Format(time.DateOnly),
+		Time:      now.Format("15:04"),
+		Timezone:  now.Location().String(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Workspace: options.Workspace,
+		Vars:      make(map[string]string, len(options.Vars)),
+	}
+	if data.Workspace == "" {
+		data.Workspace, _ = os.Getwd()
+	}
+	maps.Copy(data.Vars, options.Vars)
+	if state != nil {
+		data.SessionID = state.SessionID
+		data.Task = state.Task
+		maps.Copy(data.Vars, state.PromptVars)
+	}
+	if a.Memory != nil {
+		username, err := a.Memory.SessionUsername(ctx, data.SessionID)
+		if err != nil {
+			username = a.Memory.Username()
+		}
+		data.Username = username
+	}
+	if a.Tools != nil {
+		for _, tool := range a.Tools.List() {
+			data.Tools = append(data.Tools, tool.Name)
+		}
+	}
+	if a.Cost != nil {
+		data.RemainingBudgetUSD, data.HasBudget = a.Cost.availableBudgetUSD()
+	}
+	return data
+}
@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"agent_study/internal/model"
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestRunRendersSystemPromptTemplateWithBuiltinAndRunVars(t *testing.T) {
+	tmpl, err := ParsePromptTemplate("assistant", "Today is {{.Date}} on {{.OS}} in {{.Workspace}}. Tools: {{join .Tools \", \"}}. Budget left: {{usd .RemainingBudgetUSD}}. Project: {{.Vars.project}}, owner: {{.Vars.owner}}{{.Vars.missing}}.")
+	if err != nil {
+		t.Fatalf("ParsePromptTemplate() error = %v", err)
+	}
+	tracker, err := NewCostTracker(toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}, 1.5)
+	if err != nil {
+		t.Fatalf("NewCostTracker() error = %v", err)
+	}
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "done"}}}
+	agent := &Agent{
+		System: []llmModel.Message{{Role: llmModel.RoleSystem, Content: "static"}},
+		LLM:    llm,
+		Tools:  newWeatherRegistry(t),
+		Cost:   tracker,
+		SystemPrompt: &SystemPromptOptions{
+			Templates: []*PromptTemplate{tmpl},
+			Workspace: "/srv/app",
+			Vars:      map[string]string{"project": "default", "owner": "ops"},
+			Now:       func() time.Time { return time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC) },
+		},
+	}
+
+	ctx := WithPromptVars(context.Background(), map[string]string{"project": "atlas"})
+	state, err := agent.Run(ctx, "", "hello")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	messages := llm.requests[0].Messages
+	want := "Today is 2026-03-14 on " + runtime.GOOS + " in /srv/app. Tools: lookup_weather. Budget left: $1.5000. Project: atlas, owner: ops."
+	if len(messages) < 3 || messages[0].Content != "static" || messages[1].Role != llmModel.RoleSystem || messages[1].Content != want {
+		t.Fatalf("request messages = %#v, want static system then rendered template %q", messages, want)
+	}
+	// 运行变量保存在 State 里，恢复与分叉的运行不必重新传入。
+	if state.PromptVars["project"] != "atlas" {
+		t.Fatalf("state.PromptVars = %#v, want run variables kept", state.PromptVars)
+	}
+}
+
+func TestRunFailsWhenSystemPromptTemplateCannotRender(t *testing.T) {
+	tmpl, err := ParsePromptTemplate("broken", "{{.Vars.project.Name}}")
+	if err != nil {
+		t.Fatalf("ParsePromptTemplate() error = %v", err)
+	}
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "done"}}}
+	agent := &Agent{LLM: llm, SystemPrompt: &SystemPromptOptions{Templates: []*PromptTemplate{tmpl}}}
+
+	_, err = agent.Run(WithPromptVars(context.Background(), map[string]string{"project": "atlas"}), "", "hello")
+	if err == nil || !strings.Contains(err.Error(), "render system prompt") {
+		t.Fatalf("Run() error = %v, want render error", err)
+	}
+	if len(llm.requests) != 0 {
+		t.Fatalf("llm requests = %d, want no call with a broken prompt", len(llm.requests))
+	}
+	if _, err := ParsePromptTemplate("bad", "{{.Date"); err == nil {
+		t.Fatalf("ParsePromptTemplate() error = nil, want parse error")
+	}
+}
+
+func TestLoadPromptTemplateReadsLatestPromptByName(t *testing.T) {
+	db := newBareTestMemoryDB(t)
+	if err := db.AutoMigrate(&model.Prompt{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	now := time.Now()
+	for _, prompt := range []model.Prompt{
+		{Name: "assistant", Content: "old {{.Task}}", CreatedAt: now, UpdatedAt: now.Add(-time.Hour)},
+		{Name: "assistant", Content: "new {{.Task}}", CreatedAt: now, UpdatedAt: now},
+	} {
+		if err := db.Create(&prompt).Error; err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	tmpl, err := LoadPromptTemplate(context.Background(), db, "assistant")
+	if err != nil {
+		t.Fatalf("LoadPromptTemplate() error = %v", err)
+	}
+	if got, err := tmpl.Render(PromptData{Task: "weather?"}); err != nil || got != "new weather?" {
+		t.Fatalf("Render() = %q, %v, want latest prompt rendered", got, err)
+	}
+	if _, err := LoadPromptTemplate(context.Background(), db, "missing"); !errors.Is(err, ErrPromptTemplateNotFound) {
+		t.Fatalf("LoadPromptTemplate() error = %v, want ErrPromptTemplateNotFound", err)
+	}
+}
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"agent_study/internal/log"
+	llmModel "agent_study/pkg/llm_core/model"
+	sharedTypes "agent_study/pkg/types"
+)
+
+type Verdict string
+
+const (
+	VerdictApprove Verdict = "approve"
+	VerdictRevise  Verdict = "revise"
+)
+
+const defaultMaxReflections = 2
+
+// ReflectionOptions 开启最终回答前的自我审查：critic 对照任务与工具观察检查回答，
+// 不通过时把意见送回主循环再迭代一次。
+type ReflectionOptions struct {
+	// LLM 与 Model 是 critic 使用的模型，可以与主模型不同；NewAgent 会在为空时回填 Agent 自己的。
+	LLM   llmModel.LlmClient
+	Model string
+	// Pricing 为空时按 CostTracker 自己的价格计费。
+	Pricing *sharedTypes.ModelPricing
+	// MaxReflections 是单次运行最多打回的次数，用尽后直接接受回答，默认 2。
+	MaxReflections int
+}
+
+const criticPrompt = `你是严格的答案审查员。请对照用户任务和执行过程中的工具观察，检查候选回答：
+1. 是否完整回答了任务；
+2. 是否与工具观察矛盾，或包含观察中没有依据的内容。
+只输出 JSON：{"verdict":"approve" 或 "revise","feedback":"不通过时给出具体修改意见"}，不要输出其他内容。`
+
+const critiqueFeedbackTmpl = `审查未通过，请根据以下意见修正后重新给出最终回答：
+%s`
+
+type critique struct {
+	Verdict  Verdict `json:"verdict"`
+	Feedback string  `json:"feedback"`
+}
+
+// reflect 对候选最终回答做一次审查并把结果写入 step；回答被打回时返回修改意见，由主循环
+// 写回会话后继续迭代。critic 的网络或解析失败不会中断运行，按通过
+// 处理；触达运行上限或上下文结束则原样返回，由主循环按上限或失败收尾。
+func (a *Agent) reflect(ctx context.Context, state *State, step *Step, answer string) (string, error) {
+	if a.Reflection == nil {
+		return "", nil
+	}
+	maxReflections := a.Reflection.MaxReflections
+	if maxReflections <= 0 {
+		maxReflections = defaultMaxReflections
+	}
+	if state.Reflections >= maxReflections {
+		return "", nil
+	}
+
+	result, metrics, err := a.critique(ctx, state, answer)
+	step.Metrics.Critic = metrics
+	if err != nil {
+		if _, limited := limitReason(ctx, err); limited || ctx.Err() != nil {
+			return "", err
+		}
+		log.Warnf("critic failed, accepting answer: %v", err)
+		return "", nil
+	}
+	step.Verdict = result.Verdict
+	step.Critique = result.Feedback
+	if result.Verdict != VerdictRevise {
+		return "", nil
+	}
+
+	state.Reflections++
+	feedback := result.Feedback
+	if strings.TrimSpace(feedback) == "" {
+		feedback = "回答与任务或工具观察不一致。"
+	}
+	return fmt.Sprintf(critiqueFeedbackTmpl, feedback), nil
+}
+
+// critique 请求 critic 审查候选回答；调用前和规划一样做 token 与预算预检。只要模型
+// 返回了结果，返回的 CallMetrics 就不为空，即使后续计费或解析失败。
+func (a *Agent) critique(ctx context.Context, state *State, answer string) (critique, *CallMetrics, error) {
+	model := a.Reflection.Model
+	if model == "" {
+		model = a.Model
+	}
+
+	observations := make([]string, 0, len(state.Steps))
+	for i, step := range state.Steps {
+		if step.Observation != "" {
+			observations = append(observations, fmt.Sprintf("Step %d: %s", i+1, step.Observation))
+		}
+	}
+	if len(observations) == 0 {
+		observations = append(observations, "（无工具调用）")
+	}
+
+	request := llmModel.ChatRequest{
+		Messages: []llmModel.Message{
+			{Role: llmModel.RoleSystem, Content: criticPrompt},
+			{Role: llmModel.RoleUser, Content: "任务：\n" + state.Task + "\n\n工具观察：\n" + strings.Join(observations, "\n") + "\n\n候选回答：\n" + answer},
+		},
+	}
+	llm, pricing, err := a.preflight(state, &request, &ModelTier{LLM: a.Reflection.LLM, Model: model, Pricing: a.Reflection.Pricing})
+	if err != nil {
+		return critique{}, nil, err
+	}
+	started := time.Now()
+	response, err := llm.Chat(ctx, request)
+	if err != nil {
+		return critique{}, nil, err
+	}
+	metrics := &CallMetrics{Model: request.Model, Usage: response.Usage, Latency: time.Since(started)}
+	if a.Cost != nil {
+		if pricing != nil {
+			metrics.Cost, err = a.Cost.AddUsageWithPricing(response.Usage, *pricing)
+		} else {
+			metrics.Cost, err = a.Cost.AddUsage(response.Usage)
+		}
+		if err != nil {
+			return critique{}, metrics, err
+		}
+	}
+	result, err := parseCritique(response.Content)
+	return result, metrics, err
+}
+
+func parseCritique(content string) (critique, error) {
+	_, answer := llmModel.SplitLeadingThinkBlock(content)
+	answer = strings.TrimSpace(answer)
+	if start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}"); start >= 0 && end > start {
+		answer = answer[start : end+1]
+	}
+
+	var result critique
+	if err := json.Unmarshal([]byte(answer), &result); err != nil {
+		return critique{}, fmt.Errorf("decode critique: %w", err)
+	}
+	result.Verdict = Verdict(strings.ToLower(strings.TrimSpace(string(result.Verdict))))
+	if result.Verdict != VerdictApprove && result.Verdict != VerdictRevise {
+		return critique{}, fmt.Errorf("decode critique: unknown verdict %q", result.Verdict)
+	}
+	result.Feedback = strings.TrimSpace(result.Feedback)
+	return result, nil
+}
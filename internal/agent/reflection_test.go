@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestReflectionSendsCritiqueBackIntoLoop(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{}`}}},
+		{Content: "今天下雨"},
+		{Content: "今天晴"},
+	}}
+	critic := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{Content: `{"verdict":"revise","feedback":"工具显示 sunny，回答却说下雨"}`},
+		{Content: `{"verdict":"approve"}`},
+	}}
+	agent, err := NewAgent(NewAgentOptions{
+		LLM:        llm,
+		Model:      "main-model",
+		Tools:      newWeatherRegistry(t),
+		Reflection: &ReflectionOptions{LLM: critic},
+	})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+
+	state, err := agent.Run(context.Background(), "", "今天天气如何")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.FinalAnswer != "今天晴" || state.Reflections != 1 {
+		t.Fatalf("state = %#v, want revised answer after one reflection", state)
+	}
+	if len(state.Steps) != 3 {
+		t.Fatalf("steps = %d, want tool step, rejected answer and accepted answer", len(state.Steps))
+	}
+	rejected := state.Steps[1]
+	if rejected.Verdict != VerdictRevise || rejected.Critique != "工具显示 sunny，回答却说下雨" {
+		t.Fatalf("rejected step = %#v, want critique stored", rejected)
+	}
+	if state.Steps[2].Verdict != VerdictApprove {
+		t.Fatalf("final step verdict = %q, want approve", state.Steps[2].Verdict)
+	}
+
+	if critic.requests[0].Model != "main-model" {
+		t.Fatalf("critic model = %q, want agent model fallback", critic.requests[0].Model)
+	}
+	if !strings.Contains(critic.requests[0].Messages[1].Content, `lookup_weather => {"condition":"sunny"}`) {
+		t.Fatalf("critic request should include observations, got %q", critic.requests[0].Messages[1].Content)
+	}
+	last := llm.requests[2].Messages
+	if feedback := last[len(last)-1]; feedback.Role != llmModel.RoleUser || !strings.Contains(feedback.Content, "回答却说下雨") {
+		t.Fatalf("retry request last message = %#v, want critique feedback", feedback)
+	}
+}
+
+func TestReflectionPricesCriticSeparatelyAndRecordsItOnStep(t *testing.T) {
+	tracker, err := NewCostTracker(toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}, 0)
+	if err != nil {
+		t.Fatalf("NewCostTracker() error = %v", err)
+	}
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "sunny", Usage: llmModel.TokenUsage{PromptTokens: 1000}}}}
+	critic := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: `{"verdict":"approve"}`, Usage: llmModel.TokenUsage{PromptTokens: 1000}}}}
+	agent, err := NewAgent(NewAgentOptions{
+		LLM:   llm,
+		Model: "big",
+		Cost:  tracker,
+		Reflection: &ReflectionOptions{LLM: critic, Model: "small", Pricing: &toolTypes.ModelPricing{
+			Input:  toolTypes.TokenPrice{AmountUSD: 0.1, PerTokens: 1000},
+			Output: toolTypes.TokenPrice{AmountUSD: 0.1, PerTokens: 1000},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+
+	state, err := agent.Run(context.Background(), "", "weather?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	metrics := state.Steps[0].Metrics
+	if metrics.Critic == nil || metrics.Critic.Model != "small" || metrics.Critic.Usage.PromptTokens != 1000 {
+		t.Fatalf("critic metrics = %#v, want critic call recorded on the step", metrics.Critic)
+	}
+	if got := metrics.Critic.Cost.TotalCostUSD; got < 0.0999 || got > 0.1001 {
+		t.Fatalf("critic cost = %v, want critic pricing", got)
+	}
+	if got := metrics.Cost.TotalCostUSD; got < 0.999 || got > 1.001 {
+		t.Fatalf("planning cost = %v, want main model pricing only", got)
+	}
+	if got := tracker.Totals().Cost.TotalCostUSD; got < 1.0999 || got > 1.1001 {
+		t.Fatalf("tracked cost = %v, want both calls at their own prices", got)
+	}
+	if state.Stats.Usage.PromptTokens != 2000 || state.Stats.Cost.TotalCostUSD != tracker.Totals().Cost.TotalCostUSD {
+		t.Fatalf("run stats = %#v, want critic usage rolled up", state.Stats)
+	}
+}
+
+func TestReflectionIsBoundedAndToleratesCriticErrors(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "a"}, {Content: "b"}}}
+	critic := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: `{"verdict":"revise","feedback":"again"}`}}}
+	agent := &Agent{LLM: llm, Reflection: &ReflectionOptions{LLM: critic, MaxReflections: 1}}
+
+	state, err := agent.Run(context.Background(), "", "task")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.FinalAnswer != "b" || len(critic.requests) != 1 {
+		t.Fatalf("state = %#v, critic calls = %d, want answer accepted once the limit is reached", state, len(critic.requests))
+	}
+
+	failing := &Agent{LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "ok"}}}, Reflection: &ReflectionOptions{LLM: &fakeLlmClient{chatErr: errors.New("down")}}}
+	state, err = failing.Run(context.Background(), "", "task")
+	if err != nil || state.FinalAnswer != "ok" {
+		t.Fatalf("Run() = %#v, %v, want answer accepted when critic fails", state, err)
+	}
+}
+
+func TestReflectionPreflightsCriticAndStopsAtBudget(t *testing.T) {
+	tracker, err := NewCostTracker(toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}, 1.01)
+	if err != nil {
+		t.Fatalf("NewCostTracker() error = %v", err)
+	}
+	critic := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: `{"verdict":"approve"}`}}}
+	agent := &Agent{
+		LLM:        &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "sunny", Usage: llmModel.TokenUsage{PromptTokens: 1000}}}},
+		Cost:       tracker,
+		Reflection: &ReflectionOptions{LLM: critic},
+	}
+
+	state, err := agent.Run(context.Background(), "", "weather?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(critic.requests) != 0 {
+		t.Fatalf("critic calls = %d, want the budget preflight to skip the critic", len(critic.requests))
+	}
+	if state.StopReason != StopReasonBudget {
+		t.Fatalf("stop reason = %q, want %q instead of accepting the unreviewed answer", state.StopReason, StopReasonBudget)
+	}
+}
+
+func TestReflectionReturnsContextErrorsFromCritic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	critic := &cancelingLlmClient{fakeLlmClient: &fakeLlmClient{}, cancel: cancel}
+	agent := &Agent{LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "ok"}}}, Reflection: &ReflectionOptions{LLM: critic}}
+
+	if _, err := agent.Run(ctx, "", "task"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want the canceled run to fail instead of accepting the answer", err)
+	}
+}
+
+// cancelingLlmClient 在 critic 调用途中取消运行上下文，模拟用户中途取消。
+type cancelingLlmClient struct {
+	*fakeLlmClient
+	cancel context.CancelFunc
+}
+
+func (c *cancelingLlmClient) Chat(ctx context.Context, _ llmModel.ChatRequest) (llmModel.ChatResponse, error) {
+	c.cancel()
+	return llmModel.ChatResponse{}, ctx.Err()
+}
@@ -0,0 +1,295 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"agent_study/internal/log"
+	llmModel "agent_study/pkg/llm_core/model"
+)
+
+var ErrLoopDetected = errors.New("agent loop detected")
+
+// LoopKind 区分检测到的重复模式。
+type LoopKind string
+
+const (
+	// LoopKindExact 表示同一组工具以完全相同的参数调用并得到相同的观察。
+	LoopKindExact LoopKind = "exact"
+	// LoopKindNear 表示参数与观察都高度相似的重复调用，例如只改了标点或大小写。
+	LoopKindNear LoopKind = "near"
+	// LoopKindOscillation 表示在两组调用之间来回切换（A→B→A→B）。
+	LoopKindOscillation LoopKind = "oscillation"
+)
+
+// LoopPolicy 决定检测到重复后的处理方式。
+type LoopPolicy string
+
+const (
+	// LoopPolicyWarn 给模型追加一条警告消息后继续；警告后下一步仍在重复时升级为 LoopPolicyFinish。
+	LoopPolicyWarn LoopPolicy = "warn"
+	// LoopPolicyFinish 追加提示并在下一次规划时以 ToolChoice none 禁止调用工具，迫使模型直接回答。
+	LoopPolicyFinish LoopPolicy = "finish"
+	// LoopPolicyAbort 记录该步后以 *LoopError 结束运行。
+	LoopPolicyAbort LoopPolicy = "abort"
+)
+
+const (
+	defaultLoopMaxRepeats = 3
+	defaultLoopSimilarity = 0.9
+	defaultLoopWindow     = 6
+)
+
+// LoopDetectionOptions 开启工具调用的重复检测。每个工具 step 结束后，都会把它的调用
+// 指纹（工具名 + 规范化参数）与观察和最近的工具 step 比较。
+type LoopDetectionOptions struct {
+	// Policy 为空时等价于 LoopPolicyWarn。
+	Policy LoopPolicy
+	// MaxRepeats 是同一调用在窗口内出现多少次（含当前一步）视为重复，默认 3。
+	MaxRepeats int
+	// Similarity 是近似重复的相似度阈值（0~1，按字符二元组计算），默认 0.9。
+	Similarity float64
+	// Window 是参与比较的最近工具 step 数（含当前一步），默认 6。
+	Window int
+}
+
+// LoopDetection 记录一次检测结果，写在触发它的 Step.Loop 上，随 StepEvent 对外发布。
+type LoopDetection struct {
+	Kind LoopKind `json:"kind"`
+	// Policy 是实际采取的处理方式，警告升级后为 LoopPolicyFinish。
+	Policy LoopPolicy `json:"policy"`
+	// Steps 是构成重复的 step 序号（从 1 开始），包含当前一步。
+	Steps   []int  `json:"steps"`
+	Message string `json:"message"`
+}
+
+// LoopError 是 LoopPolicyAbort 结束运行时返回的错误，可用 errors.Is(err, ErrLoopDetected)
+// 判断，或用 errors.As 取出检测详情。
+type LoopError struct {
+	Detection LoopDetection
+}
+
+func (e *LoopError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrLoopDetected, e.Detection.Message)
+}
+
+func (e *LoopError) Unwrap() error {
+	return ErrLoopDetected
+}
+
+const loopWarningTmpl = `检测到重复操作：%s。重复调用不会带来新的信息，请换一种思路（换用其他工具或参数），或者根据已有观察直接给出最终回答。`
+
+const loopFinishTmpl = `检测到重复操作：%s。请不要再调用工具，直接根据已有观察给出最终回答。`
+
+// toolStep 是参与比较的一个工具 step 及其序号。
+type toolStep struct {
+	index int
+	step  Step
+}
+
+// detectLoop 检查 current（即将成为第 index 步）是否与之前的工具 step 构成重复；
+// 没有重复时返回 nil。
+func (o *LoopDetectionOptions) detectLoop(history []Step, current Step, index int) *LoopDetection {
+	if o == nil || len(current.Action.ToolCalls) == 0 {
+		return nil
+	}
+	maxRepeats := o.MaxRepeats
+	if maxRepeats <= 1 {
+		maxRepeats = defaultLoopMaxRepeats
+	}
+	similarity := o.Similarity
+	if similarity <= 0 || similarity > 1 {
+		similarity = defaultLoopSimilarity
+	}
+	window := o.Window
+	if window < maxRepeats {
+		window = max(defaultLoopWindow, maxRepeats)
+	}
+
+	recent := make([]toolStep, 0, window)
+	for i := len(history) - 1; i >= 0 && len(recent) < window-1; i-- {
+		if history[i].Action.Kind == ActionKindToolCalls && len(history[i].Action.ToolCalls) > 0 {
+			recent = append(recent, toolStep{index: i + 1, step: history[i]})
+		}
+	}
+	// 反转成时间顺序，当前一步放在最后。
+	for i, j := 0, len(recent)-1; i < j; i, j = i+1, j-1 {
+		recent[i], recent[j] = recent[j], recent[i]
+	}
+	recent = append(recent, toolStep{index: index, step: current})
+
+	fingerprint := stepFingerprint(current)
+	var exact, near []int
+	for _, candidate := range recent {
+		switch {
+		case stepFingerprint(candidate.step) == fingerprint:
+			exact = append(exact, candidate.index)
+			near = append(near, candidate.index)
+		case similarSteps(candidate.step, current, similarity):
+			near = append(near, candidate.index)
+		}
+	}
+	tools := toolLabel(current)
+	if len(exact) >= maxRepeats {
+		return &LoopDetection{Kind: LoopKindExact, Steps: exact, Message: fmt.Sprintf("%s 以相同参数调用了 %d 次且结果相同", tools, len(exact))}
+	}
+	if n := len(recent); n >= 4 {
+		a, b, c, d := recent[n-4], recent[n-3], recent[n-2], recent[n-1]
+		if first, second := stepFingerprint(a.step), stepFingerprint(b.step); first != second &&
+			first == stepFingerprint(c.step) && second == stepFingerprint(d.step) {
+			return &LoopDetection{
+				Kind:    LoopKindOscillation,
+				Steps:   []int{a.index, b.index, c.index, d.index},
+				Message: fmt.Sprintf("在 %s 与 %s 之间来回切换", toolLabel(a.step), toolLabel(b.step)),
+			}
+		}
+	}
+	if len(near) >= maxRepeats {
+		return &LoopDetection{Kind: LoopKindNear, Steps: near, Message: fmt.Sprintf("%s 以相近参数调用了 %d 次且结果相近", tools, len(near))}
+	}
+	return nil
+}
+
+// policyFor 返回本次检测应采取的处理方式：上一个工具 step 已经警告过仍在重复时，
+// 警告升级为强制结束。
+func (o *LoopDetectionOptions) policyFor(history []Step) LoopPolicy {
+	policy := o.Policy
+	if policy == "" {
+		policy = LoopPolicyWarn
+	}
+	if policy != LoopPolicyWarn {
+		return policy
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Action.Kind != ActionKindToolCalls {
+			continue
+		}
+		if history[i].Loop != nil {
+			return LoopPolicyFinish
+		}
+		break
+	}
+	return policy
+}
+
+// stepFingerprint 由每次调用的工具名、规范化参数和整步观察组成。
+func stepFingerprint(step Step) string {
+	var builder strings.Builder
+	for _, call := range step.Action.ToolCalls {
+		builder.WriteString(call.Name)
+		builder.WriteByte('(')
+		builder.WriteString(canonicalArguments(call.Arguments))
+		builder.WriteString(")\n")
+	}
+	builder.WriteString("=> ")
+	builder.WriteString(strings.TrimSpace(step.Observation))
+	return builder.String()
+}
+
+// canonicalArguments 把参数 JSON 重新序列化（键有序、去掉空白），使等价参数得到相同指纹。
+func canonicalArguments(raw string) string {
+	arguments, err := decodeToolArguments(raw)
+	if err != nil {
+		return strings.TrimSpace(raw)
+	}
+	encoded, err := json.Marshal(arguments)
+	if err != nil {
+		return strings.TrimSpace(raw)
+	}
+	return string(encoded)
+}
+
+// similarSteps 要求两步调用的工具序列相同，且参数与观察的相似度都不低于阈值。
+func similarSteps(a, b Step, threshold float64) bool {
+	if len(a.Action.ToolCalls) != len(b.Action.ToolCalls) {
+		return false
+	}
+	for i := range a.Action.ToolCalls {
+		if a.Action.ToolCalls[i].Name != b.Action.ToolCalls[i].Name {
+			return false
+		}
+		left := strings.ToLower(canonicalArguments(a.Action.ToolCalls[i].Arguments))
+		right := strings.ToLower(canonicalArguments(b.Action.ToolCalls[i].Arguments))
+		if textSimilarity(left, right) < threshold {
+			return false
+		}
+	}
+	return textSimilarity(strings.TrimSpace(a.Observation), strings.TrimSpace(b.Observation)) >= threshold
+}
+
+// textSimilarity 按字符二元组计算 Dice 系数，返回 0~1。
+func textSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	left, right := bigrams(a), bigrams(b)
+	total := 0
+	for _, count := range left {
+		total += count
+	}
+	for _, count := range right {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+	shared := 0
+	for gram, count := range left {
+		shared += min(count, right[gram])
+	}
+	return 2 * float64(shared) / float64(total)
+}
+
+func bigrams(text string) map[string]int {
+	runes := []rune(text)
+	grams := make(map[string]int, len(runes))
+	for i := 0; i+1 < len(runes); i++ {
+		grams[string(runes[i:i+2])]++
+	}
+	return grams
+}
+
+func toolLabel(step Step) string {
+	names := make([]string, 0, len(step.Action.ToolCalls))
+	for _, call := range step.Action.ToolCalls {
+		names = append(names, call.Name)
+	}
+	return strings.Join(names, "+")
+}
+
+// detectRepetition 检测 current 是否构成重复，并按策略处理：警告与强制结束会把提示
+// 作为用户消息写回会话；LoopPolicyAbort 只返回检测结果，由主循环记录该步后结束运行。
+func (a *Agent) detectRepetition(ctx context.Context, state *State, current Step) (*LoopDetection, error) {
+	detection := a.LoopDetection.detectLoop(state.Steps, current, state.StepIndex+1)
+	if detection == nil {
+		return nil, nil
+	}
+	detection.Policy = a.LoopDetection.policyFor(state.Steps)
+	log.Warnf("agent loop detected (%s, %s): %s", detection.Kind, detection.Policy, detection.Message)
+
+	var content string
+	switch detection.Policy {
+	case LoopPolicyFinish:
+		content = fmt.Sprintf(loopFinishTmpl, detection.Message)
+	case LoopPolicyAbort:
+		return detection, nil
+	default:
+		content = fmt.Sprintf(loopWarningTmpl, detection.Message)
+	}
+	if err := a.remember(ctx, state, llmModel.Message{Role: llmModel.RoleUser, Content: content}); err != nil {
+		return nil, err
+	}
+	return detection, nil
+}
+
+// forceFinish 在上一步触发了 LoopPolicyFinish 时返回 true，此时规划请求不再允许调用工具。
+func forceFinish(state *State) bool {
+	if state == nil || len(state.Steps) == 0 {
+		return false
+	}
+	loop := state.Steps[len(state.Steps)-1].Loop
+	return loop != nil && loop.Policy == LoopPolicyFinish
+}
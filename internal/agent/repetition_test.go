@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+func weatherCall(id string, arguments string) llmModel.ChatResponse {
+	return llmModel.ChatResponse{ToolCalls: []toolTypes.ToolCall{{ID: id, Name: "lookup_weather", Arguments: arguments}}}
+}
+
+func TestLoopDetectionWarnsThenForcesFinish(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		weatherCall("call_1", `{"city":"Paris"}`),
+		weatherCall("call_2", `{ "city": "Paris" }`),
+		weatherCall("call_3", `{"city":"Paris"}`),
+		weatherCall("call_4", `{"city":"Paris"}`),
+		{Content: "巴黎晴"},
+	}}
+	var events []StepEvent
+	agent := &Agent{
+		LLM:           llm,
+		Tools:         newWeatherRegistry(t),
+		LoopDetection: &LoopDetectionOptions{},
+		StepCallback:  func(event StepEvent) { events = append(events, event) },
+	}
+
+	state, err := agent.Run(context.Background(), "", "巴黎天气")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.FinalAnswer != "巴黎晴" || len(state.Steps) != 5 {
+		t.Fatalf("state = %#v, want answer after forced finish", state)
+	}
+	if state.Steps[1].Loop != nil {
+		t.Fatalf("second call should not be a loop yet, got %#v", state.Steps[1].Loop)
+	}
+	warned := events[2].Step.Loop
+	if warned == nil || warned.Kind != LoopKindExact || warned.Policy != LoopPolicyWarn || !reflect.DeepEqual(warned.Steps, []int{1, 2, 3}) {
+		t.Fatalf("third step loop = %#v, want exact repeat warning over steps 1-3", warned)
+	}
+	if forced := state.Steps[3].Loop; forced == nil || forced.Policy != LoopPolicyFinish {
+		t.Fatalf("fourth step loop = %#v, want escalation to finish", forced)
+	}
+
+	warning := llm.requests[3].Messages[len(llm.requests[3].Messages)-1]
+	if warning.Role != llmModel.RoleUser || !strings.Contains(warning.Content, "lookup_weather 以相同参数调用了 3 次") {
+		t.Fatalf("request after warning ends with %#v, want loop warning", warning)
+	}
+	if llm.requests[3].ToolChoice.Type != toolTypes.ToolAuto || llm.requests[4].ToolChoice.Type != toolTypes.ToolNone {
+		t.Fatalf("tool choices = %q, %q, want tools disabled only after the finish policy", llm.requests[3].ToolChoice.Type, llm.requests[4].ToolChoice.Type)
+	}
+}
+
+func TestLoopDetectionAbortsOnNearRepeatsWithTypedError(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		weatherCall("call_1", `{"city":"Paris"}`),
+		weatherCall("call_2", `{"city":"paris"}`),
+		weatherCall("call_3", `{"city":"PARIS"}`),
+	}}
+	var events []StepEvent
+	agent := &Agent{
+		LLM:           llm,
+		Tools:         newWeatherRegistry(t),
+		LoopDetection: &LoopDetectionOptions{Policy: LoopPolicyAbort},
+		StepCallback:  func(event StepEvent) { events = append(events, event) },
+	}
+
+	_, err := agent.Run(context.Background(), "", "巴黎天气")
+	var loopErr *LoopError
+	if !errors.Is(err, ErrLoopDetected) || !errors.As(err, &loopErr) {
+		t.Fatalf("Run() error = %v, want *LoopError", err)
+	}
+	if loopErr.Detection.Kind != LoopKindNear || loopErr.Detection.Policy != LoopPolicyAbort {
+		t.Fatalf("detection = %#v, want near repeat aborted", loopErr.Detection)
+	}
+	if len(events) != 3 || events[2].Step.Loop == nil {
+		t.Fatalf("events = %d, want the aborting step published with its detection", len(events))
+	}
+}
+
+func TestDetectLoopFindsOscillationAndIgnoresProgress(t *testing.T) {
+	step := func(name string, observation string) Step {
+		return Step{Action: Action{Kind: ActionKindToolCalls, ToolCalls: []toolTypes.ToolCall{{Name: name, Arguments: `{}`}}}, Observation: observation}
+	}
+	options := &LoopDetectionOptions{}
+
+	history := []Step{step("open_file", "a"), step("search", "b"), {Action: Action{Kind: ActionKindFinish}}, step("open_file", "a")}
+	detection := options.detectLoop(history, step("search", "b"), 5)
+	if detection == nil || detection.Kind != LoopKindOscillation || !reflect.DeepEqual(detection.Steps, []int{1, 2, 4, 5}) {
+		t.Fatalf("detectLoop() = %#v, want oscillation over tool steps", detection)
+	}
+
+	// 相同调用得到不同的观察（例如轮询任务进度）不算重复。
+	polling := []Step{step("job_status", `{"progress":10}`), step("job_status", `{"progress":45}`)}
+	if detection := options.detectLoop(polling, step("job_status", `{"progress":80}`), 3); detection != nil {
+		t.Fatalf("detectLoop() = %#v, want nil for changing observations", detection)
+	}
+}
@@ -0,0 +1,250 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"agent_study/internal/log"
+	llmModel "agent_study/pkg/llm_core/model"
+	sharedTypes "agent_study/pkg/types"
+)
+
+// RouteStepKind 是路由规则看到的 step 类型，由上一步的结果决定。
+type RouteStepKind string
+
+const (
+	// RouteStepStart 是运行中的第一次规划。
+	RouteStepStart RouteStepKind = "start"
+	// RouteStepObservation 是工具调用成功后的下一次规划。
+	RouteStepObservation RouteStepKind = "observation"
+	// RouteStepRetry 是上一步失败后的规划：工具报错、回答被 critic 打回、未通过 schema
+	// 校验或触发了重复检测。
+	RouteStepRetry RouteStepKind = "retry"
+	// RouteStepContinue 覆盖其余情况，例如计划模式下进入下一个计划项。
+	RouteStepContinue RouteStepKind = "continue"
+)
+
+// ModelTier 是一档可选模型。
+type ModelTier struct {
+	Name string
+	// LLM 为空时复用 Agent 自己的客户端，即同一 provider 下换一个模型名。
+	LLM   llmModel.LlmClient
+	Model string
+	// Pricing 为空时按 CostTracker 自己的价格计费。
+	Pricing *sharedTypes.ModelPricing
+	// Description 供分类模型判断这一档适合什么样的 step。
+	Description string
+}
+
+// RoutingRule 在条件全部满足时选用 Tier；未设置的条件不参与判断。
+type RoutingRule struct {
+	Tier      string
+	StepKinds []RouteStepKind
+	// MinPromptTokens 与 MaxPromptTokens 限定预估 prompt token 的范围。
+	MinPromptTokens int64
+	MaxPromptTokens int64
+	// MaxRemainingBudgetUSD 在剩余预算不超过该值时匹配，需要 CostTracker 设置了预算。
+	MaxRemainingBudgetUSD float64
+}
+
+// RoutingClassifier 在没有规则命中时让一个便宜模型按 step 情况挑选档位。
+type RoutingClassifier struct {
+	// LLM 与 Model 为空时复用 Agent 自己的。
+	LLM     llmModel.LlmClient
+	Model   string
+	Pricing *sharedTypes.ModelPricing
+}
+
+// RoutingOptions 为每次规划调用挑选模型：规则按顺序匹配，第一条命中的生效；都不命中时
+// 询问 Classifier（如果配置了），否则使用 DefaultTier（为空时为第一档）。
+type RoutingOptions struct {
+	Tiers       []ModelTier
+	DefaultTier string
+	Rules       []RoutingRule
+	Classifier  *RoutingClassifier
+}
+
+// routeDecision 是一次路由的结果；tier 为 nil 表示未配置路由。
+type routeDecision struct {
+	tier   *ModelTier
+	reason string
+}
+
+func validateRouting(options *RoutingOptions) error {
+	if options == nil {
+		return nil
+	}
+	if len(options.Tiers) == 0 {
+		return fmt.Errorf("routing requires at least one tier")
+	}
+	names := make([]string, 0, len(options.Tiers))
+	for _, tier := range options.Tiers {
+		if strings.TrimSpace(tier.Name) == "" || strings.TrimSpace(tier.Model) == "" {
+			return fmt.Errorf("routing tier requires name and model")
+		}
+		if slices.Contains(names, tier.Name) {
+			return fmt.Errorf("duplicate routing tier: %s", tier.Name)
+		}
+		if tier.Pricing != nil {
+			if err := validateModelPricing(*tier.Pricing); err != nil {
+				return fmt.Errorf("routing tier %s: %w", tier.Name, err)
+			}
+		}
+		names = append(names, tier.Name)
+	}
+	if options.DefaultTier != "" && !slices.Contains(names, options.DefaultTier) {
+		return fmt.Errorf("unknown default routing tier: %s", options.DefaultTier)
+	}
+	for _, rule := range options.Rules {
+		if !slices.Contains(names, rule.Tier) {
+			return fmt.Errorf("unknown routing tier in rule: %s", rule.Tier)
+		}
+	}
+	return nil
+}
+
+func (o *RoutingOptions) tier(name string) *ModelTier {
+	if name == "" {
+		return &o.Tiers[0]
+	}
+	for i := range o.Tiers {
+		if o.Tiers[i].Name == name {
+			return &o.Tiers[i]
+		}
+	}
+	return nil
+}
+
+// route 为这次规划调用挑选档位。分类模型调用失败不会中断运行，退回默认档。
+func (a *Agent) route(ctx context.Context, state *State, request *llmModel.ChatRequest) (routeDecision, error) {
+	routing := a.Routing
+	if routing == nil {
+		return routeDecision{}, nil
+	}
+	kind := routeStepKind(state)
+	promptTokens := a.estimatePromptTokens(request)
+	for i, rule := range routing.Rules {
+		if a.ruleMatches(rule, kind, promptTokens) {
+			return routeDecision{tier: routing.tier(rule.Tier), reason: fmt.Sprintf("rule %d", i+1)}, nil
+		}
+	}
+	if routing.Classifier != nil {
+		name, err := a.classifyRoute(ctx, state, kind)
+		if err != nil {
+			if _, ok := limitReason(ctx, err); ok {
+				return routeDecision{}, err
+			}
+			log.Warnf("route step: %v", err)
+		} else if tier := routing.tier(name); tier != nil {
+			return routeDecision{tier: tier, reason: "classifier"}, nil
+		} else {
+			log.Warnf("route step: classifier picked unknown tier %q", name)
+		}
+	}
+	return routeDecision{tier: routing.tier(routing.DefaultTier), reason: "default"}, nil
+}
+
+func (a *Agent) ruleMatches(rule RoutingRule, kind RouteStepKind, promptTokens int64) bool {
+	if len(rule.StepKinds) > 0 && !slices.Contains(rule.StepKinds, kind) {
+		return false
+	}
+	if rule.MinPromptTokens > 0 && promptTokens < rule.MinPromptTokens {
+		return false
+	}
+	if rule.MaxPromptTokens > 0 && promptTokens > rule.MaxPromptTokens {
+		return false
+	}
+	if rule.MaxRemainingBudgetUSD > 0 {
+		if a.Cost == nil {
+			return false
+		}
+		remaining, limited := a.Cost.availableBudgetUSD()
+		if !limited || remaining > rule.MaxRemainingBudgetUSD {
+			return false
+		}
+	}
+	return true
+}
+
+// routeStepKind 按本次运行上一步的结果判断即将进行的规划属于哪类 step。
+func routeStepKind(state *State) RouteStepKind {
+	if state == nil || len(state.Steps) == 0 {
+		return RouteStepStart
+	}
+	last := state.Steps[len(state.Steps)-1]
+	switch {
+	case last.ToolFailed, last.Verdict == VerdictRevise, len(last.OutputErrors) > 0, last.Loop != nil:
+		return RouteStepRetry
+	case last.Action.Kind == ActionKindToolCalls:
+		return RouteStepObservation
+	default:
+		return RouteStepContinue
+	}
+}
+
+const routerPrompt = `你负责为 agent 的下一步挑选模型档位：简单的步骤（列目录、读取文件、转述工具结果）用便宜的档位，需要推理、规划或修正错误的步骤用更强的档位。
+可选档位：
+%s
+只输出 JSON：{"tier":"档位名称"}，不要输出其他内容。`
+
+type routeChoice struct {
+	Tier string `json:"tier"`
+}
+
+func (a *Agent) classifyRoute(ctx context.Context, state *State, kind RouteStepKind) (string, error) {
+	classifier := a.Routing.Classifier
+	llm := classifier.LLM
+	if llm == nil {
+		llm = a.LLM
+	}
+	model := classifier.Model
+	if model == "" {
+		model = a.Model
+	}
+
+	tiers := make([]string, 0, len(a.Routing.Tiers))
+	for _, tier := range a.Routing.Tiers {
+		tiers = append(tiers, fmt.Sprintf("- %s：%s", tier.Name, tier.Description))
+	}
+	var situation strings.Builder
+	fmt.Fprintf(&situation, "任务：\n%s\n\n下一步类型：%s", state.Task, kind)
+	if len(state.Steps) > 0 {
+		last := state.Steps[len(state.Steps)-1]
+		fmt.Fprintf(&situation, "\n\n上一步观察：\n%s", limitSummary(compactWhitespace(last.Observation), maxLimitProgressChars))
+	}
+
+	response, err := llm.Chat(ctx, llmModel.ChatRequest{
+		Model: model,
+		Messages: []llmModel.Message{
+			{Role: llmModel.RoleSystem, Content: fmt.Sprintf(routerPrompt, strings.Join(tiers, "\n"))},
+			{Role: llmModel.RoleUser, Content: situation.String()},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if a.Cost != nil {
+		if classifier.Pricing != nil {
+			_, err = a.Cost.AddUsageWithPricing(response.Usage, *classifier.Pricing)
+		} else {
+			_, err = a.Cost.AddUsage(response.Usage)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	_, answer := llmModel.SplitLeadingThinkBlock(response.Content)
+	answer = strings.TrimSpace(answer)
+	if start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}"); start >= 0 && end > start {
+		answer = answer[start : end+1]
+	}
+	var choice routeChoice
+	if err := json.Unmarshal([]byte(answer), &choice); err != nil {
+		return "", fmt.Errorf("decode route choice: %w", err)
+	}
+	return strings.TrimSpace(choice.Tier), nil
+}
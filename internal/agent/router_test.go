@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestRoutingRulesPickTierPerStepAndPriceEachTier(t *testing.T) {
+	tracker, err := NewCostTracker(toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}, 0)
+	if err != nil {
+		t.Fatalf("NewCostTracker() error = %v", err)
+	}
+	strong := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{}`}}, Usage: llmModel.TokenUsage{PromptTokens: 1000}},
+	}}
+	cheap := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "sunny", Usage: llmModel.TokenUsage{PromptTokens: 1000}}}}
+	agent, err := NewAgent(NewAgentOptions{
+		LLM:   strong,
+		Model: "big",
+		Tools: newWeatherRegistry(t),
+		Cost:  tracker,
+		Routing: &RoutingOptions{
+			Tiers: []ModelTier{
+				{Name: "strong", Model: "big"},
+				{Name: "cheap", LLM: cheap, Model: "small", Pricing: &toolTypes.ModelPricing{
+					Input:  toolTypes.TokenPrice{AmountUSD: 0.1, PerTokens: 1000},
+					Output: toolTypes.TokenPrice{AmountUSD: 0.1, PerTokens: 1000},
+				}},
+			},
+			Rules: []RoutingRule{{Tier: "cheap", StepKinds: []RouteStepKind{RouteStepObservation}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+
+	state, err := agent.Run(context.Background(), "", "weather?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.FinalAnswer != "sunny" || len(strong.requests) != 1 || len(cheap.requests) != 1 {
+		t.Fatalf("final = %q strong = %d cheap = %d, want tool step on strong and answer on cheap", state.FinalAnswer, len(strong.requests), len(cheap.requests))
+	}
+	first, second := state.Steps[0].Metrics, state.Steps[1].Metrics
+	if first.Model != "big" || first.Tier != "strong" || first.RouteReason != "default" {
+		t.Fatalf("first metrics = %#v, want default strong tier", first)
+	}
+	if second.Model != "small" || second.Tier != "cheap" || second.RouteReason != "rule 1" {
+		t.Fatalf("second metrics = %#v, want cheap tier from rule 1", second)
+	}
+	if first.Cost.TotalCostUSD != 1 || second.Cost.TotalCostUSD < 0.0999 || second.Cost.TotalCostUSD > 0.1001 {
+		t.Fatalf("costs = %v / %v, want each tier priced separately", first.Cost.TotalCostUSD, second.Cost.TotalCostUSD)
+	}
+}
+
+func TestRoutingRulesMatchPromptSizeRetryAndRemainingBudget(t *testing.T) {
+	tracker, err := NewCostTracker(toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}, 1)
+	if err != nil {
+		t.Fatalf("NewCostTracker() error = %v", err)
+	}
+	agent := &Agent{
+		LLM:   &fakeLlmClient{},
+		Model: "big",
+		Cost:  tracker,
+		Routing: &RoutingOptions{
+			Tiers: []ModelTier{{Name: "strong", Model: "big"}, {Name: "cheap", Model: "small"}, {Name: "long", Model: "long"}},
+			Rules: []RoutingRule{
+				{Tier: "strong", StepKinds: []RouteStepKind{RouteStepRetry}},
+				{Tier: "long", MinPromptTokens: 1000},
+				{Tier: "cheap", MaxRemainingBudgetUSD: 0.5},
+			},
+		},
+	}
+	short := &llmModel.ChatRequest{Messages: []llmModel.Message{{Role: llmModel.RoleUser, Content: "hi"}}}
+	long := &llmModel.ChatRequest{Messages: []llmModel.Message{{Role: llmModel.RoleUser, Content: string(make([]rune, 2000))}}}
+
+	cases := []struct {
+		name    string
+		state   *State
+		request *llmModel.ChatRequest
+		spent   int64
+		want    string
+	}{
+		{name: "default", state: &State{}, request: short, want: "strong"},
+		{name: "long prompt", state: &State{}, request: long, want: "long"},
+		{name: "retry after tool failure", state: &State{Steps: []Step{{Action: Action{Kind: ActionKindToolCalls}, ToolFailed: true}}}, request: long, want: "strong"},
+		{name: "low budget", state: &State{}, request: short, spent: 600, want: "cheap"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker.Restore(CostTotals{})
+			if tc.spent > 0 {
+				if _, err := tracker.AddUsage(llmModel.TokenUsage{PromptTokens: tc.spent}); err != nil {
+					t.Fatalf("AddUsage() error = %v", err)
+				}
+			}
+			decision, err := agent.route(context.Background(), tc.state, tc.request)
+			if err != nil {
+				t.Fatalf("route() error = %v", err)
+			}
+			if decision.tier == nil || decision.tier.Name != tc.want {
+				t.Fatalf("route() = %#v, want tier %s", decision.tier, tc.want)
+			}
+		})
+	}
+}
+
+func TestRoutingClassifierPicksTierAndFallsBackOnFailure(t *testing.T) {
+	classifier := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: `<think>short step</think>{"tier":"cheap"}`}}}
+	agent := &Agent{
+		LLM:   &fakeLlmClient{},
+		Model: "big",
+		Routing: &RoutingOptions{
+			Tiers:       []ModelTier{{Name: "cheap", Model: "small", Description: "读取文件"}, {Name: "strong", Model: "big", Description: "复杂推理"}},
+			DefaultTier: "strong",
+			Classifier:  &RoutingClassifier{LLM: classifier, Model: "router"},
+		},
+	}
+	state := &State{Task: "list files"}
+	request := &llmModel.ChatRequest{}
+
+	decision, err := agent.route(context.Background(), state, request)
+	if err != nil {
+		t.Fatalf("route() error = %v", err)
+	}
+	if decision.tier.Name != "cheap" || decision.reason != "classifier" || classifier.requests[0].Model != "router" {
+		t.Fatalf("route() = %#v, want classifier choice from router model", decision)
+	}
+
+	classifier.chatErr = errors.New("router down")
+	decision, err = agent.route(context.Background(), state, request)
+	if err != nil {
+		t.Fatalf("route() error = %v", err)
+	}
+	if decision.tier.Name != "strong" || decision.reason != "default" {
+		t.Fatalf("route() = %#v, want default tier after classifier failure", decision)
+	}
+}
+
+func TestNewAgentRejectsInvalidRouting(t *testing.T) {
+	_, err := NewAgent(NewAgentOptions{LLM: &fakeLlmClient{}, Routing: &RoutingOptions{
+		Tiers: []ModelTier{{Name: "cheap", Model: "small"}},
+		Rules: []RoutingRule{{Tier: "missing"}},
+	}})
+	if err == nil {
+		t.Fatal("NewAgent() error = nil, want unknown tier error")
+	}
+}
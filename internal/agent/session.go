@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	llmModel "agent_study/pkg/llm_core/model"
+)
+
+var ErrSessionNotFound = errors.New("agent session not found")
+
+// Session 描述一个独立会话；列表接口返回的 Session 不携带 Messages。
+type Session struct {
+	ID        string
+	Username  string
+	Title     string
+	Messages  []llmModel.Message
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// SessionStore 负责按会话保存短期消息历史。实现需要保证消息顺序，并对传入/返回的
+// 消息做防御性拷贝。
+type SessionStore interface {
+	CreateSession(ctx context.Context, username string, title string) (*Session, error)
+	ListSessions(ctx context.Context, username string) ([]Session, error)
+	LoadSession(ctx context.Context, sessionID string) (*Session, error)
+	AppendMessages(ctx context.Context, sessionID string, messages ...llmModel.Message) error
+	ReplaceMessages(ctx context.Context, sessionID string, messages []llmModel.Message) error
+	DeleteSession(ctx context.Context, sessionID string) error
+}
+
+// InMemorySessionStore 是进程内的会话存储，适合测试或不需要跨重启保留会话的场景。
+type InMemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewInMemorySessionStore 创建一个空的进程内会话存储。
+func NewInMemorySessionStore() *InMemorySessionStore {
+	return &InMemorySessionStore{sessions: make(map[string]*Session)}
+}
+
+func (s *InMemorySessionStore) CreateSession(_ context.Context, username string, title string) (*Session, error) {
+	now := time.Now()
+	session := &Session{
+		ID:        newRunID(),
+		Username:  normalizeUsername(username),
+		Title:     strings.TrimSpace(title),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.ID] = session
+	return cloneSession(session, false), nil
+}
+
+func (s *InMemorySessionStore) ListSessions(_ context.Context, username string) ([]Session, error) {
+	username = strings.TrimSpace(username)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sessions := make([]Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		if username != "" && session.Username != username {
+			continue
+		}
+		sessions = append(sessions, *cloneSession(session, false))
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions, nil
+}
+
+func (s *InMemorySessionStore) LoadSession(_ context.Context, sessionID string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	return cloneSession(session, true), nil
+}
+
+func (s *InMemorySessionStore) AppendMessages(_ context.Context, sessionID string, messages ...llmModel.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	session.Messages = append(session.Messages, cloneMessages(messages)...)
+	session.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *InMemorySessionStore) ReplaceMessages(_ context.Context, sessionID string, messages []llmModel.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	session.Messages = cloneMessages(messages)
+	session.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *InMemorySessionStore) DeleteSession(_ context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[sessionID]; !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+func cloneSession(session *Session, withMessages bool) *Session {
+	cloned := *session
+	cloned.Messages = nil
+	if withMessages {
+		cloned.Messages = cloneMessages(session.Messages)
+	}
+	return &cloned
+}
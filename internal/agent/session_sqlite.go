@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	phase4migrate "agent_study/internal/migrate/phase4"
+	"agent_study/internal/model"
+	llmModel "agent_study/pkg/llm_core/model"
+
+	"gorm.io/gorm"
+)
+
+// SQLiteSessionStore 把会话和消息持久化到 SQLite，进程重启后仍能继续同一会话。
+type SQLiteSessionStore struct {
+	db *gorm.DB
+}
+
+// NewSQLiteSessionStore 会在首次使用前补齐 phase 4 依赖的表结构。
+func NewSQLiteSessionStore(db *gorm.DB) (*SQLiteSessionStore, error) {
+	if err := phase4migrate.BootstrapWithDB(db, phase4migrate.CurrentVersion); err != nil {
+		return nil, err
+	}
+	return &SQLiteSessionStore{db: db}, nil
+}
+
+func (s *SQLiteSessionStore) CreateSession(ctx context.Context, username string, title string) (*Session, error) {
+	record := &model.AgentSession{
+		ID:       newRunID(),
+		Username: normalizeUsername(username),
+		Title:    strings.TrimSpace(title),
+	}
+	if err := s.db.WithContext(ctx).Create(record).Error; err != nil {
+		return nil, err
+	}
+	return sessionFromRecord(record), nil
+}
+
+func (s *SQLiteSessionStore) ListSessions(ctx context.Context, username string) ([]Session, error) {
+	query := s.db.WithContext(ctx).Order("updated_at DESC")
+	if username = strings.TrimSpace(username); username != "" {
+		query = query.Where("username = ?", username)
+	}
+
+	var records []model.AgentSession
+	if err := query.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(records))
+	for i := range records {
+		sessions = append(sessions, *sessionFromRecord(&records[i]))
+	}
+	return sessions, nil
+}
+
+func (s *SQLiteSessionStore) LoadSession(ctx context.Context, sessionID string) (*Session, error) {
+	record, err := s.findSession(s.db.WithContext(ctx), sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []model.AgentSessionMessage
+	if err := s.db.WithContext(ctx).Where("session_id = ?", sessionID).Order("seq ASC").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	session := sessionFromRecord(record)
+	session.Messages = make([]llmModel.Message, 0, len(rows))
+	for _, row := range rows {
+		var message llmModel.Message
+		if err := json.Unmarshal([]byte(row.Payload), &message); err != nil {
+			return nil, fmt.Errorf("decode session message %d: %w", row.Seq, err)
+		}
+		session.Messages = append(session.Messages, message)
+	}
+	return session, nil
+}
+
+func (s *SQLiteSessionStore) AppendMessages(ctx context.Context, sessionID string, messages ...llmModel.Message) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := s.findSession(tx, sessionID); err != nil {
+			return err
+		}
+		var maxSeq int
+		if err := tx.Model(&model.AgentSessionMessage{}).
+			Where("session_id = ?", sessionID).
+			Select("COALESCE(MAX(seq), 0)").
+			Scan(&maxSeq).Error; err != nil {
+			return err
+		}
+		if err := insertSessionMessages(tx, sessionID, maxSeq, messages); err != nil {
+			return err
+		}
+		return touchSession(tx, sessionID)
+	})
+}
+
+func (s *SQLiteSessionStore) ReplaceMessages(ctx context.Context, sessionID string, messages []llmModel.Message) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := s.findSession(tx, sessionID); err != nil {
+			return err
+		}
+		if err := tx.Where("session_id = ?", sessionID).Delete(&model.AgentSessionMessage{}).Error; err != nil {
+			return err
+		}
+		if err := insertSessionMessages(tx, sessionID, 0, messages); err != nil {
+			return err
+		}
+		return touchSession(tx, sessionID)
+	})
+}
+
+func (s *SQLiteSessionStore) DeleteSession(ctx context.Context, sessionID string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := s.findSession(tx, sessionID); err != nil {
+			return err
+		}
+		if err := tx.Where("session_id = ?", sessionID).Delete(&model.AgentSessionMessage{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", sessionID).Delete(&model.AgentSession{}).Error
+	})
+}
+
+func (s *SQLiteSessionStore) findSession(tx *gorm.DB, sessionID string) (*model.AgentSession, error) {
+	record := &model.AgentSession{}
+	if err := tx.Where("id = ?", sessionID).First(record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+func insertSessionMessages(tx *gorm.DB, sessionID string, startSeq int, messages []llmModel.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	rows := make([]model.AgentSessionMessage, 0, len(messages))
+	for i, message := range messages {
+		payload, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("encode session message: %w", err)
+		}
+		rows = append(rows, model.AgentSessionMessage{
+			SessionID: sessionID,
+			Seq:       startSeq + i + 1,
+			Role:      message.Role,
+			Payload:   string(payload),
+		})
+	}
+	return tx.Create(&rows).Error
+}
+
+func touchSession(tx *gorm.DB, sessionID string) error {
+	return tx.Model(&model.AgentSession{}).Where("id = ?", sessionID).Update("updated_at", time.Now()).Error
+}
+
+func sessionFromRecord(record *model.AgentSession) *Session {
+	return &Session{
+		ID:        record.ID,
+		Username:  record.Username,
+		Title:     record.Title,
+		CreatedAt: record.CreatedAt,
+		UpdatedAt: record.UpdatedAt,
+	}
+}
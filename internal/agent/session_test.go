@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"agent_study/internal/model"
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestSessionStoresRoundTripFullMessages(t *testing.T) {
+	sqliteStore, err := NewSQLiteSessionStore(newBareTestMemoryDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteSessionStore() error = %v", err)
+	}
+	stores := map[string]SessionStore{
+		"memory": NewInMemorySessionStore(),
+		"sqlite": sqliteStore,
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			session, err := store.CreateSession(ctx, "alice", "weather")
+			if err != nil {
+				t.Fatalf("CreateSession() error = %v", err)
+			}
+
+			messages := []llmModel.Message{
+				{Role: llmModel.RoleUser, Content: "看图", Attachments: []llmModel.Attachment{{FileName: "a.png", MimeType: "image/png", Data: []byte{1, 2, 3}}}},
+				{
+					Role:           llmModel.RoleAssistant,
+					Reasoning:      "need tool",
+					ReasoningItems: []llmModel.ReasoningItem{{ID: "rs_1", Summary: []llmModel.ReasoningSummary{{Text: "plan"}}, EncryptedContent: "enc"}},
+					ToolCalls:      []toolTypes.ToolCall{{ID: "call_1", Name: "ls", Arguments: `{}`, ThoughtSignature: []byte("sig")}},
+				},
+			}
+			if err := store.AppendMessages(ctx, session.ID, messages[0]); err != nil {
+				t.Fatalf("AppendMessages() error = %v", err)
+			}
+			if err := store.AppendMessages(ctx, session.ID, messages[1]); err != nil {
+				t.Fatalf("AppendMessages() error = %v", err)
+			}
+
+			loaded, err := store.LoadSession(ctx, session.ID)
+			if err != nil {
+				t.Fatalf("LoadSession() error = %v", err)
+			}
+			if loaded.Username != "alice" || len(loaded.Messages) != 2 {
+				t.Fatalf("LoadSession() = %#v, want alice session with 2 messages", loaded)
+			}
+			if string(loaded.Messages[0].Attachments[0].Data) != string([]byte{1, 2, 3}) {
+				t.Fatalf("attachment data = %v, want [1 2 3]", loaded.Messages[0].Attachments[0].Data)
+			}
+			assistant := loaded.Messages[1]
+			if assistant.ReasoningItems[0].EncryptedContent != "enc" || string(assistant.ToolCalls[0].ThoughtSignature) != "sig" {
+				t.Fatalf("assistant message = %#v, want reasoning item and thought signature preserved", assistant)
+			}
+
+			listed, err := store.ListSessions(ctx, "alice")
+			if err != nil || len(listed) != 1 || listed[0].Messages != nil {
+				t.Fatalf("ListSessions() = %#v, %v, want one session without messages", listed, err)
+			}
+			if others, _ := store.ListSessions(ctx, "bob"); len(others) != 0 {
+				t.Fatalf("ListSessions(bob) = %#v, want none", others)
+			}
+
+			if err := store.DeleteSession(ctx, session.ID); err != nil {
+				t.Fatalf("DeleteSession() error = %v", err)
+			}
+			if _, err := store.LoadSession(ctx, session.ID); !errors.Is(err, ErrSessionNotFound) {
+				t.Fatalf("LoadSession() after delete error = %v, want ErrSessionNotFound", err)
+			}
+		})
+	}
+}
+
+func TestRunKeepsSessionsIsolatedAndSharesLongTermMemoryPerUser(t *testing.T) {
+	db := newTestMemoryDB(t)
+	memory, err := NewMemoryManager(MemoryOptions{DB: db})
+	if err != nil {
+		t.Fatalf("NewMemoryManager() error = %v", err)
+	}
+	if err := db.Create(&model.LongTermMemory{Username: "alice", Summary: "alice likes golang"}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	ctx := context.Background()
+	first, err := memory.CreateSession(ctx, "alice", "")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	second, err := memory.CreateSession(ctx, "alice", "")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{{Content: "one"}, {Content: "two"}}}
+	agent := &Agent{LLM: llm, Memory: memory}
+
+	if _, err := agent.Run(ctx, first.ID, "first task"); err != nil {
+		t.Fatalf("Run(first) error = %v", err)
+	}
+	if _, err := agent.Run(ctx, second.ID, "second task"); err != nil {
+		t.Fatalf("Run(second) error = %v", err)
+	}
+
+	// 第二个会话的请求里只应有长期记忆和它自己的用户消息，看不到第一个会话的历史。
+	messages := llm.requests[1].Messages
+	if len(messages) != 2 {
+		t.Fatalf("second session request messages = %#v, want long-term block and own task", messages)
+	}
+	if messages[0].Role != llmModel.RoleSystem || messages[0].Content == "" {
+		t.Fatalf("first message = %#v, want long-term memory system block", messages[0])
+	}
+	if messages[1].Content != "second task" {
+		t.Fatalf("second message = %#v, want only the second session task", messages[1])
+	}
+
+	stored, err := memory.SessionMessages(ctx, first.ID)
+	if err != nil || len(stored) != 2 {
+		t.Fatalf("SessionMessages(first) = %#v, %v, want task and answer", stored, err)
+	}
+	if len(memory.ShortTermMessages()) != 0 {
+		t.Fatalf("default session should stay untouched by named sessions")
+	}
+
+	if _, err := agent.Run(ctx, "missing", "task"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Run(missing) error = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestMemoryManagerFlushSessionToLongTermUsesSessionOwner(t *testing.T) {
+	db := newTestMemoryDB(t)
+	memory, err := NewMemoryManager(MemoryOptions{DB: db})
+	if err != nil {
+		t.Fatalf("NewMemoryManager() error = %v", err)
+	}
+
+	ctx := context.Background()
+	session, err := memory.CreateSession(ctx, "bob", "")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if err := memory.AddSessionMessage(ctx, session.ID, llmModel.Message{Role: llmModel.RoleUser, Content: "bob uses vim"}); err != nil {
+		t.Fatalf("AddSessionMessage() error = %v", err)
+	}
+
+	summary, err := memory.FlushSessionToLongTerm(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("FlushSessionToLongTerm() error = %v", err)
+	}
+	if summary != "user: bob uses vim" {
+		t.Fatalf("summary = %q, want compressed session", summary)
+	}
+	if got, _ := memory.LongTermSummaryFor(ctx, "bob"); got != summary {
+		t.Fatalf("LongTermSummaryFor(bob) = %q, want %q", got, summary)
+	}
+	if got, _ := memory.LongTermSummary(ctx); got != "" {
+		t.Fatalf("default user summary = %q, want untouched", got)
+	}
+	if remaining, _ := memory.SessionMessages(ctx, session.ID); len(remaining) != 0 {
+		t.Fatalf("session messages after flush = %#v, want cleared", remaining)
+	}
+}
@@ -0,0 +1,297 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	internalConfig "agent_study/internal/config"
+	llmModel "agent_study/pkg/llm_core/model"
+	mcpClient "agent_study/pkg/mcp/client"
+	"agent_study/pkg/tools"
+
+	"gorm.io/gorm"
+)
+
+// SpecFactory 把 internal/config 加载的声明式 agent 定义组装成 Agent。
+type SpecFactory struct {
+	Specs *internalConfig.AgentSpecFile
+	// Provider 是 spec 未引用具名 provider 时使用的默认模型配置，通常是 app.yaml 的 llmProvider。
+	Provider *internalConfig.LLMProvider
+	// DB 可选；提供后记忆持久化到 SQLite，并允许启用事实级记忆。
+	DB *gorm.DB
+	// Checkpoints 可选；提供后构造出的 Agent 支持 Resume。
+	Checkpoints *CheckpointStore
+
+	mu      sync.Mutex
+	clients []mcpClient.Client
+}
+
+// Build 按名称构造 Agent。构造过程中连接的 MCP server 由 Close 统一关闭。
+func (f *SpecFactory) Build(name string) (*Agent, error) {
+	if f == nil || f.Specs == nil {
+		return nil, fmt.Errorf("agent specs are not loaded")
+	}
+	spec, err := f.Specs.Agent(name)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := f.Provider
+	if spec.Provider != "" {
+		named := f.Specs.Providers[spec.Provider]
+		provider = &named
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("agent %q: %w", name, ErrAgentLLMRequired)
+	}
+
+	registry, clients, err := specTools(spec)
+	if err != nil {
+		closeMCPClients(clients)
+		return nil, fmt.Errorf("agent %q: %w", name, err)
+	}
+	guardrails, err := GuardrailsFromConfig(spec.Guardrails)
+	if err != nil {
+		closeMCPClients(clients)
+		return nil, fmt.Errorf("agent %q: %w", name, err)
+	}
+
+	var system []llmModel.Message
+	if spec.SystemPrompt != "" {
+		system = []llmModel.Message{{Role: llmModel.RoleSystem, Content: spec.SystemPrompt}}
+	}
+	limits := spec.Limits
+	built, err := NewAgent(NewAgentOptions{
+		Provider:      provider,
+		Model:         spec.Model,
+		System:        system,
+		Tools:         registry,
+		MemoryOptions: MemoryOptionsFromConfig(spec.Memory, f.DB),
+		Checkpoints:   f.Checkpoints,
+		Reflection:    ReflectionFromConfig(spec.Reflection),
+		LoopDetection: LoopDetectionFromConfig(spec.LoopDetection),
+		Routing:       RoutingFromConfig(spec.Routing),
+		ToolSelection: ToolSelectionFromConfig(spec.ToolSelection),
+		Guardrails:    guardrails,
+		Config: Config{
+			MaxSteps:        limits.MaxSteps,
+			MaxBudgetUSD:    limits.MaxBudgetUSD,
+			ToolTimeout:     time.Duration(limits.ToolTimeoutSeconds) * time.Second,
+			MaxObservation:  limits.MaxObservation,
+			Mode:            Mode(limits.Mode),
+			MaxReplans:      limits.MaxReplans,
+			MaxDuration:     time.Duration(limits.MaxDurationSeconds) * time.Second,
+			MaxTokens:       limits.MaxTokens,
+			MaxOutputTokens: limits.MaxOutputTokens,
+		},
+	})
+	if err != nil {
+		closeMCPClients(clients)
+		return nil, fmt.Errorf("agent %q: %w", name, err)
+	}
+
+	f.mu.Lock()
+	f.clients = append(f.clients, clients...)
+	f.mu.Unlock()
+	return built, nil
+}
+
+// Close 关闭所有由 Build 连接的 MCP server。
+func (f *SpecFactory) Close() error {
+	f.mu.Lock()
+	clients := f.clients
+	f.clients = nil
+	f.mu.Unlock()
+	return closeMCPClients(clients)
+}
+
+func specTools(spec *internalConfig.AgentSpec) (*tools.Registry, []mcpClient.Client, error) {
+	registry := tools.NewRegistry()
+	for _, toolSpec := range spec.Tools {
+		tool, err := tools.NewBuiltinTool(toolSpec.Name, tools.BuiltinOptions{
+			RootDir:            toolSpec.RootDir,
+			DefaultLSDepth:     toolSpec.DefaultLSDepth,
+			DefaultExecTimeout: time.Duration(toolSpec.DefaultExecTimeoutSeconds) * time.Second,
+			MaxExecTimeout:     time.Duration(toolSpec.MaxExecTimeoutSeconds) * time.Second,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := registry.Register(tool); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var clients []mcpClient.Client
+	for _, server := range spec.MCPServers {
+		var client mcpClient.Client
+		var err error
+		if server.Command != "" {
+			client, err = mcpClient.NewMCPClient(server.Command)
+		} else {
+			client, err = mcpClient.NewHTTPMCPClient(server.URL, nil)
+		}
+		if err != nil {
+			return nil, clients, fmt.Errorf("connect mcp server %q: %w", server.Name, err)
+		}
+		clients = append(clients, client)
+		if err := registry.RegisterMCPClient(client, tools.MCPRegistrationOptions{Prefix: server.Prefix}); err != nil {
+			return nil, clients, fmt.Errorf("register mcp server %q: %w", server.Name, err)
+		}
+	}
+	return registry, clients, nil
+}
+
+func closeMCPClients(clients []mcpClient.Client) error {
+	var errs []error
+	for _, client := range clients {
+		if err := client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MemoryOptionsFromConfig 把记忆配置转换成 MemoryOptions；事实级记忆只在提供了 DB 时启用。
+func MemoryOptionsFromConfig(cfg internalConfig.MemoryConfig, db *gorm.DB) *MemoryOptions {
+	return &MemoryOptions{
+		DB:              db,
+		Username:        cfg.Username,
+		MaxSummaryChars: cfg.MaxSummaryChars,
+		Compression: MemoryCompressionOptions{
+			Mode:             cfg.Compressor.Type,
+			Model:            cfg.Compressor.Model,
+			MaxSummaryTokens: cfg.Compressor.MaxSummaryTokens,
+		},
+		Items: MemoryItemOptions{
+			Enabled:    cfg.Items.Enabled && db != nil,
+			TopK:       cfg.Items.TopK,
+			Model:      cfg.Items.Model,
+			HalfLife:   time.Duration(cfg.Items.HalfLifeDays) * 24 * time.Hour,
+			PruneBelow: cfg.Items.PruneBelow,
+		},
+	}
+}
+
+// ReflectionFromConfig 在配置未开启时返回 nil。
+func ReflectionFromConfig(cfg internalConfig.ReflectionConfig) *ReflectionOptions {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &ReflectionOptions{Model: cfg.Model, Pricing: cfg.Cost.Pricing(), MaxReflections: cfg.MaxReflections}
+}
+
+// LoopDetectionFromConfig 在配置未开启时返回 nil。
+func LoopDetectionFromConfig(cfg internalConfig.LoopDetectionConfig) *LoopDetectionOptions {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &LoopDetectionOptions{
+		Policy:     LoopPolicy(cfg.Policy),
+		MaxRepeats: cfg.MaxRepeats,
+		Similarity: cfg.Similarity,
+		Window:     cfg.Window,
+	}
+}
+
+// RoutingFromConfig 在未配置档位时返回 nil；所有档位与分类模型都使用 Agent 自己的客户端。
+func RoutingFromConfig(cfg internalConfig.RoutingConfig) *RoutingOptions {
+	if len(cfg.Tiers) == 0 {
+		return nil
+	}
+	routing := &RoutingOptions{DefaultTier: cfg.DefaultTier}
+	for _, tier := range cfg.Tiers {
+		routing.Tiers = append(routing.Tiers, ModelTier{Name: tier.Name, Model: tier.Model, Description: tier.Description, Pricing: tier.Cost.Pricing()})
+	}
+	for _, rule := range cfg.Rules {
+		kinds := make([]RouteStepKind, 0, len(rule.StepKinds))
+		for _, kind := range rule.StepKinds {
+			kinds = append(kinds, RouteStepKind(kind))
+		}
+		routing.Rules = append(routing.Rules, RoutingRule{
+			Tier:                  rule.Tier,
+			StepKinds:             kinds,
+			MinPromptTokens:       rule.MinPromptTokens,
+			MaxPromptTokens:       rule.MaxPromptTokens,
+			MaxRemainingBudgetUSD: rule.MaxRemainingBudgetUSD,
+		})
+	}
+	if cfg.Classifier.Enabled {
+		routing.Classifier = &RoutingClassifier{Model: cfg.Classifier.Model, Pricing: cfg.Classifier.Cost.Pricing()}
+	}
+	return routing
+}
+
+// ToolSelectionFromConfig 在配置未开启时返回 nil。
+func ToolSelectionFromConfig(cfg internalConfig.ToolSelectionConfig) *ToolSelectionOptions {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &ToolSelectionOptions{
+		TopK:           cfg.TopK,
+		Pinned:         cfg.Pinned,
+		RecentMessages: cfg.RecentMessages,
+		DisableSearch:  cfg.DisableSearch,
+	}
+}
+
+// SystemPromptFromConfig 加载配置的模板，未配置模板时返回 nil；数据库中的模板需要提供 db。
+func SystemPromptFromConfig(ctx context.Context, cfg internalConfig.SystemPromptConfig, db *gorm.DB) (*SystemPromptOptions, error) {
+	if len(cfg.Files) == 0 && len(cfg.Prompts) == 0 {
+		return nil, nil
+	}
+	options := &SystemPromptOptions{Workspace: cfg.Workspace, Vars: cfg.Vars}
+	for _, path := range cfg.Files {
+		tmpl, err := LoadPromptTemplateFile(path)
+		if err != nil {
+			return nil, err
+		}
+		options.Templates = append(options.Templates, tmpl)
+	}
+	for _, name := range cfg.Prompts {
+		tmpl, err := LoadPromptTemplate(ctx, db, name)
+		if err != nil {
+			return nil, err
+		}
+		options.Templates = append(options.Templates, tmpl)
+	}
+	return options, nil
+}
+
+// GuardrailsFromConfig 按配置组装 guardrail：先做廉价的正则与长度检查，最后才调用分类模型。
+func GuardrailsFromConfig(cfg internalConfig.GuardrailsConfig) ([]Guardrail, error) {
+	var guardrails []Guardrail
+	if cfg.Injection {
+		injection, err := NewRegexGuardrail("injection", DefaultInjectionPatterns, RegexGuardrailOptions{
+			Stages: []GuardrailStage{GuardrailStageInput, GuardrailStageObservation},
+		})
+		if err != nil {
+			return nil, err
+		}
+		guardrails = append(guardrails, injection)
+	}
+	if len(cfg.DenyPatterns) > 0 {
+		deny, err := NewRegexGuardrail("deny_list", cfg.DenyPatterns, RegexGuardrailOptions{})
+		if err != nil {
+			return nil, err
+		}
+		guardrails = append(guardrails, deny)
+	}
+	if cfg.MaxInputChars > 0 {
+		guardrails = append(guardrails, &MaxLengthGuardrail{MaxRunes: cfg.MaxInputChars, Stages: []GuardrailStage{GuardrailStageInput}})
+	}
+	if cfg.MaxObservationChars > 0 {
+		guardrails = append(guardrails, &MaxLengthGuardrail{MaxRunes: cfg.MaxObservationChars, Stages: []GuardrailStage{GuardrailStageObservation}, Truncate: true})
+	}
+	if cfg.Classifier.Enabled {
+		stages := make([]GuardrailStage, 0, len(cfg.Classifier.Stages))
+		for _, stage := range cfg.Classifier.Stages {
+			stages = append(stages, GuardrailStage(stage))
+		}
+		guardrails = append(guardrails, &LLMGuardrail{Model: cfg.Classifier.Model, Policy: cfg.Classifier.Policy, Stages: stages})
+	}
+	return guardrails, nil
+}
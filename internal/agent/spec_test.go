@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"agent_study/internal/config"
+)
+
+func TestSpecFactoryBuildsNamedAgentFromSpec(t *testing.T) {
+	specs, err := config.ParseAgentSpecs([]byte(`
+providers:
+  cheap:
+    model: gpt-5.4-mini
+    type: openai
+    baseUrl: https://api.openai.com/v1
+    apiKey: test-key
+agents:
+  - name: default
+    tools:
+      - name: ls
+  - name: reviewer
+    provider: cheap
+    model: gpt-5.4-nano
+    systemPrompt: review carefully
+    tools:
+      - name: read_file
+      - name: exec
+        maxExecTimeoutSeconds: 30
+    limits:
+      mode: plan_execute
+      maxSteps: 5
+      maxBudgetUSD: 0.5
+      toolTimeoutSeconds: 20
+    reflection:
+      enabled: true
+      maxReflections: 1
+    guardrails:
+      injection: true
+      maxInputChars: 100
+`), t.TempDir())
+	if err != nil {
+		t.Fatalf("ParseAgentSpecs() error = %v", err)
+	}
+	factory := &SpecFactory{Specs: specs}
+	defer factory.Close()
+
+	reviewer, err := factory.Build("reviewer")
+	if err != nil {
+		t.Fatalf("Build(reviewer) error = %v", err)
+	}
+	if reviewer.Model != "gpt-5.4-nano" {
+		t.Fatalf("reviewer.Model = %q, want spec model override", reviewer.Model)
+	}
+	if len(reviewer.System) != 1 || reviewer.System[0].Content != "review carefully" {
+		t.Fatalf("reviewer.System = %#v, want spec system prompt", reviewer.System)
+	}
+	toolNames := map[string]bool{}
+	for _, tool := range reviewer.Tools.List() {
+		toolNames[tool.Name] = true
+	}
+	if len(toolNames) != 2 || !toolNames["read_file"] || !toolNames["exec"] {
+		t.Fatalf("reviewer tools = %v, want read_file and exec", toolNames)
+	}
+	if reviewer.Config.Mode != ModePlanExecute || reviewer.Config.MaxSteps != 5 || reviewer.Config.ToolTimeout != 20*time.Second {
+		t.Fatalf("reviewer.Config = %#v, want spec limits", reviewer.Config)
+	}
+	if reviewer.Reflection == nil || reviewer.Reflection.MaxReflections != 1 {
+		t.Fatalf("reviewer.Reflection = %#v, want enabled reflection", reviewer.Reflection)
+	}
+	if len(reviewer.Guardrails) != 2 {
+		t.Fatalf("len(reviewer.Guardrails) = %d, want injection and length guardrails", len(reviewer.Guardrails))
+	}
+
+	// 未引用具名 provider 的 agent 需要调用方提供默认 provider。
+	if _, err := factory.Build("default"); !errors.Is(err, ErrAgentLLMRequired) {
+		t.Fatalf("Build(default) error = %v, want ErrAgentLLMRequired", err)
+	}
+	if _, err := factory.Build("missing"); !errors.Is(err, config.ErrInvalidAgentSpec) {
+		t.Fatalf("Build(missing) error = %v, want ErrInvalidAgentSpec", err)
+	}
+}
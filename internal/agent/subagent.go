@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"agent_study/pkg/tools"
+	toolTypes "agent_study/pkg/types"
+)
+
+// SubAgentToolOptions 描述把一个 Agent 包装成工具时对父 agent 暴露的信息。
+type SubAgentToolOptions struct {
+	// Name 是工具名，同时作为子 agent 事件里的 Agent 字段。
+	Name string
+	// Description 告诉父 agent 何时把子任务委派给它。
+	Description string
+}
+
+type parentRunKey struct{}
+
+// parentRun 记录正在执行工具调用的父运行。
+type parentRun struct {
+	agent *Agent
+	state *State
+}
+
+func withParentRun(ctx context.Context, agent *Agent, state *State) context.Context {
+	return context.WithValue(ctx, parentRunKey{}, parentRun{agent: agent, state: state})
+}
+
+func parentRunFrom(ctx context.Context) (parentRun, bool) {
+	run, ok := ctx.Value(parentRunKey{}).(parentRun)
+	return run, ok
+}
+
+// NewSubAgentTool 把 child 包装成工具：每次调用都以 child 的系统提示词、模型、工具
+// 和 Config 起一次全新的运行，使用独立的进程内记忆，返回其最终回答。
+//
+// 在父 agent 的运行中调用时：
+//   - 子运行的每个 step 会以嵌套事件（Agent/Depth/ParentIndex）转发到父 agent 的 StepCallback
+//   - 父运行的 RunController 不传入子运行：注入的消息留给父运行的下一步，CancelTool 取消整个子运行
+//   - 子运行单独按 child.Cost（未配置时按 child.Config.MaxBudgetUSD）计算预算，用量同时计入父 agent 的 CostTracker
+func NewSubAgentTool(child *Agent, options SubAgentToolOptions) (tools.Tool, error) {
+	if child == nil || child.LLM == nil {
+		return tools.Tool{}, ErrAgentLLMRequired
+	}
+	name := strings.TrimSpace(options.Name)
+	if name == "" {
+		return tools.Tool{}, fmt.Errorf("sub-agent tool name is required")
+	}
+	description := strings.TrimSpace(options.Description)
+	if description == "" {
+		description = "Delegate a focused subtask to the " + name + " agent and get its final answer."
+	}
+
+	return tools.Tool{
+		Name:        name,
+		Description: description,
+		Source:      "agent",
+		Parameters: toolTypes.JSONSchema{
+			Type: "object",
+			Properties: map[string]toolTypes.SchemaProperty{
+				"task": {Type: "string", Description: "A self-contained description of the subtask, including any context the agent needs"},
+			},
+			Required: []string{"task"},
+		},
+		Handler: func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+			task, _ := arguments["task"].(string)
+			if strings.TrimSpace(task) == "" {
+				return "", fmt.Errorf("task is required")
+			}
+			run, err := newSubAgentRun(ctx, child, name)
+			if err != nil {
+				return "", err
+			}
+			// 父运行的 RunController 不能传进子运行，否则注入的消息会被子运行取走，
+			// 子运行的工具调用也会顶掉父运行登记的 CancelTool。
+			state, err := run.Run(WithRunController(ctx, nil), "", task)
+			if err != nil {
+				return "", fmt.Errorf("sub-agent %s: %w", name, err)
+			}
+			return state.FinalAnswer, nil
+		},
+	}, nil
+}
+
+// newSubAgentRun 基于 child 复制出一次性的运行实例：记忆与费用都是全新的，不落检查点，
+// 事件和用量挂到 ctx 里的父运行上。
+func newSubAgentRun(ctx context.Context, child *Agent, name string) (*Agent, error) {
+	memory, err := NewMemoryManager(MemoryOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	run := *child
+	run.System = cloneMessages(child.System)
+	run.Memory = memory
+	run.Checkpoints = nil
+	run.StepCallback = nil
+	run.Cost = newChildCostTracker(child.Cost, nil, child.Config.MaxBudgetUSD)
+
+	parent, ok := parentRunFrom(ctx)
+	if !ok {
+		return &run, nil
+	}
+	run.Cost = newChildCostTracker(child.Cost, parent.agent.Cost, child.Config.MaxBudgetUSD)
+	parentIndex := parent.state.StepIndex + 1
+	run.StepCallback = func(event StepEvent) {
+		// 子运行自己的事件挂到发起调用的父 step 下；更深层的事件已由下一层标好归属，
+		// 这里只需要再加一层深度。
+		if event.Depth == 0 {
+			event.Agent = name
+			event.ParentIndex = parentIndex
+		}
+		event.Depth++
+		parent.agent.emitStep(event)
+	}
+	return &run, nil
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	"agent_study/pkg/tools"
+	toolTypes "agent_study/pkg/types"
+)
+
+func TestSubAgentToolNestsStepsAndRollsUpCost(t *testing.T) {
+	pricing := toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}
+	usage := llmModel.TokenUsage{PromptTokens: 10, CompletionTokens: 5}
+	childLLM := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_c", Name: "lookup_weather", Arguments: `{}`}}, Usage: usage},
+		{Content: "上海晴", Usage: usage},
+	}}
+	child, err := NewAgent(NewAgentOptions{
+		LLM:    childLLM,
+		Model:  "child-model",
+		System: []llmModel.Message{{Role: llmModel.RoleSystem, Content: "你是天气研究员"}},
+		Tools:  newWeatherRegistry(t),
+	})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+	researcher, err := NewSubAgentTool(child, SubAgentToolOptions{Name: "researcher"})
+	if err != nil {
+		t.Fatalf("NewSubAgentTool() error = %v", err)
+	}
+	registry := tools.NewRegistry()
+	if err := registry.Register(researcher); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	parentCost, _ := NewCostTracker(pricing, 0)
+	var events []StepEvent
+	parent := &Agent{
+		LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{
+			{ToolCalls: []toolTypes.ToolCall{{ID: "call_p", Name: "researcher", Arguments: `{"task":"查上海天气"}`}}, Usage: usage},
+			{Content: "上海今天晴", Usage: usage},
+		}},
+		Tools:        registry,
+		Cost:         parentCost,
+		StepCallback: func(event StepEvent) { events = append(events, event) },
+	}
+
+	state, err := parent.Run(context.Background(), "", "上海天气如何")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if state.FinalAnswer != "上海今天晴" || state.Steps[0].Observation != "researcher => 上海晴" {
+		t.Fatalf("state = %#v, want child answer as observation", state)
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("events = %#v, want two child steps then two parent steps", events)
+	}
+	for i, event := range events[:2] {
+		if event.Agent != "researcher" || event.Depth != 1 || event.ParentIndex != 1 || event.Index != i+1 {
+			t.Fatalf("child event %d = %#v, want nested under parent step 1", i, event)
+		}
+	}
+	if events[2].Agent != "" || events[2].Depth != 0 || events[2].Index != 1 {
+		t.Fatalf("parent event = %#v, want top-level step 1", events[2])
+	}
+
+	if total := parentCost.Totals().Usage.PromptTokens; total != 40 {
+		t.Fatalf("parent prompt tokens = %d, want parent and child usage combined", total)
+	}
+	if first := childLLM.requests[0]; first.Model != "child-model" || len(first.Messages) != 2 || first.Messages[0].Content != "你是天气研究员" {
+		t.Fatalf("child request = %#v, want own system prompt and isolated memory", first)
+	}
+	messages, _ := parent.Memory.SessionMessages(context.Background(), "")
+	for _, message := range messages {
+		if message.ToolCallId == "call_c" {
+			t.Fatalf("parent memory contains child tool result %#v", message)
+		}
+	}
+}
+
+func TestSubAgentToolEnforcesChildBudget(t *testing.T) {
+	child := &Agent{
+		LLM: &fakeLlmClient{responses: []llmModel.ChatResponse{
+			{Content: "done", Usage: llmModel.TokenUsage{PromptTokens: 1000}},
+		}},
+		Config: Config{MaxBudgetUSD: 0.5},
+	}
+	tool, err := NewSubAgentTool(child, SubAgentToolOptions{Name: "worker"})
+	if err != nil {
+		t.Fatalf("NewSubAgentTool() error = %v", err)
+	}
+	parentCost, _ := NewCostTracker(toolTypes.ModelPricing{
+		Input:  toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+		Output: toolTypes.TokenPrice{AmountUSD: 1, PerTokens: 1000},
+	}, 0)
+	ctx := withParentRun(context.Background(), &Agent{Cost: parentCost}, &State{})
+
+	result, err := tool.Handler(ctx, map[string]interface{}{"task": "x"})
+	if err != nil || !strings.Contains(result, "费用预算上限") {
+		t.Fatalf("Handler() = %q, %v, want child stopped at its own budget", result, err)
+	}
+	if parentCost.OverBudget() || parentCost.Totals().Cost.TotalCostUSD != 1 {
+		t.Fatalf("parent totals = %#v, want child spend recorded without parent limit", parentCost.Totals())
+	}
+}
@@ -0,0 +1,374 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"agent_study/internal/log"
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+// SearchToolsToolName 是开启工具检索后暴露给模型的元工具，用于查找未出现在本步工具列表里的工具。
+const SearchToolsToolName = "search_tools"
+
+const (
+	defaultToolSelectionTopK           = 8
+	defaultToolSelectionRecentMessages = 4
+	defaultSearchToolsLimit            = 5
+	maxSearchToolsLimit                = 20
+)
+
+// BM25 参数，与 SQLite FTS5 的默认值一致。
+const (
+	toolBM25K1 = 1.2
+	toolBM25B  = 0.75
+)
+
+// ToolSelectionOptions 让每次规划只携带与当前任务相关的工具：按任务与最近消息检索
+// top-k 个工具，再加上 Pinned 与本次运行中通过 search_tools 找到的工具。注册的工具
+// 不超过 TopK+len(Pinned) 时不做筛选。
+type ToolSelectionOptions struct {
+	// TopK 是每步检索出的工具数，默认 8。
+	TopK int
+	// Pinned 中的工具总是携带。
+	Pinned []string
+	// Embedder 可选；提供后检索结合向量相似度，否则只按关键词 BM25 打分。
+	Embedder Embedder
+	// RecentMessages 是参与检索的最近消息条数，默认 4。
+	RecentMessages int
+	// DisableSearch 为 true 时不暴露 search_tools 元工具。
+	DisableSearch bool
+}
+
+// ScoredTool 是一条检索结果，Score 归一化到 (0,1]。
+type ScoredTool struct {
+	Tool  toolTypes.Tool
+	Score float64
+}
+
+// ToolIndex 是工具名称与描述的检索索引：关键词与记忆检索使用相同的切词，在进程内按
+// BM25 打分；提供 Embedder 时同时保存工具向量。
+type ToolIndex struct {
+	tools     []toolTypes.Tool
+	terms     []map[string]int
+	lengths   []int
+	docFreq   map[string]int
+	avgLength float64
+	embedder  Embedder
+	vectors   [][]float32
+	signature string
+}
+
+// NewToolIndex 为 tools 建立索引；embedder 调用失败时返回错误。
+func NewToolIndex(ctx context.Context, tools []toolTypes.Tool, embedder Embedder) (*ToolIndex, error) {
+	index := &ToolIndex{
+		tools:     slices.Clone(tools),
+		terms:     make([]map[string]int, 0, len(tools)),
+		lengths:   make([]int, 0, len(tools)),
+		docFreq:   make(map[string]int),
+		embedder:  embedder,
+		signature: toolSignature(tools),
+	}
+	texts := make([]string, 0, len(tools))
+	total := 0
+	for _, tool := range tools {
+		text := toolSearchText(tool)
+		texts = append(texts, text)
+		frequencies := make(map[string]int)
+		length := 0
+		// memorySearchTerms 会去重，这里按词逐段统计出现次数。
+		for _, field := range strings.Fields(text) {
+			for _, term := range memorySearchTerms(field) {
+				frequencies[term]++
+				length++
+			}
+		}
+		for term := range frequencies {
+			index.docFreq[term]++
+		}
+		index.terms = append(index.terms, frequencies)
+		index.lengths = append(index.lengths, length)
+		total += length
+	}
+	if len(tools) > 0 {
+		index.avgLength = float64(total) / float64(len(tools))
+	}
+	if embedder != nil && len(texts) > 0 {
+		vectors, err := embedder.Embed(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("embed tools: %w", err)
+		}
+		if len(vectors) != len(texts) {
+			return nil, fmt.Errorf("embed tools: got %d vectors for %d tools", len(vectors), len(texts))
+		}
+		index.vectors = vectors
+	}
+	return index, nil
+}
+
+// Search 返回与 query 最相关的最多 limit 个工具，跳过 exclude 中的名称；没有任何相关度的工具不会返回。
+func (i *ToolIndex) Search(ctx context.Context, query string, limit int, exclude ...string) []ScoredTool {
+	if i == nil || limit <= 0 {
+		return nil
+	}
+	relevance := i.lexicalRelevance(query)
+	if len(i.vectors) > 0 && strings.TrimSpace(query) != "" {
+		vectors, err := i.embedder.Embed(ctx, []string{query})
+		if err != nil || len(vectors) != 1 {
+			log.Warnf("embed tool query: %v", err)
+		} else {
+			for n, vector := range i.vectors {
+				if similarity := cosineSimilarity(vectors[0], vector); similarity > relevance[n] {
+					relevance[n] = similarity
+				}
+			}
+		}
+	}
+
+	results := make([]ScoredTool, 0, len(relevance))
+	for n, score := range relevance {
+		if score <= 0 || slices.Contains(exclude, i.tools[n].Name) {
+			continue
+		}
+		results = append(results, ScoredTool{Tool: i.tools[n], Score: score})
+	}
+	sort.SliceStable(results, func(a, b int) bool {
+		if results[a].Score != results[b].Score {
+			return results[a].Score > results[b].Score
+		}
+		return results[a].Tool.Name < results[b].Tool.Name
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// lexicalRelevance 按 BM25 给每个工具打分，并以最佳命中为 1 归一化。
+func (i *ToolIndex) lexicalRelevance(query string) map[int]float64 {
+	relevance := make(map[int]float64)
+	terms := memorySearchTerms(query)
+	if len(terms) == 0 || len(i.tools) == 0 {
+		return relevance
+	}
+	best := 0.0
+	for n, frequencies := range i.terms {
+		score := 0.0
+		for _, term := range terms {
+			frequency := float64(frequencies[term])
+			if frequency == 0 {
+				continue
+			}
+			docFreq := float64(i.docFreq[term])
+			idf := math.Log(1 + (float64(len(i.tools))-docFreq+0.5)/(docFreq+0.5))
+			norm := 1 - toolBM25B + toolBM25B*float64(i.lengths[n])/i.avgLength
+			score += idf * frequency * (toolBM25K1 + 1) / (frequency + toolBM25K1*norm)
+		}
+		if score > 0 {
+			relevance[n] = score
+			best = math.Max(best, score)
+		}
+	}
+	for n, score := range relevance {
+		relevance[n] = score / best
+	}
+	return relevance
+}
+
+// toolSearchText 是参与检索的工具文本：名称（下划线等分隔符拆成单词）、描述与参数说明。
+func toolSearchText(tool toolTypes.Tool) string {
+	parts := []string{tool.Name, strings.NewReplacer("_", " ", "-", " ", ".", " ").Replace(tool.Name), tool.Description}
+	names := make([]string, 0, len(tool.Parameters.Properties))
+	for name := range tool.Parameters.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, name, tool.Parameters.Properties[name].Description)
+	}
+	return strings.Join(parts, " ")
+}
+
+func toolSignature(tools []toolTypes.Tool) string {
+	parts := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		parts = append(parts, tool.Name+"\x00"+tool.Description)
+	}
+	return strings.Join(parts, "\x01")
+}
+
+// toolIndexCache 缓存最近一次建立的索引，由 NewAgent 创建；Agent 以值复制时（例如子
+// agent 运行）共享同一份缓存。
+type toolIndexCache struct {
+	mu    sync.Mutex
+	index *ToolIndex
+}
+
+// toolIndexFor 返回与当前注册工具一致的索引，工具变化（例如新连接了 MCP server）时重建。
+func (a *Agent) toolIndexFor(ctx context.Context, tools []toolTypes.Tool) (*ToolIndex, error) {
+	cache := a.toolIndexes
+	if cache == nil {
+		return NewToolIndex(ctx, tools, a.ToolSelection.Embedder)
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.index != nil && cache.index.signature == toolSignature(tools) {
+		return cache.index, nil
+	}
+	index, err := NewToolIndex(ctx, tools, a.ToolSelection.Embedder)
+	if err != nil {
+		return nil, err
+	}
+	cache.index = index
+	return index, nil
+}
+
+// selectTools 返回这一步携带的工具与其名称；未开启检索时返回全部注册工具，names 为空。
+func (a *Agent) selectTools(ctx context.Context, state *State, messages []llmModel.Message) ([]toolTypes.Tool, []string, error) {
+	if a.Tools == nil {
+		return nil, nil, nil
+	}
+	all := a.Tools.List()
+	options := a.ToolSelection
+	if options == nil {
+		return all, nil, nil
+	}
+	topK := options.TopK
+	if topK <= 0 {
+		topK = defaultToolSelectionTopK
+	}
+	if len(all) <= topK+len(options.Pinned) {
+		return all, toolNames(all), nil
+	}
+
+	index, err := a.toolIndexFor(ctx, all)
+	if err != nil {
+		return nil, nil, err
+	}
+	chosen := make(map[string]struct{})
+	for _, name := range options.Pinned {
+		chosen[name] = struct{}{}
+	}
+	if state != nil {
+		for _, name := range state.DiscoveredTools {
+			chosen[name] = struct{}{}
+		}
+	}
+	exclude := make([]string, 0, len(chosen))
+	for name := range chosen {
+		exclude = append(exclude, name)
+	}
+	for _, result := range index.Search(ctx, a.toolQuery(state, messages), topK, exclude...) {
+		chosen[result.Tool.Name] = struct{}{}
+	}
+
+	// 保持注册器的名称顺序，相同的工具集合产生相同的请求前缀。
+	selected := make([]toolTypes.Tool, 0, len(chosen)+1)
+	for _, tool := range all {
+		if _, ok := chosen[tool.Name]; ok {
+			selected = append(selected, tool)
+		}
+	}
+	if !options.DisableSearch {
+		selected = append(selected, searchToolsTool(len(all)))
+	}
+	return selected, toolNames(selected), nil
+}
+
+// toolQuery 用任务与最近几条非 system 消息拼出检索词。
+func (a *Agent) toolQuery(state *State, messages []llmModel.Message) string {
+	recent := a.ToolSelection.RecentMessages
+	if recent <= 0 {
+		recent = defaultToolSelectionRecentMessages
+	}
+	parts := make([]string, 0, recent+1)
+	if state != nil {
+		parts = append(parts, state.Task)
+	}
+	start := len(parts)
+	for n := len(messages) - 1; n >= 0 && len(parts)-start < recent; n-- {
+		message := messages[n]
+		if message.Role == llmModel.RoleSystem {
+			continue
+		}
+		text := message.Content
+		for _, call := range message.ToolCalls {
+			text += " " + call.Name
+		}
+		if strings.TrimSpace(text) != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func toolNames(tools []toolTypes.Tool) []string {
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+func searchToolsTool(total int) toolTypes.Tool {
+	return toolTypes.Tool{
+		Name:        SearchToolsToolName,
+		Description: fmt.Sprintf("Search the %d available tools by keyword when none of the listed tools fits the next step. Matching tools become callable from the next step on.", total),
+		Parameters: toolTypes.JSONSchema{
+			Type: "object",
+			Properties: map[string]toolTypes.SchemaProperty{
+				"query": {Type: "string", Description: "What the tool should do, e.g. \"create github issue\""},
+				"limit": {Type: "integer", Description: fmt.Sprintf("Maximum number of tools to return, default %d", defaultSearchToolsLimit)},
+			},
+			Required: []string{"query"},
+		},
+	}
+}
+
+const searchToolsEmptyTmpl = `没有找到与“%s”相关的工具。`
+
+const searchToolsResultTmpl = `以下工具从下一步起可以调用：
+%s`
+
+// searchTools 执行 search_tools 元工具，把找到的工具记入 State.DiscoveredTools。
+func (a *Agent) searchTools(ctx context.Context, state *State, arguments map[string]interface{}) (string, error) {
+	query, _ := arguments["query"].(string)
+	if strings.TrimSpace(query) == "" {
+		return "", fmt.Errorf("query is required")
+	}
+	limit := defaultSearchToolsLimit
+	if value, ok := arguments["limit"].(float64); ok && value > 0 {
+		limit = min(int(value), maxSearchToolsLimit)
+	}
+
+	index, err := a.toolIndexFor(ctx, a.Tools.List())
+	if err != nil {
+		return "", err
+	}
+	results := index.Search(ctx, query, limit)
+	if len(results) == 0 {
+		return fmt.Sprintf(searchToolsEmptyTmpl, query), nil
+	}
+	lines := make([]string, 0, len(results))
+	for _, result := range results {
+		if !slices.Contains(state.DiscoveredTools, result.Tool.Name) {
+			state.DiscoveredTools = append(state.DiscoveredTools, result.Tool.Name)
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %s", result.Tool.Name, result.Tool.Description))
+	}
+	return fmt.Sprintf(searchToolsResultTmpl, strings.Join(lines, "\n")), nil
+}
+
+// executeTool 执行一次工具调用；开启工具检索时 search_tools 由 agent 自己处理。
+func (a *Agent) executeTool(ctx context.Context, state *State, name string, arguments map[string]interface{}) (string, error) {
+	if name == SearchToolsToolName && a.ToolSelection != nil && !a.ToolSelection.DisableSearch {
+		return a.searchTools(ctx, state, arguments)
+	}
+	return a.Tools.Execute(ctx, name, arguments)
+}
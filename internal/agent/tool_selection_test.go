@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	"agent_study/pkg/tools"
+	toolTypes "agent_study/pkg/types"
+)
+
+func newLargeToolRegistry(t *testing.T) *tools.Registry {
+	t.Helper()
+	registry := tools.NewRegistry()
+	definitions := map[string]string{
+		"github_create_issue":  "Create a new issue in a GitHub repository",
+		"github_list_pulls":    "List pull requests of a GitHub repository",
+		"slack_post_message":   "Post a message to a Slack channel",
+		"slack_list_channels":  "List Slack channels",
+		"jira_create_ticket":   "Create a Jira ticket",
+		"calendar_list_events": "List calendar events for a day",
+		"weather_lookup":       "Look up the weather forecast of a city",
+		"read_file":            "Read a file from the workspace",
+	}
+	for name, description := range definitions {
+		if err := registry.Register(tools.Tool{
+			Name:        name,
+			Description: description,
+			Parameters:  toolTypes.JSONSchema{Type: "object"},
+			Handler: func(context.Context, map[string]interface{}) (string, error) {
+				return name + " ok", nil
+			},
+		}); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+	return registry
+}
+
+func TestToolIndexRanksByNameAndDescription(t *testing.T) {
+	index, err := NewToolIndex(context.Background(), newLargeToolRegistry(t).List(), nil)
+	if err != nil {
+		t.Fatalf("NewToolIndex() error = %v", err)
+	}
+
+	results := index.Search(context.Background(), "open a github issue", 2)
+	if len(results) != 2 || results[0].Tool.Name != "github_create_issue" || results[0].Score != 1 {
+		t.Fatalf("Search() = %#v, want github_create_issue first", results)
+	}
+	if results := index.Search(context.Background(), "github", 5, "github_list_pulls"); len(results) != 1 || results[0].Tool.Name != "github_create_issue" {
+		t.Fatalf("Search() with exclude = %#v, want only github_create_issue", results)
+	}
+	if results := index.Search(context.Background(), "quantum chemistry", 5); len(results) != 0 {
+		t.Fatalf("Search() = %#v, want no results for unrelated query", results)
+	}
+}
+
+type keywordEmbedder struct{}
+
+// Embed 把“天气”相关文本映射到同一方向，用来验证向量召回。
+func (keywordEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		if strings.Contains(text, "weather") || strings.Contains(text, "下雨") {
+			vectors = append(vectors, []float32{1, 0})
+		} else {
+			vectors = append(vectors, []float32{0, 1})
+		}
+	}
+	return vectors, nil
+}
+
+func TestToolIndexCombinesEmbeddingSimilarity(t *testing.T) {
+	index, err := NewToolIndex(context.Background(), newLargeToolRegistry(t).List(), keywordEmbedder{})
+	if err != nil {
+		t.Fatalf("NewToolIndex() error = %v", err)
+	}
+	results := index.Search(context.Background(), "明天会下雨吗", 1)
+	if len(results) != 1 || results[0].Tool.Name != "weather_lookup" {
+		t.Fatalf("Search() = %#v, want weather_lookup via embedding", results)
+	}
+}
+
+func TestRunSelectsToolsPerStepAndSearchToolAddsMore(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: SearchToolsToolName, Arguments: `{"query":"slack message"}`}}},
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_2", Name: "slack_post_message", Arguments: `{}`}}},
+		{Content: "done"},
+	}}
+	agent, err := NewAgent(NewAgentOptions{
+		LLM:           llm,
+		Tools:         newLargeToolRegistry(t),
+		ToolSelection: &ToolSelectionOptions{TopK: 1, Pinned: []string{"read_file"}},
+	})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+
+	state, err := agent.Run(context.Background(), "", "create a github issue for the bug")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	first := toolNames(llm.requests[0].Tools)
+	if !slices.Equal(first, []string{"github_create_issue", "read_file", SearchToolsToolName}) {
+		t.Fatalf("first step tools = %v, want top-1, pinned and search_tools", first)
+	}
+	if !strings.Contains(state.Steps[0].Observation, "slack_post_message: Post a message to a Slack channel") {
+		t.Fatalf("search observation = %q, want slack_post_message", state.Steps[0].Observation)
+	}
+	second := toolNames(llm.requests[1].Tools)
+	if !slices.Contains(second, "slack_post_message") || !slices.Contains(second, "read_file") {
+		t.Fatalf("second step tools = %v, want discovered and pinned tools", second)
+	}
+	if state.Steps[1].Observation != "slack_post_message => slack_post_message ok" {
+		t.Fatalf("second observation = %q, want discovered tool executed", state.Steps[1].Observation)
+	}
+	if !slices.Equal(state.Steps[0].Metrics.SelectedTools, first) {
+		t.Fatalf("selected tools = %v, want %v recorded on step", state.Steps[0].Metrics.SelectedTools, first)
+	}
+	if !slices.Contains(state.DiscoveredTools, "slack_post_message") {
+		t.Fatalf("DiscoveredTools = %v, want slack_post_message", state.DiscoveredTools)
+	}
+}
+
+func TestSelectToolsKeepsSmallRegistriesIntact(t *testing.T) {
+	agent := &Agent{Tools: newWeatherRegistry(t), ToolSelection: &ToolSelectionOptions{}}
+	selected, names, err := agent.selectTools(context.Background(), &State{Task: "weather"}, nil)
+	if err != nil {
+		t.Fatalf("selectTools() error = %v", err)
+	}
+	if len(selected) != 1 || !slices.Equal(names, []string{"lookup_weather"}) {
+		t.Fatalf("selectTools() = %v, want every tool without search_tools", names)
+	}
+}
@@ -0,0 +1,511 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"agent_study/internal/log"
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+// ExportFormat 是轨迹导出的目标格式。
+type ExportFormat string
+
+const (
+	// ExportOpenAI 是 OpenAI chat 微调使用的 JSONL，每条轨迹一行 {"messages","tools"}。
+	ExportOpenAI ExportFormat = "openai"
+	// ExportGemini 是 Gemini 风格的 JSONL，每条轨迹一行 {"systemInstruction","contents","tools"}。
+	ExportGemini ExportFormat = "gemini"
+	// ExportMarkdown 是便于人工排查的对话记录。
+	ExportMarkdown ExportFormat = "markdown"
+)
+
+var ErrUnsupportedExportFormat = errors.New("unsupported trajectory export format")
+
+const redactedText = "[REDACTED]"
+
+// DefaultSecretPatterns 覆盖常见的 API key、访问令牌与 key=value 形式的口令。
+var DefaultSecretPatterns = []string{
+	`sk-[A-Za-z0-9_\-]{16,}`,
+	`AKIA[0-9A-Z]{16}`,
+	`AIza[0-9A-Za-z_\-]{35}`,
+	`gh[pousr]_[A-Za-z0-9]{36,}`,
+	`(?i)bearer\s+[A-Za-z0-9._\-]{16,}`,
+	`(?i)\b(api[_-]?key|secret|password|passwd|access[_-]?token)\b\s*[:=]\s*[^\s"',;]+`,
+}
+
+// Trajectory 是一次运行的可导出视图：System 与 Tools 取自导出时的 Agent，其中
+// SystemPrompt 模板按该运行的 PromptVars 渲染（时间、剩余预算等内置变量取导出时的值）；
+// Messages 是该运行写入会话的消息（不含之前轮次）。
+type Trajectory struct {
+	RunID       string
+	SessionID   string
+	Task        string
+	FinalAnswer string
+	StopReason  StopReason
+	System      []llmModel.Message
+	Tools       []toolTypes.Tool
+	Messages    []llmModel.Message
+	Steps       []Step
+	Stats       RunStats
+}
+
+// ExportOptions 控制导出时的脱敏与推理内容处理。
+type ExportOptions struct {
+	// Redact 为 true 时用 DefaultSecretPatterns 脱敏，RedactPatterns 总是额外生效。
+	Redact         bool
+	RedactPatterns []string
+	// DropReasoning 去掉模型的思考文本；保留时 OpenAI 格式以前置 <think> 块写入回答。
+	DropReasoning bool
+}
+
+// Trajectory 由运行结束后的 State 与其会话消息构造轨迹。
+func (a *Agent) Trajectory(ctx context.Context, state *State) (*Trajectory, error) {
+	if a == nil || state == nil {
+		return nil, fmt.Errorf("agent or state is nil")
+	}
+	if err := a.ensureMemory(); err != nil {
+		return nil, err
+	}
+	messages, err := a.Memory.SessionMessages(ctx, state.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	return a.newTrajectory(ctx, *state, messages), nil
+}
+
+// LoadTrajectory 从检查点读取已持久化的运行并构造轨迹。
+func (a *Agent) LoadTrajectory(ctx context.Context, runID string) (*Trajectory, error) {
+	if a == nil {
+		return nil, fmt.Errorf("agent is nil")
+	}
+	if a.Checkpoints == nil {
+		return nil, ErrCheckpointDisabled
+	}
+	checkpoint, err := a.Checkpoints.Load(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	state := checkpoint.State
+	state.RunID = checkpoint.ID
+	return a.newTrajectory(ctx, state, checkpoint.Memory), nil
+}
+
+func (a *Agent) newTrajectory(ctx context.Context, state State, messages []llmModel.Message) *Trajectory {
+	// 压缩可能缩短了会话，偏移量越界时退回整段会话。
+	offset := state.MemoryOffset
+	if offset < 0 || offset > len(messages) {
+		offset = 0
+	}
+	trajectory := &Trajectory{
+		RunID:       state.RunID,
+		SessionID:   state.SessionID,
+		Task:        state.Task,
+		FinalAnswer: state.FinalAnswer,
+		StopReason:  state.StopReason,
+		System:      append([]llmModel.Message(nil), a.System...),
+		Messages:    append([]llmModel.Message(nil), messages[offset:]...),
+		Steps:       state.Steps,
+		Stats:       state.Stats,
+	}
+	// 与规划时一样排在静态 System 之后；渲染失败时只导出静态部分。
+	rendered, err := a.renderSystemPrompts(ctx, &state)
+	if err != nil {
+		log.Warnf("render system prompt for trajectory: %v", err)
+	}
+	trajectory.System = append(trajectory.System, rendered...)
+	if a.Tools != nil {
+		trajectory.Tools = a.Tools.List()
+	}
+	return trajectory
+}
+
+// TrajectoryRecorder 通过 StepCallback 记录进行中的运行，运行出错（Run 不返回
+// State）时也能导出已完成的部分。
+type TrajectoryRecorder struct {
+	agent     *Agent
+	sessionID string
+	offset    int
+
+	mu    sync.Mutex
+	runID string
+	steps []Step
+	stats RunStats
+}
+
+// NewTrajectoryRecorder 记下会话当前的消息数，之后写入的消息都归入这次运行。
+// 调用方需要把 Callback 安装到 Agent 上：a.StepCallback = recorder.Callback(a.StepCallback)。
+func (a *Agent) NewTrajectoryRecorder(ctx context.Context, sessionID string) (*TrajectoryRecorder, error) {
+	if a == nil {
+		return nil, fmt.Errorf("agent is nil")
+	}
+	if err := a.ensureMemory(); err != nil {
+		return nil, err
+	}
+	messages, err := a.Memory.SessionMessages(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &TrajectoryRecorder{agent: a, sessionID: sessionID, offset: len(messages)}, nil
+}
+
+// Callback 返回记录顶层 step 后再转发给 next 的回调；子 agent 的 step 只转发。
+func (r *TrajectoryRecorder) Callback(next StepCallback) StepCallback {
+	return func(event StepEvent) {
+		if event.Depth == 0 {
+			r.mu.Lock()
+			r.runID = event.RunID
+			r.steps = append(r.steps, event.Step)
+			r.stats = event.Stats
+			r.mu.Unlock()
+		}
+		if next != nil {
+			next(event)
+		}
+	}
+}
+
+// Trajectory 返回目前为止记录到的轨迹；任务与最终回答从会话消息中恢复。
+func (r *TrajectoryRecorder) Trajectory(ctx context.Context) (*Trajectory, error) {
+	messages, err := r.agent.Memory.SessionMessages(ctx, r.sessionID)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	state := State{
+		RunID:        r.runID,
+		SessionID:    r.sessionID,
+		MemoryOffset: r.offset,
+		Steps:        append([]Step(nil), r.steps...),
+		Stats:        r.stats,
+	}
+	r.mu.Unlock()
+	offset := state.MemoryOffset
+	if offset > len(messages) {
+		offset = 0
+	}
+	for _, message := range messages[offset:] {
+		if message.Role == llmModel.RoleUser {
+			state.Task = message.Content
+			break
+		}
+	}
+	// 记录器拿不到 State，运行变量从 ctx 里取，调用方应传入发起运行时的 ctx。
+	applyPromptVars(ctx, &state)
+
+	trajectory := r.agent.newTrajectory(ctx, state, messages)
+	if n := len(state.Steps); n > 0 && state.Steps[n-1].Action.Kind == ActionKindFinish {
+		trajectory.FinalAnswer = state.Steps[n-1].Action.Answer
+	}
+	return trajectory, nil
+}
+
+// ExportTrajectories 把轨迹按格式写入 w；JSONL 格式每条轨迹一行，Markdown 以分隔线隔开。
+func ExportTrajectories(w io.Writer, format ExportFormat, options ExportOptions, trajectories ...*Trajectory) error {
+	switch format {
+	case ExportOpenAI, ExportGemini, ExportMarkdown:
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedExportFormat, format)
+	}
+	redactor, err := newRedactor(options)
+	if err != nil {
+		return err
+	}
+	// 训练数据里的 <think> 等标记保持原样，不做 HTML 转义；Encode 会在每条记录后换行。
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for i, trajectory := range trajectories {
+		if trajectory == nil {
+			continue
+		}
+		var record any
+		switch format {
+		case ExportOpenAI:
+			record = openAIRecord(trajectory, options, redactor)
+		case ExportGemini:
+			record = geminiRecord(trajectory, options, redactor)
+		case ExportMarkdown:
+			if i > 0 {
+				if _, err := io.WriteString(w, "\n---\n\n"); err != nil {
+					return err
+				}
+			}
+			if _, err := io.WriteString(w, markdownTranscript(trajectory, options, redactor)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("encode trajectory: %w", err)
+		}
+	}
+	return nil
+}
+
+type redactor []*regexp.Regexp
+
+func newRedactor(options ExportOptions) (redactor, error) {
+	patterns := options.RedactPatterns
+	if options.Redact {
+		patterns = append(append([]string(nil), DefaultSecretPatterns...), patterns...)
+	}
+	compiled := make(redactor, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compile redact pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func (r redactor) apply(text string) string {
+	for _, re := range r {
+		text = re.ReplaceAllString(text, redactedText)
+	}
+	return text
+}
+
+func reasoningText(message llmModel.Message, options ExportOptions) string {
+	if options.DropReasoning {
+		return ""
+	}
+	if text := strings.TrimSpace(message.Reasoning); text != "" {
+		return text
+	}
+	// 只有结构化推理的 provider（如 Responses API）把可读内容放在 summary 里。
+	var summaries []string
+	for _, item := range message.ReasoningItems {
+		for _, summary := range item.Summary {
+			summaries = append(summaries, summary.Text)
+		}
+	}
+	return llmModel.JoinReasoning(summaries...)
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    *string          `json:"content,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function toolTypes.Tool `json:"function"`
+}
+
+func openAIRecord(t *Trajectory, options ExportOptions, redact redactor) any {
+	var messages []openAIMessage
+	for _, message := range append(append([]llmModel.Message(nil), t.System...), t.Messages...) {
+		content := redact.apply(message.Content)
+		if message.Role == llmModel.RoleAssistant {
+			if reasoning := reasoningText(message, options); reasoning != "" {
+				content = "<think>\n" + redact.apply(reasoning) + "\n</think>\n" + content
+			}
+		}
+		converted := openAIMessage{Role: message.Role, ToolCallID: message.ToolCallId}
+		// assistant 只发起工具调用时省略 content，与 API 返回的结构保持一致。
+		if content != "" || message.Role != llmModel.RoleAssistant {
+			converted.Content = &content
+		}
+		for _, call := range message.ToolCalls {
+			toolCall := openAIToolCall{ID: call.ID, Type: "function"}
+			toolCall.Function.Name = call.Name
+			toolCall.Function.Arguments = redact.apply(call.Arguments)
+			converted.ToolCalls = append(converted.ToolCalls, toolCall)
+		}
+		messages = append(messages, converted)
+	}
+
+	record := struct {
+		Messages []openAIMessage `json:"messages"`
+		Tools    []openAITool    `json:"tools,omitempty"`
+	}{Messages: messages}
+	for _, tool := range exportTools(t.Tools) {
+		record.Tools = append(record.Tools, openAITool{Type: "function", Function: tool})
+	}
+	return record
+}
+
+// exportTools 把空的 properties/required 补成空集合，避免写出 null 导致训练数据校验失败。
+func exportTools(tools []toolTypes.Tool) []toolTypes.Tool {
+	exported := make([]toolTypes.Tool, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Parameters.Type == "" {
+			tool.Parameters.Type = "object"
+		}
+		if tool.Parameters.Properties == nil {
+			tool.Parameters.Properties = map[string]toolTypes.SchemaProperty{}
+		}
+		if tool.Parameters.Required == nil {
+			tool.Parameters.Required = []string{}
+		}
+		exported = append(exported, tool)
+	}
+	return exported
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	ID   string         `json:"id,omitempty"`
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+type geminiFunctionResponse struct {
+	ID       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+func geminiRecord(t *Trajectory, options ExportOptions, redact redactor) any {
+	record := struct {
+		SystemInstruction *geminiContent  `json:"systemInstruction,omitempty"`
+		Contents          []geminiContent `json:"contents"`
+		Tools             []any           `json:"tools,omitempty"`
+	}{}
+	if len(t.System) > 0 {
+		system := &geminiContent{}
+		for _, message := range t.System {
+			system.Parts = append(system.Parts, geminiPart{Text: redact.apply(message.Content)})
+		}
+		record.SystemInstruction = system
+	}
+
+	toolNames := make(map[string]string)
+	for _, message := range t.Messages {
+		var content geminiContent
+		switch message.Role {
+		case llmModel.RoleAssistant:
+			content.Role = "model"
+			if reasoning := reasoningText(message, options); reasoning != "" {
+				content.Parts = append(content.Parts, geminiPart{Text: redact.apply(reasoning), Thought: true})
+			}
+			if message.Content != "" {
+				content.Parts = append(content.Parts, geminiPart{Text: redact.apply(message.Content)})
+			}
+			for _, call := range message.ToolCalls {
+				toolNames[call.ID] = call.Name
+				content.Parts = append(content.Parts, geminiPart{FunctionCall: &geminiFunctionCall{
+					ID:   call.ID,
+					Name: call.Name,
+					Args: jsonObject(redact.apply(call.Arguments), "arguments"),
+				}})
+			}
+		case llmModel.RoleTool:
+			// Gemini 以 user 角色回传工具结果，同一轮的多个结果需要合并到一条 content。
+			name := toolNames[message.ToolCallId]
+			if name == "" {
+				name = "tool_response"
+			}
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{
+				ID:       message.ToolCallId,
+				Name:     name,
+				Response: jsonObject(redact.apply(message.Content), "output"),
+			}}
+			if n := len(record.Contents); n > 0 && record.Contents[n-1].Parts[0].FunctionResponse != nil {
+				record.Contents[n-1].Parts = append(record.Contents[n-1].Parts, part)
+				continue
+			}
+			content = geminiContent{Role: "user", Parts: []geminiPart{part}}
+		default:
+			content = geminiContent{Role: "user", Parts: []geminiPart{{Text: redact.apply(message.Content)}}}
+		}
+		if len(content.Parts) == 0 {
+			continue
+		}
+		record.Contents = append(record.Contents, content)
+	}
+
+	if len(t.Tools) > 0 {
+		record.Tools = []any{map[string]any{"functionDeclarations": exportTools(t.Tools)}}
+	}
+	return record
+}
+
+// jsonObject 把工具参数或结果归一化成对象；非对象 JSON 与纯文本放进 key 字段。
+func jsonObject(raw string, key string) map[string]any {
+	raw = strings.TrimSpace(raw)
+	var parsed any
+	if raw != "" && json.Unmarshal([]byte(raw), &parsed) == nil {
+		if object, ok := parsed.(map[string]any); ok {
+			return object
+		}
+		return map[string]any{key: parsed}
+	}
+	return map[string]any{key: raw}
+}
+
+func markdownTranscript(t *Trajectory, options ExportOptions, redact redactor) string {
+	var b strings.Builder
+	title := strings.TrimSpace(strings.SplitN(redact.apply(t.Task), "\n", 2)[0])
+	fmt.Fprintf(&b, "# Trajectory: %s\n\n", title)
+	if t.RunID != "" {
+		fmt.Fprintf(&b, "- Run: %s\n", t.RunID)
+	}
+	if t.SessionID != "" {
+		fmt.Fprintf(&b, "- Session: %s\n", t.SessionID)
+	}
+	fmt.Fprintf(&b, "- Steps: %d, tool calls: %d, tokens: %d, cost: $%.6f\n",
+		t.Stats.Steps, t.Stats.ToolCalls, t.Stats.Usage.TotalTokens, t.Stats.Cost.TotalCostUSD)
+	if t.StopReason != "" {
+		fmt.Fprintf(&b, "- Stop reason: %s\n", t.StopReason)
+	}
+
+	for _, message := range t.System {
+		fmt.Fprintf(&b, "\n## System\n\n%s\n", redact.apply(message.Content))
+	}
+	toolNames := make(map[string]string)
+	for _, message := range t.Messages {
+		switch message.Role {
+		case llmModel.RoleUser:
+			fmt.Fprintf(&b, "\n## User\n\n%s\n", redact.apply(message.Content))
+		case llmModel.RoleAssistant:
+			b.WriteString("\n## Assistant\n")
+			if reasoning := reasoningText(message, options); reasoning != "" {
+				b.WriteString("\n> Thinking: " + strings.ReplaceAll(redact.apply(reasoning), "\n", "\n> ") + "\n")
+			}
+			if message.Content != "" {
+				fmt.Fprintf(&b, "\n%s\n", redact.apply(message.Content))
+			}
+			for _, call := range message.ToolCalls {
+				toolNames[call.ID] = call.Name
+				fmt.Fprintf(&b, "\nTool call `%s`:\n\n```json\n%s\n```\n", call.Name, redact.apply(call.Arguments))
+			}
+		case llmModel.RoleTool:
+			fmt.Fprintf(&b, "\n## Tool: %s\n\n```\n%s\n```\n", toolNames[message.ToolCallId], redact.apply(message.Content))
+		default:
+			fmt.Fprintf(&b, "\n## %s\n\n%s\n", message.Role, redact.apply(message.Content))
+		}
+	}
+	return b.String()
+}
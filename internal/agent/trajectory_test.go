@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+func newTrajectoryAgent(t *testing.T, responses ...llmModel.ChatResponse) *Agent {
+	t.Helper()
+	store, err := NewCheckpointStore(newBareTestMemoryDB(t))
+	if err != nil {
+		t.Fatalf("NewCheckpointStore() error = %v", err)
+	}
+	return &Agent{
+		System:      []llmModel.Message{{Role: llmModel.RoleSystem, Content: "你是天气助手"}},
+		LLM:         &fakeLlmClient{responses: responses},
+		Tools:       newWeatherRegistry(t),
+		Checkpoints: store,
+	}
+}
+
+func TestExportPersistedRunAsOpenAIAndGeminiJSONL(t *testing.T) {
+	agent := newTrajectoryAgent(t,
+		llmModel.ChatResponse{Content: "第一轮"},
+		llmModel.ChatResponse{Reasoning: "需要先查天气", ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{"city":"Paris","api_key":"sk-abcdefghijklmnopqrstuv"}`}}},
+		llmModel.ChatResponse{Content: "巴黎晴"},
+	)
+	if _, err := agent.Run(context.Background(), "", "你好"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	state, err := agent.Run(context.Background(), "", "巴黎天气")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	trajectory, err := agent.LoadTrajectory(context.Background(), state.RunID)
+	if err != nil {
+		t.Fatalf("LoadTrajectory() error = %v", err)
+	}
+	if trajectory.Task != "巴黎天气" || trajectory.FinalAnswer != "巴黎晴" || len(trajectory.Messages) != 4 {
+		t.Fatalf("trajectory = %#v, want only the second run's messages", trajectory)
+	}
+
+	var openAI bytes.Buffer
+	if err := ExportTrajectories(&openAI, ExportOpenAI, ExportOptions{Redact: true}, trajectory); err != nil {
+		t.Fatalf("ExportTrajectories(openai) error = %v", err)
+	}
+	var record struct {
+		Messages []map[string]any `json:"messages"`
+		Tools    []struct {
+			Type     string `json:"type"`
+			Function struct {
+				Name       string         `json:"name"`
+				Parameters map[string]any `json:"parameters"`
+			} `json:"function"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(openAI.Bytes(), &record); err != nil {
+		t.Fatalf("decode openai line %q: %v", openAI.String(), err)
+	}
+	if len(record.Messages) != 5 || record.Messages[0]["role"] != "system" || record.Messages[1]["content"] != "巴黎天气" {
+		t.Fatalf("messages = %#v, want system prompt followed by the run", record.Messages)
+	}
+	call := record.Messages[2]
+	if call["content"] != "<think>\n需要先查天气\n</think>\n" {
+		t.Fatalf("assistant content = %#v, want reasoning kept as think block", call["content"])
+	}
+	arguments := call["tool_calls"].([]any)[0].(map[string]any)["function"].(map[string]any)["arguments"].(string)
+	if strings.Contains(arguments, "sk-") || !strings.Contains(arguments, redactedText) {
+		t.Fatalf("arguments = %q, want secret redacted", arguments)
+	}
+	if record.Messages[3]["tool_call_id"] != "call_1" || record.Tools[0].Type != "function" || record.Tools[0].Function.Parameters["required"] == nil {
+		t.Fatalf("record = %#v, want tool result and declared tools", record)
+	}
+
+	var gemini bytes.Buffer
+	if err := ExportTrajectories(&gemini, ExportGemini, ExportOptions{DropReasoning: true}, trajectory, trajectory); err != nil {
+		t.Fatalf("ExportTrajectories(gemini) error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(gemini.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("gemini lines = %d, want one per trajectory", len(lines))
+	}
+	var contents struct {
+		SystemInstruction geminiContent   `json:"systemInstruction"`
+		Contents          []geminiContent `json:"contents"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &contents); err != nil {
+		t.Fatalf("decode gemini line: %v", err)
+	}
+	model := contents.Contents[1]
+	if model.Role != "model" || len(model.Parts) != 1 || model.Parts[0].FunctionCall.Args["city"] != "Paris" {
+		t.Fatalf("model content = %#v, want function call without thought", model)
+	}
+	response := contents.Contents[2].Parts[0].FunctionResponse
+	if contents.Contents[2].Role != "user" || response.Name != "lookup_weather" || response.Response["condition"] != "sunny" {
+		t.Fatalf("tool content = %#v, want function response named after the call", contents.Contents[2])
+	}
+	if contents.SystemInstruction.Parts[0].Text != "你是天气助手" {
+		t.Fatalf("system instruction = %#v", contents.SystemInstruction)
+	}
+}
+
+func TestTrajectoryRecorderExportsFailedLiveRunAsMarkdown(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{Reasoning: "查一下", ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{"city":"Paris"}`}}},
+	}}
+	agent := &Agent{LLM: llm, Tools: newWeatherRegistry(t)}
+	recorder, err := agent.NewTrajectoryRecorder(context.Background(), "")
+	if err != nil {
+		t.Fatalf("NewTrajectoryRecorder() error = %v", err)
+	}
+	var forwarded int
+	agent.StepCallback = recorder.Callback(func(StepEvent) { forwarded++ })
+
+	// 第二次规划时没有可用响应，Run 报错且不返回 State。
+	if _, err := agent.Run(context.Background(), "", "巴黎天气，token=abc123"); err == nil {
+		t.Fatalf("Run() error = nil, want planning failure")
+	}
+	trajectory, err := recorder.Trajectory(context.Background())
+	if err != nil {
+		t.Fatalf("Trajectory() error = %v", err)
+	}
+	if forwarded != 1 || len(trajectory.Steps) != 1 || trajectory.Stats.ToolCalls != 1 || trajectory.Task != "巴黎天气，token=abc123" {
+		t.Fatalf("trajectory = %#v, forwarded = %d, want the completed step recorded", trajectory, forwarded)
+	}
+
+	var markdown bytes.Buffer
+	options := ExportOptions{RedactPatterns: []string{`token=\w+`}, DropReasoning: true}
+	if err := ExportTrajectories(&markdown, ExportMarkdown, options, trajectory); err != nil {
+		t.Fatalf("ExportTrajectories(markdown) error = %v", err)
+	}
+	for _, want := range []string{
+		"# Trajectory: 巴黎天气，[REDACTED]",
+		"- Steps: 1, tool calls: 1",
+		"Tool call `lookup_weather`:\n\n```json\n{\"city\":\"Paris\"}\n```",
+		"## Tool: lookup_weather\n\n```\n{\"condition\":\"sunny\"}\n```",
+	} {
+		if !strings.Contains(markdown.String(), want) {
+			t.Fatalf("markdown missing %q:\n%s", want, markdown.String())
+		}
+	}
+	if strings.Contains(markdown.String(), "Thinking") {
+		t.Fatalf("markdown = %q, want reasoning dropped", markdown.String())
+	}
+
+	if err := ExportTrajectories(&markdown, ExportFormat("csv"), ExportOptions{}); !errors.Is(err, ErrUnsupportedExportFormat) {
+		t.Fatalf("ExportTrajectories(csv) error = %v, want ErrUnsupportedExportFormat", err)
+	}
+}
+
+func TestTrajectoryIncludesRenderedSystemPromptTemplates(t *testing.T) {
+	tmpl, err := ParsePromptTemplate("project", "Project: {{.Vars.project}}, task: {{.Task}}")
+	if err != nil {
+		t.Fatalf("ParsePromptTemplate() error = %v", err)
+	}
+	agent := newTrajectoryAgent(t, llmModel.ChatResponse{Content: "巴黎晴"})
+	agent.SystemPrompt = &SystemPromptOptions{Templates: []*PromptTemplate{tmpl}, Vars: map[string]string{"project": "default"}}
+	state, err := agent.Run(WithPromptVars(context.Background(), map[string]string{"project": "atlas"}), "", "巴黎天气")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// 导出时不再传入运行变量，模板按检查点里保存的 PromptVars 渲染。
+	trajectory, err := agent.LoadTrajectory(context.Background(), state.RunID)
+	if err != nil {
+		t.Fatalf("LoadTrajectory() error = %v", err)
+	}
+	if len(trajectory.System) != 2 || trajectory.System[0].Content != "你是天气助手" || trajectory.System[1].Content != "Project: atlas, task: 巴黎天气" {
+		t.Fatalf("trajectory system = %#v, want static prompt then rendered template", trajectory.System)
+	}
+
+	var openAI bytes.Buffer
+	if err := ExportTrajectories(&openAI, ExportOpenAI, ExportOptions{}, trajectory); err != nil {
+		t.Fatalf("ExportTrajectories(openai) error = %v", err)
+	}
+	if !strings.Contains(openAI.String(), "Project: atlas, task: 巴黎天气") {
+		t.Fatalf("openai export = %s, want rendered system message", openAI.String())
+	}
+}
@@ -0,0 +1,473 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+// FinalAnswerToolName 是不支持原生 structured output 时用来提交结构化最终回答的工具名。
+const FinalAnswerToolName = "final_answer"
+
+const defaultMaxOutputRetries = 2
+
+var (
+	ErrOutputInvalid         = errors.New("final answer does not match output schema")
+	ErrUnsupportedOutputType = errors.New("unsupported output type")
+)
+
+// OutputMode 决定 RunTyped 如何约束最终回答的格式。
+type OutputMode string
+
+const (
+	// OutputModeAuto 在客户端实现了 llmModel.StructuredOutputClient 时使用原生 structured
+	// output，否则退回 final_answer 工具。
+	OutputModeAuto   OutputMode = ""
+	OutputModeNative OutputMode = "native"
+	OutputModeTool   OutputMode = "tool"
+)
+
+type TypedOptions struct {
+	Mode OutputMode
+	// MaxRetries 是最终回答校验失败后带着错误重试的次数，默认 2。
+	MaxRetries int
+}
+
+// TypedResult 是 RunTyped 的结果：Value 是解码后的最终回答，Raw 是模型给出的原始 JSON。
+type TypedResult[T any] struct {
+	Value T
+	Raw   string
+	State *State
+}
+
+// OutputValidationError 表示重试用尽后最终回答仍不符合 schema，Raw 与 Errors 分别是
+// 最后一次的原始回答与校验错误。
+type OutputValidationError struct {
+	Raw    string
+	Errors []string
+}
+
+func (e *OutputValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrOutputInvalid, strings.Join(e.Errors, "; "))
+}
+
+func (e *OutputValidationError) Unwrap() error {
+	return ErrOutputInvalid
+}
+
+// outputSpec 是一次类型化运行对最终回答的要求，挂在 State 上供规划与主循环使用。
+type outputSpec struct {
+	name       string
+	schema     toolTypes.JSONSchema
+	native     bool
+	maxRetries int
+	validate   func(raw string) []string
+}
+
+const outputNativeTmpl = `最终回答必须是且只能是一个符合以下 JSON Schema 的 JSON 对象，不要附加其他文字：
+%s`
+
+const outputToolPrompt = `完成任务时调用 final_answer 工具提交最终回答，参数即结构化的最终结果；不要直接用文本回答。`
+
+const outputFeedbackTmpl = `最终回答不符合要求的 JSON Schema，请修正以下问题后重新给出完整的最终回答：
+%s`
+
+// RunTyped 与 Agent.Run 相同地执行任务，但要求最终回答是符合 T 的 JSON：schema 由 T
+// 推导（json 标签决定字段名与是否必填，description/enum 标签补充说明），支持原生
+// structured output 的客户端直接约束输出，其他客户端通过 final_answer 工具提交。
+// 回答不符合 schema 时把校验错误反馈给模型重试；重试用尽仍失败时返回带着原始回答的
+// 结果与 *OutputValidationError。T 实现了 Validate() error 时也会参与校验。
+func RunTyped[T any](ctx context.Context, a *Agent, sessionID string, task string, options TypedOptions) (*TypedResult[T], error) {
+	if a == nil {
+		return nil, fmt.Errorf("agent is nil")
+	}
+	schema, err := SchemaFor[T]()
+	if err != nil {
+		return nil, err
+	}
+	maxRetries := options.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxOutputRetries
+	}
+	native := options.Mode == OutputModeNative
+	if options.Mode == OutputModeAuto {
+		client, ok := a.LLM.(llmModel.StructuredOutputClient)
+		native = ok && client.SupportsStructuredOutput()
+	}
+
+	output := &outputSpec{
+		name:       outputName(reflect.TypeFor[T]()),
+		schema:     schema,
+		native:     native,
+		maxRetries: maxRetries,
+		validate: func(raw string) []string {
+			_, problems := decodeTyped[T](raw, schema)
+			return problems
+		},
+	}
+	state, err := a.run(ctx, sessionID, task, output)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TypedResult[T]{Raw: state.FinalAnswer, State: state}
+	value, problems := decodeTyped[T](state.FinalAnswer, schema)
+	if len(problems) > 0 {
+		return result, &OutputValidationError{Raw: state.FinalAnswer, Errors: problems}
+	}
+	result.Value = value
+	return result, nil
+}
+
+// SchemaFor 由结构体类型 T 推导 JSON Schema：字段名取 json 标签，带 omitempty 的字段为
+// 可选，`description:"..."` 与 `enum:"a,b"` 标签分别写入字段说明与枚举值。
+func SchemaFor[T any]() (toolTypes.JSONSchema, error) {
+	t := reflect.TypeFor[T]()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return toolTypes.JSONSchema{}, fmt.Errorf("%w: %s is not a struct", ErrUnsupportedOutputType, t)
+	}
+	property, err := schemaProperty(t, map[reflect.Type]bool{})
+	if err != nil {
+		return toolTypes.JSONSchema{}, err
+	}
+	return toolTypes.JSONSchema{Type: "object", Properties: property.Properties, Required: property.Required}, nil
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+func schemaProperty(t reflect.Type, visiting map[reflect.Type]bool) (toolTypes.SchemaProperty, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return toolTypes.SchemaProperty{Type: "string", Description: "RFC 3339 时间"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return toolTypes.SchemaProperty{Type: "string"}, nil
+	case reflect.Bool:
+		return toolTypes.SchemaProperty{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return toolTypes.SchemaProperty{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return toolTypes.SchemaProperty{Type: "number"}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte 按 encoding/json 的约定编码为 base64 字符串。
+			return toolTypes.SchemaProperty{Type: "string"}, nil
+		}
+		items, err := schemaProperty(t.Elem(), visiting)
+		if err != nil {
+			return toolTypes.SchemaProperty{}, err
+		}
+		return toolTypes.SchemaProperty{Type: "array", Items: &items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return toolTypes.SchemaProperty{}, fmt.Errorf("%w: map key of %s", ErrUnsupportedOutputType, t)
+		}
+		return toolTypes.SchemaProperty{Type: "object"}, nil
+	case reflect.Struct:
+		if visiting[t] {
+			return toolTypes.SchemaProperty{}, fmt.Errorf("%w: recursive type %s", ErrUnsupportedOutputType, t)
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		closed := false
+		object := toolTypes.SchemaProperty{
+			Type:                 "object",
+			Properties:           map[string]toolTypes.SchemaProperty{},
+			Required:             []string{},
+			AdditionalProperties: &closed,
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, omitEmpty, skip := jsonFieldName(field)
+			if skip {
+				continue
+			}
+			property, err := schemaProperty(field.Type, visiting)
+			if err != nil {
+				return toolTypes.SchemaProperty{}, err
+			}
+			if name == "" {
+				// 未命名的内嵌结构体与 encoding/json 一样把字段提升到外层。
+				for embedded, value := range property.Properties {
+					object.Properties[embedded] = value
+				}
+				object.Required = append(object.Required, property.Required...)
+				continue
+			}
+			if description := field.Tag.Get("description"); description != "" {
+				property.Description = description
+			}
+			if enum := field.Tag.Get("enum"); enum != "" {
+				property.Enum = strings.Split(enum, ",")
+			}
+			object.Properties[name] = property
+			if !omitEmpty {
+				object.Required = append(object.Required, name)
+			}
+		}
+		return object, nil
+	default:
+		return toolTypes.SchemaProperty{}, fmt.Errorf("%w: %s", ErrUnsupportedOutputType, t)
+	}
+}
+
+// jsonFieldName 按 encoding/json 的规则解析字段名；name 为空表示需要展开的内嵌结构体。
+func jsonFieldName(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	name, options, _ := strings.Cut(tag, ",")
+	omitEmpty = strings.Contains(","+options+",", ",omitempty,") || strings.Contains(","+options+",", ",omitzero,")
+	if field.Anonymous && name == "" {
+		embedded := field.Type
+		if embedded.Kind() == reflect.Pointer {
+			embedded = embedded.Elem()
+		}
+		if embedded.Kind() == reflect.Struct {
+			return "", omitEmpty, false
+		}
+	}
+	if !field.IsExported() {
+		return "", false, true
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, omitEmpty, false
+}
+
+// outputName 生成 structured output 的 schema 名，只保留 provider 接受的字符。
+func outputName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, t.Name())
+	if name == "" {
+		return FinalAnswerToolName
+	}
+	return name
+}
+
+// decodeTyped 先按 schema 校验回答中的 JSON，再解码成 T 并执行 T 自己的 Validate；
+// 返回的错误是给模型看的修正意见。
+func decodeTyped[T any](raw string, schema toolTypes.JSONSchema) (T, []string) {
+	var value T
+	_, answer := llmModel.SplitLeadingThinkBlock(raw)
+	answer = strings.TrimSpace(answer)
+	if start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}"); start >= 0 && end > start {
+		answer = answer[start : end+1]
+	}
+
+	var document any
+	if err := json.Unmarshal([]byte(answer), &document); err != nil {
+		return value, []string{fmt.Sprintf("回答不是合法的 JSON 对象：%v", err)}
+	}
+	closed := false
+	root := toolTypes.SchemaProperty{Type: "object", Properties: schema.Properties, Required: schema.Required, AdditionalProperties: &closed}
+	if problems := validateSchemaValue("$", document, root); len(problems) > 0 {
+		return value, problems
+	}
+	if err := json.Unmarshal([]byte(answer), &value); err != nil {
+		return value, []string{fmt.Sprintf("无法解码为目标结构：%v", err)}
+	}
+	if validator, ok := any(&value).(interface{ Validate() error }); ok {
+		if err := validator.Validate(); err != nil {
+			return value, []string{err.Error()}
+		}
+	}
+	return value, nil
+}
+
+func validateSchemaValue(path string, value any, property toolTypes.SchemaProperty) []string {
+	var problems []string
+	switch property.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s 应为 object", path)}
+		}
+		for _, name := range property.Required {
+			if _, ok := object[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s 缺少必填字段 %s", path, name))
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child, known := property.Properties[name]
+			switch {
+			case !known && property.AdditionalProperties != nil && !*property.AdditionalProperties:
+				problems = append(problems, fmt.Sprintf("%s 包含未定义的字段 %s", path, name))
+			case known && object[name] == nil && !containsString(property.Required, name):
+				// 可选字段允许显式给 null。
+			case known:
+				problems = append(problems, validateSchemaValue(path+"."+name, object[name], child)...)
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s 应为 array", path)}
+		}
+		if property.Items != nil {
+			for i, item := range items {
+				problems = append(problems, validateSchemaValue(fmt.Sprintf("%s[%d]", path, i), item, *property.Items)...)
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s 应为 string", path)}
+		}
+		if len(property.Enum) > 0 && !containsString(property.Enum, text) {
+			problems = append(problems, fmt.Sprintf("%s 必须是 %s 之一", path, strings.Join(property.Enum, "、")))
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return []string{fmt.Sprintf("%s 应为 integer", path)}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{fmt.Sprintf("%s 应为 number", path)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s 应为 boolean", path)}
+		}
+	}
+	return problems
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+// activeOutput 返回当前规划需要遵守的输出要求；计划模式下只有最后一个计划项的回答
+// 才是最终回答，之前的计划项不受约束。
+func activeOutput(state *State) *outputSpec {
+	if state == nil || state.output == nil {
+		return nil
+	}
+	if state.Plan != nil {
+		remaining := 0
+		for _, item := range state.Plan.Items {
+			if item.Status == PlanItemPending || item.Status == PlanItemInProgress {
+				remaining++
+			}
+		}
+		if remaining > 1 {
+			return nil
+		}
+	}
+	return state.output
+}
+
+// outputContext 返回注入 system 的输出格式说明。
+func outputContext(state *State) string {
+	output := activeOutput(state)
+	if output == nil {
+		return ""
+	}
+	if !output.native {
+		return outputToolPrompt
+	}
+	encoded, err := json.Marshal(output.schema)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(outputNativeTmpl, encoded)
+}
+
+func finalAnswerTool(schema toolTypes.JSONSchema) toolTypes.Tool {
+	return toolTypes.Tool{
+		Name:        FinalAnswerToolName,
+		Description: "提交最终回答。任务完成时调用，参数即结构化的最终结果。",
+		Parameters:  schema,
+	}
+}
+
+// applyOutput 按输出要求调整规划请求：原生模式设置 ResponseFormat，工具模式追加
+// final_answer 工具；上一次回答校验失败或需要强制结束时，工具模式直接强制调用它。
+func applyOutput(state *State, request *llmModel.ChatRequest) {
+	output := activeOutput(state)
+	if output == nil {
+		return
+	}
+	if output.native {
+		request.ResponseFormat = &llmModel.ResponseFormat{Name: output.name, Schema: output.schema}
+		return
+	}
+	request.Tools = append(request.Tools, finalAnswerTool(output.schema))
+	if last := len(state.Steps) - 1; forceFinish(state) || last >= 0 && len(state.Steps[last].OutputErrors) > 0 {
+		request.ToolChoice = toolTypes.ToolChoice{Type: toolTypes.ToolForce, Name: FinalAnswerToolName}
+	}
+}
+
+// finalAnswerAction 把对 final_answer 工具的调用转换成 finish 动作，参数即最终回答。
+func finalAnswerAction(state *State, action *Action) {
+	if output := activeOutput(state); output == nil || output.native || action.Kind != ActionKindToolCalls {
+		return
+	}
+	for _, call := range action.ToolCalls {
+		if call.Name == FinalAnswerToolName {
+			*action = Action{Kind: ActionKindFinish, Answer: call.Arguments}
+			return
+		}
+	}
+}
+
+// checkOutput 校验候选最终回答；不符合 schema 且还有重试次数时返回修正意见，由主循环
+// 写回会话后继续迭代。重试用尽后照常结束，由 RunTyped 返回校验错误。
+func (a *Agent) checkOutput(ctx context.Context, state *State, step *Step, answer string) (string, error) {
+	output := state.output
+	if output == nil {
+		return "", nil
+	}
+	problems := output.validate(answer)
+	if len(problems) == 0 {
+		return "", nil
+	}
+	step.OutputErrors = problems
+	if state.OutputRetries >= output.maxRetries {
+		return "", nil
+	}
+	state.OutputRetries++
+	feedback := fmt.Sprintf(outputFeedbackTmpl, "- "+strings.Join(problems, "\n- "))
+	if !output.native {
+		feedback += "\n" + outputToolPrompt
+	}
+	return feedback, nil
+}
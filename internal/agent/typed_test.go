@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+type weatherReport struct {
+	City      string   `json:"city" description:"城市名"`
+	Condition string   `json:"condition" enum:"sunny,rainy"`
+	TempC     int      `json:"temp_c"`
+	Notes     []string `json:"notes,omitempty"`
+}
+
+type structuredLlmClient struct {
+	*fakeLlmClient
+}
+
+func (c structuredLlmClient) SupportsStructuredOutput() bool {
+	return true
+}
+
+func TestRunTypedUsesFinalAnswerToolAndRetriesOnValidationErrors(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_1", Name: "lookup_weather", Arguments: `{"city":"Paris"}`}}},
+		{Content: "巴黎今天晴，21 度"},
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_2", Name: FinalAnswerToolName, Arguments: `{"city":"Paris","condition":"cloudy","temp_c":21}`}}},
+		{ToolCalls: []toolTypes.ToolCall{{ID: "call_3", Name: FinalAnswerToolName, Arguments: `{"city":"Paris","condition":"sunny","temp_c":21}`}}},
+	}}
+	agent := &Agent{LLM: llm, Tools: newWeatherRegistry(t)}
+
+	result, err := RunTyped[weatherReport](context.Background(), agent, "", "巴黎天气", TypedOptions{MaxRetries: 3})
+	if err != nil {
+		t.Fatalf("RunTyped() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Value, weatherReport{City: "Paris", Condition: "sunny", TempC: 21}) || result.State.OutputRetries != 2 {
+		t.Fatalf("result = %#v, retries = %d, want decoded report after two retries", result.Value, result.State.OutputRetries)
+	}
+	if len(result.State.Steps[1].OutputErrors) == 0 || len(result.State.Steps[2].OutputErrors) == 0 {
+		t.Fatalf("steps = %#v, want validation errors recorded on rejected answers", result.State.Steps)
+	}
+
+	first := llm.requests[0]
+	if first.ResponseFormat != nil || first.Tools[len(first.Tools)-1].Name != FinalAnswerToolName || first.ToolChoice.Type != toolTypes.ToolAuto {
+		t.Fatalf("first request = %#v, want final_answer tool offered without forcing", first)
+	}
+	if choice := llm.requests[2].ToolChoice; choice.Type != toolTypes.ToolForce || choice.Name != FinalAnswerToolName {
+		t.Fatalf("retry tool choice = %#v, want final_answer forced", choice)
+	}
+	feedback := llm.requests[3].Messages[len(llm.requests[3].Messages)-1]
+	if feedback.Role != llmModel.RoleUser || !strings.Contains(feedback.Content, "$.condition 必须是 sunny、rainy 之一") {
+		t.Fatalf("retry feedback = %#v, want enum violation", feedback)
+	}
+}
+
+func TestRunTypedReturnsRawAnswerWhenRetriesAreExhausted(t *testing.T) {
+	llm := &fakeLlmClient{responses: []llmModel.ChatResponse{
+		{Content: `{"city":"Paris"}`},
+		{Content: "```json\n{\"city\":\"Paris\",\"condition\":\"sunny\",\"temp_c\":\"warm\"}\n```"},
+	}}
+	agent := &Agent{LLM: structuredLlmClient{llm}}
+
+	result, err := RunTyped[weatherReport](context.Background(), agent, "", "巴黎天气", TypedOptions{MaxRetries: 1})
+	var validationErr *OutputValidationError
+	if !errors.Is(err, ErrOutputInvalid) || !errors.As(err, &validationErr) {
+		t.Fatalf("RunTyped() error = %v, want *OutputValidationError", err)
+	}
+	if result == nil || !strings.Contains(result.Raw, `"temp_c":"warm"`) || validationErr.Raw != result.Raw {
+		t.Fatalf("result = %#v, want raw answer returned", result)
+	}
+	if !reflect.DeepEqual(validationErr.Errors, []string{"$.temp_c 应为 integer"}) {
+		t.Fatalf("errors = %#v, want last validation errors", validationErr.Errors)
+	}
+
+	request := llm.requests[0]
+	if request.ResponseFormat == nil || request.ResponseFormat.Name != "weatherReport" || len(request.Tools) != 0 {
+		t.Fatalf("request = %#v, want native response format", request)
+	}
+	if feedback := llm.requests[1].Messages[len(llm.requests[1].Messages)-1]; !strings.Contains(feedback.Content, "$ 缺少必填字段 condition") {
+		t.Fatalf("retry feedback = %q, want missing field", feedback.Content)
+	}
+}
+
+func TestSchemaForDerivesNestedSchema(t *testing.T) {
+	type day struct {
+		Date string  `json:"date"`
+		High float64 `json:"high,omitempty"`
+	}
+	type forecast struct {
+		weatherReport
+		Days    []day             `json:"days"`
+		Extra   map[string]string `json:"extra,omitempty"`
+		Ignored string            `json:"-"`
+		private string
+	}
+
+	schema, err := SchemaFor[*forecast]()
+	if err != nil {
+		t.Fatalf("SchemaFor() error = %v", err)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"city", "condition", "temp_c", "days"}) {
+		t.Fatalf("required = %#v, want embedded fields promoted and optional fields skipped", schema.Required)
+	}
+	if city := schema.Properties["city"]; city.Type != "string" || city.Description != "城市名" {
+		t.Fatalf("city = %#v, want description from tag", city)
+	}
+	if condition := schema.Properties["condition"]; !reflect.DeepEqual(condition.Enum, []string{"sunny", "rainy"}) {
+		t.Fatalf("condition = %#v, want enum from tag", condition)
+	}
+	days := schema.Properties["days"]
+	if days.Type != "array" || days.Items == nil || days.Items.Properties["high"].Type != "number" || !reflect.DeepEqual(days.Items.Required, []string{"date"}) {
+		t.Fatalf("days = %#v, want array of nested objects", days)
+	}
+	if _, ok := schema.Properties["Ignored"]; ok || len(schema.Properties) != 6 {
+		t.Fatalf("properties = %#v, want skipped and unexported fields omitted", schema.Properties)
+	}
+
+	if _, err := SchemaFor[[]string](); !errors.Is(err, ErrUnsupportedOutputType) {
+		t.Fatalf("SchemaFor[[]string]() error = %v, want ErrUnsupportedOutputType", err)
+	}
+	if _, err := SchemaFor[struct{ C chan int }](); !errors.Is(err, ErrUnsupportedOutputType) {
+		t.Fatalf("SchemaFor[chan]() error = %v, want ErrUnsupportedOutputType", err)
+	}
+}
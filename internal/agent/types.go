@@ -2,6 +2,7 @@ package agent
 
 import (
 	llmModel "agent_study/pkg/llm_core/model"
+	llmTools "agent_study/pkg/llm_core/tools"
 	"agent_study/pkg/tools"
 	toolTypes "agent_study/pkg/types"
 	"time"
@@ -13,12 +14,24 @@ type Config struct {
 	MaxBudgetUSD   float64
 	ToolTimeout    time.Duration
 	MaxObservation int
+	// Mode 为空时等价于 ModeReAct。
+	Mode Mode
+	// MaxReplans 是 plan-and-execute 模式下允许重新规划的次数，默认 2。
+	MaxReplans int
+	// MaxDuration 是整次运行（含恢复前）的时长上限，MaxTokens 是规划调用累计 token
+	// 上限，0 表示不限制；触发时运行会带着进展摘要体面结束。
+	MaxDuration time.Duration
+	MaxTokens   int64
+	// MaxOutputTokens 写入每次规划请求的 MaxTokens，同时用于预估下一次调用的最坏费用。
+	MaxOutputTokens int64
 }
 
 type StepCallback func(StepEvent)
 
 // Agent 聚合一次智能体运行所需的系统提示词、模型、工具、记忆和费用控制能力。
 type Agent struct {
+	// Name 只在多 agent 编排时使用，会记录到该 agent 产生的每个 Step 上。
+	Name   string
 	System []llmModel.Message
 	LLM    llmModel.LlmClient
 	// Model 保存默认模型名，供 Planner 在请求里回填。
@@ -28,26 +41,115 @@ type Agent struct {
 	Cost         *CostTracker
 	Config       Config
 	StepCallback StepCallback
+	// Checkpoints 可选；配置后每完成一个 step 都会把运行快照落库，支持 Resume。
+	Checkpoints *CheckpointStore
+	// Reflection 可选；配置后最终回答需要先通过 critic 审查。
+	Reflection *ReflectionOptions
+	// Downshift 可选；预估下一次调用会超出剩余预算时改用的更便宜模型。
+	Downshift *DownshiftOptions
+	// Routing 可选；配置后每次规划调用按规则或分类模型从多档模型中挑选一个。
+	Routing *RoutingOptions
+	// TokenCounter 可选，用于预估请求的 prompt token，为空时按 rune 近似。
+	TokenCounter *llmTools.TokenCounter
+	// LoopDetection 可选；配置后检测重复的工具调用并按策略警告、强制结束或中止。
+	LoopDetection *LoopDetectionOptions
+	// Guardrails 按顺序检查用户输入、工具输出和最终回答。
+	Guardrails []Guardrail
+	// Hooks 按顺序挂在规划、工具调用与运行结束上，可观察或改写这些环节。
+	Hooks []Hook
+	// ToolSelection 可选；配置后每次规划只携带检索出的相关工具，并暴露 search_tools 元工具。
+	ToolSelection *ToolSelectionOptions
+	// SystemPrompt 可选；配置后每次规划都按当前运行变量渲染模板化的 system prompt。
+	SystemPrompt *SystemPromptOptions
+
+	toolIndexes *toolIndexCache
 }
 
 type State struct {
-	Task        string
-	Steps       []Step
-	FinalAnswer string
-	StepIndex   int
+	// RunID 只在配置了 Checkpoints 时生成，用于恢复和查询这次运行。
+	RunID string
+	// ParentRunID 与 ForkStep 只在 Fork 发起的运行中存在：该运行从 ParentRunID 的第
+	// ForkStep 步之后分叉。
+	ParentRunID string
+	ForkStep    int
+	// SessionID 为空表示使用 MemoryManager 的进程内默认会话。
+	SessionID string
+	// MemoryOffset 是本次运行开始时会话已有的消息数，运行结束后只从之后的消息抽取记忆。
+	MemoryOffset int
+	Task         string
+	Steps        []Step
+	FinalAnswer  string
+	StepIndex    int
+	// Plan 只在 plan-and-execute 模式下存在，记录每个计划项的执行状态。
+	Plan *Plan
+	// DiscoveredTools 是本次运行中模型通过 search_tools 找到的工具，之后每一步都会携带。
+	DiscoveredTools []string
+	// Reflections 是本次运行中最终回答被 critic 打回的次数。
+	Reflections int
+	// Agent 是编排运行中当前持有控制权的 agent，Handoffs 按顺序记录每次交接。
+	Agent    string
+	Handoffs []Handoff
+	// StopReason 非空表示运行因上限提前结束（FinalAnswer 是进展摘要），或内容被
+	// guardrail 拦截（FinalAnswer 是拦截说明）。
+	StopReason StopReason
+	// Stats 是本次运行（含恢复前）所有 step 的用量、费用与耗时汇总。
+	Stats RunStats
+	// Guardrails 按顺序记录 guardrail 对内容的修改与拦截。
+	Guardrails []GuardrailEvent
+	// OutputRetries 是类型化运行中最终回答因不符合 schema 被打回的次数。
+	OutputRetries int
+	// PromptVars 是通过 WithPromptVars 传入的运行级模板变量。
+	PromptVars map[string]string
+	// output 只在 RunTyped 发起的运行中存在，描述最终回答必须满足的 schema。
+	output *outputSpec
+	// handoffTargets 是当前 agent 此刻可以交接的对象，由 Orchestrator 在每段运行前设置。
+	handoffTargets []HandoffTarget
+	// recalled 与 recalledItems 缓存本次运行召回的长期记忆，避免每一步重复召回并
+	// 反复累加记忆的使用统计。
+	recalled      bool
+	recalledItems []MemoryItem
 }
 
 type Step struct {
-	Thought string
+	// Agent 是产生该步的 agent 名称，非编排运行时为空。
+	Agent string
+	// Injected 是运行期间通过 RunController 注入、在该步规划前写入会话的用户消息。
+	Injected []string
+	Thought  string
 	// ReasoningItems 记录模型返回的结构化推理片段，主要用于调试展示和上下文回放。
 	ReasoningItems []llmModel.ReasoningItem
 	Action         Action
 	Observation    string
+	// ToolFailed 表示该步的工具调用以错误结束，Observation 是错误信息。
+	ToolFailed bool
+	// PlanItemID 是该步所执行的计划项编号，非计划模式下为 0。
+	PlanItemID int
+	// Verdict 与 Critique 是 critic 对该步候选最终回答的结论与意见，未审查时为空。
+	Verdict  Verdict
+	Critique string
+	// OutputErrors 是该步候选最终回答未通过 schema 校验的原因，只出现在类型化运行中。
+	OutputErrors []string
+	// Metrics 记录该步的 token 用量、费用、模型与各阶段耗时。
+	Metrics StepMetrics
+	// Loop 是该步触发的重复检测结果，未检测到重复时为 nil。
+	Loop *LoopDetection
 }
 
 type StepEvent struct {
+	// RunID 与 State.RunID 一致，未启用检查点时为空。
+	RunID string
 	Index int
 	Step  Step
+	// Plan 是该步完成后的计划快照，Progress 是对应的进度；非计划模式下均为零值。
+	Plan     *Plan
+	Progress PlanProgress
+	// Agent 是产生该步的子 agent 名称，Depth 是嵌套层级，ParentIndex 是发起调用的父
+	// step 序号；顶层运行的事件里三者均为零值。
+	Agent       string
+	Depth       int
+	ParentIndex int
+	// Stats 是该步完成时所属运行的累计汇总。
+	Stats RunStats
 }
 
 type ActionKind string
@@ -55,10 +157,13 @@ type ActionKind string
 const (
 	ActionKindToolCalls ActionKind = "tool_calls"
 	ActionKindFinish    ActionKind = "finish"
+	// ActionKindHandoff 只出现在编排运行的 Step 里，表示把控制权交给另一个 agent。
+	ActionKindHandoff ActionKind = "handoff"
 )
 
 type Action struct {
 	Kind      ActionKind           `json:"kind"`
 	ToolCalls []toolTypes.ToolCall `json:"tool_calls,omitempty"`
 	Answer    string               `json:"answer,omitempty"`
+	Handoff   *Handoff             `json:"handoff,omitempty"`
 }
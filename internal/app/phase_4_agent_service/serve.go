@@ -0,0 +1,216 @@
+package phase_4_agent_service
+
+import (
+	"agent_study/internal/agent"
+	"agent_study/internal/app"
+	"agent_study/internal/config"
+	"agent_study/internal/db"
+	"agent_study/internal/job"
+	"agent_study/internal/log"
+	phase4logic "agent_study/internal/logic/phase4"
+	phase4router "agent_study/internal/router/phase4"
+	"agent_study/pkg/tools"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func Serve(c *config.Config) {
+	app.GracefulExit()
+	// 初始化日志
+	log.Init(&c.Log)
+
+	// 初始化数据库与检查点；未配置 sqlite 时会话与运行记录只保存在进程内
+	var dbConn *gorm.DB
+	var checkpoints *agent.CheckpointStore
+	if c.Sqlite.Name != "" {
+		databaseCfg := c.Sqlite
+		var err error
+		dbConn, err = db.InitSqlite(&databaseCfg)
+		if err != nil {
+			log.Panicf("Failed to init sqlite: %v", err)
+		}
+		checkpoints, err = agent.NewCheckpointStore(dbConn)
+		if err != nil {
+			log.Panicf("Failed to init checkpoints: %v", err)
+		}
+	}
+
+	// 构造基础 agent，每次运行都从它复制出独立实例
+	base, specs, err := newBaseAgent(c, dbConn, checkpoints)
+	if err != nil {
+		log.Panicf("Failed to build agent: %v", err)
+	}
+	runs, err := phase4logic.NewRunManager(app.Ctx, base)
+	if err != nil {
+		log.Panicf("Failed to init run manager: %v", err)
+	}
+
+	// 初始化路由
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+
+	// 后台任务与 HTTP 运行共用同一个基础 agent；队列依赖 sqlite 持久化，未配置时不启用
+	var jobs *job.Queue
+	if c.Jobs.Enabled {
+		if dbConn == nil {
+			log.Panicf("jobs require sqlite to be configured")
+		}
+		jobs, err = newJobQueue(c, dbConn, base)
+		if err != nil {
+			log.Panicf("Failed to init job queue: %v", err)
+		}
+	}
+
+	phase4router.InitRouter(e, c.Server.ApiBasePath, c.Server.StaticPath, runs, jobs)
+
+	addr := fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Panicf("Failed to listen on %s: %v", addr, err)
+	}
+
+	// 启动服务器
+	go func() {
+		if err := e.RunListener(ln); err != nil {
+			log.Panicf("Failed to run server: %v", err)
+		}
+	}()
+	log.Infof("gin listening on %s", addr)
+
+	// 启动任务 worker；退出时执行到一半的任务会放回队列，等待 worker 退出后再关闭 MCP 连接
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		if jobs != nil {
+			jobs.Run(app.Ctx)
+		}
+	}()
+
+	// 等待关闭信号
+	select {
+	case <-app.Ctx.Done():
+		_ = ln.Close()
+		<-jobsDone
+		if specs != nil {
+			_ = specs.Close()
+		}
+		log.Info("Shutting down server...")
+	}
+}
+
+// newBaseAgent 与 phase 4 CLI 相同：设置了 agent.spec 时按声明式定义构造，否则按 app.yaml
+// 的执行策略构造。返回的 SpecFactory 持有 spec 连接的 MCP server，需要在退出时关闭。
+func newBaseAgent(c *config.Config, dbConn *gorm.DB, checkpoints *agent.CheckpointStore) (*agent.Agent, *agent.SpecFactory, error) {
+	var base *agent.Agent
+	var factory *agent.SpecFactory
+	if c.Agent.Spec.File != "" {
+		specs, err := config.LoadAgentSpecs(c.Agent.Spec.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		factory = &agent.SpecFactory{Specs: specs, Provider: &c.LLM, DB: dbConn, Checkpoints: checkpoints}
+		base, err = factory.Build(c.Agent.Spec.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		var err error
+		base, err = newConfigAgent(c, dbConn, checkpoints)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// 有持久化长期记忆时才需要让 agent 能响应“忘记 X”。
+	if dbConn != nil {
+		if base.Tools == nil {
+			base.Tools = tools.NewRegistry()
+		}
+		if err := base.Tools.Register(agent.NewForgetMemoryTool(base.Memory)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return base, factory, nil
+}
+
+// newJobQueue 按配置构造任务队列，并把配置文件中的定时任务按名称同步到数据库。
+func newJobQueue(c *config.Config, dbConn *gorm.DB, base *agent.Agent) (*job.Queue, error) {
+	store, err := job.NewStore(dbConn)
+	if err != nil {
+		return nil, err
+	}
+	for _, schedule := range c.Jobs.Schedules {
+		if _, err := store.UpsertSchedule(app.Ctx, job.ScheduleSpec{
+			Name:        schedule.Name,
+			Cron:        schedule.Cron,
+			Username:    schedule.Username,
+			Task:        schedule.Task,
+			BudgetUSD:   schedule.BudgetUSD,
+			MaxSteps:    schedule.MaxSteps,
+			MaxAttempts: schedule.MaxAttempts,
+			Enabled:     schedule.Enabled,
+		}); err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", schedule.Name, err)
+		}
+	}
+	return job.NewQueue(store, base, job.QueueOptions{
+		Workers:      c.Jobs.Workers,
+		PollInterval: time.Duration(c.Jobs.PollIntervalSeconds) * time.Second,
+		Lease:        time.Duration(c.Jobs.LeaseSeconds) * time.Second,
+		Backoff:      time.Duration(c.Jobs.BackoffSeconds) * time.Second,
+	})
+}
+
+func newConfigAgent(c *config.Config, dbConn *gorm.DB, checkpoints *agent.CheckpointStore) (*agent.Agent, error) {
+	buildinTools, _ := tools.NewBuiltinTools(tools.BuiltinOptions{})
+	toolsReg := tools.NewRegistry()
+	_ = toolsReg.Register(buildinTools...)
+
+	// 预算按每次运行计算，未配置时与 CLI 一样默认 2 美元。
+	limits := c.Agent.Limits
+	maxBudgetUSD := limits.MaxBudgetUSD
+	if maxBudgetUSD <= 0 {
+		maxBudgetUSD = 2
+	}
+	var downshift *agent.DownshiftOptions
+	if pricing := limits.Downshift.Cost.Pricing(); limits.Downshift.Model != "" && pricing != nil {
+		downshift = &agent.DownshiftOptions{Model: limits.Downshift.Model, Pricing: *pricing}
+	}
+
+	guardrails, err := agent.GuardrailsFromConfig(c.Agent.Guardrails)
+	if err != nil {
+		return nil, err
+	}
+	systemPrompt, err := agent.SystemPromptFromConfig(context.Background(), c.Agent.SystemPrompt, dbConn)
+	if err != nil {
+		return nil, err
+	}
+
+	return agent.NewAgent(agent.NewAgentOptions{
+		Provider:      &c.LLM,
+		MemoryOptions: agent.MemoryOptionsFromConfig(c.Memory, dbConn),
+		Checkpoints:   checkpoints,
+		Reflection:    agent.ReflectionFromConfig(c.Agent.Reflection),
+		Downshift:     downshift,
+		LoopDetection: agent.LoopDetectionFromConfig(c.Agent.LoopDetection),
+		Routing:       agent.RoutingFromConfig(c.Agent.Routing),
+		ToolSelection: agent.ToolSelectionFromConfig(c.Agent.ToolSelection),
+		Guardrails:    guardrails,
+		SystemPrompt:  systemPrompt,
+		Tools:         toolsReg,
+		Config: agent.Config{
+			MaxSteps:        8,
+			MaxBudgetUSD:    maxBudgetUSD,
+			Mode:            agent.Mode(c.Agent.Mode),
+			MaxReplans:      c.Agent.MaxReplans,
+			MaxDuration:     time.Duration(limits.MaxDurationSeconds) * time.Second,
+			MaxTokens:       limits.MaxTokens,
+			MaxOutputTokens: limits.MaxOutputTokens,
+		},
+	})
+}
@@ -0,0 +1,134 @@
+package config
+
+// AgentConfig 描述 agent 执行策略相关的可选配置。
+type AgentConfig struct {
+	// Mode 为空或 react 时逐步决策；plan_execute 时先生成计划再逐项执行。
+	Mode       string `yaml:"mode"`
+	MaxReplans int    `yaml:"maxReplans"`
+	// Reflection 开启最终回答前的 critic 审查；Model 为空时沿用 llmProvider 的模型。
+	Reflection ReflectionConfig `yaml:"reflection"`
+	// Limits 是单次运行的资源上限，触发时运行会带着进展摘要体面结束。
+	Limits LimitsConfig `yaml:"limits"`
+	// LoopDetection 开启重复工具调用检测。
+	LoopDetection LoopDetectionConfig `yaml:"loopDetection"`
+	// Guardrails 检查用户输入、工具输出与最终回答。
+	Guardrails GuardrailsConfig `yaml:"guardrails"`
+	// Routing 按 step 从多档模型（同一 provider）中挑选一个。
+	Routing RoutingConfig `yaml:"routing"`
+	// ToolSelection 在注册了大量工具时按任务检索每步携带的工具。
+	ToolSelection ToolSelectionConfig `yaml:"toolSelection"`
+	// SystemPrompt 配置按 text/template 渲染的 system prompt。
+	SystemPrompt SystemPromptConfig `yaml:"systemPrompt"`
+	// Spec 指向声明式 agent 定义；设置 File 后以上执行策略改由 spec 中名为 Name 的 agent 决定。
+	Spec AgentSpecRef `yaml:"spec"`
+}
+
+type AgentSpecRef struct {
+	File string `yaml:"file"`
+	Name string `yaml:"name"`
+}
+
+// ReflectionConfig 的 Model 为空时沿用 llmProvider 的模型，Cost 未配置时按 llmProvider 的价格计费。
+type ReflectionConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	Model          string        `yaml:"model"`
+	Cost           LLMCostConfig `yaml:"cost"`
+	MaxReflections int           `yaml:"maxReflections"`
+}
+
+// LoopDetectionConfig 的 Policy 可选 warn、finish、abort；数值为 0 时使用 agent 默认值。
+type LoopDetectionConfig struct {
+	Enabled    bool    `yaml:"enabled"`
+	Policy     string  `yaml:"policy"`
+	MaxRepeats int     `yaml:"maxRepeats"`
+	Similarity float64 `yaml:"similarity"`
+	Window     int     `yaml:"window"`
+}
+
+// GuardrailsConfig 中的长度上限为 0 表示不限制。
+type GuardrailsConfig struct {
+	// Injection 用内置正则拦截用户输入与工具输出中的 prompt injection。
+	Injection bool `yaml:"injection"`
+	// DenyPatterns 是自定义正则 deny list，命中即拦截，作用于所有阶段。
+	DenyPatterns        []string                  `yaml:"denyPatterns"`
+	MaxInputChars       int                       `yaml:"maxInputChars"`
+	MaxObservationChars int                       `yaml:"maxObservationChars"`
+	Classifier          GuardrailClassifierConfig `yaml:"classifier"`
+}
+
+// GuardrailClassifierConfig 的 Model 为空时沿用 llmProvider 的模型，Stages 为空时检查所有阶段。
+type GuardrailClassifierConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Model   string   `yaml:"model"`
+	Policy  string   `yaml:"policy"`
+	Stages  []string `yaml:"stages"`
+}
+
+// LimitsConfig 描述单次运行的资源上限，0 表示不限制（MaxBudgetUSD 为 0 时沿用 CLI 默认值）。
+type LimitsConfig struct {
+	MaxBudgetUSD       float64 `yaml:"maxBudgetUSD"`
+	MaxDurationSeconds int     `yaml:"maxDurationSeconds"`
+	MaxTokens          int64   `yaml:"maxTokens"`
+	// MaxOutputTokens 为 0 时不限制输出，调用前的预估只计 prompt token。
+	MaxOutputTokens int64 `yaml:"maxOutputTokens"`
+	// Downshift 是预算不足时改用的同 provider 更便宜模型及其价格。
+	Downshift DownshiftConfig `yaml:"downshift"`
+}
+
+type DownshiftConfig struct {
+	Model string        `yaml:"model"`
+	Cost  LLMCostConfig `yaml:"cost"`
+}
+
+// RoutingConfig 的 Tiers 为空时不启用路由；DefaultTier 为空时使用第一档。
+type RoutingConfig struct {
+	Tiers       []ModelTierConfig       `yaml:"tiers"`
+	DefaultTier string                  `yaml:"defaultTier"`
+	Rules       []RoutingRuleConfig     `yaml:"rules"`
+	Classifier  RoutingClassifierConfig `yaml:"classifier"`
+}
+
+// ModelTierConfig 的 Cost 未配置时按 llmProvider 的价格计费。
+type ModelTierConfig struct {
+	Name        string        `yaml:"name"`
+	Model       string        `yaml:"model"`
+	Description string        `yaml:"description"`
+	Cost        LLMCostConfig `yaml:"cost"`
+}
+
+// RoutingRuleConfig 的 StepKinds 可选 start、observation、retry、continue；数值为 0 时不参与判断。
+type RoutingRuleConfig struct {
+	Tier                  string   `yaml:"tier"`
+	StepKinds             []string `yaml:"stepKinds"`
+	MinPromptTokens       int64    `yaml:"minPromptTokens"`
+	MaxPromptTokens       int64    `yaml:"maxPromptTokens"`
+	MaxRemainingBudgetUSD float64  `yaml:"maxRemainingBudgetUSD"`
+}
+
+// RoutingClassifierConfig 的 Model 为空时沿用 llmProvider 的模型。
+type RoutingClassifierConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Model   string        `yaml:"model"`
+	Cost    LLMCostConfig `yaml:"cost"`
+}
+
+// ToolSelectionConfig 的数值为 0 时使用 agent 默认值。
+type ToolSelectionConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	TopK           int      `yaml:"topK"`
+	Pinned         []string `yaml:"pinned"`
+	RecentMessages int      `yaml:"recentMessages"`
+	// DisableSearch 为 true 时不向模型暴露 search_tools 元工具。
+	DisableSearch bool `yaml:"disableSearch"`
+}
+
+// SystemPromptConfig 的模板按 Files、Prompts 的顺序渲染；两者都为空时不启用。
+type SystemPromptConfig struct {
+	// Files 是模板文件路径。
+	Files []string `yaml:"files"`
+	// Prompts 是 phase 1 prompts 表中的模板名称，需要配置 SQLite。
+	Prompts []string `yaml:"prompts"`
+	// Workspace 为空时使用进程工作目录。
+	Workspace string            `yaml:"workspace"`
+	Vars      map[string]string `yaml:"vars"`
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"agent_study/pkg/tools"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidAgentSpec = errors.New("invalid agent spec")
+
+// AgentSpecFile 是声明式 agent 定义文件，一个文件可以定义多个具名 agent，
+// 并共享文件内声明的具名 provider。
+type AgentSpecFile struct {
+	Providers map[string]LLMProvider `yaml:"providers"`
+	Agents    []AgentSpec            `yaml:"agents"`
+}
+
+// AgentSpec 描述一个 agent 的模型、提示词、工具与运行策略。
+type AgentSpec struct {
+	Name string `yaml:"name"`
+	// Provider 引用 providers 中的具名配置，为空时由调用方提供默认 provider。
+	Provider string `yaml:"provider"`
+	// Model 覆盖 provider 上的模型名。
+	Model        string `yaml:"model"`
+	SystemPrompt string `yaml:"systemPrompt"`
+	// SystemPromptFile 相对于 spec 文件所在目录，加载时读入 SystemPrompt。
+	SystemPromptFile string              `yaml:"systemPromptFile"`
+	Tools            []ToolSpec          `yaml:"tools"`
+	MCPServers       []MCPServerSpec     `yaml:"mcpServers"`
+	Memory           MemoryConfig        `yaml:"memory"`
+	Limits           AgentLimitsSpec     `yaml:"limits"`
+	Reflection       ReflectionConfig    `yaml:"reflection"`
+	LoopDetection    LoopDetectionConfig `yaml:"loopDetection"`
+	Guardrails       GuardrailsConfig    `yaml:"guardrails"`
+	Routing          RoutingConfig       `yaml:"routing"`
+	ToolSelection    ToolSelectionConfig `yaml:"toolSelection"`
+}
+
+// ToolSpec 启用一个内置工具；数值为 0 时使用工具默认值，RootDir 为空时使用工作目录。
+type ToolSpec struct {
+	Name                      string `yaml:"name"`
+	RootDir                   string `yaml:"rootDir"`
+	DefaultLSDepth            int    `yaml:"defaultLSDepth"`
+	DefaultExecTimeoutSeconds int    `yaml:"defaultExecTimeoutSeconds"`
+	MaxExecTimeoutSeconds     int    `yaml:"maxExecTimeoutSeconds"`
+}
+
+// MCPServerSpec 连接一个 MCP server：Command 走 STDIO，URL 走 HTTP，二者只能设置一个。
+// Prefix 非空时注册的工具名带上该前缀。
+type MCPServerSpec struct {
+	Name    string `yaml:"name"`
+	Command string `yaml:"command"`
+	URL     string `yaml:"url"`
+	Prefix  string `yaml:"prefix"`
+}
+
+// AgentLimitsSpec 对应 agent.Config 的执行上限，0 表示使用 agent 默认值或不限制。
+type AgentLimitsSpec struct {
+	Mode               string  `yaml:"mode"`
+	MaxSteps           int     `yaml:"maxSteps"`
+	MaxReplans         int     `yaml:"maxReplans"`
+	MaxBudgetUSD       float64 `yaml:"maxBudgetUSD"`
+	ToolTimeoutSeconds int     `yaml:"toolTimeoutSeconds"`
+	MaxObservation     int     `yaml:"maxObservation"`
+	MaxDurationSeconds int     `yaml:"maxDurationSeconds"`
+	MaxTokens          int64   `yaml:"maxTokens"`
+	MaxOutputTokens    int64   `yaml:"maxOutputTokens"`
+}
+
+// SpecError 是一处校验失败，Path 是 YAML 中的字段路径（如 agents[0].tools[1].name），
+// Line 是该字段（缺失时为最近的上级节点）所在行。
+type SpecError struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (e *SpecError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s (line %d): %s", e.Path, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+func (e *SpecError) Unwrap() error {
+	return ErrInvalidAgentSpec
+}
+
+// Agent 按名称查找 agent 定义。
+func (f *AgentSpecFile) Agent(name string) (*AgentSpec, error) {
+	for i := range f.Agents {
+		if f.Agents[i].Name == name {
+			return &f.Agents[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: agent %q is not defined", ErrInvalidAgentSpec, name)
+}
+
+// LoadAgentSpecs 读取 spec 文件、展开环境变量、读入提示词文件并校验；所有校验错误
+// 一次性返回，每条都指向对应的 YAML 路径。
+func LoadAgentSpecs(path string) (*AgentSpecFile, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAgentSpecs(raw, filepath.Dir(path))
+}
+
+// ParseAgentSpecs 解析 spec 内容，baseDir 用于解析 systemPromptFile 的相对路径。
+func ParseAgentSpecs(raw []byte, baseDir string) (*AgentSpecFile, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(raw))), &root); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAgentSpec, err)
+	}
+	file := &AgentSpecFile{}
+	if err := root.Decode(file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAgentSpec, err)
+	}
+
+	v := &specValidator{root: &root}
+	file.validate(v, baseDir)
+	if len(v.errs) > 0 {
+		return nil, errors.Join(v.errs...)
+	}
+	return file, nil
+}
+
+type specValidator struct {
+	root *yaml.Node
+	errs []error
+}
+
+func (v *specValidator) fail(path string, format string, args ...any) {
+	v.errs = append(v.errs, &SpecError{Path: path, Line: nodeLine(v.root, path), Message: fmt.Sprintf(format, args...)})
+}
+
+var supportedProviderTypes = []string{"openai", "openai_completions", "openai_responses", "google", "gemini"}
+
+func (f *AgentSpecFile) validate(v *specValidator, baseDir string) {
+	for name, provider := range f.Providers {
+		path := "providers." + name
+		if !slices.Contains(supportedProviderTypes, strings.ToLower(strings.TrimSpace(provider.Type()))) {
+			v.fail(path+".type", "unsupported provider type %q, want one of %s", provider.Type(), strings.Join(supportedProviderTypes, ", "))
+		}
+		if strings.TrimSpace(provider.ModelName()) == "" {
+			v.fail(path+".model", "model is required")
+		}
+	}
+
+	if len(f.Agents) == 0 {
+		v.fail("agents", "at least one agent is required")
+	}
+	names := make(map[string]struct{}, len(f.Agents))
+	for i := range f.Agents {
+		spec := &f.Agents[i]
+		path := fmt.Sprintf("agents[%d]", i)
+		if strings.TrimSpace(spec.Name) == "" {
+			v.fail(path+".name", "name is required")
+		} else if _, ok := names[spec.Name]; ok {
+			v.fail(path+".name", "duplicate agent name %q", spec.Name)
+		}
+		names[spec.Name] = struct{}{}
+		spec.validate(v, path, f.Providers, baseDir)
+	}
+}
+
+func (s *AgentSpec) validate(v *specValidator, path string, providers map[string]LLMProvider, baseDir string) {
+	if s.Provider != "" {
+		if _, ok := providers[s.Provider]; !ok {
+			v.fail(path+".provider", "provider %q is not defined in providers", s.Provider)
+		}
+	}
+
+	switch {
+	case s.SystemPrompt != "" && s.SystemPromptFile != "":
+		v.fail(path+".systemPromptFile", "systemPrompt and systemPromptFile are mutually exclusive")
+	case s.SystemPromptFile != "":
+		promptPath := s.SystemPromptFile
+		if !filepath.IsAbs(promptPath) {
+			promptPath = filepath.Join(baseDir, promptPath)
+		}
+		prompt, err := os.ReadFile(promptPath)
+		if err != nil {
+			v.fail(path+".systemPromptFile", "read prompt file: %v", err)
+		} else {
+			s.SystemPrompt = string(prompt)
+		}
+	}
+
+	seenTools := make(map[string]struct{}, len(s.Tools))
+	for i, tool := range s.Tools {
+		toolPath := fmt.Sprintf("%s.tools[%d]", path, i)
+		if !slices.Contains(tools.BuiltinToolNames, tool.Name) {
+			v.fail(toolPath+".name", "unknown builtin tool %q, want one of %s", tool.Name, strings.Join(tools.BuiltinToolNames, ", "))
+		} else if _, ok := seenTools[tool.Name]; ok {
+			v.fail(toolPath+".name", "duplicate tool %q", tool.Name)
+		}
+		seenTools[tool.Name] = struct{}{}
+		nonNegative(v, toolPath+".defaultLSDepth", tool.DefaultLSDepth)
+		nonNegative(v, toolPath+".defaultExecTimeoutSeconds", tool.DefaultExecTimeoutSeconds)
+		nonNegative(v, toolPath+".maxExecTimeoutSeconds", tool.MaxExecTimeoutSeconds)
+	}
+
+	seenServers := make(map[string]struct{}, len(s.MCPServers))
+	for i, server := range s.MCPServers {
+		serverPath := fmt.Sprintf("%s.mcpServers[%d]", path, i)
+		if strings.TrimSpace(server.Name) == "" {
+			v.fail(serverPath+".name", "name is required")
+		} else if _, ok := seenServers[server.Name]; ok {
+			v.fail(serverPath+".name", "duplicate mcp server %q", server.Name)
+		}
+		seenServers[server.Name] = struct{}{}
+		if (server.Command == "") == (server.URL == "") {
+			v.fail(serverPath, "exactly one of command or url is required")
+		}
+	}
+
+	switch s.Memory.Compressor.Type {
+	case "", "simple", "llm":
+	default:
+		v.fail(path+".memory.compressor.type", "unsupported compressor %q, want simple or llm", s.Memory.Compressor.Type)
+	}
+
+	limits := s.Limits
+	switch limits.Mode {
+	case "", "react", "plan_execute":
+	default:
+		v.fail(path+".limits.mode", "unsupported mode %q, want react or plan_execute", limits.Mode)
+	}
+	nonNegative(v, path+".limits.maxSteps", limits.MaxSteps)
+	nonNegative(v, path+".limits.maxReplans", limits.MaxReplans)
+	nonNegative(v, path+".limits.toolTimeoutSeconds", limits.ToolTimeoutSeconds)
+	nonNegative(v, path+".limits.maxObservation", limits.MaxObservation)
+	nonNegative(v, path+".limits.maxDurationSeconds", limits.MaxDurationSeconds)
+	nonNegative(v, path+".limits.maxTokens", limits.MaxTokens)
+	nonNegative(v, path+".limits.maxOutputTokens", limits.MaxOutputTokens)
+	nonNegative(v, path+".limits.maxBudgetUSD", limits.MaxBudgetUSD)
+
+	if s.LoopDetection.Enabled {
+		switch s.LoopDetection.Policy {
+		case "", "warn", "finish", "abort":
+		default:
+			v.fail(path+".loopDetection.policy", "unsupported policy %q, want warn, finish or abort", s.LoopDetection.Policy)
+		}
+	}
+
+	s.Routing.validate(v, path+".routing")
+	nonNegative(v, path+".toolSelection.topK", s.ToolSelection.TopK)
+	nonNegative(v, path+".toolSelection.recentMessages", s.ToolSelection.RecentMessages)
+
+	for i, pattern := range s.Guardrails.DenyPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			v.fail(fmt.Sprintf("%s.guardrails.denyPatterns[%d]", path, i), "invalid pattern: %v", err)
+		}
+	}
+	for i, stage := range s.Guardrails.Classifier.Stages {
+		if !slices.Contains([]string{"input", "observation", "output"}, stage) {
+			v.fail(fmt.Sprintf("%s.guardrails.classifier.stages[%d]", path, i), "unsupported stage %q, want input, observation or output", stage)
+		}
+	}
+}
+
+func (r RoutingConfig) validate(v *specValidator, path string) {
+	tiers := make([]string, 0, len(r.Tiers))
+	for i, tier := range r.Tiers {
+		tierPath := fmt.Sprintf("%s.tiers[%d]", path, i)
+		if strings.TrimSpace(tier.Name) == "" {
+			v.fail(tierPath+".name", "name is required")
+		} else if slices.Contains(tiers, tier.Name) {
+			v.fail(tierPath+".name", "duplicate tier %q", tier.Name)
+		}
+		if strings.TrimSpace(tier.Model) == "" {
+			v.fail(tierPath+".model", "model is required")
+		}
+		tiers = append(tiers, tier.Name)
+	}
+	if len(r.Tiers) == 0 && (r.DefaultTier != "" || len(r.Rules) > 0 || r.Classifier.Enabled) {
+		v.fail(path+".tiers", "at least one tier is required")
+		return
+	}
+	if r.DefaultTier != "" && !slices.Contains(tiers, r.DefaultTier) {
+		v.fail(path+".defaultTier", "tier %q is not defined in tiers", r.DefaultTier)
+	}
+	for i, rule := range r.Rules {
+		rulePath := fmt.Sprintf("%s.rules[%d]", path, i)
+		if !slices.Contains(tiers, rule.Tier) {
+			v.fail(rulePath+".tier", "tier %q is not defined in tiers", rule.Tier)
+		}
+		for j, kind := range rule.StepKinds {
+			if !slices.Contains([]string{"start", "observation", "retry", "continue"}, kind) {
+				v.fail(fmt.Sprintf("%s.stepKinds[%d]", rulePath, j), "unsupported step kind %q, want start, observation, retry or continue", kind)
+			}
+		}
+		nonNegative(v, rulePath+".minPromptTokens", rule.MinPromptTokens)
+		nonNegative(v, rulePath+".maxPromptTokens", rule.MaxPromptTokens)
+		nonNegative(v, rulePath+".maxRemainingBudgetUSD", rule.MaxRemainingBudgetUSD)
+	}
+}
+
+func nonNegative[T int | int64 | float64](v *specValidator, path string, value T) {
+	if value < 0 {
+		v.fail(path, "must not be negative")
+	}
+}
+
+// nodeLine 按 a.b[0].c 形式的路径在 YAML 树中定位节点并返回行号；路径中途缺失时
+// 返回最近一个存在的上级节点的行号。
+func nodeLine(root *yaml.Node, path string) int {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+	for _, segment := range strings.Split(path, ".") {
+		key, rest, _ := strings.Cut(segment, "[")
+		if node = mappingValue(node, key); node == nil {
+			return line
+		}
+		line = node.Line
+		for rest != "" {
+			var indexText string
+			indexText, rest, _ = strings.Cut(rest, "]")
+			rest = strings.TrimPrefix(rest, "[")
+			index, err := strconv.Atoi(indexText)
+			if err != nil || node.Kind != yaml.SequenceNode || index >= len(node.Content) {
+				return line
+			}
+			node = node.Content[index]
+			line = node.Line
+		}
+	}
+	return line
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAgentSpecsLoadsNamedAgentsAndPromptFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "reviewer.md"), []byte("review carefully"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	specs, err := ParseAgentSpecs([]byte(`
+providers:
+  cheap:
+    model: gpt-5.4-mini
+    type: openai
+agents:
+  - name: assistant
+    systemPrompt: be helpful
+    tools:
+      - name: read_file
+      - name: exec
+        maxExecTimeoutSeconds: 30
+    limits:
+      mode: plan_execute
+      maxSteps: 6
+  - name: reviewer
+    provider: cheap
+    model: gpt-5.4-nano
+    systemPromptFile: reviewer.md
+    mcpServers:
+      - name: docs
+        url: http://127.0.0.1:8080/mcp
+`), dir)
+	if err != nil {
+		t.Fatalf("ParseAgentSpecs() error = %v", err)
+	}
+
+	assistant, err := specs.Agent("assistant")
+	if err != nil {
+		t.Fatalf("Agent(assistant) error = %v", err)
+	}
+	if assistant.SystemPrompt != "be helpful" || len(assistant.Tools) != 2 || assistant.Tools[1].MaxExecTimeoutSeconds != 30 {
+		t.Fatalf("assistant = %#v, want prompt and two tools", assistant)
+	}
+	if assistant.Limits.Mode != "plan_execute" || assistant.Limits.MaxSteps != 6 {
+		t.Fatalf("assistant limits = %#v, want plan_execute with 6 steps", assistant.Limits)
+	}
+
+	reviewer, err := specs.Agent("reviewer")
+	if err != nil {
+		t.Fatalf("Agent(reviewer) error = %v", err)
+	}
+	if reviewer.SystemPrompt != "review carefully" {
+		t.Fatalf("reviewer prompt = %q, want prompt file contents", reviewer.SystemPrompt)
+	}
+	if reviewer.Provider != "cheap" || specs.Providers["cheap"].ModelName() != "gpt-5.4-mini" {
+		t.Fatalf("reviewer provider = %q, providers = %#v", reviewer.Provider, specs.Providers)
+	}
+
+	if _, err := specs.Agent("missing"); !errors.Is(err, ErrInvalidAgentSpec) {
+		t.Fatalf("Agent(missing) error = %v, want ErrInvalidAgentSpec", err)
+	}
+}
+
+func TestParseAgentSpecsReportsEveryErrorWithYAMLPath(t *testing.T) {
+	_, err := ParseAgentSpecs([]byte(`agents:
+  - name: assistant
+    provider: missing
+    tools:
+      - name: read_file
+      - name: rm_rf
+  - name: assistant
+    mcpServers:
+      - name: docs
+    limits:
+      maxSteps: -1
+    guardrails:
+      denyPatterns: ["("]
+`), t.TempDir())
+	if !errors.Is(err, ErrInvalidAgentSpec) {
+		t.Fatalf("ParseAgentSpecs() error = %v, want ErrInvalidAgentSpec", err)
+	}
+	var specErr *SpecError
+	if !errors.As(err, &specErr) {
+		t.Fatalf("ParseAgentSpecs() error = %T, want *SpecError", err)
+	}
+
+	message := err.Error()
+	for _, want := range []string{
+		"agents[0].provider (line 3)",
+		"agents[0].tools[1].name (line 6)",
+		"agents[1].name (line 7): duplicate agent name",
+		"agents[1].mcpServers[0] (line 9): exactly one of command or url",
+		"agents[1].limits.maxSteps (line 11)",
+		"agents[1].guardrails.denyPatterns[0] (line 13)",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("error = %q, want it to contain %q", message, want)
+		}
+	}
+}
+
+func TestParseAgentSpecsRejectsPromptConflictsAndMissingFiles(t *testing.T) {
+	_, err := ParseAgentSpecs([]byte(`
+agents:
+  - name: both
+    systemPrompt: inline
+    systemPromptFile: prompt.md
+  - name: missing
+    systemPromptFile: missing.md
+`), t.TempDir())
+	if err == nil {
+		t.Fatal("ParseAgentSpecs() error = nil, want prompt errors")
+	}
+	message := err.Error()
+	if !strings.Contains(message, "agents[0].systemPromptFile (line 5): systemPrompt and systemPromptFile are mutually exclusive") {
+		t.Fatalf("error = %q, want mutually exclusive prompt error", message)
+	}
+	if !strings.Contains(message, "agents[1].systemPromptFile (line 7): read prompt file") {
+		t.Fatalf("error = %q, want missing prompt file error", message)
+	}
+}
+
+func TestLoadAgentSpecsSampleFile(t *testing.T) {
+	specs, err := LoadAgentSpecs(filepath.Join("..", "..", "conf", "phase4", "agents.yaml"))
+	if err != nil {
+		t.Fatalf("LoadAgentSpecs() error = %v", err)
+	}
+	reviewer, err := specs.Agent("reviewer")
+	if err != nil {
+		t.Fatalf("Agent(reviewer) error = %v", err)
+	}
+	if strings.TrimSpace(reviewer.SystemPrompt) == "" {
+		t.Fatal("reviewer prompt is empty, want contents of prompts/reviewer.md")
+	}
+}
+
+func TestParseAgentSpecsValidatesRouting(t *testing.T) {
+	_, err := ParseAgentSpecs([]byte(`agents:
+  - name: router
+    routing:
+      tiers:
+        - name: cheap
+          model: gpt-5.4-mini
+      defaultTier: strong
+      rules:
+        - tier: cheap
+          stepKinds: [observation, thinking]
+`), t.TempDir())
+	if err == nil {
+		t.Fatal("ParseAgentSpecs() error = nil, want routing errors")
+	}
+	message := err.Error()
+	for _, want := range []string{
+		`agents[0].routing.defaultTier (line 7): tier "strong" is not defined in tiers`,
+		`agents[0].routing.rules[0].stepKinds[1] (line 10): unsupported step kind "thinking"`,
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("error = %q, want it to contain %q", message, want)
+		}
+	}
+}
@@ -12,6 +12,9 @@ type Config struct {
 	LLM       LLMProvider       `yaml:"llmProvider"`
 	Embedding EmbeddingProvider `yaml:"embeddingProvider"`
 	Rerank    RerankingProvider `yaml:"rerankProvider"`
+	Memory    MemoryConfig      `yaml:"memory"`
+	Agent     AgentConfig       `yaml:"agent"`
+	Jobs      JobsConfig        `yaml:"jobs"`
 }
 
 type Server struct {
@@ -0,0 +1,25 @@
+package config
+
+// JobsConfig 描述后台任务队列；只在配置了 sqlite 时生效，数值为 0 时使用队列默认值。
+type JobsConfig struct {
+	Enabled             bool `yaml:"enabled"`
+	Workers             int  `yaml:"workers"`
+	PollIntervalSeconds int  `yaml:"pollIntervalSeconds"`
+	LeaseSeconds        int  `yaml:"leaseSeconds"`
+	BackoffSeconds      int  `yaml:"backoffSeconds"`
+	// Schedules 在启动时按 Name 同步到数据库，通过 API 创建的定时任务不受影响。
+	Schedules []JobScheduleConfig `yaml:"schedules"`
+}
+
+// JobScheduleConfig 是按 cron 表达式周期性投递的任务；BudgetUSD、MaxSteps 为 0 时沿用
+// agent 的配置，Enabled 为空时默认启用。
+type JobScheduleConfig struct {
+	Name        string  `yaml:"name"`
+	Cron        string  `yaml:"cron"`
+	Username    string  `yaml:"username"`
+	Task        string  `yaml:"task"`
+	BudgetUSD   float64 `yaml:"budgetUSD"`
+	MaxSteps    int     `yaml:"maxSteps"`
+	MaxAttempts int     `yaml:"maxAttempts"`
+	Enabled     *bool   `yaml:"enabled"`
+}
@@ -0,0 +1,27 @@
+package config
+
+// MemoryConfig 描述 agent 记忆相关的可选配置。
+type MemoryConfig struct {
+	Username        string                 `yaml:"username"`
+	MaxSummaryChars int                    `yaml:"maxSummaryChars"`
+	Compressor      MemoryCompressorConfig `yaml:"compressor"`
+	Items           MemoryItemsConfig      `yaml:"items"`
+}
+
+// MemoryCompressorConfig 选择长期记忆压缩器；Type 为空或 simple 时使用内置拼接压缩器，
+// 为 llm 时使用模型生成结构化画像。Model 为空时沿用 llmProvider 的模型。
+type MemoryCompressorConfig struct {
+	Type             string `yaml:"type"`
+	Model            string `yaml:"model"`
+	MaxSummaryTokens int    `yaml:"maxSummaryTokens"`
+}
+
+// MemoryItemsConfig 启用事实级长期记忆；需要同时配置 sqlite。Model 为空时沿用
+// llmProvider 的模型做记忆抽取。
+type MemoryItemsConfig struct {
+	Enabled      bool    `yaml:"enabled"`
+	TopK         int     `yaml:"topK"`
+	Model        string  `yaml:"model"`
+	HalfLifeDays int     `yaml:"halfLifeDays"`
+	PruneBelow   float64 `yaml:"pruneBelow"`
+}
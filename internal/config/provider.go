@@ -65,22 +65,27 @@ type LLMContextConfig struct {
 // Pricing 会把配置文件里按“每百万 token”书写的人类友好价格，转换成运行时费用统计
 // 所使用的统一价格结构。
 func (p *LLMProvider) Pricing() *sharedTypes.ModelPricing {
-	if p.Cost.Input == nil || p.Cost.Output == nil {
+	return p.Cost.Pricing()
+}
+
+// Pricing 把按“每百万 token”书写的价格转换成统一价格结构；输入或输出价格未配置时返回 nil。
+func (c LLMCostConfig) Pricing() *sharedTypes.ModelPricing {
+	if c.Input == nil || c.Output == nil {
 		return nil
 	}
 	pricing := &sharedTypes.ModelPricing{
 		Input: sharedTypes.TokenPrice{
-			AmountUSD: *p.Cost.Input,
+			AmountUSD: *c.Input,
 			PerTokens: tokensPerMillion,
 		},
 		Output: sharedTypes.TokenPrice{
-			AmountUSD: *p.Cost.Output,
+			AmountUSD: *c.Output,
 			PerTokens: tokensPerMillion,
 		},
 	}
-	if p.Cost.CachedInput != nil {
+	if c.CachedInput != nil {
 		pricing.CachedInput = &sharedTypes.TokenPrice{
-			AmountUSD: *p.Cost.CachedInput,
+			AmountUSD: *c.CachedInput,
 			PerTokens: tokensPerMillion,
 		}
 	}
@@ -0,0 +1,175 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"agent_study/internal/agent"
+	llmModel "agent_study/pkg/llm_core/model"
+)
+
+const (
+	CheckAnswer = "answer"
+	CheckRegex  = "regex"
+	CheckFiles  = "files"
+	CheckTools  = "tools"
+	CheckRubric = "rubric"
+)
+
+// CheckResult 是一项期望的判定结果；Skipped 的检查不影响用例是否通过。
+type CheckResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// Judge 用 LLM 按评分标准判断最终回答是否达标。
+type Judge struct {
+	LLM   llmModel.LlmClient
+	Model string
+}
+
+const judgePrompt = `你是 agent 评测的评审员。根据评分标准判断回答是否达标，只输出 JSON：
+{"pass": true 或 false, "reason": "一句话理由"}`
+
+// Evaluate 返回是否达标及理由；评审模型输出无法解析时返回错误，由调用方记为未通过。
+func (j *Judge) Evaluate(ctx context.Context, task string, answer string, rubric string) (bool, string, error) {
+	if j == nil || j.LLM == nil {
+		return false, "", errors.New("judge llm is nil")
+	}
+	resp, err := j.LLM.Chat(ctx, llmModel.ChatRequest{
+		Model: j.Model,
+		Messages: []llmModel.Message{
+			{Role: llmModel.RoleSystem, Content: judgePrompt},
+			{Role: llmModel.RoleUser, Content: fmt.Sprintf("任务：\n%s\n\n评分标准：\n%s\n\n回答：\n%s", task, rubric, answer)},
+		},
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("judge chat: %w", err)
+	}
+	return parseJudgeVerdict(resp.Content)
+}
+
+func parseJudgeVerdict(content string) (bool, string, error) {
+	_, answer := llmModel.SplitLeadingThinkBlock(content)
+	answer = strings.TrimSpace(answer)
+	if start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}"); start >= 0 && end > start {
+		answer = answer[start : end+1]
+	}
+
+	var result struct {
+		Pass   *bool  `json:"pass"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(answer), &result); err != nil {
+		return false, "", fmt.Errorf("decode judge verdict: %w", err)
+	}
+	if result.Pass == nil {
+		return false, "", errors.New("decode judge verdict: missing pass")
+	}
+	return *result.Pass, strings.TrimSpace(result.Reason), nil
+}
+
+// checkCase 依次判定用例的各项期望，只返回用例声明过的检查。
+func (r *Runner) checkCase(ctx context.Context, c Case, state *agent.State, workspace string) []CheckResult {
+	answer := strings.TrimSpace(state.FinalAnswer)
+	var checks []CheckResult
+
+	if c.Expect.Answer != "" {
+		want := strings.TrimSpace(c.Expect.Answer)
+		check := CheckResult{Name: CheckAnswer, Passed: answer == want}
+		if !check.Passed {
+			check.Detail = fmt.Sprintf("want %q, got %q", want, answer)
+		}
+		checks = append(checks, check)
+	}
+
+	if c.Expect.Regex != "" {
+		// 正则已在 Suite.Validate 中校验过。
+		pattern := regexp.MustCompile(c.Expect.Regex)
+		check := CheckResult{Name: CheckRegex, Passed: pattern.MatchString(answer)}
+		if !check.Passed {
+			check.Detail = fmt.Sprintf("answer does not match %s", c.Expect.Regex)
+		}
+		checks = append(checks, check)
+	}
+
+	if len(c.Expect.Files) > 0 {
+		checks = append(checks, checkFiles(workspace, c.Expect.Files))
+	}
+
+	if len(c.Expect.Tools) > 0 {
+		called := calledTools(state)
+		var missing []string
+		for _, name := range c.Expect.Tools {
+			if _, ok := called[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		check := CheckResult{Name: CheckTools, Passed: len(missing) == 0}
+		if !check.Passed {
+			check.Detail = "not called: " + strings.Join(missing, ", ")
+		}
+		checks = append(checks, check)
+	}
+
+	if c.Expect.Rubric != "" {
+		check := CheckResult{Name: CheckRubric}
+		if r.Judge == nil {
+			check.Skipped = true
+			check.Detail = "no judge configured"
+		} else {
+			passed, reason, err := r.Judge.Evaluate(ctx, state.Task, answer, c.Expect.Rubric)
+			check.Passed = passed && err == nil
+			check.Detail = reason
+			if err != nil {
+				check.Detail = err.Error()
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func checkFiles(workspace string, files map[string]string) CheckResult {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var problems []string
+	for _, path := range paths {
+		resolved, err := workspacePath(workspace, path)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		raw, err := os.ReadFile(resolved)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: missing", path))
+			continue
+		}
+		if got, want := strings.TrimSpace(string(raw)), strings.TrimSpace(files[path]); got != want {
+			problems = append(problems, fmt.Sprintf("%s: want %q, got %q", path, want, got))
+		}
+	}
+	return CheckResult{Name: CheckFiles, Passed: len(problems) == 0, Detail: strings.Join(problems, "; ")}
+}
+
+func calledTools(state *agent.State) map[string]struct{} {
+	called := make(map[string]struct{})
+	for _, step := range state.Steps {
+		for _, call := range step.Action.ToolCalls {
+			called[call.Name] = struct{}{}
+		}
+	}
+	return called
+}
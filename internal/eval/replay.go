@@ -0,0 +1,90 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	llmModel "agent_study/pkg/llm_core/model"
+	toolTypes "agent_study/pkg/types"
+)
+
+var ErrReplayExhausted = errors.New("replay responses exhausted")
+
+// ReplayResponse 是一条录制的模型响应，按调用顺序依次回放。
+type ReplayResponse struct {
+	Content   string           `yaml:"content"`
+	ToolCalls []ReplayToolCall `yaml:"toolCalls"`
+	Usage     ReplayUsage      `yaml:"usage"`
+}
+
+type ReplayToolCall struct {
+	ID        string `yaml:"id"`
+	Name      string `yaml:"name"`
+	Arguments string `yaml:"arguments"`
+}
+
+type ReplayUsage struct {
+	PromptTokens       int64 `yaml:"promptTokens"`
+	CachedPromptTokens int64 `yaml:"cachedPromptTokens"`
+	CompletionTokens   int64 `yaml:"completionTokens"`
+}
+
+// ReplayClient 按顺序返回录制的响应，用来在没有网络和 API key 时稳定复现一次运行。
+// 它实现 llmModel.LlmClient，但不支持流式输出。
+type ReplayClient struct {
+	mu        sync.Mutex
+	responses []ReplayResponse
+	next      int
+}
+
+func NewReplayClient(responses []ReplayResponse) *ReplayClient {
+	return &ReplayClient{responses: responses}
+}
+
+func (c *ReplayClient) Chat(ctx context.Context, req llmModel.ChatRequest) (llmModel.ChatResponse, error) {
+	_ = req
+	if err := ctx.Err(); err != nil {
+		return llmModel.ChatResponse{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.next >= len(c.responses) {
+		return llmModel.ChatResponse{}, fmt.Errorf("%w: %d recorded", ErrReplayExhausted, len(c.responses))
+	}
+	recorded := c.responses[c.next]
+	c.next++
+
+	resp := llmModel.ChatResponse{
+		Content: recorded.Content,
+		Usage: llmModel.TokenUsage{
+			PromptTokens:       recorded.Usage.PromptTokens,
+			CachedPromptTokens: recorded.Usage.CachedPromptTokens,
+			CompletionTokens:   recorded.Usage.CompletionTokens,
+			TotalTokens:        recorded.Usage.PromptTokens + recorded.Usage.CompletionTokens,
+		},
+	}
+	for i, call := range recorded.ToolCalls {
+		id := call.ID
+		if id == "" {
+			// 录制时常省略 ID，这里按调用序号补一个稳定值，保证工具结果能对应回调用。
+			id = fmt.Sprintf("replay_%d_%d", c.next, i+1)
+		}
+		resp.ToolCalls = append(resp.ToolCalls, toolTypes.ToolCall{ID: id, Name: call.Name, Arguments: call.Arguments})
+	}
+	return resp, nil
+}
+
+func (c *ReplayClient) ChatStream(ctx context.Context, req llmModel.ChatRequest) (llmModel.Stream, error) {
+	_ = ctx
+	_ = req
+	return nil, errors.New("replay client does not support streaming")
+}
+
+// Remaining 返回尚未回放的响应数，便于发现录制与实际运行不一致。
+func (c *ReplayClient) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.responses) - c.next
+}
@@ -0,0 +1,233 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Result 是单个用例的运行结果。Tokens 与 CostUSD 在配置了费用跟踪时包含辅助调用。
+type Result struct {
+	ID               string        `json:"id"`
+	Passed           bool          `json:"passed"`
+	Error            string        `json:"error,omitempty"`
+	Answer           string        `json:"answer"`
+	StopReason       string        `json:"stop_reason,omitempty"`
+	Checks           []CheckResult `json:"checks,omitempty"`
+	Steps            int           `json:"steps"`
+	ToolCalls        int           `json:"tool_calls"`
+	PromptTokens     int64         `json:"prompt_tokens"`
+	CompletionTokens int64         `json:"completion_tokens"`
+	TotalTokens      int64         `json:"total_tokens"`
+	CostUSD          float64       `json:"cost_usd"`
+	Latency          time.Duration `json:"latency"`
+}
+
+// Summary 汇总整个套件；平均值按用例数计算。
+type Summary struct {
+	Cases        int           `json:"cases"`
+	Passed       int           `json:"passed"`
+	Errors       int           `json:"errors"`
+	PassRate     float64       `json:"pass_rate"`
+	AvgSteps     float64       `json:"avg_steps"`
+	TotalTokens  int64         `json:"total_tokens"`
+	TotalCostUSD float64       `json:"total_cost_usd"`
+	AvgLatency   time.Duration `json:"avg_latency"`
+}
+
+// Report 是一次评测的完整结果，JSON 形式可作为下次评测的基线。
+type Report struct {
+	Suite     string    `json:"suite"`
+	Label     string    `json:"label,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Summary   Summary   `json:"summary"`
+	Results   []Result  `json:"results"`
+}
+
+func summarize(results []Result) Summary {
+	summary := Summary{Cases: len(results)}
+	if len(results) == 0 {
+		return summary
+	}
+	var steps int
+	var latency time.Duration
+	for _, result := range results {
+		if result.Passed {
+			summary.Passed++
+		}
+		if result.Error != "" {
+			summary.Errors++
+		}
+		steps += result.Steps
+		summary.TotalTokens += result.TotalTokens
+		summary.TotalCostUSD += result.CostUSD
+		latency += result.Latency
+	}
+	summary.PassRate = float64(summary.Passed) / float64(len(results))
+	summary.AvgSteps = float64(steps) / float64(len(results))
+	summary.AvgLatency = latency / time.Duration(len(results))
+	return summary
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// LoadReport 读取之前 WriteJSON 写出的报告，用作对比基线。
+func LoadReport(path string) (*Report, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	if err := json.Unmarshal(raw, report); err != nil {
+		return nil, fmt.Errorf("decode report %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// Comparison 是当前报告相对基线的变化。Regressions 是基线通过而本次未通过的用例。
+type Comparison struct {
+	Baseline      string        `json:"baseline,omitempty"`
+	PassRateDelta float64       `json:"pass_rate_delta"`
+	AvgStepsDelta float64       `json:"avg_steps_delta"`
+	TokensDelta   int64         `json:"tokens_delta"`
+	CostDeltaUSD  float64       `json:"cost_delta_usd"`
+	LatencyDelta  time.Duration `json:"latency_delta"`
+	Regressions   []string      `json:"regressions,omitempty"`
+	Fixed         []string      `json:"fixed,omitempty"`
+	Added         []string      `json:"added,omitempty"`
+	Removed       []string      `json:"removed,omitempty"`
+}
+
+// Compare 按用例 ID 对齐两次评测，只有两边都存在的用例参与通过/失败变化的判断。
+func Compare(baseline *Report, current *Report) Comparison {
+	comparison := Comparison{
+		Baseline:      baseline.Label,
+		PassRateDelta: current.Summary.PassRate - baseline.Summary.PassRate,
+		AvgStepsDelta: current.Summary.AvgSteps - baseline.Summary.AvgSteps,
+		TokensDelta:   current.Summary.TotalTokens - baseline.Summary.TotalTokens,
+		CostDeltaUSD:  current.Summary.TotalCostUSD - baseline.Summary.TotalCostUSD,
+		LatencyDelta:  current.Summary.AvgLatency - baseline.Summary.AvgLatency,
+	}
+	before := make(map[string]bool, len(baseline.Results))
+	for _, result := range baseline.Results {
+		before[result.ID] = result.Passed
+	}
+	seen := make(map[string]struct{}, len(current.Results))
+	for _, result := range current.Results {
+		seen[result.ID] = struct{}{}
+		passed, ok := before[result.ID]
+		switch {
+		case !ok:
+			comparison.Added = append(comparison.Added, result.ID)
+		case passed && !result.Passed:
+			comparison.Regressions = append(comparison.Regressions, result.ID)
+		case !passed && result.Passed:
+			comparison.Fixed = append(comparison.Fixed, result.ID)
+		}
+	}
+	for _, result := range baseline.Results {
+		if _, ok := seen[result.ID]; !ok {
+			comparison.Removed = append(comparison.Removed, result.ID)
+		}
+	}
+	return comparison
+}
+
+func (c Comparison) HasRegressions() bool {
+	return len(c.Regressions) > 0
+}
+
+// WriteMarkdown 输出便于贴进 PR 或文档的报告；comparison 为 nil 时不输出对比小节。
+func (r *Report) WriteMarkdown(w io.Writer, comparison *Comparison) error {
+	var b strings.Builder
+	title := r.Suite
+	if r.Label != "" {
+		title += " (" + r.Label + ")"
+	}
+	fmt.Fprintf(&b, "# Eval: %s\n\n", title)
+	s := r.Summary
+	fmt.Fprintf(&b, "- Pass rate: %.1f%% (%d/%d, %d errors)\n", s.PassRate*100, s.Passed, s.Cases, s.Errors)
+	fmt.Fprintf(&b, "- Avg steps: %.2f\n", s.AvgSteps)
+	fmt.Fprintf(&b, "- Tokens: %d\n", s.TotalTokens)
+	fmt.Fprintf(&b, "- Cost: $%.6f\n", s.TotalCostUSD)
+	fmt.Fprintf(&b, "- Avg latency: %s\n\n", formatLatency(s.AvgLatency))
+
+	b.WriteString("| Case | Result | Steps | Tokens | Cost (USD) | Latency | Details |\n")
+	b.WriteString("| --- | --- | ---: | ---: | ---: | ---: | --- |\n")
+	for _, result := range r.Results {
+		fmt.Fprintf(&b, "| %s | %s | %d | %d | %.6f | %s | %s |\n",
+			markdownCell(result.ID), resultLabel(result), result.Steps, result.TotalTokens,
+			result.CostUSD, formatLatency(result.Latency), markdownCell(resultDetails(result)))
+	}
+
+	if comparison != nil {
+		baseline := comparison.Baseline
+		if baseline == "" {
+			baseline = "baseline"
+		}
+		fmt.Fprintf(&b, "\n## Compared with %s\n\n", baseline)
+		fmt.Fprintf(&b, "- Pass rate: %+.1f%%\n", comparison.PassRateDelta*100)
+		fmt.Fprintf(&b, "- Avg steps: %+.2f\n", comparison.AvgStepsDelta)
+		fmt.Fprintf(&b, "- Tokens: %+d\n", comparison.TokensDelta)
+		fmt.Fprintf(&b, "- Cost: %+.6f USD\n", comparison.CostDeltaUSD)
+		fmt.Fprintf(&b, "- Avg latency: %+dms\n", comparison.LatencyDelta.Milliseconds())
+		for _, group := range []struct {
+			name string
+			ids  []string
+		}{
+			{"Regressions", comparison.Regressions},
+			{"Fixed", comparison.Fixed},
+			{"Added", comparison.Added},
+			{"Removed", comparison.Removed},
+		} {
+			if len(group.ids) > 0 {
+				fmt.Fprintf(&b, "- %s: %s\n", group.name, strings.Join(group.ids, ", "))
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func resultLabel(result Result) string {
+	switch {
+	case result.Error != "":
+		return "ERROR"
+	case result.Passed:
+		return "PASS"
+	default:
+		return "FAIL"
+	}
+}
+
+func resultDetails(result Result) string {
+	if result.Error != "" {
+		return result.Error
+	}
+	var details []string
+	for _, check := range result.Checks {
+		switch {
+		case check.Skipped:
+			details = append(details, check.Name+" skipped")
+		case !check.Passed:
+			details = append(details, check.Name+": "+check.Detail)
+		}
+	}
+	return strings.Join(details, "; ")
+}
+
+func formatLatency(latency time.Duration) string {
+	return fmt.Sprintf("%dms", latency.Milliseconds())
+}
+
+func markdownCell(text string) string {
+	text = strings.ReplaceAll(text, "|", `\|`)
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,75 @@
+package eval
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareFlagsRegressionsAgainstSavedBaseline(t *testing.T) {
+	baseline := &Report{Suite: "smoke", Label: "v1", Results: []Result{
+		{ID: "a", Passed: true, TotalTokens: 100, CostUSD: 0.01, Steps: 2, Latency: time.Second},
+		{ID: "b", Passed: false, TotalTokens: 100, Steps: 4, Latency: time.Second},
+		{ID: "gone", Passed: true},
+	}}
+	baseline.Summary = summarize(baseline.Results)
+
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	var buf bytes.Buffer
+	if err := baseline.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadReport(path)
+	if err != nil {
+		t.Fatalf("LoadReport() error = %v", err)
+	}
+
+	current := &Report{Suite: "smoke", Label: "v2", Results: []Result{
+		{ID: "a", Passed: false, Checks: []CheckResult{{Name: CheckAnswer, Detail: `want "2", got "3"`}, {Name: CheckRubric, Skipped: true}}, TotalTokens: 150, Steps: 3, Latency: 2 * time.Second},
+		{ID: "b", Passed: true, TotalTokens: 100, Steps: 2, Latency: time.Second},
+		{ID: "new", Error: "new agent: boom"},
+	}}
+	current.Summary = summarize(current.Results)
+
+	comparison := Compare(loaded, current)
+	want := Comparison{
+		Baseline:      "v1",
+		PassRateDelta: current.Summary.PassRate - baseline.Summary.PassRate,
+		AvgStepsDelta: current.Summary.AvgSteps - baseline.Summary.AvgSteps,
+		TokensDelta:   50,
+		CostDeltaUSD:  -0.01,
+		LatencyDelta:  current.Summary.AvgLatency - baseline.Summary.AvgLatency,
+		Regressions:   []string{"a"},
+		Fixed:         []string{"b"},
+		Added:         []string{"new"},
+		Removed:       []string{"gone"},
+	}
+	if !reflect.DeepEqual(comparison, want) || !comparison.HasRegressions() {
+		t.Fatalf("Compare() = %#v, want %#v", comparison, want)
+	}
+
+	var markdown bytes.Buffer
+	if err := current.WriteMarkdown(&markdown, &comparison); err != nil {
+		t.Fatalf("WriteMarkdown() error = %v", err)
+	}
+	for _, line := range []string{
+		"# Eval: smoke (v2)",
+		"- Pass rate: 33.3% (1/3, 1 errors)",
+		`| a | FAIL | 3 | 150 | 0.000000 | 2000ms | answer: want "2", got "3"; rubric skipped |`,
+		"| new | ERROR | 0 | 0 | 0.000000 | 0ms | new agent: boom |",
+		"## Compared with v1",
+		"- Regressions: a",
+		"- Removed: gone",
+	} {
+		if !strings.Contains(markdown.String(), line) {
+			t.Fatalf("markdown missing %q:\n%s", line, markdown.String())
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"agent_study/internal/agent"
+	llmModel "agent_study/pkg/llm_core/model"
+)
+
+var ErrNoReplay = errors.New("case has no recorded responses")
+
+// CaseEnv 是为单个用例构造 Agent 所需的环境。LLM 只在用例带录制响应时非空，
+// 工厂应优先使用它，否则连接配置的真实模型。
+type CaseEnv struct {
+	Case      Case
+	Workspace string
+	LLM       llmModel.LlmClient
+}
+
+// AgentFactory 为每个用例创建独立的 Agent，保证记忆、费用与工作区互不干扰。
+type AgentFactory func(env CaseEnv) (*agent.Agent, error)
+
+// Runner 依次执行套件中的用例并生成报告。
+type Runner struct {
+	NewAgent AgentFactory
+	// Judge 为空时 rubric 检查记为跳过。
+	Judge *Judge
+	// ReplayOnly 要求所有用例都使用录制响应，没有录制的用例直接记为错误，
+	// 用于在 CI 中离线跑回归。
+	ReplayOnly bool
+	// Timeout 是单个用例的运行上限，0 表示不限制。
+	Timeout time.Duration
+	// Label 标识这次评测（例如模型名或 prompt 版本），写入报告便于对比。
+	Label string
+	// OnResult 在每个用例完成后调用，可用于打印进度。
+	OnResult func(Result)
+}
+
+func (r *Runner) Run(ctx context.Context, suite *Suite) (*Report, error) {
+	if r == nil || r.NewAgent == nil {
+		return nil, errors.New("eval runner requires an agent factory")
+	}
+	if suite == nil {
+		return nil, fmt.Errorf("%w: suite is nil", ErrInvalidSuite)
+	}
+
+	report := &Report{Suite: suite.Name, Label: r.Label, StartedAt: time.Now()}
+	for _, c := range suite.Cases {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result := r.runCase(ctx, c)
+		report.Results = append(report.Results, result)
+		if r.OnResult != nil {
+			r.OnResult(result)
+		}
+	}
+	report.Summary = summarize(report.Results)
+	return report, nil
+}
+
+func (r *Runner) runCase(ctx context.Context, c Case) Result {
+	result := Result{ID: c.ID}
+	fail := func(err error) Result {
+		result.Error = err.Error()
+		return result
+	}
+
+	workspace, err := os.MkdirTemp("", "agent-eval-*")
+	if err != nil {
+		return fail(fmt.Errorf("create workspace: %w", err))
+	}
+	defer os.RemoveAll(workspace)
+	if err := seedWorkspace(workspace, c.Workspace); err != nil {
+		return fail(err)
+	}
+
+	env := CaseEnv{Case: c, Workspace: workspace}
+	if len(c.Replay) > 0 {
+		env.LLM = NewReplayClient(c.Replay)
+	} else if r.ReplayOnly {
+		return fail(ErrNoReplay)
+	}
+	runner, err := r.NewAgent(env)
+	if err != nil {
+		return fail(fmt.Errorf("new agent: %w", err))
+	}
+
+	runCtx := ctx
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	// 运行出错时 Run 不返回 State，这里从 step 事件里保留已完成部分的汇总。
+	var stats agent.RunStats
+	callback := runner.StepCallback
+	runner.StepCallback = func(event agent.StepEvent) {
+		if event.Depth == 0 {
+			stats = event.Stats
+		}
+		if callback != nil {
+			callback(event)
+		}
+	}
+
+	started := time.Now()
+	state, runErr := runner.Run(runCtx, "", c.Task)
+	result.Latency = time.Since(started)
+
+	if state != nil {
+		result.Answer = state.FinalAnswer
+		result.StopReason = string(state.StopReason)
+		stats = state.Stats
+	}
+	result.Steps = stats.Steps
+	result.ToolCalls = stats.ToolCalls
+	usage, cost := stats.Usage, stats.Cost.TotalCostUSD
+	// CostTracker 额外包含计划、critic 等辅助调用，比 step 汇总更接近真实开销。
+	if runner.Cost != nil {
+		totals := runner.Cost.Totals()
+		usage, cost = totals.Usage, totals.Cost.TotalCostUSD
+	}
+	result.PromptTokens = usage.PromptTokens
+	result.CompletionTokens = usage.CompletionTokens
+	result.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	result.CostUSD = cost
+	if runErr != nil {
+		return fail(runErr)
+	}
+
+	result.Checks = r.checkCase(ctx, c, state, workspace)
+	result.Passed = true
+	for _, check := range result.Checks {
+		if !check.Passed && !check.Skipped {
+			result.Passed = false
+		}
+	}
+	return result
+}
+
+func seedWorkspace(root string, files map[string]string) error {
+	for path, content := range files {
+		resolved, err := workspacePath(root, path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(resolved), 0o755); err != nil {
+			return fmt.Errorf("seed workspace: %w", err)
+		}
+		if err := os.WriteFile(resolved, []byte(content), 0o644); err != nil {
+			return fmt.Errorf("seed workspace: %w", err)
+		}
+	}
+	return nil
+}
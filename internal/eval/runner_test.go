@@ -0,0 +1,116 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"agent_study/internal/agent"
+	llmModel "agent_study/pkg/llm_core/model"
+	"agent_study/pkg/tools"
+)
+
+type fakeJudgeClient struct {
+	content  string
+	requests []llmModel.ChatRequest
+}
+
+func (f *fakeJudgeClient) Chat(ctx context.Context, req llmModel.ChatRequest) (llmModel.ChatResponse, error) {
+	f.requests = append(f.requests, req)
+	return llmModel.ChatResponse{Content: f.content}, nil
+}
+
+func (f *fakeJudgeClient) ChatStream(ctx context.Context, req llmModel.ChatRequest) (llmModel.Stream, error) {
+	return nil, errors.New("not implemented")
+}
+
+func newWorkspaceAgent(env CaseEnv) (*agent.Agent, error) {
+	builtin, err := tools.NewBuiltinTools(tools.BuiltinOptions{RootDir: env.Workspace})
+	if err != nil {
+		return nil, err
+	}
+	registry := tools.NewRegistry()
+	if err := registry.Register(builtin...); err != nil {
+		return nil, err
+	}
+	return agent.NewAgent(agent.NewAgentOptions{LLM: env.LLM, Model: "replay", Tools: registry})
+}
+
+func TestRunnerChecksExpectationsAgainstReplayedRuns(t *testing.T) {
+	suite := &Suite{Name: "files", Cases: []Case{
+		{
+			ID:        "copy",
+			Task:      "把 in.txt 的内容写进 out.txt",
+			Workspace: map[string]string{"in.txt": "hello"},
+			Expect: Expect{
+				Answer: "已完成",
+				Files:  map[string]string{"out.txt": "hello"},
+				Tools:  []string{"read_file", "write_file"},
+				Rubric: "回答需要确认已完成",
+			},
+			Replay: []ReplayResponse{
+				{ToolCalls: []ReplayToolCall{{Name: "read_file", Arguments: `{"path":"in.txt"}`}}, Usage: ReplayUsage{PromptTokens: 100, CompletionTokens: 10}},
+				{ToolCalls: []ReplayToolCall{{Name: "write_file", Arguments: `{"path":"out.txt","content":"hello\n"}`}}, Usage: ReplayUsage{PromptTokens: 120, CompletionTokens: 12}},
+				{Content: "已完成", Usage: ReplayUsage{PromptTokens: 140, CompletionTokens: 3}},
+			},
+		},
+		{
+			ID:     "wrong",
+			Task:   "1+1 等于几",
+			Expect: Expect{Regex: `^2$`, Tools: []string{"exec"}},
+			Replay: []ReplayResponse{{Content: "3"}},
+		},
+		{ID: "live", Task: "需要真实模型"},
+	}}
+	judge := &fakeJudgeClient{content: `<think>看起来没问题</think>{"pass": true, "reason": "确认完成"}`}
+	var progress []string
+	runner := &Runner{
+		NewAgent:   newWorkspaceAgent,
+		Judge:      &Judge{LLM: judge, Model: "judge-model"},
+		ReplayOnly: true,
+		Label:      "baseline",
+		OnResult:   func(result Result) { progress = append(progress, result.ID) },
+	}
+
+	report, err := runner.Run(context.Background(), suite)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	copied, wrong, live := report.Results[0], report.Results[1], report.Results[2]
+	if !copied.Passed || len(copied.Checks) != 4 || copied.Steps != 3 || copied.ToolCalls != 2 || copied.TotalTokens != 385 {
+		t.Fatalf("copy result = %#v, want all checks passed with replayed usage", copied)
+	}
+	if judge.requests[0].Model != "judge-model" || !strings.Contains(judge.requests[0].Messages[1].Content, "回答需要确认已完成") {
+		t.Fatalf("judge request = %#v, want rubric sent to judge model", judge.requests[0])
+	}
+	if wrong.Passed || wrong.Checks[0].Passed || wrong.Checks[1].Detail != "not called: exec" {
+		t.Fatalf("wrong result = %#v, want regex and tool checks failed", wrong)
+	}
+	if live.Passed || live.Error != ErrNoReplay.Error() {
+		t.Fatalf("live result = %#v, want replay-only error", live)
+	}
+	if report.Summary.Cases != 3 || report.Summary.Passed != 1 || report.Summary.Errors != 1 || report.Summary.TotalTokens != 385 {
+		t.Fatalf("summary = %#v, want one pass and one error", report.Summary)
+	}
+	if strings.Join(progress, ",") != "copy,wrong,live" {
+		t.Fatalf("progress = %v, want results reported in suite order", progress)
+	}
+}
+
+func TestRunnerSkipsRubricWithoutJudgeAndReportsReplayExhaustion(t *testing.T) {
+	suite := &Suite{Name: "judge", Cases: []Case{
+		{ID: "rubric", Task: "介绍巴黎", Expect: Expect{Rubric: "提到埃菲尔铁塔"}, Replay: []ReplayResponse{{Content: "巴黎有埃菲尔铁塔"}}},
+		{ID: "short", Task: "读文件", Replay: []ReplayResponse{{ToolCalls: []ReplayToolCall{{Name: "ls", Arguments: `{}`}}}}},
+	}}
+	report, err := (&Runner{NewAgent: newWorkspaceAgent}).Run(context.Background(), suite)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if rubric := report.Results[0]; !rubric.Passed || !rubric.Checks[0].Skipped {
+		t.Fatalf("rubric result = %#v, want skipped rubric not to fail the case", rubric)
+	}
+	if short := report.Results[1]; short.Passed || !strings.Contains(short.Error, ErrReplayExhausted.Error()) || short.Steps != 1 {
+		t.Fatalf("short result = %#v, want replay exhaustion error with partial metrics", short)
+	}
+}
@@ -0,0 +1,154 @@
+package eval
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidSuite = errors.New("invalid eval suite")
+
+// Suite 是一组评测用例。YAML 文件整体描述一个 Suite；JSONL 文件每行一个 Case，
+// 套件名取文件名。
+type Suite struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Cases       []Case `yaml:"cases"`
+}
+
+// Case 是单个评测任务。Workspace 中的文件会在运行前写入该用例独享的临时目录，
+// 内置文件工具以该目录为根；Replay 非空时用录制的模型响应代替真实 LLM。
+type Case struct {
+	ID        string            `yaml:"id"`
+	Task      string            `yaml:"task"`
+	Workspace map[string]string `yaml:"workspace"`
+	Expect    Expect            `yaml:"expect"`
+	Replay    []ReplayResponse  `yaml:"replay"`
+}
+
+// Expect 描述用例的期望结果，所有非空字段都必须满足用例才算通过。
+type Expect struct {
+	// Answer 与最终回答去掉首尾空白后精确比较。
+	Answer string `yaml:"answer"`
+	// Regex 需要能在最终回答中匹配到。
+	Regex string `yaml:"regex"`
+	// Files 是运行结束后工作区里必须存在的文件及其内容（去掉首尾空白后比较）。
+	Files map[string]string `yaml:"files"`
+	// Tools 是运行中至少要调用一次的工具名。
+	Tools []string `yaml:"tools"`
+	// Rubric 交给评审模型判断最终回答是否达标。
+	Rubric string `yaml:"rubric"`
+}
+
+// LoadSuite 按扩展名读取 .yaml/.yml 或 .jsonl 套件并校验用例。
+func LoadSuite(path string) (*Suite, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	var suite *Suite
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		suite, err = ParseSuiteYAML(raw)
+	case ".jsonl":
+		suite, err = ParseSuiteJSONL(raw)
+	default:
+		return nil, fmt.Errorf("%w: unsupported suite file %s", ErrInvalidSuite, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if suite.Name == "" {
+		suite.Name = name
+	}
+	return suite, nil
+}
+
+func ParseSuiteYAML(raw []byte) (*Suite, error) {
+	suite := &Suite{}
+	if err := yaml.Unmarshal(raw, suite); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSuite, err)
+	}
+	if err := suite.Validate(); err != nil {
+		return nil, err
+	}
+	return suite, nil
+}
+
+// ParseSuiteJSONL 逐行解析用例；JSON 是 YAML 的子集，因此两种格式共用同一组字段名。
+func ParseSuiteJSONL(raw []byte) (*Suite, error) {
+	suite := &Suite{}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var c Case
+		if err := yaml.Unmarshal([]byte(text), &c); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidSuite, line, err)
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := suite.Validate(); err != nil {
+		return nil, err
+	}
+	return suite, nil
+}
+
+// Validate 检查用例 ID 唯一、任务非空、正则可编译，并拒绝逃出工作区的文件路径。
+func (s *Suite) Validate() error {
+	if len(s.Cases) == 0 {
+		return fmt.Errorf("%w: no cases", ErrInvalidSuite)
+	}
+	seen := make(map[string]struct{}, len(s.Cases))
+	for i, c := range s.Cases {
+		if strings.TrimSpace(c.ID) == "" {
+			return fmt.Errorf("%w: case %d has no id", ErrInvalidSuite, i+1)
+		}
+		if _, ok := seen[c.ID]; ok {
+			return fmt.Errorf("%w: duplicate case id %q", ErrInvalidSuite, c.ID)
+		}
+		seen[c.ID] = struct{}{}
+		if strings.TrimSpace(c.Task) == "" {
+			return fmt.Errorf("%w: case %q has no task", ErrInvalidSuite, c.ID)
+		}
+		if c.Expect.Regex != "" {
+			if _, err := regexp.Compile(c.Expect.Regex); err != nil {
+				return fmt.Errorf("%w: case %q regex: %v", ErrInvalidSuite, c.ID, err)
+			}
+		}
+		for _, files := range []map[string]string{c.Workspace, c.Expect.Files} {
+			for path := range files {
+				if _, err := workspacePath("", path); err != nil {
+					return fmt.Errorf("%w: case %q: %v", ErrInvalidSuite, c.ID, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// workspacePath 把用例里的相对路径解析到工作区内。
+func workspacePath(root string, path string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(path))
+	if path == "" || filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file path %q must stay inside the workspace", path)
+	}
+	return filepath.Join(root, cleaned), nil
+}
@@ -0,0 +1,73 @@
+package eval
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSuiteReadsYAMLAndJSONL(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "smoke.yaml")
+	yamlSuite := `
+cases:
+  - id: greet
+    task: 打个招呼
+    workspace:
+      notes/todo.md: "- buy milk"
+    expect:
+      regex: "你好"
+      tools: [read_file]
+    replay:
+      - toolCalls:
+          - name: read_file
+            arguments: '{"path":"notes/todo.md"}'
+      - content: 你好
+        usage: {promptTokens: 10, completionTokens: 2}
+`
+	if err := os.WriteFile(yamlPath, []byte(yamlSuite), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	suite, err := LoadSuite(yamlPath)
+	if err != nil {
+		t.Fatalf("LoadSuite(yaml) error = %v", err)
+	}
+	c := suite.Cases[0]
+	if suite.Name != "smoke" || c.Workspace["notes/todo.md"] != "- buy milk" || c.Expect.Tools[0] != "read_file" {
+		t.Fatalf("suite = %#v, want name from file and case fields", suite)
+	}
+	if len(c.Replay) != 2 || c.Replay[0].ToolCalls[0].Arguments != `{"path":"notes/todo.md"}` || c.Replay[1].Usage.CompletionTokens != 2 {
+		t.Fatalf("replay = %#v, want recorded responses", c.Replay)
+	}
+
+	jsonlPath := filepath.Join(dir, "regression.jsonl")
+	lines := `{"id":"a","task":"1+1","expect":{"answer":"2"}}
+
+{"id":"b","task":"写文件","expect":{"files":{"out.txt":"ok"}}}
+`
+	if err := os.WriteFile(jsonlPath, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	suite, err = LoadSuite(jsonlPath)
+	if err != nil {
+		t.Fatalf("LoadSuite(jsonl) error = %v", err)
+	}
+	if suite.Name != "regression" || len(suite.Cases) != 2 || suite.Cases[0].Expect.Answer != "2" || suite.Cases[1].Expect.Files["out.txt"] != "ok" {
+		t.Fatalf("suite = %#v, want two cases skipping blank lines", suite)
+	}
+}
+
+func TestParseSuiteRejectsInvalidCases(t *testing.T) {
+	for name, raw := range map[string]string{
+		"duplicate id": "cases:\n  - {id: a, task: x}\n  - {id: a, task: y}\n",
+		"missing task": "cases:\n  - {id: a}\n",
+		"bad regex":    "cases:\n  - {id: a, task: x, expect: {regex: '('}}\n",
+		"escaping":     "cases:\n  - {id: a, task: x, workspace: {'../etc/passwd': x}}\n",
+		"empty":        "name: empty\n",
+	} {
+		if _, err := ParseSuiteYAML([]byte(raw)); !errors.Is(err, ErrInvalidSuite) {
+			t.Fatalf("%s: ParseSuiteYAML() error = %v, want ErrInvalidSuite", name, err)
+		}
+	}
+}
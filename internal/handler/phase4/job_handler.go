@@ -0,0 +1,288 @@
+package phase4handler
+
+import (
+	"agent_study/internal/job"
+	"agent_study/internal/resp"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobRegister 返回注册后台任务 API 的 Register：投递、查询、取消、重试任务，以及定时任务的增删改查与立即执行。
+func JobRegister(queue *job.Queue) func(apiGroup *gin.RouterGroup) {
+	return func(apiGroup *gin.RouterGroup) {
+		resp.HandlerWrapper(apiGroup, "jobs",
+			[]*resp.Handler{
+				resp.NewJsonHandler(handleEnqueueJob(queue)),
+				resp.NewJsonHandler(handleListJobs(queue)),
+				resp.NewJsonHandler(handleGetJob(queue)),
+				resp.NewJsonHandler(handleCancelJob(queue)),
+				resp.NewJsonHandler(handleRetryJob(queue)),
+			})
+		resp.HandlerWrapper(apiGroup, "schedules",
+			[]*resp.Handler{
+				resp.NewJsonHandler(handleCreateSchedule(queue)),
+				resp.NewJsonHandler(handleListSchedules(queue)),
+				resp.NewJsonHandler(handleGetSchedule(queue)),
+				resp.NewJsonHandler(handleUpdateSchedule(queue)),
+				resp.NewJsonHandler(handleDeleteSchedule(queue)),
+				resp.NewJsonHandler(handleTriggerSchedule(queue)),
+			})
+	}
+}
+
+// EnqueueJobReq 投递任务请求参数
+type EnqueueJobReq struct {
+	Name        string     `json:"name"`                    // 任务名称，为空时取任务描述的前缀
+	Username    string     `json:"username"`                // 用户名，为空时归属默认用户
+	Task        string     `json:"task" binding:"required"` // 任务描述
+	BudgetUSD   float64    `json:"budget_usd"`              // 单次任务预算(美元)，0 表示沿用 agent 配置
+	MaxSteps    int        `json:"max_steps"`               // 最大步数，0 表示沿用 agent 配置
+	MaxAttempts int        `json:"max_attempts"`            // 含首次在内的最大执行次数，默认3
+	RunAt       *time.Time `json:"run_at"`                  // 最早执行时间(RFC3339)，为空时立即执行
+}
+
+// CreateScheduleReq 创建定时任务请求参数
+type CreateScheduleReq struct {
+	Name        string  `json:"name" binding:"required"` // 定时任务名称，唯一
+	Cron        string  `json:"cron" binding:"required"` // cron表达式：分 时 日 月 周，支持 @daily 等简写
+	Username    string  `json:"username"`                // 用户名，为空时归属默认用户
+	Task        string  `json:"task" binding:"required"` // 任务描述
+	BudgetUSD   float64 `json:"budget_usd"`              // 单次任务预算(美元)，0 表示沿用 agent 配置
+	MaxSteps    int     `json:"max_steps"`               // 最大步数，0 表示沿用 agent 配置
+	MaxAttempts int     `json:"max_attempts"`            // 含首次在内的最大执行次数，默认3
+	Enabled     *bool   `json:"enabled"`                 // 是否启用，默认启用
+}
+
+// handleEnqueueJob
+//
+//	@Summary		投递任务
+//	@Description	把 agent 任务放入持久化队列，由后台 worker 执行，失败时按退避策略重试
+//	@Tags			jobs
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		EnqueueJobReq	true	"任务参数"
+//	@Router			/jobs [post]
+//	@Success		200	{object}	resp.Result{data=job.Job}
+func handleEnqueueJob(queue *job.Queue) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPost, "", func(c *gin.Context) (any, error) {
+			var req EnqueueJobReq
+			if err := c.ShouldBindJSON(&req); err != nil {
+				return nil, err
+			}
+			return queue.Enqueue(c.Request.Context(), job.JobSpec{
+				Name:        req.Name,
+				Username:    req.Username,
+				Task:        req.Task,
+				BudgetUSD:   req.BudgetUSD,
+				MaxSteps:    req.MaxSteps,
+				MaxAttempts: req.MaxAttempts,
+				RunAt:       req.RunAt,
+			})
+		}, nil
+	}
+}
+
+// handleListJobs
+//
+//	@Summary		获取任务列表
+//	@Description	按创建时间倒序列出任务，可按用户、状态、定时任务过滤
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			username	query		string	false	"只看指定用户"
+//	@Param			status		query		string	false	"pending、running、succeeded、failed、cancelled"
+//	@Param			schedule_id	query		string	false	"只看指定定时任务投递的任务"
+//	@Param			limit		query		int		false	"最多返回条数，默认50"
+//	@Router			/jobs [get]
+//	@Success		200	{object}	resp.Result{data=[]job.Job}
+func handleListJobs(queue *job.Queue) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodGet, "", func(c *gin.Context) (any, error) {
+			limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+			return queue.Store().List(c.Request.Context(), job.ListFilter{
+				Username:   c.Query("username"),
+				Status:     job.Status(c.Query("status")),
+				ScheduleID: c.Query("schedule_id"),
+				Limit:      limit,
+			})
+		}, nil
+	}
+}
+
+// handleGetJob
+//
+//	@Summary		获取任务详情
+//	@Description	返回任务状态、执行次数与结果
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			id	path		string	true	"任务ID"
+//	@Router			/jobs/{id} [get]
+//	@Success		200	{object}	resp.Result{data=job.Job}
+func handleGetJob(queue *job.Queue) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodGet, "/:id", func(c *gin.Context) (any, error) {
+			return queue.Store().Get(c.Request.Context(), c.Param("id"))
+		}, nil
+	}
+}
+
+// handleCancelJob
+//
+//	@Summary		取消任务
+//	@Description	取消排队或执行中的任务，执行中的任务会被中断
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			id	path		string	true	"任务ID"
+//	@Router			/jobs/{id}/cancel [post]
+//	@Success		200	{object}	resp.Result{data=job.Job}
+func handleCancelJob(queue *job.Queue) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPost, "/:id/cancel", func(c *gin.Context) (any, error) {
+			return queue.Cancel(c.Request.Context(), c.Param("id"))
+		}, nil
+	}
+}
+
+// handleRetryJob
+//
+//	@Summary		重试任务
+//	@Description	把失败或已取消的任务重新放回队列，配置了检查点时从中断处继续
+//	@Tags			jobs
+//	@Produce		json
+//	@Param			id	path		string	true	"任务ID"
+//	@Router			/jobs/{id}/retry [post]
+//	@Success		200	{object}	resp.Result{data=job.Job}
+func handleRetryJob(queue *job.Queue) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPost, "/:id/retry", func(c *gin.Context) (any, error) {
+			return queue.Retry(c.Request.Context(), c.Param("id"))
+		}, nil
+	}
+}
+
+// handleCreateSchedule
+//
+//	@Summary		创建定时任务
+//	@Description	按 cron 表达式（服务所在时区）周期性投递任务
+//	@Tags			schedules
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		CreateScheduleReq	true	"定时任务参数"
+//	@Router			/schedules [post]
+//	@Success		200	{object}	resp.Result{data=job.ScheduleView}
+func handleCreateSchedule(queue *job.Queue) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPost, "", func(c *gin.Context) (any, error) {
+			var req CreateScheduleReq
+			if err := c.ShouldBindJSON(&req); err != nil {
+				return nil, err
+			}
+			return queue.Store().CreateSchedule(c.Request.Context(), job.ScheduleSpec{
+				Name:        req.Name,
+				Cron:        req.Cron,
+				Username:    req.Username,
+				Task:        req.Task,
+				BudgetUSD:   req.BudgetUSD,
+				MaxSteps:    req.MaxSteps,
+				MaxAttempts: req.MaxAttempts,
+				Enabled:     req.Enabled,
+			})
+		}, nil
+	}
+}
+
+// handleListSchedules
+//
+//	@Summary		获取定时任务列表
+//	@Description	按名称列出所有定时任务及下次投递时间
+//	@Tags			schedules
+//	@Produce		json
+//	@Router			/schedules [get]
+//	@Success		200	{object}	resp.Result{data=[]job.ScheduleView}
+func handleListSchedules(queue *job.Queue) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodGet, "", func(c *gin.Context) (any, error) {
+			return queue.Store().ListSchedules(c.Request.Context())
+		}, nil
+	}
+}
+
+// handleGetSchedule
+//
+//	@Summary		获取定时任务详情
+//	@Description	返回定时任务配置与最近一次投递
+//	@Tags			schedules
+//	@Produce		json
+//	@Param			id	path		string	true	"定时任务ID"
+//	@Router			/schedules/{id} [get]
+//	@Success		200	{object}	resp.Result{data=job.ScheduleView}
+func handleGetSchedule(queue *job.Queue) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodGet, "/:id", func(c *gin.Context) (any, error) {
+			return queue.Store().GetSchedule(c.Request.Context(), c.Param("id"))
+		}, nil
+	}
+}
+
+// handleUpdateSchedule
+//
+//	@Summary		修改定时任务
+//	@Description	部分修改定时任务，未提供的字段保持不变；修改 cron 或启停时重新计算下次投递时间
+//	@Tags			schedules
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string				true	"定时任务ID"
+//	@Param			body	body		job.SchedulePatch	true	"修改内容"
+//	@Router			/schedules/{id} [put]
+//	@Success		200	{object}	resp.Result{data=job.ScheduleView}
+func handleUpdateSchedule(queue *job.Queue) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPut, "/:id", func(c *gin.Context) (any, error) {
+			var patch job.SchedulePatch
+			if err := c.ShouldBindJSON(&patch); err != nil {
+				return nil, err
+			}
+			return queue.Store().UpdateSchedule(c.Request.Context(), c.Param("id"), patch)
+		}, nil
+	}
+}
+
+// handleDeleteSchedule
+//
+//	@Summary		删除定时任务
+//	@Description	删除定时任务，已经投递的任务不受影响
+//	@Tags			schedules
+//	@Produce		json
+//	@Param			id	path		string	true	"定时任务ID"
+//	@Router			/schedules/{id} [delete]
+//	@Success		200	{object}	resp.Result{data=CountResp}
+func handleDeleteSchedule(queue *job.Queue) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodDelete, "/:id", func(c *gin.Context) (any, error) {
+			if err := queue.Store().DeleteSchedule(c.Request.Context(), c.Param("id")); err != nil {
+				return nil, err
+			}
+			return CountResp{Count: 1}, nil
+		}, nil
+	}
+}
+
+// handleTriggerSchedule
+//
+//	@Summary		立即执行定时任务
+//	@Description	按定时任务的配置立即投递一次任务，不影响下次定时投递
+//	@Tags			schedules
+//	@Produce		json
+//	@Param			id	path		string	true	"定时任务ID"
+//	@Router			/schedules/{id}/trigger [post]
+//	@Success		200	{object}	resp.Result{data=job.Job}
+func handleTriggerSchedule(queue *job.Queue) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPost, "/:id/trigger", func(c *gin.Context) (any, error) {
+			return queue.TriggerSchedule(c.Request.Context(), c.Param("id"))
+		}, nil
+	}
+}
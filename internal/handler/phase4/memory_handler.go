@@ -0,0 +1,338 @@
+package phase4handler
+
+import (
+	"agent_study/internal/agent"
+	"agent_study/internal/resp"
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MemoryRegister 返回注册长期记忆管理 API 的 Register，所有接口都作用于路径中的用户。
+func MemoryRegister(memory *agent.MemoryManager) func(apiGroup *gin.RouterGroup) {
+	return func(apiGroup *gin.RouterGroup) {
+		resp.HandlerWrapper(apiGroup, "memory",
+			[]*resp.Handler{
+				resp.NewJsonHandler(handleListMemoryItems(memory)),
+				resp.NewJsonHandler(handleAddMemoryItem(memory)),
+				resp.NewJsonHandler(handleUpdateMemoryItem(memory)),
+				resp.NewJsonHandler(handleDeleteMemoryItem(memory)),
+				resp.NewJsonHandler(handleGetSummary(memory)),
+				resp.NewJsonHandler(handleSetSummary(memory)),
+				resp.NewJsonHandler(handleForget(memory)),
+				resp.NewJsonHandler(handleClearMemories(memory)),
+				resp.NewJsonHandler(handleExportMemories(memory)),
+				resp.NewJsonHandler(handleImportMemories(memory)),
+				resp.NewJsonHandler(handleListMemoryAudits(memory)),
+			})
+	}
+}
+
+// AddMemoryItemReq 新增记忆请求参数
+type AddMemoryItemReq struct {
+	Text       string  `json:"text" binding:"required"` // 记忆内容
+	Type       string  `json:"type"`                    // 记忆类型：preference/fact/project，默认fact
+	Importance float64 `json:"importance"`              // 重要度(0-1)，默认0.5
+}
+
+// SummaryReq 覆盖长期摘要请求参数
+type SummaryReq struct {
+	Summary string `json:"summary"` // 新摘要，为空表示清空
+}
+
+// ForgetReq 忘记记忆请求参数
+type ForgetReq struct {
+	Query string `json:"query" binding:"required"` // 要忘记内容的关键词
+}
+
+// SummaryResp 长期摘要响应
+type SummaryResp struct {
+	Summary string `json:"summary"` // 长期摘要
+}
+
+// CountResp 受影响条数响应
+type CountResp struct {
+	Count int `json:"count"` // 受影响的记忆条数
+}
+
+// userContext 为请求附加“用户直接操作”的审计来源。
+func userContext(c *gin.Context) context.Context {
+	return agent.WithMemoryAuditSource(c.Request.Context(), agent.MemoryAuditSource{Actor: agent.MemoryActorUser})
+}
+
+func parseItemID(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+// handleListMemoryItems
+//
+//	@Summary		获取记忆列表
+//	@Description	列出用户的记忆条目；带q时按相关度检索，不会影响记忆的使用统计
+//	@Tags			memory
+//	@Produce		json
+//	@Param			username	path		string	true	"用户名"
+//	@Param			q			query		string	false	"检索关键词"
+//	@Param			limit		query		int		false	"最多返回条数，默认不限制"
+//	@Router			/memory/{username}/items [get]
+//	@Success		200	{object}	resp.Result{data=[]agent.MemoryItem}
+func handleListMemoryItems(memory *agent.MemoryManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodGet, "/:username/items", func(c *gin.Context) (any, error) {
+			limit, _ := strconv.Atoi(c.DefaultQuery("limit", "0"))
+			return memory.SearchMemoryItems(c.Request.Context(), c.Param("username"), c.Query("q"), limit)
+		}, nil
+	}
+}
+
+// handleAddMemoryItem
+//
+//	@Summary		新增记忆
+//	@Description	为用户新增一条记忆，与已有记忆文本相同时合并
+//	@Tags			memory
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string				true	"用户名"
+//	@Param			body		body		AddMemoryItemReq	true	"记忆内容"
+//	@Router			/memory/{username}/items [post]
+//	@Success		200	{object}	resp.Result{data=agent.MemoryItem}
+func handleAddMemoryItem(memory *agent.MemoryManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPost, "/:username/items", func(c *gin.Context) (any, error) {
+			var req AddMemoryItemReq
+			if err := c.ShouldBindJSON(&req); err != nil {
+				return nil, err
+			}
+			return memory.AddMemoryItem(userContext(c), c.Param("username"), agent.MemoryItem{
+				Text:       req.Text,
+				Type:       req.Type,
+				Importance: req.Importance,
+			})
+		}, nil
+	}
+}
+
+// handleUpdateMemoryItem
+//
+//	@Summary		修改记忆
+//	@Description	部分修改一条记忆，未提供的字段保持不变
+//	@Tags			memory
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string				true	"用户名"
+//	@Param			id			path		int					true	"记忆ID"
+//	@Param			body		body		agent.MemoryItemPatch	true	"修改内容"
+//	@Router			/memory/{username}/items/{id} [put]
+//	@Success		200	{object}	resp.Result{data=agent.MemoryItem}
+func handleUpdateMemoryItem(memory *agent.MemoryManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPut, "/:username/items/:id", func(c *gin.Context) (any, error) {
+			id, err := parseItemID(c)
+			if err != nil {
+				return nil, err
+			}
+			var patch agent.MemoryItemPatch
+			if err := c.ShouldBindJSON(&patch); err != nil {
+				return nil, err
+			}
+			return memory.UpdateMemoryItem(userContext(c), c.Param("username"), id, patch)
+		}, nil
+	}
+}
+
+// handleDeleteMemoryItem
+//
+//	@Summary		删除记忆
+//	@Description	删除用户的一条记忆
+//	@Tags			memory
+//	@Produce		json
+//	@Param			username	path		string	true	"用户名"
+//	@Param			id			path		int		true	"记忆ID"
+//	@Router			/memory/{username}/items/{id} [delete]
+//	@Success		200	{object}	resp.Result{data=CountResp}
+func handleDeleteMemoryItem(memory *agent.MemoryManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodDelete, "/:username/items/:id", func(c *gin.Context) (any, error) {
+			id, err := parseItemID(c)
+			if err != nil {
+				return nil, err
+			}
+			count, err := memory.ForgetMemoryItems(userContext(c), c.Param("username"), id)
+			if err != nil {
+				return nil, err
+			}
+			return CountResp{Count: count}, nil
+		}, nil
+	}
+}
+
+// handleGetSummary
+//
+//	@Summary		获取长期摘要
+//	@Description	获取用户的长期记忆摘要
+//	@Tags			memory
+//	@Produce		json
+//	@Param			username	path		string	true	"用户名"
+//	@Router			/memory/{username}/summary [get]
+//	@Success		200	{object}	resp.Result{data=SummaryResp}
+func handleGetSummary(memory *agent.MemoryManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodGet, "/:username/summary", func(c *gin.Context) (any, error) {
+			summary, err := memory.LongTermSummaryFor(c.Request.Context(), c.Param("username"))
+			if err != nil {
+				return nil, err
+			}
+			return SummaryResp{Summary: summary}, nil
+		}, nil
+	}
+}
+
+// handleSetSummary
+//
+//	@Summary		覆盖长期摘要
+//	@Description	直接覆盖用户的长期记忆摘要，为空表示清空
+//	@Tags			memory
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string		true	"用户名"
+//	@Param			body		body		SummaryReq	true	"新摘要"
+//	@Router			/memory/{username}/summary [put]
+//	@Success		200	{object}	resp.Result{data=SummaryResp}
+func handleSetSummary(memory *agent.MemoryManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPut, "/:username/summary", func(c *gin.Context) (any, error) {
+			var req SummaryReq
+			if err := c.ShouldBindJSON(&req); err != nil {
+				return nil, err
+			}
+			if err := memory.SetLongTermSummary(userContext(c), c.Param("username"), req.Summary); err != nil {
+				return nil, err
+			}
+			return SummaryResp{Summary: req.Summary}, nil
+		}, nil
+	}
+}
+
+// handleForget
+//
+//	@Summary		忘记记忆
+//	@Description	删除与关键词匹配的记忆条目和摘要行
+//	@Tags			memory
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string		true	"用户名"
+//	@Param			body		body		ForgetReq	true	"要忘记的内容"
+//	@Router			/memory/{username}/forget [post]
+//	@Success		200	{object}	resp.Result{data=agent.ForgetResult}
+func handleForget(memory *agent.MemoryManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPost, "/:username/forget", func(c *gin.Context) (any, error) {
+			var req ForgetReq
+			if err := c.ShouldBindJSON(&req); err != nil {
+				return nil, err
+			}
+			return memory.ForgetMemories(userContext(c), c.Param("username"), req.Query)
+		}, nil
+	}
+}
+
+// handleClearMemories
+//
+//	@Summary		清空记忆
+//	@Description	清空用户的全部长期记忆（摘要与记忆条目）
+//	@Tags			memory
+//	@Produce		json
+//	@Param			username	path		string	true	"用户名"
+//	@Router			/memory/{username} [delete]
+//	@Success		200	{object}	resp.Result{data=CountResp}
+func handleClearMemories(memory *agent.MemoryManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodDelete, "/:username", func(c *gin.Context) (any, error) {
+			count, err := memory.ClearMemories(userContext(c), c.Param("username"))
+			if err != nil {
+				return nil, err
+			}
+			return CountResp{Count: count}, nil
+		}, nil
+	}
+}
+
+// handleExportMemories
+//
+//	@Summary		导出记忆
+//	@Description	导出用户的长期摘要与全部记忆条目
+//	@Tags			memory
+//	@Produce		json
+//	@Param			username	path		string	true	"用户名"
+//	@Router			/memory/{username}/export [get]
+//	@Success		200	{object}	resp.Result{data=agent.MemoryExport}
+func handleExportMemories(memory *agent.MemoryManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodGet, "/:username/export", func(c *gin.Context) (any, error) {
+			return memory.ExportMemories(c.Request.Context(), c.Param("username"))
+		}, nil
+	}
+}
+
+// handleImportMemories
+//
+//	@Summary		导入记忆
+//	@Description	把导出的快照导入到用户名下；replace=true 时先清空已有记忆
+//	@Tags			memory
+//	@Accept			json
+//	@Produce		json
+//	@Param			username	path		string				true	"用户名"
+//	@Param			replace		query		bool				false	"是否替换已有记忆"
+//	@Param			body		body		agent.MemoryExport	true	"记忆快照"
+//	@Router			/memory/{username}/import [post]
+//	@Success		200	{object}	resp.Result{data=CountResp}
+func handleImportMemories(memory *agent.MemoryManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPost, "/:username/import", func(c *gin.Context) (any, error) {
+			var export agent.MemoryExport
+			if err := c.ShouldBindJSON(&export); err != nil {
+				return nil, err
+			}
+			replace, _ := strconv.ParseBool(c.DefaultQuery("replace", "false"))
+			count, err := memory.ImportMemories(userContext(c), c.Param("username"), export, replace)
+			if err != nil {
+				return nil, err
+			}
+			return CountResp{Count: count}, nil
+		}, nil
+	}
+}
+
+// handleListMemoryAudits
+//
+//	@Summary		获取记忆变更记录
+//	@Description	按时间倒序返回记忆变更记录，包含触发变更的运行ID
+//	@Tags			memory
+//	@Produce		json
+//	@Param			username	path		string	true	"用户名"
+//	@Param			item_id		query		int		false	"只看指定记忆，0表示长期摘要"
+//	@Param			limit		query		int		false	"最多返回条数，默认50"
+//	@Router			/memory/{username}/audits [get]
+//	@Success		200	{object}	resp.Result{data=[]agent.MemoryAuditEntry}
+func handleListMemoryAudits(memory *agent.MemoryManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodGet, "/:username/audits", func(c *gin.Context) (any, error) {
+			limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+			var itemID *uint
+			if raw := c.Query("item_id"); raw != "" {
+				id, err := strconv.ParseUint(raw, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				value := uint(id)
+				itemID = &value
+			}
+			return memory.MemoryAuditLog(c.Request.Context(), c.Param("username"), itemID, limit)
+		}, nil
+	}
+}
@@ -0,0 +1,223 @@
+package phase4handler
+
+import (
+	"agent_study/internal/agent"
+	phase4logic "agent_study/internal/logic/phase4"
+	"agent_study/internal/resp"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RunRegister 返回注册 agent 运行 API 的 Register：发起/继续运行、SSE 订阅、取消与查询。
+func RunRegister(runs *phase4logic.RunManager) func(apiGroup *gin.RouterGroup) {
+	return func(apiGroup *gin.RouterGroup) {
+		resp.HandlerWrapper(apiGroup, "agent",
+			[]*resp.Handler{
+				resp.NewJsonHandler(handleStartRun(runs)),
+				resp.NewJsonHandler(handleListRuns(runs)),
+				resp.NewJsonHandler(handleGetRun(runs)),
+				resp.NewHandler(http.MethodGet, "/runs/:id/events", handleRunEvents(runs)),
+				resp.NewJsonHandler(handleCancelRun(runs)),
+				resp.NewJsonHandler(handleListSessions(runs)),
+				resp.NewJsonHandler(handleContinueSession(runs)),
+			})
+	}
+}
+
+// StartRunReq 发起运行请求参数
+type StartRunReq struct {
+	Username  string            `json:"username"`                // 用户名，为空时归属默认用户
+	SessionID string            `json:"session_id"`              // 会话ID，为空时新建会话
+	Task      string            `json:"task" binding:"required"` // 任务描述
+	Vars      map[string]string `json:"vars"`                    // 本次运行的 system prompt 模板变量
+}
+
+// ContinueSessionReq 在已有会话中继续运行的请求参数
+type ContinueSessionReq struct {
+	Task string `json:"task" binding:"required"` // 任务描述
+}
+
+// SessionResp 会话响应
+type SessionResp struct {
+	ID        string    `json:"id"`         // 会话ID
+	Username  string    `json:"username"`   // 所属用户
+	Title     string    `json:"title"`      // 会话标题
+	CreatedAt time.Time `json:"created_at"` // 创建时间
+	UpdatedAt time.Time `json:"updated_at"` // 更新时间
+}
+
+// handleStartRun
+//
+//	@Summary		发起运行
+//	@Description	在后台发起一次 agent 运行并立即返回运行快照，通过 events 接口订阅进度
+//	@Tags			agent
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		StartRunReq	true	"运行请求"
+//	@Router			/agent/runs [post]
+//	@Success		200	{object}	resp.Result{data=phase4logic.RunView}
+func handleStartRun(runs *phase4logic.RunManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPost, "/runs", func(c *gin.Context) (any, error) {
+			var req StartRunReq
+			if err := c.ShouldBindJSON(&req); err != nil {
+				return nil, err
+			}
+			return runs.StartRun(c.Request.Context(), phase4logic.StartRunRequest{
+				Username:  req.Username,
+				SessionID: req.SessionID,
+				Task:      req.Task,
+				Vars:      req.Vars,
+			})
+		}, nil
+	}
+}
+
+// handleListRuns
+//
+//	@Summary		获取运行列表
+//	@Description	按创建时间倒序列出运行，包含每个 step 与累计用量和费用
+//	@Tags			agent
+//	@Produce		json
+//	@Param			username	query		string	false	"只看指定用户"
+//	@Param			limit		query		int		false	"最多返回条数，默认20"
+//	@Router			/agent/runs [get]
+//	@Success		200	{object}	resp.Result{data=[]phase4logic.RunView}
+func handleListRuns(runs *phase4logic.RunManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodGet, "/runs", func(c *gin.Context) (any, error) {
+			limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+			return runs.ListRuns(c.Query("username"), limit), nil
+		}, nil
+	}
+}
+
+// handleGetRun
+//
+//	@Summary		获取运行详情
+//	@Description	返回一次运行的快照
+//	@Tags			agent
+//	@Produce		json
+//	@Param			id	path		string	true	"运行ID"
+//	@Router			/agent/runs/{id} [get]
+//	@Success		200	{object}	resp.Result{data=phase4logic.RunView}
+func handleGetRun(runs *phase4logic.RunManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodGet, "/runs/:id", func(c *gin.Context) (any, error) {
+			return runs.GetRun(c.Param("id"))
+		}, nil
+	}
+}
+
+// handleRunEvents
+//
+//	@Summary		订阅运行事件
+//	@Description	通过SSE推送运行事件：先回放已产生的事件，再推送新的 step、error，最后以携带最终回答的 done 结束
+//	@Tags			agent
+//	@Produce		text/event-stream
+//	@Param			id	path	string	true	"运行ID"
+//	@Router			/agent/runs/{id}/events [get]
+//	@Success		200	{object}	phase4logic.StepView
+func handleRunEvents(runs *phase4logic.RunManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := runs.GetRun(c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 设置SSE响应头
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("Access-Control-Allow-Origin", "*")
+
+		// 客户端断开只结束订阅，运行本身继续，需要停止时调用 cancel 接口
+		err := runs.Subscribe(c.Request.Context(), c.Param("id"), func(event phase4logic.RunEvent) bool {
+			c.SSEvent(event.Name, event.Data)
+			c.Writer.Flush()
+			return true
+		})
+		if err != nil && c.Request.Context().Err() == nil {
+			c.SSEvent(phase4logic.EventError, phase4logic.ErrorEvent{Error: err.Error()})
+			c.Writer.Flush()
+		}
+	}
+}
+
+// handleCancelRun
+//
+//	@Summary		取消运行
+//	@Description	取消进行中的运行，已结束的运行不受影响
+//	@Tags			agent
+//	@Produce		json
+//	@Param			id	path		string	true	"运行ID"
+//	@Router			/agent/runs/{id}/cancel [post]
+//	@Success		200	{object}	resp.Result{data=phase4logic.RunView}
+func handleCancelRun(runs *phase4logic.RunManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPost, "/runs/:id/cancel", func(c *gin.Context) (any, error) {
+			return runs.CancelRun(c.Param("id"))
+		}, nil
+	}
+}
+
+// handleListSessions
+//
+//	@Summary		获取会话列表
+//	@Description	列出用户的会话，不含消息内容
+//	@Tags			agent
+//	@Produce		json
+//	@Param			username	query		string	false	"用户名，为空时列出全部会话"
+//	@Router			/agent/sessions [get]
+//	@Success		200	{object}	resp.Result{data=[]SessionResp}
+func handleListSessions(runs *phase4logic.RunManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodGet, "/sessions", func(c *gin.Context) (any, error) {
+			sessions, err := runs.Memory().ListSessions(c.Request.Context(), c.Query("username"))
+			if err != nil {
+				return nil, err
+			}
+			list := make([]SessionResp, 0, len(sessions))
+			for _, session := range sessions {
+				list = append(list, sessionResp(session))
+			}
+			return list, nil
+		}, nil
+	}
+}
+
+// handleContinueSession
+//
+//	@Summary		继续会话
+//	@Description	在已有会话中发起一次运行，沿用会话历史与所属用户的长期记忆
+//	@Tags			agent
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string				true	"会话ID"
+//	@Param			body	body		ContinueSessionReq	true	"运行请求"
+//	@Router			/agent/sessions/{id}/runs [post]
+//	@Success		200	{object}	resp.Result{data=phase4logic.RunView}
+func handleContinueSession(runs *phase4logic.RunManager) func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+	return func() (string, string, resp.JsonResultWrapper, []resp.WrapperOption) {
+		return http.MethodPost, "/sessions/:id/runs", func(c *gin.Context) (any, error) {
+			var req ContinueSessionReq
+			if err := c.ShouldBindJSON(&req); err != nil {
+				return nil, err
+			}
+			return runs.ContinueSession(c.Request.Context(), c.Param("id"), req.Task)
+		}, nil
+	}
+}
+
+func sessionResp(session agent.Session) SessionResp {
+	return SessionResp{
+		ID:        session.ID,
+		Username:  session.Username,
+		Title:     session.Title,
+		CreatedAt: session.CreatedAt,
+		UpdatedAt: session.UpdatedAt,
+	}
+}
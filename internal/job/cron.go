@@ -0,0 +1,188 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCron = errors.New("invalid cron expression")
+
+// cronMacros 是常用的简写，与标准 cron 的含义一致。
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSearchLimit 限制 Next 向后搜索的范围，避免 "0 0 30 2 *" 这类永远不会命中的表达式死循环。
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// Schedule 是解析后的 5 段 cron 表达式（分 时 日 月 周），按 loc 所在时区计算触发时间。
+type Schedule struct {
+	expr   string
+	loc    *time.Location
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// domAny/dowAny 记录日与周是否为 *：两者都被限定时按标准 cron 取并集。
+	domAny bool
+	dowAny bool
+}
+
+// ParseSchedule 解析 cron 表达式，支持 *、a-b、列表、/步长以及 @daily 等简写；
+// 周字段的 0 与 7 都表示周日。loc 为空时使用本地时区。
+func ParseSchedule(expr string, loc *time.Location) (*Schedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	normalized := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(normalized)]; ok {
+		normalized = macro
+	}
+	parts := strings.Fields(normalized)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("%w %q: want 5 fields, got %d", ErrInvalidCron, expr, len(parts))
+	}
+
+	bits := make([]uint64, len(parts))
+	for i, part := range parts {
+		value, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidCron, expr, err)
+		}
+		bits[i] = value
+	}
+	// 周日既可以写成 0 也可以写成 7，统一折叠到 0。
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+	return &Schedule{
+		expr:   expr,
+		loc:    loc,
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// Next 返回严格晚于 after 的下一次触发时间；表达式永远不会命中时返回零值。
+func (s *Schedule) Next(after time.Time) time.Time {
+	t := after.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if !s.domAny && !s.dowAny {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+func parseCronField(field string, spec cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			value, err := strconv.Atoi(stepPart)
+			if err != nil || value <= 0 {
+				return 0, fmt.Errorf("%s: invalid step %q", spec.name, stepPart)
+			}
+			step = value
+		}
+
+		start, end := spec.min, spec.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			low, high, _ := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = parseCronValue(low, spec); err != nil {
+				return 0, err
+			}
+			if end, err = parseCronValue(high, spec); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("%s: range %q is reversed", spec.name, rangePart)
+			}
+		default:
+			value, err := parseCronValue(rangePart, spec)
+			if err != nil {
+				return 0, err
+			}
+			start = value
+			// "5/15" 表示从 5 开始每 15 个单位一次；不带步长时只取这一个值。
+			if !hasStep {
+				end = value
+			}
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(raw string, spec cronField) (int, error) {
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value %q", spec.name, raw)
+	}
+	if value < spec.min || value > spec.max {
+		return 0, fmt.Errorf("%s: value %d out of range %d-%d", spec.name, value, spec.min, spec.max)
+	}
+	return value, nil
+}
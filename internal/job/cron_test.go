@@ -0,0 +1,51 @@
+package job
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScheduleNextFollowsCronFields(t *testing.T) {
+	base := time.Date(2026, 3, 14, 10, 7, 30, 0, time.UTC) // 周六
+
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "*/15 * * * *", want: time.Date(2026, 3, 14, 10, 15, 0, 0, time.UTC)},
+		{expr: "0 2 * * *", want: time.Date(2026, 3, 15, 2, 0, 0, 0, time.UTC)},
+		{expr: "@hourly", want: time.Date(2026, 3, 14, 11, 0, 0, 0, time.UTC)},
+		{expr: "30 9 * * 1-5", want: time.Date(2026, 3, 16, 9, 30, 0, 0, time.UTC)},
+		{expr: "0 0 * * 7", want: time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 1 */3 *", want: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "5,10 10 * * *", want: time.Date(2026, 3, 14, 10, 10, 0, 0, time.UTC)},
+		// 日与周同时限定时取并集：15 号（周日）早于下一个周三。
+		{expr: "0 8 15 * 3", want: time.Date(2026, 3, 15, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		schedule, err := ParseSchedule(tc.expr, time.UTC)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q) error = %v", tc.expr, err)
+		}
+		if got := schedule.Next(base); !got.Equal(tc.want) {
+			t.Errorf("Next(%q) = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+
+	never, err := ParseSchedule("0 0 30 2 *", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+	if got := never.Next(base); !got.IsZero() {
+		t.Fatalf("Next() = %v, want zero for an impossible date", got)
+	}
+}
+
+func TestParseScheduleRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(expr, time.UTC); !errors.Is(err, ErrInvalidCron) {
+			t.Errorf("ParseSchedule(%q) error = %v, want ErrInvalidCron", expr, err)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package job
+
+import (
+	"agent_study/internal/agent"
+	"agent_study/internal/log"
+	"agent_study/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrAgentRequired = errors.New("base agent is required")
+
+const (
+	defaultWorkers      = 2
+	defaultPollInterval = 5 * time.Second
+	defaultLease        = time.Minute
+	defaultBackoff      = 30 * time.Second
+	// maxBackoff 限制指数退避的上限，避免多次失败后重试间隔无限增长。
+	maxBackoff = time.Hour
+)
+
+// QueueOptions 控制 worker 数量与轮询、租约、重试退避的时间参数，零值使用默认值。
+type QueueOptions struct {
+	// Workers 是同时执行任务的 worker 数。
+	Workers int
+	// PollInterval 是 worker 没有领到任务时的等待时间，也是检查定时任务的间隔。
+	PollInterval time.Duration
+	// Lease 是任务租约时长；worker 每隔 Lease/3 续约一次，进程崩溃后租约过期的任务会被
+	// 其它 worker 接管。
+	Lease time.Duration
+	// Backoff 是第一次重试前的等待时间，之后每次翻倍。
+	Backoff time.Duration
+}
+
+// Queue 从 Store 领取任务并用基础 Agent 的副本执行；每个任务按自己的预算与步数上限
+// 运行在独立会话里，配置了检查点时重试会从上一次中断处恢复。
+type Queue struct {
+	store   *Store
+	base    *agent.Agent
+	options QueueOptions
+	wake    chan struct{}
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+// NewQueue 用基础 Agent 构造任务队列；调用 Run 后才会开始执行任务。
+func NewQueue(store *Store, base *agent.Agent, options QueueOptions) (*Queue, error) {
+	if store == nil {
+		return nil, fmt.Errorf("job store is required")
+	}
+	if base == nil {
+		return nil, ErrAgentRequired
+	}
+	if options.Workers <= 0 {
+		options.Workers = defaultWorkers
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultPollInterval
+	}
+	if options.Lease <= 0 {
+		options.Lease = defaultLease
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = defaultBackoff
+	}
+	return &Queue{
+		store:   store,
+		base:    base,
+		options: options,
+		wake:    make(chan struct{}, 1),
+		running: make(map[string]context.CancelFunc),
+	}, nil
+}
+
+// Store 返回队列使用的存储，供 API 查询任务与管理定时任务。
+func (q *Queue) Store() *Store {
+	return q.store
+}
+
+// Enqueue 投递任务并唤醒空闲的 worker。
+func (q *Queue) Enqueue(ctx context.Context, spec JobSpec) (*Job, error) {
+	job, err := q.store.Enqueue(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	q.notify()
+	return job, nil
+}
+
+// TriggerSchedule 立即执行一次定时任务并唤醒空闲的 worker。
+func (q *Queue) TriggerSchedule(ctx context.Context, id string) (*Job, error) {
+	job, err := q.store.TriggerSchedule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	q.notify()
+	return job, nil
+}
+
+// Cancel 取消任务；任务正由本进程执行时立即中断运行，而不是等到下一次续约。
+func (q *Queue) Cancel(ctx context.Context, id string) (*Job, error) {
+	job, err := q.store.Cancel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
+	cancel := q.running[id]
+	q.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	return job, nil
+}
+
+// Retry 把失败或已取消的任务重新放回队列并唤醒空闲的 worker。
+func (q *Queue) Retry(ctx context.Context, id string) (*Job, error) {
+	job, err := q.store.Retry(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	q.notify()
+	return job, nil
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run 启动 worker 与定时任务调度，阻塞到 ctx 取消且所有 worker 退出。退出时执行到一半
+// 的任务会被放回队列，下次启动后继续。
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.options.Workers; i++ {
+		workerID := fmt.Sprintf("%s-%d", uuid.NewString()[:8], i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, workerID)
+		}()
+	}
+
+	ticker := time.NewTicker(q.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		q.enqueueDue(ctx)
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce 投递到期的定时任务，并在当前 goroutine 里依次执行调用开始时已到期的任务，
+// 返回执行的任务数；适合测试与一次性批处理。
+func (q *Queue) RunOnce(ctx context.Context) (int, error) {
+	if _, err := q.store.enqueueDue(ctx); err != nil {
+		return 0, err
+	}
+	workerID := "once-" + uuid.NewString()[:8]
+	dueBy := q.store.now()
+	executed := 0
+	for {
+		record, err := q.store.claimDue(ctx, workerID, q.options.Lease, dueBy)
+		if err != nil {
+			return executed, err
+		}
+		if record == nil {
+			return executed, nil
+		}
+		q.execute(ctx, workerID, record)
+		executed++
+	}
+}
+
+func (q *Queue) enqueueDue(ctx context.Context) {
+	enqueued, err := q.store.enqueueDue(ctx)
+	if err != nil {
+		log.Warnf("enqueue scheduled jobs failed: %v", err)
+	}
+	if enqueued > 0 {
+		q.notify()
+	}
+}
+
+func (q *Queue) work(ctx context.Context, workerID string) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		record, err := q.store.claim(ctx, workerID, q.options.Lease)
+		if err != nil && ctx.Err() == nil {
+			log.Warnf("job worker %s claim failed: %v", workerID, err)
+		}
+		if record != nil {
+			q.execute(ctx, workerID, record)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(q.options.PollInterval):
+		}
+	}
+}
+
+// execute 执行一个已领取的任务并写回结果；执行期间定期续约，续约失败（任务被取消或
+// 被其它 worker 接管）时中断运行。
+func (q *Queue) execute(ctx context.Context, workerID string, record *model.AgentJob) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	q.mu.Lock()
+	q.running[record.ID] = cancel
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.running, record.ID)
+		q.mu.Unlock()
+	}()
+	go q.heartbeat(jobCtx, cancel, workerID, record.ID)
+
+	state, costUSD, err := q.runAgent(jobCtx, workerID, record)
+	cancel()
+
+	outcome := jobOutcome{CostUSD: costUSD}
+	if state != nil {
+		outcome.Result = state.FinalAnswer
+		outcome.StopReason = string(state.StopReason)
+		outcome.Steps = len(state.Steps)
+	}
+	// 进程退出时 ctx 已取消，结果仍要写回，因此改用不随 ctx 取消的上下文。
+	writeCtx := context.WithoutCancel(ctx)
+	switch {
+	case err == nil:
+		err = q.store.complete(writeCtx, record.ID, workerID, outcome)
+	case ctx.Err() != nil:
+		log.Infof("job %s interrupted by shutdown, releasing it back to the queue", record.ID)
+		err = q.store.release(writeCtx, record.ID, workerID)
+	case errors.Is(err, context.Canceled):
+		// 任务已被取消或租约被接管，状态已由取消方写入。
+		log.Infof("job %s stopped: %v", record.ID, err)
+		return
+	default:
+		log.Warnf("job %s attempt %d/%d failed: %v", record.ID, record.Attempts, record.MaxAttempts, err)
+		var retryAt *time.Time
+		if record.Attempts < record.MaxAttempts {
+			next := q.store.now().Add(q.retryDelay(record.Attempts))
+			retryAt = &next
+		}
+		err = q.store.fail(writeCtx, record.ID, workerID, outcome, err, retryAt)
+	}
+	if err != nil {
+		log.Warnf("job %s save result failed: %v", record.ID, err)
+	}
+}
+
+// runAgent 用基础 Agent 的副本执行任务，返回运行状态与本次执行新增的费用。任务已有检查点
+// 时从中断处恢复：检查点已完成（上次执行在写回结果前中断）直接复用其结果，检查点不存在
+// （第一步完成前中断）则重新开始。
+func (q *Queue) runAgent(ctx context.Context, workerID string, record *model.AgentJob) (*agent.State, float64, error) {
+	run := q.base.CloneWithBudget(record.BudgetUSD)
+	if record.MaxSteps > 0 {
+		run.Config.MaxSteps = record.MaxSteps
+	}
+
+	sessionID := record.SessionID
+	if sessionID == "" {
+		session, err := run.Memory.CreateSession(ctx, record.Username, record.Name)
+		if err != nil {
+			return nil, 0, err
+		}
+		sessionID = session.ID
+		if err := q.store.recordRun(ctx, record.ID, workerID, sessionID, ""); err != nil {
+			return nil, 0, err
+		}
+	}
+	agentRunID := record.AgentRunID
+	run.SetStepCallback(func(event agent.StepEvent) {
+		if event.Depth != 0 || event.RunID == "" || event.RunID == agentRunID {
+			return
+		}
+		agentRunID = event.RunID
+		if err := q.store.recordRun(ctx, record.ID, workerID, "", event.RunID); err != nil {
+			log.Warnf("job %s record agent run failed: %v", record.ID, err)
+		}
+	})
+
+	if record.AgentRunID != "" && run.Checkpoints != nil {
+		checkpoint, err := run.Checkpoints.Load(ctx, record.AgentRunID)
+		switch {
+		case err == nil && checkpoint.Status == agent.RunStatusCompleted:
+			state := checkpoint.State
+			state.RunID = checkpoint.ID
+			state.FinalAnswer = checkpoint.FinalAnswer
+			return &state, 0, nil
+		case err == nil:
+			// 恢复会把费用跟踪器还原到检查点的累计值，本次新增的费用需要减去这部分。
+			baseline := 0.0
+			if checkpoint.Cost != nil {
+				baseline = checkpoint.Cost.Cost.TotalCostUSD
+			}
+			state, err := run.Resume(ctx, record.AgentRunID, agent.ResumeOptions{MaxSteps: record.MaxSteps})
+			return state, spentUSD(run, baseline), err
+		case !errors.Is(err, agent.ErrRunNotFound):
+			return nil, 0, err
+		}
+	}
+
+	state, err := run.Run(ctx, sessionID, record.Task)
+	return state, spentUSD(run, 0), err
+}
+
+func (q *Queue) heartbeat(ctx context.Context, cancel context.CancelFunc, workerID string, jobID string) {
+	ticker := time.NewTicker(q.options.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := q.store.heartbeat(ctx, jobID, workerID, q.options.Lease)
+		if errors.Is(err, ErrLeaseLost) {
+			cancel()
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Warnf("job %s heartbeat failed: %v", jobID, err)
+		}
+	}
+}
+
+// retryDelay 按 Backoff·2^(attempts-1) 计算下一次重试前的等待时间。
+func (q *Queue) retryDelay(attempts int) time.Duration {
+	delay := q.options.Backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+func spentUSD(run *agent.Agent, baseline float64) float64 {
+	if run.Cost == nil {
+		return 0
+	}
+	return max(run.Cost.Totals().Cost.TotalCostUSD-baseline, 0)
+}
@@ -0,0 +1,237 @@
+package job
+
+import (
+	"agent_study/internal/agent"
+	llmModel "agent_study/pkg/llm_core/model"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// flakyLLM 前 failures 次调用返回错误，之后回答任务内容。
+type flakyLLM struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+}
+
+func (f *flakyLLM) Chat(_ context.Context, req llmModel.ChatRequest) (llmModel.ChatResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.calls <= f.failures {
+		return llmModel.ChatResponse{}, errors.New("upstream unavailable")
+	}
+	return llmModel.ChatResponse{Content: "done: " + req.Messages[len(req.Messages)-1].Content}, nil
+}
+
+func (f *flakyLLM) ChatStream(context.Context, llmModel.ChatRequest) (llmModel.Stream, error) {
+	return nil, errors.New("not implemented")
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+	return db
+}
+
+func newTestQueue(t *testing.T, db *gorm.DB, llm llmModel.LlmClient) *Queue {
+	t.Helper()
+	store, err := NewStore(db)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	checkpoints, err := agent.NewCheckpointStore(db)
+	if err != nil {
+		t.Fatalf("NewCheckpointStore() error = %v", err)
+	}
+	base, err := agent.NewAgent(agent.NewAgentOptions{
+		LLM:           llm,
+		MemoryOptions: &agent.MemoryOptions{DB: db},
+		Checkpoints:   checkpoints,
+	})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+	queue, err := NewQueue(store, base, QueueOptions{Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewQueue() error = %v", err)
+	}
+	return queue
+}
+
+func TestQueueRetriesFailedJobWithBackoffAndStoresResult(t *testing.T) {
+	queue := newTestQueue(t, newTestDB(t), &flakyLLM{failures: 1})
+	queue.options.Backoff = time.Minute
+	now := time.Now()
+	queue.Store().now = func() time.Time { return now }
+	ctx := context.Background()
+
+	job, err := queue.Enqueue(ctx, JobSpec{Username: "alice", Task: "summarize news", MaxAttempts: 2})
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if executed, err := queue.RunOnce(ctx); err != nil || executed != 1 {
+		t.Fatalf("RunOnce() = %d, %v, want 1 job executed", executed, err)
+	}
+	failed, err := queue.Store().Get(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if failed.Status != StatusPending || failed.Attempts != 1 || !strings.Contains(failed.Error, "upstream unavailable") {
+		t.Fatalf("after first attempt job = %#v, want pending retry with the error recorded", failed)
+	}
+	if !failed.RunAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("retry run_at = %v, want one backoff after the failure", failed.RunAt)
+	}
+	if executed, err := queue.RunOnce(ctx); err != nil || executed != 0 {
+		t.Fatalf("RunOnce() = %d, %v, want the retry held back until its backoff elapses", executed, err)
+	}
+
+	now = now.Add(time.Minute)
+	if executed, err := queue.RunOnce(ctx); err != nil || executed != 1 {
+		t.Fatalf("RunOnce() = %d, %v, want the retry run once due", executed, err)
+	}
+	done, err := queue.Store().Get(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if done.Status != StatusSucceeded || done.Attempts != 2 || done.Result != "done: summarize news" || done.Error != "" {
+		t.Fatalf("after retry job = %#v, want succeeded with the answer", done)
+	}
+	if done.SessionID == "" || done.AgentRunID == "" || done.FinishedAt == nil {
+		t.Fatalf("job = %#v, want session, agent run and finish time recorded", done)
+	}
+}
+
+func TestQueueTakesOverJobWhoseLeaseExpired(t *testing.T) {
+	db := newTestDB(t)
+	queue := newTestQueue(t, db, &flakyLLM{})
+	ctx := context.Background()
+
+	job, err := queue.Enqueue(ctx, JobSpec{Task: "nightly report", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	// 模拟上一个进程领取任务后崩溃：租约过期前任务不会被重复领取。
+	if _, err := queue.Store().claim(ctx, "crashed", time.Hour); err != nil {
+		t.Fatalf("claim() error = %v", err)
+	}
+	if executed, _ := queue.RunOnce(ctx); executed != 0 {
+		t.Fatalf("RunOnce() executed %d, want leased job left alone", executed)
+	}
+	if err := db.Exec("UPDATE agent_jobs SET locked_until = ? WHERE id = ?", time.Now().UTC().Add(-time.Second), job.ID).Error; err != nil {
+		t.Fatalf("expire lease error = %v", err)
+	}
+
+	// 已用完执行次数的过期任务直接判定失败。
+	if executed, _ := queue.RunOnce(ctx); executed != 0 {
+		t.Fatalf("RunOnce() executed %d, want exhausted job failed without running", executed)
+	}
+	failed, _ := queue.Store().Get(ctx, job.ID)
+	if failed.Status != StatusFailed {
+		t.Fatalf("status = %s, want failed", failed.Status)
+	}
+
+	// 重试给出新的执行机会，原持有者的续约会失败。
+	if _, err := queue.Retry(ctx, job.ID); err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+	if executed, _ := queue.RunOnce(ctx); executed != 1 {
+		t.Fatalf("RunOnce() executed %d, want retried job run", executed)
+	}
+	done, _ := queue.Store().Get(ctx, job.ID)
+	if done.Status != StatusSucceeded || done.Attempts != 2 {
+		t.Fatalf("job = %#v, want succeeded on the second attempt", done)
+	}
+	if err := queue.Store().heartbeat(ctx, job.ID, "crashed", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("heartbeat() error = %v, want ErrLeaseLost", err)
+	}
+	if _, err := queue.Cancel(ctx, job.ID); !errors.Is(err, ErrJobNotCancellable) {
+		t.Fatalf("Cancel() error = %v, want ErrJobNotCancellable", err)
+	}
+}
+
+func TestStoreSchedulesEnqueueEachDueRunOnce(t *testing.T) {
+	store, err := NewStore(newTestDB(t))
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	store.loc = time.UTC
+	now := time.Date(2026, 3, 14, 10, 7, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	schedule, err := store.CreateSchedule(ctx, ScheduleSpec{Name: "digest", Cron: "*/15 * * * *", Task: "send digest", BudgetUSD: 0.5})
+	if err != nil {
+		t.Fatalf("CreateSchedule() error = %v", err)
+	}
+	if schedule.NextRunAt == nil || !schedule.NextRunAt.Equal(now.Add(8*time.Minute)) {
+		t.Fatalf("NextRunAt = %v, want 10:15", schedule.NextRunAt)
+	}
+	if _, err := store.CreateSchedule(ctx, ScheduleSpec{Name: "digest", Cron: "@daily", Task: "x"}); !errors.Is(err, ErrScheduleNameExists) {
+		t.Fatalf("CreateSchedule() duplicate error = %v, want ErrScheduleNameExists", err)
+	}
+	if _, err := store.CreateSchedule(ctx, ScheduleSpec{Name: "bad", Cron: "every day", Task: "x"}); !errors.Is(err, ErrInvalidCron) {
+		t.Fatalf("CreateSchedule() invalid cron error = %v, want ErrInvalidCron", err)
+	}
+
+	if enqueued, err := store.enqueueDue(ctx); err != nil || enqueued != 0 {
+		t.Fatalf("enqueueDue() = %d, %v, want nothing due yet", enqueued, err)
+	}
+	// 停机错过了 10:15 与 10:30 两次触发，恢复后只补投一次。
+	now = time.Date(2026, 3, 14, 10, 40, 0, 0, time.UTC)
+	if enqueued, err := store.enqueueDue(ctx); err != nil || enqueued != 1 {
+		t.Fatalf("enqueueDue() = %d, %v, want 1", enqueued, err)
+	}
+	if enqueued, _ := store.enqueueDue(ctx); enqueued != 0 {
+		t.Fatalf("enqueueDue() again = %d, want 0", enqueued)
+	}
+	jobs, err := store.List(ctx, ListFilter{ScheduleID: schedule.ID})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].BudgetUSD != 0.5 || jobs[0].Task != "send digest" || jobs[0].MaxAttempts != DefaultMaxAttempts {
+		t.Fatalf("jobs = %#v, want one job built from the schedule", jobs)
+	}
+	updated, err := store.GetSchedule(ctx, schedule.ID)
+	if err != nil {
+		t.Fatalf("GetSchedule() error = %v", err)
+	}
+	if !updated.NextRunAt.Equal(time.Date(2026, 3, 14, 10, 45, 0, 0, time.UTC)) || updated.LastJobID != jobs[0].ID {
+		t.Fatalf("schedule = %#v, want next run 10:45 and last job recorded", updated)
+	}
+
+	disabled := false
+	if updated, err = store.UpdateSchedule(ctx, schedule.ID, SchedulePatch{Enabled: &disabled}); err != nil || updated.NextRunAt != nil {
+		t.Fatalf("UpdateSchedule() = %#v, %v, want disabled schedule without next run", updated, err)
+	}
+	now = now.Add(time.Hour)
+	if enqueued, _ := store.enqueueDue(ctx); enqueued != 0 {
+		t.Fatalf("enqueueDue() = %d, want disabled schedule skipped", enqueued)
+	}
+	if _, err := store.TriggerSchedule(ctx, schedule.ID); err != nil {
+		t.Fatalf("TriggerSchedule() error = %v", err)
+	}
+	cancelled, err := store.Cancel(ctx, jobs[0].ID)
+	if err != nil || cancelled.Status != StatusCancelled {
+		t.Fatalf("Cancel() = %#v, %v, want cancelled", cancelled, err)
+	}
+	if err := store.DeleteSchedule(ctx, schedule.ID); err != nil {
+		t.Fatalf("DeleteSchedule() error = %v", err)
+	}
+	if _, err := store.GetSchedule(ctx, schedule.ID); !errors.Is(err, ErrScheduleNotFound) {
+		t.Fatalf("GetSchedule() error = %v, want ErrScheduleNotFound", err)
+	}
+}
@@ -0,0 +1,783 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	phase4migrate "agent_study/internal/migrate/phase4"
+	"agent_study/internal/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Status 描述后台任务所处的阶段。
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// 错误定义
+var (
+	ErrEmptyTask          = errors.New("job task cannot be empty")
+	ErrJobNotFound        = errors.New("job not found")
+	ErrJobNotCancellable  = errors.New("job is not pending or running")
+	ErrJobNotRetryable    = errors.New("job is not failed or cancelled")
+	ErrScheduleNotFound   = errors.New("schedule not found")
+	ErrScheduleNameExists = errors.New("schedule name already exists")
+	ErrEmptyScheduleName  = errors.New("schedule name cannot be empty")
+	ErrLeaseLost          = errors.New("job lease lost")
+)
+
+const (
+	// DefaultMaxAttempts 是未指定 MaxAttempts 时任务最多执行的次数（含首次）。
+	DefaultMaxAttempts = 3
+	defaultListLimit   = 50
+	jobNameRunes       = 40
+)
+
+// JobSpec 是投递任务的参数；BudgetUSD、MaxSteps 为 0 时沿用基础 Agent 的配置。
+type JobSpec struct {
+	Name        string     `json:"name"`
+	Username    string     `json:"username"`
+	Task        string     `json:"task"`
+	BudgetUSD   float64    `json:"budget_usd"`
+	MaxSteps    int        `json:"max_steps"`
+	MaxAttempts int        `json:"max_attempts"`
+	RunAt       *time.Time `json:"run_at"`
+}
+
+// Job 是任务对外暴露的只读视图。
+type Job struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	ScheduleID  string     `json:"schedule_id,omitempty"`
+	Username    string     `json:"username"`
+	Task        string     `json:"task"`
+	Status      Status     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	BudgetUSD   float64    `json:"budget_usd"`
+	MaxSteps    int        `json:"max_steps"`
+	RunAt       time.Time  `json:"run_at"`
+	SessionID   string     `json:"session_id,omitempty"`
+	AgentRunID  string     `json:"agent_run_id,omitempty"`
+	Result      string     `json:"result,omitempty"`
+	StopReason  string     `json:"stop_reason,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Steps       int        `json:"steps"`
+	CostUSD     float64    `json:"cost_usd"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ListFilter 过滤任务列表；字段为空表示不限制，Limit 为 0 时返回最近 50 条。
+type ListFilter struct {
+	Username   string
+	Status     Status
+	ScheduleID string
+	Limit      int
+}
+
+// ScheduleSpec 是创建定时任务的参数；Enabled 为空时默认启用。
+type ScheduleSpec struct {
+	Name        string  `json:"name"`
+	Cron        string  `json:"cron"`
+	Username    string  `json:"username"`
+	Task        string  `json:"task"`
+	BudgetUSD   float64 `json:"budget_usd"`
+	MaxSteps    int     `json:"max_steps"`
+	MaxAttempts int     `json:"max_attempts"`
+	Enabled     *bool   `json:"enabled"`
+}
+
+// SchedulePatch 只更新非空字段；修改 Cron 或重新启用时会重新计算下次投递时间。
+type SchedulePatch struct {
+	Cron        *string  `json:"cron"`
+	Username    *string  `json:"username"`
+	Task        *string  `json:"task"`
+	BudgetUSD   *float64 `json:"budget_usd"`
+	MaxSteps    *int     `json:"max_steps"`
+	MaxAttempts *int     `json:"max_attempts"`
+	Enabled     *bool    `json:"enabled"`
+}
+
+// ScheduleView 是定时任务对外暴露的只读视图。
+type ScheduleView struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Cron        string     `json:"cron"`
+	Username    string     `json:"username"`
+	Task        string     `json:"task"`
+	BudgetUSD   float64    `json:"budget_usd"`
+	MaxSteps    int        `json:"max_steps"`
+	MaxAttempts int        `json:"max_attempts"`
+	Enabled     bool       `json:"enabled"`
+	NextRunAt   *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt   *time.Time `json:"last_run_at,omitempty"`
+	LastJobID   string     `json:"last_job_id,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Store 把任务与定时任务持久化到 SQLite；所有时间按 UTC 存储，保证跨时区比较一致。
+type Store struct {
+	db  *gorm.DB
+	loc *time.Location
+	now func() time.Time
+}
+
+// NewStore 会在首次使用前补齐 phase 4 依赖的表结构；cron 表达式按本地时区解释。
+func NewStore(db *gorm.DB) (*Store, error) {
+	if err := phase4migrate.BootstrapWithDB(db, phase4migrate.CurrentVersion); err != nil {
+		return nil, err
+	}
+	return &Store{db: db, loc: time.Local, now: time.Now}, nil
+}
+
+// Enqueue 投递一个任务；RunAt 为空时立即可执行。
+func (s *Store) Enqueue(ctx context.Context, spec JobSpec) (*Job, error) {
+	record, err := s.newJobRecord(spec, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Create(record).Error; err != nil {
+		return nil, err
+	}
+	return jobFromRecord(record), nil
+}
+
+func (s *Store) newJobRecord(spec JobSpec, scheduleID string) (*model.AgentJob, error) {
+	task := strings.TrimSpace(spec.Task)
+	if task == "" {
+		return nil, ErrEmptyTask
+	}
+	if spec.BudgetUSD < 0 || spec.MaxSteps < 0 || spec.MaxAttempts < 0 {
+		return nil, fmt.Errorf("job budget_usd, max_steps and max_attempts cannot be negative")
+	}
+	name := strings.TrimSpace(spec.Name)
+	if name == "" {
+		name = truncateRunes(task, jobNameRunes)
+	}
+	maxAttempts := spec.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	runAt := s.now()
+	if spec.RunAt != nil && !spec.RunAt.IsZero() {
+		runAt = *spec.RunAt
+	}
+	return &model.AgentJob{
+		ID:          uuid.NewString(),
+		Name:        name,
+		ScheduleID:  scheduleID,
+		Username:    strings.TrimSpace(spec.Username),
+		Task:        task,
+		Status:      string(StatusPending),
+		MaxAttempts: maxAttempts,
+		BudgetUSD:   spec.BudgetUSD,
+		MaxSteps:    spec.MaxSteps,
+		RunAt:       runAt.UTC(),
+	}, nil
+}
+
+// Get 返回单个任务。
+func (s *Store) Get(ctx context.Context, id string) (*Job, error) {
+	record, err := s.loadJob(s.db.WithContext(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+	return jobFromRecord(record), nil
+}
+
+// List 按创建时间倒序返回任务。
+func (s *Store) List(ctx context.Context, filter ListFilter) ([]Job, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	query := s.db.WithContext(ctx).Model(&model.AgentJob{}).Order("created_at DESC").Limit(limit)
+	if filter.Username != "" {
+		query = query.Where("username = ?", filter.Username)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", string(filter.Status))
+	}
+	if filter.ScheduleID != "" {
+		query = query.Where("schedule_id = ?", filter.ScheduleID)
+	}
+	var records []model.AgentJob
+	if err := query.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0, len(records))
+	for i := range records {
+		jobs = append(jobs, *jobFromRecord(&records[i]))
+	}
+	return jobs, nil
+}
+
+// Cancel 取消排队或执行中的任务；执行中的任务会在下一次续约时发现状态变化并中断运行。
+func (s *Store) Cancel(ctx context.Context, id string) (*Job, error) {
+	now := s.now().UTC()
+	result := s.db.WithContext(ctx).Model(&model.AgentJob{}).
+		Where("id = ? AND status IN ?", id, []string{string(StatusPending), string(StatusRunning)}).
+		Updates(map[string]any{
+			"status":       string(StatusCancelled),
+			"locked_by":    "",
+			"locked_until": nil,
+			"finished_at":  now,
+			"updated_at":   now,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		if _, err := s.Get(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrJobNotCancellable, id)
+	}
+	return s.Get(ctx, id)
+}
+
+// Retry 把失败或已取消的任务重新放回队列，并额外给一次执行机会；已保存的检查点会被
+// 保留，重试时从中断处恢复。
+func (s *Store) Retry(ctx context.Context, id string) (*Job, error) {
+	now := s.now().UTC()
+	result := s.db.WithContext(ctx).Model(&model.AgentJob{}).
+		Where("id = ? AND status IN ?", id, []string{string(StatusFailed), string(StatusCancelled)}).
+		Updates(map[string]any{
+			"status":       string(StatusPending),
+			"max_attempts": gorm.Expr("attempts + 1"),
+			"run_at":       now,
+			"finished_at":  nil,
+			"updated_at":   now,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		if _, err := s.Get(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrJobNotRetryable, id)
+	}
+	return s.Get(ctx, id)
+}
+
+// claim 为 worker 领取一个到期任务：优先排队中的任务，其次是租约过期（持有者崩溃或
+// 进程重启）的执行中任务。领取通过带原状态与次数条件的更新实现，多个 worker 并发
+// 领取同一任务时只有一个会成功。
+func (s *Store) claim(ctx context.Context, workerID string, lease time.Duration) (*model.AgentJob, error) {
+	return s.claimDue(ctx, workerID, lease, s.now())
+}
+
+// claimDue 与 claim 相同，但只领取在 dueBy 时已经到期的任务；RunOnce 用调用开始的时间
+// 作为截止点，本次调用中失败后安排了重试的任务不会被立刻再次领取。
+func (s *Store) claimDue(ctx context.Context, workerID string, lease time.Duration, dueBy time.Time) (*model.AgentJob, error) {
+	now := s.now().UTC()
+	due := dueBy.UTC()
+	for {
+		var record model.AgentJob
+		err := s.db.WithContext(ctx).
+			Where("(status = ? AND run_at <= ?) OR (status = ? AND locked_until < ?)",
+				string(StatusPending), due, string(StatusRunning), due).
+			Order("run_at ASC").Limit(1).Find(&record).Error
+		if err != nil {
+			return nil, err
+		}
+		if record.ID == "" {
+			return nil, nil
+		}
+
+		// 租约过期的任务已经用完执行次数时直接判定失败，不再重新执行。
+		if record.Status == string(StatusRunning) && record.Attempts >= record.MaxAttempts {
+			err := s.db.WithContext(ctx).Model(&model.AgentJob{}).
+				Where("id = ? AND status = ? AND attempts = ?", record.ID, record.Status, record.Attempts).
+				Updates(map[string]any{
+					"status":       string(StatusFailed),
+					"error":        "job lease expired after the last attempt",
+					"locked_by":    "",
+					"locked_until": nil,
+					"finished_at":  now,
+					"updated_at":   now,
+				}).Error
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		lockedUntil := now.Add(lease)
+		updates := map[string]any{
+			"status":       string(StatusRunning),
+			"attempts":     record.Attempts + 1,
+			"locked_by":    workerID,
+			"locked_until": lockedUntil,
+			"updated_at":   now,
+		}
+		if record.StartedAt == nil {
+			updates["started_at"] = now
+		}
+		result := s.db.WithContext(ctx).Model(&model.AgentJob{}).
+			Where("id = ? AND status = ? AND attempts = ?", record.ID, record.Status, record.Attempts).
+			Updates(updates)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 被其它 worker 抢先领取，继续找下一个。
+			continue
+		}
+		return s.loadJob(s.db.WithContext(ctx), record.ID)
+	}
+}
+
+// heartbeat 延长 worker 持有的租约；任务已被取消或被其它 worker 接管时返回 ErrLeaseLost。
+func (s *Store) heartbeat(ctx context.Context, id string, workerID string, lease time.Duration) error {
+	now := s.now().UTC()
+	result := s.db.WithContext(ctx).Model(&model.AgentJob{}).
+		Where("id = ? AND status = ? AND locked_by = ?", id, string(StatusRunning), workerID).
+		Updates(map[string]any{"locked_until": now.Add(lease), "updated_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrLeaseLost, id)
+	}
+	return nil
+}
+
+// recordRun 保存任务运行所在的会话与检查点运行 ID，重试或接管时据此恢复。
+func (s *Store) recordRun(ctx context.Context, id string, workerID string, sessionID string, agentRunID string) error {
+	updates := map[string]any{"updated_at": s.now().UTC()}
+	if sessionID != "" {
+		updates["session_id"] = sessionID
+	}
+	if agentRunID != "" {
+		updates["agent_run_id"] = agentRunID
+	}
+	return s.db.WithContext(ctx).Model(&model.AgentJob{}).
+		Where("id = ? AND locked_by = ?", id, workerID).
+		Updates(updates).Error
+}
+
+// jobOutcome 是一次执行结束后写回任务的结果。
+type jobOutcome struct {
+	Result     string
+	StopReason string
+	Steps      int
+	CostUSD    float64
+}
+
+// complete 把任务标记为成功；只有仍持有租约的 worker 才能写入结果。
+func (s *Store) complete(ctx context.Context, id string, workerID string, outcome jobOutcome) error {
+	now := s.now().UTC()
+	return s.db.WithContext(ctx).Model(&model.AgentJob{}).
+		Where("id = ? AND status = ? AND locked_by = ?", id, string(StatusRunning), workerID).
+		Updates(map[string]any{
+			"status":       string(StatusSucceeded),
+			"result":       outcome.Result,
+			"stop_reason":  outcome.StopReason,
+			"steps":        outcome.Steps,
+			"cost_usd":     gorm.Expr("cost_usd + ?", outcome.CostUSD),
+			"error":        "",
+			"locked_by":    "",
+			"locked_until": nil,
+			"finished_at":  now,
+			"updated_at":   now,
+		}).Error
+}
+
+// fail 记录一次失败；retryAt 非空时任务回到队列等待重试，否则判定为最终失败。
+func (s *Store) fail(ctx context.Context, id string, workerID string, outcome jobOutcome, cause error, retryAt *time.Time) error {
+	now := s.now().UTC()
+	updates := map[string]any{
+		"error":        cause.Error(),
+		"stop_reason":  outcome.StopReason,
+		"steps":        outcome.Steps,
+		"cost_usd":     gorm.Expr("cost_usd + ?", outcome.CostUSD),
+		"locked_by":    "",
+		"locked_until": nil,
+		"updated_at":   now,
+	}
+	if retryAt != nil {
+		updates["status"] = string(StatusPending)
+		updates["run_at"] = retryAt.UTC()
+	} else {
+		updates["status"] = string(StatusFailed)
+		updates["finished_at"] = now
+	}
+	return s.db.WithContext(ctx).Model(&model.AgentJob{}).
+		Where("id = ? AND status = ? AND locked_by = ?", id, string(StatusRunning), workerID).
+		Updates(updates).Error
+}
+
+// release 在进程退出时把执行到一半的任务放回队列，不计入执行次数。
+func (s *Store) release(ctx context.Context, id string, workerID string) error {
+	now := s.now().UTC()
+	return s.db.WithContext(ctx).Model(&model.AgentJob{}).
+		Where("id = ? AND status = ? AND locked_by = ?", id, string(StatusRunning), workerID).
+		Updates(map[string]any{
+			"status":       string(StatusPending),
+			"attempts":     gorm.Expr("attempts - 1"),
+			"run_at":       now,
+			"locked_by":    "",
+			"locked_until": nil,
+			"updated_at":   now,
+		}).Error
+}
+
+func (s *Store) loadJob(db *gorm.DB, id string) (*model.AgentJob, error) {
+	var record model.AgentJob
+	if err := db.Where("id = ?", id).Limit(1).Find(&record).Error; err != nil {
+		return nil, err
+	}
+	if record.ID == "" {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return &record, nil
+}
+
+// CreateSchedule 新建定时任务，下次投递时间从当前时刻起按 cron 计算。
+func (s *Store) CreateSchedule(ctx context.Context, spec ScheduleSpec) (*ScheduleView, error) {
+	record, err := s.newScheduleRecord(spec)
+	if err != nil {
+		return nil, err
+	}
+	var existing model.AgentSchedule
+	if err := s.db.WithContext(ctx).Select("id").Where("name = ?", record.Name).Limit(1).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing.ID != "" {
+		return nil, fmt.Errorf("%w: %s", ErrScheduleNameExists, record.Name)
+	}
+	if err := s.db.WithContext(ctx).Create(record).Error; err != nil {
+		return nil, err
+	}
+	return scheduleFromRecord(record), nil
+}
+
+// UpsertSchedule 按名称创建或覆盖定时任务，供启动时从配置文件同步；未改变 cron 时保留
+// 原有的下次投递时间，避免每次重启都重新计时。
+func (s *Store) UpsertSchedule(ctx context.Context, spec ScheduleSpec) (*ScheduleView, error) {
+	record, err := s.newScheduleRecord(spec)
+	if err != nil {
+		return nil, err
+	}
+	var existing model.AgentSchedule
+	if err := s.db.WithContext(ctx).Where("name = ?", record.Name).Limit(1).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing.ID == "" {
+		if err := s.db.WithContext(ctx).Create(record).Error; err != nil {
+			return nil, err
+		}
+		return scheduleFromRecord(record), nil
+	}
+
+	record.ID = existing.ID
+	record.CreatedAt = existing.CreatedAt
+	record.LastRunAt = existing.LastRunAt
+	record.LastJobID = existing.LastJobID
+	if existing.Cron == record.Cron && existing.Enabled && record.Enabled {
+		record.NextRunAt = existing.NextRunAt
+	}
+	if err := s.db.WithContext(ctx).Save(record).Error; err != nil {
+		return nil, err
+	}
+	return scheduleFromRecord(record), nil
+}
+
+func (s *Store) newScheduleRecord(spec ScheduleSpec) (*model.AgentSchedule, error) {
+	name := strings.TrimSpace(spec.Name)
+	if name == "" {
+		return nil, ErrEmptyScheduleName
+	}
+	if strings.TrimSpace(spec.Task) == "" {
+		return nil, ErrEmptyTask
+	}
+	if spec.BudgetUSD < 0 || spec.MaxSteps < 0 || spec.MaxAttempts < 0 {
+		return nil, fmt.Errorf("schedule budget_usd, max_steps and max_attempts cannot be negative")
+	}
+	cron := strings.TrimSpace(spec.Cron)
+	schedule, err := ParseSchedule(cron, s.loc)
+	if err != nil {
+		return nil, err
+	}
+	enabled := spec.Enabled == nil || *spec.Enabled
+	record := &model.AgentSchedule{
+		ID:          uuid.NewString(),
+		Name:        name,
+		Cron:        cron,
+		Username:    strings.TrimSpace(spec.Username),
+		Task:        strings.TrimSpace(spec.Task),
+		BudgetUSD:   spec.BudgetUSD,
+		MaxSteps:    spec.MaxSteps,
+		MaxAttempts: spec.MaxAttempts,
+		Enabled:     enabled,
+	}
+	record.NextRunAt = s.nextRunAt(schedule, enabled)
+	return record, nil
+}
+
+// nextRunAt 计算下次投递时间；停用或永远不会命中的定时任务存零值。
+func (s *Store) nextRunAt(schedule *Schedule, enabled bool) time.Time {
+	if !enabled {
+		return time.Time{}
+	}
+	next := schedule.Next(s.now())
+	if next.IsZero() {
+		return next
+	}
+	return next.UTC()
+}
+
+// ListSchedules 按名称返回所有定时任务。
+func (s *Store) ListSchedules(ctx context.Context) ([]ScheduleView, error) {
+	var records []model.AgentSchedule
+	if err := s.db.WithContext(ctx).Order("name ASC").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	schedules := make([]ScheduleView, 0, len(records))
+	for i := range records {
+		schedules = append(schedules, *scheduleFromRecord(&records[i]))
+	}
+	return schedules, nil
+}
+
+// GetSchedule 返回单个定时任务。
+func (s *Store) GetSchedule(ctx context.Context, id string) (*ScheduleView, error) {
+	record, err := s.loadSchedule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return scheduleFromRecord(record), nil
+}
+
+// UpdateSchedule 按 patch 修改定时任务。
+func (s *Store) UpdateSchedule(ctx context.Context, id string, patch SchedulePatch) (*ScheduleView, error) {
+	record, err := s.loadSchedule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	reschedule := false
+	if patch.Cron != nil {
+		reschedule = strings.TrimSpace(*patch.Cron) != record.Cron
+		record.Cron = strings.TrimSpace(*patch.Cron)
+	}
+	if patch.Username != nil {
+		record.Username = strings.TrimSpace(*patch.Username)
+	}
+	if patch.Task != nil {
+		if strings.TrimSpace(*patch.Task) == "" {
+			return nil, ErrEmptyTask
+		}
+		record.Task = strings.TrimSpace(*patch.Task)
+	}
+	if patch.BudgetUSD != nil {
+		record.BudgetUSD = *patch.BudgetUSD
+	}
+	if patch.MaxSteps != nil {
+		record.MaxSteps = *patch.MaxSteps
+	}
+	if patch.MaxAttempts != nil {
+		record.MaxAttempts = *patch.MaxAttempts
+	}
+	if record.BudgetUSD < 0 || record.MaxSteps < 0 || record.MaxAttempts < 0 {
+		return nil, fmt.Errorf("schedule budget_usd, max_steps and max_attempts cannot be negative")
+	}
+	if patch.Enabled != nil {
+		reschedule = reschedule || *patch.Enabled != record.Enabled
+		record.Enabled = *patch.Enabled
+	}
+
+	schedule, err := ParseSchedule(record.Cron, s.loc)
+	if err != nil {
+		return nil, err
+	}
+	if reschedule {
+		record.NextRunAt = s.nextRunAt(schedule, record.Enabled)
+	}
+	if err := s.db.WithContext(ctx).Save(record).Error; err != nil {
+		return nil, err
+	}
+	return scheduleFromRecord(record), nil
+}
+
+// DeleteSchedule 删除定时任务；已经投递的任务不受影响。
+func (s *Store) DeleteSchedule(ctx context.Context, id string) error {
+	result := s.db.WithContext(ctx).Where("id = ?", id).Delete(&model.AgentSchedule{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrScheduleNotFound, id)
+	}
+	return nil
+}
+
+// TriggerSchedule 立即按定时任务的配置投递一次任务，不影响下次定时投递。
+func (s *Store) TriggerSchedule(ctx context.Context, id string) (*Job, error) {
+	record, err := s.loadSchedule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	job, err := s.newJobRecord(jobSpecFromSchedule(record), record.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.AgentSchedule{}).Where("id = ?", record.ID).
+			Updates(map[string]any{"last_run_at": job.RunAt, "last_job_id": job.ID}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobFromRecord(job), nil
+}
+
+// enqueueDue 为所有到期的定时任务投递任务并推进下次投递时间。推进使用以原
+// next_run_at 为条件的更新，多个进程共享同一数据库时同一次触发只会投递一次；
+// 停机期间错过的多次触发只补投一次。
+func (s *Store) enqueueDue(ctx context.Context) (int, error) {
+	now := s.now().UTC()
+	var records []model.AgentSchedule
+	err := s.db.WithContext(ctx).
+		Where("enabled = ? AND next_run_at > ? AND next_run_at <= ?", true, time.Time{}, now).
+		Find(&records).Error
+	if err != nil {
+		return 0, err
+	}
+
+	enqueued := 0
+	for i := range records {
+		record := &records[i]
+		schedule, err := ParseSchedule(record.Cron, s.loc)
+		if err != nil {
+			return enqueued, fmt.Errorf("schedule %s: %w", record.Name, err)
+		}
+		job, err := s.newJobRecord(jobSpecFromSchedule(record), record.ID)
+		if err != nil {
+			return enqueued, fmt.Errorf("schedule %s: %w", record.Name, err)
+		}
+		job.RunAt = record.NextRunAt
+		next := s.nextRunAt(schedule, true)
+
+		claimed := false
+		err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&model.AgentSchedule{}).
+				Where("id = ? AND next_run_at = ?", record.ID, record.NextRunAt).
+				Updates(map[string]any{"next_run_at": next, "last_run_at": now, "last_job_id": job.ID})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return nil
+			}
+			claimed = true
+			return tx.Create(job).Error
+		})
+		if err != nil {
+			return enqueued, err
+		}
+		if claimed {
+			enqueued++
+		}
+	}
+	return enqueued, nil
+}
+
+func (s *Store) loadSchedule(ctx context.Context, id string) (*model.AgentSchedule, error) {
+	var record model.AgentSchedule
+	if err := s.db.WithContext(ctx).Where("id = ?", id).Limit(1).Find(&record).Error; err != nil {
+		return nil, err
+	}
+	if record.ID == "" {
+		return nil, fmt.Errorf("%w: %s", ErrScheduleNotFound, id)
+	}
+	return &record, nil
+}
+
+func jobSpecFromSchedule(record *model.AgentSchedule) JobSpec {
+	return JobSpec{
+		Name:        record.Name,
+		Username:    record.Username,
+		Task:        record.Task,
+		BudgetUSD:   record.BudgetUSD,
+		MaxSteps:    record.MaxSteps,
+		MaxAttempts: record.MaxAttempts,
+	}
+}
+
+func jobFromRecord(record *model.AgentJob) *Job {
+	return &Job{
+		ID:          record.ID,
+		Name:        record.Name,
+		ScheduleID:  record.ScheduleID,
+		Username:    record.Username,
+		Task:        record.Task,
+		Status:      Status(record.Status),
+		Attempts:    record.Attempts,
+		MaxAttempts: record.MaxAttempts,
+		BudgetUSD:   record.BudgetUSD,
+		MaxSteps:    record.MaxSteps,
+		RunAt:       record.RunAt,
+		SessionID:   record.SessionID,
+		AgentRunID:  record.AgentRunID,
+		Result:      record.Result,
+		StopReason:  record.StopReason,
+		Error:       record.Error,
+		Steps:       record.Steps,
+		CostUSD:     record.CostUSD,
+		StartedAt:   record.StartedAt,
+		FinishedAt:  record.FinishedAt,
+		CreatedAt:   record.CreatedAt,
+		UpdatedAt:   record.UpdatedAt,
+	}
+}
+
+func scheduleFromRecord(record *model.AgentSchedule) *ScheduleView {
+	view := &ScheduleView{
+		ID:          record.ID,
+		Name:        record.Name,
+		Cron:        record.Cron,
+		Username:    record.Username,
+		Task:        record.Task,
+		BudgetUSD:   record.BudgetUSD,
+		MaxSteps:    record.MaxSteps,
+		MaxAttempts: record.MaxAttempts,
+		Enabled:     record.Enabled,
+		LastRunAt:   record.LastRunAt,
+		LastJobID:   record.LastJobID,
+		CreatedAt:   record.CreatedAt,
+		UpdatedAt:   record.UpdatedAt,
+	}
+	if !record.NextRunAt.IsZero() {
+		next := record.NextRunAt
+		view.NextRunAt = &next
+	}
+	return view
+}
+
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "..."
+}
@@ -159,6 +159,11 @@ func Init(l *Config) {
 }
 
 func Log() *zap.Logger {
+	if logger == nil {
+		// 未调用 Init 时（例如单元测试直接使用 agent 包）退化为空 logger，
+		// 避免库代码里的日志调用直接 panic。
+		return zap.NewNop()
+	}
 	return logger
 }
 
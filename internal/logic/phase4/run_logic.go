@@ -0,0 +1,424 @@
+package phase4logic
+
+import (
+	"agent_study/internal/agent"
+	"agent_study/internal/log"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 错误定义
+var (
+	ErrEmptyTask     = errors.New("task cannot be empty")
+	ErrRunNotFound   = errors.New("agent run not found")
+	ErrSessionBusy   = errors.New("session already has a running agent run")
+	ErrSessionOwner  = errors.New("session belongs to another user")
+	ErrAgentRequired = errors.New("base agent is required")
+)
+
+const (
+	// defaultMaxRuns 是进程内保留的运行记录数，超出后丢弃最早结束的运行。
+	defaultMaxRuns    = 100
+	sessionTitleRunes = 40
+)
+
+// RunStatus 描述一次 HTTP 发起的运行所处的阶段。
+type RunStatus string
+
+const (
+	RunStatusRunning   RunStatus = "running"
+	RunStatusCompleted RunStatus = "completed"
+	RunStatusFailed    RunStatus = "failed"
+	RunStatusCancelled RunStatus = "cancelled"
+)
+
+// SSE 事件名：step 在每个 step 完成后推送，error 只在运行失败时出现，done 总是最后一个
+// 事件，携带运行的完整视图（含最终回答）。
+const (
+	EventStep  = "step"
+	EventError = "error"
+	EventDone  = "done"
+)
+
+// StartRunRequest 发起运行的参数；SessionID 为空时为用户新建一个会话。
+type StartRunRequest struct {
+	Username  string
+	SessionID string
+	Task      string
+	// Vars 是本次运行的自定义 system prompt 模板变量。
+	Vars map[string]string
+}
+
+// StepView 是 StepEvent 的 JSON 视图；Depth 大于 0 的是子 agent 产生的 step。
+type StepView struct {
+	Index       int               `json:"index"`
+	Agent       string            `json:"agent,omitempty"`
+	Depth       int               `json:"depth,omitempty"`
+	ParentIndex int               `json:"parent_index,omitempty"`
+	Thought     string            `json:"thought,omitempty"`
+	Action      agent.Action      `json:"action"`
+	Observation string            `json:"observation,omitempty"`
+	ToolFailed  bool              `json:"tool_failed,omitempty"`
+	Metrics     agent.StepMetrics `json:"metrics"`
+}
+
+// RunView 是一次运行对外暴露的快照，Stats 中包含累计用量与费用。
+type RunView struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	SessionID string    `json:"session_id"`
+	Task      string    `json:"task"`
+	Status    RunStatus `json:"status"`
+	// CheckpointRunID 是 agent 检查点里的运行 ID，只在配置了 SQLite 时存在。
+	CheckpointRunID string           `json:"checkpoint_run_id,omitempty"`
+	FinalAnswer     string           `json:"final_answer,omitempty"`
+	StopReason      agent.StopReason `json:"stop_reason,omitempty"`
+	Error           string           `json:"error,omitempty"`
+	Steps           []StepView       `json:"steps"`
+	Stats           agent.RunStats   `json:"stats"`
+	CreatedAt       time.Time        `json:"created_at"`
+	FinishedAt      *time.Time       `json:"finished_at,omitempty"`
+}
+
+// ErrorEvent 是 error 事件的数据。
+type ErrorEvent struct {
+	Error string `json:"error"`
+}
+
+// RunEvent 是推送给订阅方的一条事件，Name 对应 SSE 的 event 字段。
+type RunEvent struct {
+	Name string
+	Data any
+}
+
+// RunManager 管理 HTTP 发起的并发运行：每次运行使用 base.Clone() 得到的独立 Agent，
+// 事件回调与预算互不干扰；会话与长期记忆通过共享的 MemoryManager 按用户隔离。
+type RunManager struct {
+	ctx  context.Context
+	base *agent.Agent
+
+	mu       sync.Mutex
+	runs     map[string]*runRecord
+	order    []string
+	sessions map[string]string
+	maxRuns  int
+}
+
+// NewRunManager 创建运行管理器；ctx 取消时所有进行中的运行都会被取消。
+func NewRunManager(ctx context.Context, base *agent.Agent) (*RunManager, error) {
+	if base == nil {
+		return nil, ErrAgentRequired
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &RunManager{
+		ctx:      ctx,
+		base:     base,
+		runs:     make(map[string]*runRecord),
+		sessions: make(map[string]string),
+		maxRuns:  defaultMaxRuns,
+	}, nil
+}
+
+// Memory 返回所有运行共享的记忆管理器。
+func (m *RunManager) Memory() *agent.MemoryManager {
+	return m.base.Memory
+}
+
+// StartRun 在后台发起一次运行并立即返回其快照；同一会话同时只允许一个运行。
+func (m *RunManager) StartRun(ctx context.Context, req StartRunRequest) (*RunView, error) {
+	task := strings.TrimSpace(req.Task)
+	if task == "" {
+		return nil, ErrEmptyTask
+	}
+	memory := m.base.Memory
+	username := strings.TrimSpace(req.Username)
+	sessionID := req.SessionID
+	if sessionID == "" {
+		session, err := memory.CreateSession(ctx, username, sessionTitle(task))
+		if err != nil {
+			return nil, err
+		}
+		sessionID = session.ID
+		username = session.Username
+	} else {
+		owner, err := memory.SessionUsername(ctx, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		if username != "" && username != owner {
+			return nil, fmt.Errorf("%w: %s", ErrSessionOwner, sessionID)
+		}
+		username = owner
+	}
+
+	runCtx, cancel := context.WithCancel(agent.WithPromptVars(m.ctx, req.Vars))
+	record := newRunRecord(RunView{
+		ID:        uuid.NewString(),
+		Username:  username,
+		SessionID: sessionID,
+		Task:      task,
+		Status:    RunStatusRunning,
+		Steps:     []StepView{},
+		CreatedAt: time.Now(),
+	}, cancel)
+
+	m.mu.Lock()
+	if active, ok := m.sessions[sessionID]; ok {
+		m.mu.Unlock()
+		cancel()
+		return nil, fmt.Errorf("%w: %s", ErrSessionBusy, active)
+	}
+	m.sessions[sessionID] = record.id
+	m.runs[record.id] = record
+	m.order = append(m.order, record.id)
+	m.pruneLocked()
+	m.mu.Unlock()
+
+	run := m.base.Clone()
+	run.SetStepCallback(record.onStep)
+	go m.execute(runCtx, run, record)
+
+	view := record.snapshot()
+	return &view, nil
+}
+
+// ContinueSession 在已有会话里继续发起一次运行。
+func (m *RunManager) ContinueSession(ctx context.Context, sessionID string, task string) (*RunView, error) {
+	return m.StartRun(ctx, StartRunRequest{SessionID: sessionID, Task: task})
+}
+
+// CancelRun 取消进行中的运行；已结束的运行原样返回。
+func (m *RunManager) CancelRun(runID string) (*RunView, error) {
+	record, err := m.record(runID)
+	if err != nil {
+		return nil, err
+	}
+	record.cancel()
+	view := record.snapshot()
+	return &view, nil
+}
+
+// GetRun 返回一次运行的快照。
+func (m *RunManager) GetRun(runID string) (*RunView, error) {
+	record, err := m.record(runID)
+	if err != nil {
+		return nil, err
+	}
+	view := record.snapshot()
+	return &view, nil
+}
+
+// ListRuns 按创建时间倒序返回运行快照；username 为空时返回全部用户，limit <= 0 时不限制。
+func (m *RunManager) ListRuns(username string, limit int) []RunView {
+	m.mu.Lock()
+	records := make([]*runRecord, 0, len(m.order))
+	for i := len(m.order) - 1; i >= 0; i-- {
+		records = append(records, m.runs[m.order[i]])
+	}
+	m.mu.Unlock()
+
+	views := make([]RunView, 0, len(records))
+	for _, record := range records {
+		view := record.snapshot()
+		if username != "" && view.Username != username {
+			continue
+		}
+		views = append(views, view)
+		if limit > 0 && len(views) >= limit {
+			break
+		}
+	}
+	return views
+}
+
+// Subscribe 先回放运行已产生的事件，再持续推送新事件，直到运行结束、ctx 取消或
+// send 返回 false。
+func (m *RunManager) Subscribe(ctx context.Context, runID string, send func(RunEvent) bool) error {
+	record, err := m.record(runID)
+	if err != nil {
+		return err
+	}
+	next := 0
+	for {
+		record.mu.Lock()
+		pending := record.events[next:]
+		next = len(record.events)
+		finished := record.finished
+		changed := record.changed
+		record.mu.Unlock()
+
+		for _, event := range pending {
+			if !send(event) {
+				return nil
+			}
+		}
+		if finished {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (m *RunManager) record(runID string) (*runRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.runs[runID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+	}
+	return record, nil
+}
+
+func (m *RunManager) execute(ctx context.Context, run *agent.Agent, record *runRecord) {
+	defer record.cancel()
+	state, err := run.Run(ctx, record.sessionID, record.task)
+	if err != nil {
+		log.Warnf("agent run %s failed: %v", record.id, err)
+	}
+	record.finish(state, err)
+
+	m.mu.Lock()
+	if m.sessions[record.sessionID] == record.id {
+		delete(m.sessions, record.sessionID)
+	}
+	m.mu.Unlock()
+}
+
+// pruneLocked 丢弃超出 maxRuns 的最早已结束运行，进行中的运行始终保留。
+func (m *RunManager) pruneLocked() {
+	for i := 0; len(m.order) > m.maxRuns && i < len(m.order); {
+		record := m.runs[m.order[i]]
+		if !record.done() {
+			i++
+			continue
+		}
+		delete(m.runs, m.order[i])
+		m.order = append(m.order[:i], m.order[i+1:]...)
+	}
+}
+
+// runRecord 保存一次运行的快照与事件日志；事件只追加，订阅方据此回放并等待 changed。
+type runRecord struct {
+	id        string
+	sessionID string
+	task      string
+	cancel    context.CancelFunc
+
+	mu       sync.Mutex
+	view     RunView
+	events   []RunEvent
+	changed  chan struct{}
+	finished bool
+}
+
+func newRunRecord(view RunView, cancel context.CancelFunc) *runRecord {
+	return &runRecord{
+		id:        view.ID,
+		sessionID: view.SessionID,
+		task:      view.Task,
+		cancel:    cancel,
+		view:      view,
+		changed:   make(chan struct{}),
+	}
+}
+
+func (r *runRecord) onStep(event agent.StepEvent) {
+	step := StepView{
+		Index:       event.Index,
+		Agent:       event.Agent,
+		Depth:       event.Depth,
+		ParentIndex: event.ParentIndex,
+		Thought:     event.Step.Thought,
+		Action:      event.Step.Action,
+		Observation: event.Step.Observation,
+		ToolFailed:  event.Step.ToolFailed,
+		Metrics:     event.Step.Metrics,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.view.Steps = append(r.view.Steps, step)
+	// 子 agent 事件里的 Stats 属于子运行，顶层运行的累计值只看 Depth 为 0 的事件。
+	if event.Depth == 0 {
+		r.view.Stats = event.Stats
+		if event.RunID != "" {
+			r.view.CheckpointRunID = event.RunID
+		}
+	}
+	r.publishLocked(RunEvent{Name: EventStep, Data: step})
+}
+
+// finish 记录运行结果并推送收尾事件。规划调用需要完整的工具调用，最终回答还要经过
+// critic、schema 与 guardrail 检查才能确定，因此不做流式推送，只随 done 事件下发。
+func (r *runRecord) finish(state *agent.State, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.view.FinishedAt = &now
+	if state != nil {
+		r.view.FinalAnswer = state.FinalAnswer
+		r.view.StopReason = state.StopReason
+		r.view.Stats = state.Stats
+		if state.RunID != "" {
+			r.view.CheckpointRunID = state.RunID
+		}
+	}
+	switch {
+	case err == nil:
+		r.view.Status = RunStatusCompleted
+	case errors.Is(err, context.Canceled):
+		r.view.Status = RunStatusCancelled
+		r.view.Error = err.Error()
+	default:
+		r.view.Status = RunStatusFailed
+		r.view.Error = err.Error()
+		r.publishLocked(RunEvent{Name: EventError, Data: ErrorEvent{Error: err.Error()}})
+	}
+	r.publishLocked(RunEvent{Name: EventDone, Data: r.snapshotLocked()})
+	r.finished = true
+}
+
+func (r *runRecord) publishLocked(event RunEvent) {
+	r.events = append(r.events, event)
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+func (r *runRecord) done() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finished
+}
+
+func (r *runRecord) snapshot() RunView {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshotLocked()
+}
+
+func (r *runRecord) snapshotLocked() RunView {
+	view := r.view
+	view.Steps = append(make([]StepView, 0, len(r.view.Steps)), r.view.Steps...)
+	return view
+}
+
+func sessionTitle(task string) string {
+	runes := []rune(task)
+	if len(runes) <= sessionTitleRunes {
+		return task
+	}
+	return string(runes[:sessionTitleRunes]) + "..."
+}
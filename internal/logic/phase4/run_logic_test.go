@@ -0,0 +1,146 @@
+package phase4logic
+
+import (
+	"agent_study/internal/agent"
+	llmModel "agent_study/pkg/llm_core/model"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedLLM 按任务内容回答；任务里带 "wait" 时阻塞到 ctx 取消，用来验证取消。
+type scriptedLLM struct {
+	mu      sync.Mutex
+	started chan struct{}
+}
+
+func (s *scriptedLLM) Chat(ctx context.Context, req llmModel.ChatRequest) (llmModel.ChatResponse, error) {
+	last := req.Messages[len(req.Messages)-1].Content
+	if strings.Contains(last, "wait") {
+		s.mu.Lock()
+		if s.started != nil {
+			close(s.started)
+			s.started = nil
+		}
+		s.mu.Unlock()
+		<-ctx.Done()
+		return llmModel.ChatResponse{}, ctx.Err()
+	}
+	return llmModel.ChatResponse{Content: "answer to " + last + strings.Repeat(" and more", 10)}, nil
+}
+
+func (s *scriptedLLM) ChatStream(context.Context, llmModel.ChatRequest) (llmModel.Stream, error) {
+	return nil, errors.New("not implemented")
+}
+
+func newTestRunManager(t *testing.T, llm llmModel.LlmClient) *RunManager {
+	t.Helper()
+	base, err := agent.NewAgent(agent.NewAgentOptions{LLM: llm})
+	if err != nil {
+		t.Fatalf("NewAgent() error = %v", err)
+	}
+	runs, err := NewRunManager(context.Background(), base)
+	if err != nil {
+		t.Fatalf("NewRunManager() error = %v", err)
+	}
+	return runs
+}
+
+func collectEvents(t *testing.T, runs *RunManager, runID string) []RunEvent {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var events []RunEvent
+	if err := runs.Subscribe(ctx, runID, func(event RunEvent) bool {
+		events = append(events, event)
+		return true
+	}); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	return events
+}
+
+func TestRunManagerStreamsStepsAndContinuesSession(t *testing.T) {
+	runs := newTestRunManager(t, &scriptedLLM{})
+
+	started, err := runs.StartRun(context.Background(), StartRunRequest{Username: "alice", Task: "hello"})
+	if err != nil {
+		t.Fatalf("StartRun() error = %v", err)
+	}
+	if started.Username != "alice" || started.SessionID == "" {
+		t.Fatalf("StartRun() = %#v, want a new session for alice", started)
+	}
+
+	events := collectEvents(t, runs, started.ID)
+	if len(events) != 2 || events[0].Name != EventStep || events[1].Name != EventDone {
+		t.Fatalf("events = %#v, want step then done", events)
+	}
+	done := events[1].Data.(RunView)
+	if done.Status != RunStatusCompleted || done.FinalAnswer == "" || len(done.Steps) != 1 {
+		t.Fatalf("done = %#v, want completed run carrying the final answer", done)
+	}
+
+	// 订阅已结束的运行会完整回放事件。
+	if replay := collectEvents(t, runs, started.ID); len(replay) != len(events) {
+		t.Fatalf("replayed %d events, want %d", len(replay), len(events))
+	}
+
+	next, err := runs.ContinueSession(context.Background(), started.SessionID, "again")
+	if err != nil {
+		t.Fatalf("ContinueSession() error = %v", err)
+	}
+	collectEvents(t, runs, next.ID)
+	if next.Username != "alice" || next.SessionID != started.SessionID {
+		t.Fatalf("ContinueSession() = %#v, want same session and user", next)
+	}
+	if _, err := runs.StartRun(context.Background(), StartRunRequest{Username: "bob", SessionID: started.SessionID, Task: "x"}); !errors.Is(err, ErrSessionOwner) {
+		t.Fatalf("StartRun() error = %v, want ErrSessionOwner", err)
+	}
+
+	listed := runs.ListRuns("alice", 0)
+	if len(listed) != 2 || listed[0].ID != next.ID {
+		t.Fatalf("ListRuns() = %#v, want newest run first", listed)
+	}
+	if len(runs.ListRuns("bob", 0)) != 0 {
+		t.Fatal("ListRuns(bob) should not return alice's runs")
+	}
+}
+
+func TestRunManagerRejectsBusySessionAndCancelsRun(t *testing.T) {
+	llm := &scriptedLLM{started: make(chan struct{})}
+	runs := newTestRunManager(t, llm)
+	started := llm.started
+
+	run, err := runs.StartRun(context.Background(), StartRunRequest{Username: "alice", Task: "wait for me"})
+	if err != nil {
+		t.Fatalf("StartRun() error = %v", err)
+	}
+	<-started
+	if _, err := runs.ContinueSession(context.Background(), run.SessionID, "hello"); !errors.Is(err, ErrSessionBusy) {
+		t.Fatalf("ContinueSession() error = %v, want ErrSessionBusy", err)
+	}
+	// 其它会话可以并发运行。
+	other, err := runs.StartRun(context.Background(), StartRunRequest{Username: "bob", Task: "hello"})
+	if err != nil {
+		t.Fatalf("StartRun(bob) error = %v", err)
+	}
+	collectEvents(t, runs, other.ID)
+
+	if _, err := runs.CancelRun(run.ID); err != nil {
+		t.Fatalf("CancelRun() error = %v", err)
+	}
+	events := collectEvents(t, runs, run.ID)
+	done := events[len(events)-1].Data.(RunView)
+	if done.Status != RunStatusCancelled {
+		t.Fatalf("status = %s, want cancelled", done.Status)
+	}
+	if _, err := runs.ContinueSession(context.Background(), run.SessionID, "hello"); err != nil {
+		t.Fatalf("ContinueSession() after cancel error = %v", err)
+	}
+	if _, err := runs.GetRun("missing"); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("GetRun() error = %v, want ErrRunNotFound", err)
+	}
+}
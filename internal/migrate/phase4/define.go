@@ -16,3 +16,36 @@ var to001 = migrate.NewMigration("0.0.5", func(tx *gorm.DB) error {
 var to002 = migrate.NewMigration("0.0.6", func(tx *gorm.DB) error {
 	return tx.AutoMigrate(&model.LongTermMemory{})
 })
+
+// to003 引入 agent 运行检查点表，用于持久化每一步之后的 State、短期记忆与费用。
+var to003 = migrate.NewMigration("0.0.7", func(tx *gorm.DB) error {
+	return tx.AutoMigrate(&model.AgentRun{})
+})
+
+// to004 引入按会话隔离的短期记忆表，使多会话与进程重启后的上下文恢复成为可能。
+var to004 = migrate.NewMigration("0.0.8", func(tx *gorm.DB) error {
+	return tx.AutoMigrate(&model.AgentSession{}, &model.AgentSessionMessage{})
+})
+
+// to005 引入事实级长期记忆表及其 FTS5 检索索引。
+var to005 = migrate.NewMigration("0.0.9", func(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&model.MemoryItem{}); err != nil {
+		return err
+	}
+	return tx.Exec(MemoryItemFTSTableSQL).Error
+})
+
+// to006 引入长期记忆变更审计表，记录每条记忆由哪次运行或哪个入口创建与修改。
+var to006 = migrate.NewMigration("0.0.10", func(tx *gorm.DB) error {
+	return tx.AutoMigrate(&model.MemoryAudit{})
+})
+
+// to007 引入后台任务队列与定时任务表，排队、执行中的任务与执行结果都持久化，进程重启后可继续。
+var to007 = migrate.NewMigration("0.0.11", func(tx *gorm.DB) error {
+	return tx.AutoMigrate(&model.AgentJob{}, &model.AgentSchedule{})
+})
+
+// to008 引入逐步运行快照表，并为运行记录补充分叉来源，支持从任意一步分叉并追溯血缘。
+var to008 = migrate.NewMigration("0.0.12", func(tx *gorm.DB) error {
+	return tx.AutoMigrate(&model.AgentRun{}, &model.AgentRunStep{})
+})
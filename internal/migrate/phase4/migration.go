@@ -9,11 +9,28 @@ import (
 	"gorm.io/gorm"
 )
 
-const CurrentVersion = "0.0.6"
+const CurrentVersion = "0.0.12"
+
+// MemoryItemFTSTable 是事实级记忆的 FTS5 索引表；terms 列存放预先切好的检索词
+// （拉丁文按词、中日韩文字按二元组），item_id 指回 memory_items 主键。
+const (
+	MemoryItemFTSTable    = "memory_items_fts"
+	MemoryItemFTSTableSQL = `CREATE VIRTUAL TABLE IF NOT EXISTS memory_items_fts USING fts5(
+	terms,
+	item_id UNINDEXED,
+	username UNINDEXED
+);`
+)
 
 var versionMigrations = []migrate.Migration{
 	to001,
 	to002,
+	to003,
+	to004,
+	to005,
+	to006,
+	to007,
+	to008,
 }
 
 func Bootstrap(version string) {
@@ -21,8 +38,8 @@ func Bootstrap(version string) {
 	migrate.AutoMigrate(version, versionMigrations)
 }
 
-// BootstrapWithDB 是给 memory 这类组件使用的轻量初始化路径，它们只要求长期记忆表
-// 在首次使用前存在即可。
+// BootstrapWithDB 是给 memory、checkpoint 这类组件使用的轻量初始化路径，它们只要求
+// phase 4 自己的表在首次使用前存在即可。
 func BootstrapWithDB(database *gorm.DB, _ string) error {
 	if database == nil {
 		return errors.New("database is nil")
@@ -30,5 +47,23 @@ func BootstrapWithDB(database *gorm.DB, _ string) error {
 
 	// 这里刻意不走完整的版本迁移链，只让依赖方在已经拿到 gorm.DB 的前提下，
 	// 以最小代价完成自己真正需要的表初始化。
-	return database.AutoMigrate(&model.LongTermMemory{})
+	if err := database.AutoMigrate(phase4Models()...); err != nil {
+		return err
+	}
+	return database.Exec(MemoryItemFTSTableSQL).Error
+}
+
+// phase4Models 汇总 phase 4 依赖的全部表结构，供轻量初始化路径一次性建表。
+func phase4Models() []interface{} {
+	return []interface{}{
+		&model.LongTermMemory{},
+		&model.AgentRun{},
+		&model.AgentRunStep{},
+		&model.AgentSession{},
+		&model.AgentSessionMessage{},
+		&model.MemoryItem{},
+		&model.MemoryAudit{},
+		&model.AgentJob{},
+		&model.AgentSchedule{},
+	}
 }
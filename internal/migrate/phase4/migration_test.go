@@ -3,14 +3,17 @@ package phase4migrate
 import "testing"
 
 func TestPhase4MigrationVersionsAdvanceGlobalDataVersion(t *testing.T) {
-	if len(versionMigrations) != 2 {
-		t.Fatalf("versionMigrations len = %d, want 2", len(versionMigrations))
+	want := []string{"0.0.5", "0.0.6", "0.0.7", "0.0.8", "0.0.9", "0.0.10", "0.0.11", "0.0.12"}
+	if len(versionMigrations) != len(want) {
+		t.Fatalf("versionMigrations len = %d, want %d", len(versionMigrations), len(want))
 	}
 
-	if versionMigrations[0].Version != "0.0.5" {
-		t.Fatalf("first migration version = %q, want %q", versionMigrations[0].Version, "0.0.5")
+	for i, version := range want {
+		if versionMigrations[i].Version != version {
+			t.Fatalf("migration[%d] version = %q, want %q", i, versionMigrations[i].Version, version)
+		}
 	}
-	if versionMigrations[1].Version != "0.0.6" {
-		t.Fatalf("second migration version = %q, want %q", versionMigrations[1].Version, "0.0.6")
+	if CurrentVersion != want[len(want)-1] {
+		t.Fatalf("CurrentVersion = %q, want %q", CurrentVersion, want[len(want)-1])
 	}
 }
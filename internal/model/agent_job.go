@@ -0,0 +1,60 @@
+package model
+
+import "time"
+
+// AgentJob 是后台任务队列中的一次 agent 任务，保存重试状态、租约与执行结果，
+// 使排队与执行中的任务在进程重启后仍能继续。
+type AgentJob struct {
+	ID          string     `json:"id" gorm:"type:varchar(64);not null;primaryKey;comment:任务ID"`
+	Name        string     `json:"name" gorm:"type:varchar(128);not null;default:'';comment:任务名称"`
+	ScheduleID  string     `json:"schedule_id" gorm:"type:varchar(64);not null;default:'';index;comment:来源定时任务ID"`
+	Username    string     `json:"username" gorm:"type:varchar(128);not null;index;comment:用户名"`
+	Task        string     `json:"task" gorm:"type:text;not null;comment:用户任务"`
+	Status      string     `json:"status" gorm:"type:varchar(32);not null;index:idx_agent_jobs_status_run_at,priority:1;comment:任务状态"`
+	Attempts    int        `json:"attempts" gorm:"type:integer;not null;default:0;comment:已执行次数"`
+	MaxAttempts int        `json:"max_attempts" gorm:"type:integer;not null;default:1;comment:最大执行次数"`
+	BudgetUSD   float64    `json:"budget_usd" gorm:"type:real;not null;default:0;comment:单次任务预算(美元)"`
+	MaxSteps    int        `json:"max_steps" gorm:"type:integer;not null;default:0;comment:最大步数"`
+	RunAt       time.Time  `json:"run_at" gorm:"type:datetime;not null;index:idx_agent_jobs_status_run_at,priority:2;comment:最早执行时间"`
+	LockedBy    string     `json:"locked_by" gorm:"type:varchar(64);not null;default:'';comment:执行中的worker"`
+	LockedUntil *time.Time `json:"locked_until" gorm:"type:datetime;comment:租约到期时间"`
+	SessionID   string     `json:"session_id" gorm:"type:varchar(64);not null;default:'';comment:运行所在会话ID"`
+	AgentRunID  string     `json:"agent_run_id" gorm:"type:varchar(64);not null;default:'';comment:agent检查点运行ID"`
+	Result      string     `json:"result" gorm:"type:text;not null;default:'';comment:最终回答"`
+	StopReason  string     `json:"stop_reason" gorm:"type:varchar(32);not null;default:'';comment:提前结束原因"`
+	Error       string     `json:"error" gorm:"type:text;not null;default:'';comment:最近一次失败原因"`
+	Steps       int        `json:"steps" gorm:"type:integer;not null;default:0;comment:步数"`
+	CostUSD     float64    `json:"cost_usd" gorm:"type:real;not null;default:0;comment:费用(美元)"`
+	StartedAt   *time.Time `json:"started_at" gorm:"type:datetime;comment:首次开始时间"`
+	FinishedAt  *time.Time `json:"finished_at" gorm:"type:datetime;comment:结束时间"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"type:datetime;not null;comment:创建时间"`
+	UpdatedAt   time.Time  `json:"updated_at" gorm:"type:datetime;not null;comment:更新时间"`
+}
+
+// TableName 显式固定表名，避免依赖 GORM 默认复数化规则带来的不确定性。
+func (AgentJob) TableName() string {
+	return "agent_jobs"
+}
+
+// AgentSchedule 是按 cron 表达式周期性投递 AgentJob 的定时任务。
+type AgentSchedule struct {
+	ID          string     `json:"id" gorm:"type:varchar(64);not null;primaryKey;comment:定时任务ID"`
+	Name        string     `json:"name" gorm:"type:varchar(128);not null;uniqueIndex;comment:定时任务名称"`
+	Cron        string     `json:"cron" gorm:"type:varchar(128);not null;comment:cron表达式"`
+	Username    string     `json:"username" gorm:"type:varchar(128);not null;default:'';comment:用户名"`
+	Task        string     `json:"task" gorm:"type:text;not null;comment:用户任务"`
+	BudgetUSD   float64    `json:"budget_usd" gorm:"type:real;not null;default:0;comment:单次任务预算(美元)"`
+	MaxSteps    int        `json:"max_steps" gorm:"type:integer;not null;default:0;comment:最大步数"`
+	MaxAttempts int        `json:"max_attempts" gorm:"type:integer;not null;default:0;comment:最大执行次数"`
+	Enabled     bool       `json:"enabled" gorm:"type:boolean;not null;default:true;comment:是否启用"`
+	NextRunAt   time.Time  `json:"next_run_at" gorm:"type:datetime;not null;index;comment:下次投递时间"`
+	LastRunAt   *time.Time `json:"last_run_at" gorm:"type:datetime;comment:上次投递时间"`
+	LastJobID   string     `json:"last_job_id" gorm:"type:varchar(64);not null;default:'';comment:上次投递的任务ID"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"type:datetime;not null;comment:创建时间"`
+	UpdatedAt   time.Time  `json:"updated_at" gorm:"type:datetime;not null;comment:更新时间"`
+}
+
+// TableName 显式固定表名，避免依赖 GORM 默认复数化规则带来的不确定性。
+func (AgentSchedule) TableName() string {
+	return "agent_schedules"
+}
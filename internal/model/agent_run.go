@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+// AgentRun 保存一次智能体运行的检查点，使进程退出或触达步数上限后仍能从最后一个
+// 完成的 step 继续执行。
+type AgentRun struct {
+	ID          string    `json:"id" gorm:"type:varchar(64);not null;primaryKey;comment:运行ID"`
+	Username    string    `json:"username" gorm:"type:varchar(128);not null;index;comment:用户名"`
+	Task        string    `json:"task" gorm:"type:text;not null;default:'';comment:用户任务"`
+	Status      string    `json:"status" gorm:"type:varchar(32);not null;index;comment:运行状态"`
+	StepIndex   int       `json:"step_index" gorm:"type:integer;not null;default:0;comment:已完成步数"`
+	MaxSteps    int       `json:"max_steps" gorm:"type:integer;not null;default:0;comment:最大步数"`
+	FinalAnswer string    `json:"final_answer" gorm:"type:text;not null;default:'';comment:最终回答"`
+	Error       string    `json:"error" gorm:"type:text;not null;default:'';comment:失败原因"`
+	StateJSON   string    `json:"state_json" gorm:"type:text;not null;default:'';comment:State快照(JSON)"`
+	MemoryJSON  string    `json:"memory_json" gorm:"type:text;not null;default:'';comment:短期记忆快照(JSON)"`
+	CostJSON    string    `json:"cost_json" gorm:"type:text;not null;default:'';comment:累计费用快照(JSON)"`
+	ParentRunID string    `json:"parent_run_id" gorm:"type:varchar(64);not null;default:'';index;comment:分叉来源运行ID"`
+	ForkStep    int       `json:"fork_step" gorm:"type:integer;not null;default:0;comment:从来源运行的第几步之后分叉"`
+	CreatedAt   time.Time `json:"created_at" gorm:"type:datetime;not null;comment:创建时间"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"type:datetime;not null;comment:更新时间"`
+}
+
+// TableName 显式固定表名，避免依赖 GORM 默认复数化规则带来的不确定性。
+func (AgentRun) TableName() string {
+	return "agent_runs"
+}
+
+// AgentRunStep 是运行在每个 step 完成时的快照，AgentRun 只保留最新一份；分叉运行时据此
+// 回到任意一步。
+type AgentRunStep struct {
+	ID         uint      `json:"id" gorm:"type:integer;not null;primaryKey;autoIncrement;comment:主键ID"`
+	RunID      string    `json:"run_id" gorm:"type:varchar(64);not null;uniqueIndex:idx_agent_run_steps_run_step,priority:1;comment:运行ID"`
+	StepIndex  int       `json:"step_index" gorm:"type:integer;not null;uniqueIndex:idx_agent_run_steps_run_step,priority:2;comment:已完成步数"`
+	StateJSON  string    `json:"state_json" gorm:"type:text;not null;default:'';comment:State快照(JSON)"`
+	MemoryJSON string    `json:"memory_json" gorm:"type:text;not null;default:'';comment:短期记忆快照(JSON)"`
+	CostJSON   string    `json:"cost_json" gorm:"type:text;not null;default:'';comment:累计费用快照(JSON)"`
+	CreatedAt  time.Time `json:"created_at" gorm:"type:datetime;not null;comment:创建时间"`
+}
+
+// TableName 显式固定表名，避免依赖 GORM 默认复数化规则带来的不确定性。
+func (AgentRunStep) TableName() string {
+	return "agent_run_steps"
+}
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// AgentSession 表示一个用户与智能体之间的独立会话，短期记忆按会话隔离保存。
+type AgentSession struct {
+	ID        string    `json:"id" gorm:"type:varchar(64);not null;primaryKey;comment:会话ID"`
+	Username  string    `json:"username" gorm:"type:varchar(128);not null;index;comment:用户名"`
+	Title     string    `json:"title" gorm:"type:varchar(255);not null;default:'';comment:会话标题"`
+	CreatedAt time.Time `json:"created_at" gorm:"type:datetime;not null;comment:创建时间"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"type:datetime;not null;comment:更新时间"`
+}
+
+// TableName 显式固定表名，避免依赖 GORM 默认复数化规则带来的不确定性。
+func (AgentSession) TableName() string {
+	return "agent_sessions"
+}
+
+// AgentSessionMessage 按顺序保存会话中的单条消息；Payload 是完整序列化后的消息，
+// 包含 reasoning items、工具调用和附件，保证重启后可以原样回放。
+type AgentSessionMessage struct {
+	ID        uint      `json:"id" gorm:"type:integer;not null;primaryKey;autoIncrement;comment:主键ID"`
+	SessionID string    `json:"session_id" gorm:"type:varchar(64);not null;uniqueIndex:idx_session_seq;comment:会话ID"`
+	Seq       int       `json:"seq" gorm:"type:integer;not null;uniqueIndex:idx_session_seq;comment:会话内顺序"`
+	Role      string    `json:"role" gorm:"type:varchar(32);not null;comment:消息角色"`
+	Payload   string    `json:"payload" gorm:"type:text;not null;comment:消息内容(JSON)"`
+	CreatedAt time.Time `json:"created_at" gorm:"type:datetime;not null;comment:创建时间"`
+}
+
+// TableName 显式固定表名，避免依赖 GORM 默认复数化规则带来的不确定性。
+func (AgentSessionMessage) TableName() string {
+	return "agent_session_messages"
+}
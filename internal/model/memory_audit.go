@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// MemoryAudit 记录长期记忆（记忆条目与摘要）的每一次变更，便于追溯某条记忆由哪次运行
+// 或哪个入口创建、修改和删除。
+type MemoryAudit struct {
+	ID        uint      `json:"id" gorm:"type:integer;not null;primaryKey;autoIncrement;comment:主键ID"`
+	Username  string    `json:"username" gorm:"type:varchar(128);not null;index;comment:用户名"`
+	ItemID    uint      `json:"item_id" gorm:"type:integer;not null;default:0;index;comment:记忆条目ID，0表示长期摘要"`
+	Action    string    `json:"action" gorm:"type:varchar(32);not null;comment:变更动作"`
+	Actor     string    `json:"actor" gorm:"type:varchar(32);not null;default:'';comment:变更来源"`
+	RunID     string    `json:"run_id" gorm:"type:varchar(64);not null;default:'';index;comment:触发变更的运行ID"`
+	SessionID string    `json:"session_id" gorm:"type:varchar(64);not null;default:'';comment:触发变更的会话ID"`
+	Before    string    `json:"before" gorm:"type:text;not null;default:'';comment:变更前内容"`
+	After     string    `json:"after" gorm:"type:text;not null;default:'';comment:变更后内容"`
+	CreatedAt time.Time `json:"created_at" gorm:"type:datetime;not null;comment:创建时间"`
+}
+
+// TableName 显式固定表名，避免依赖 GORM 默认复数化规则带来的不确定性。
+func (MemoryAudit) TableName() string {
+	return "memory_audits"
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// MemoryItem 是长期记忆中的单条事实，按用户维度保存，检索时只取与当前任务相关的若干条。
+type MemoryItem struct {
+	ID              uint      `json:"id" gorm:"type:integer;not null;primaryKey;autoIncrement;comment:主键ID"`
+	Username        string    `json:"username" gorm:"type:varchar(128);not null;index;comment:用户名"`
+	Text            string    `json:"text" gorm:"type:text;not null;comment:记忆内容"`
+	Type            string    `json:"type" gorm:"type:varchar(32);not null;default:'fact';comment:记忆类型"`
+	SourceRunID     string    `json:"source_run_id" gorm:"type:varchar(64);not null;default:'';index;comment:产生该记忆的运行ID"`
+	SourceSessionID string    `json:"source_session_id" gorm:"type:varchar(64);not null;default:'';comment:产生该记忆的会话ID"`
+	Importance      float64   `json:"importance" gorm:"type:real;not null;default:0.5;comment:重要度(0-1)"`
+	UseCount        int       `json:"use_count" gorm:"type:integer;not null;default:0;comment:被注入上下文的次数"`
+	EmbeddingJSON   string    `json:"-" gorm:"type:text;not null;default:'';comment:向量(JSON)"`
+	LastUsedAt      time.Time `json:"last_used_at" gorm:"type:datetime;not null;comment:最近使用时间"`
+	CreatedAt       time.Time `json:"created_at" gorm:"type:datetime;not null;comment:创建时间"`
+	UpdatedAt       time.Time `json:"updated_at" gorm:"type:datetime;not null;comment:更新时间"`
+}
+
+// TableName 显式固定表名，避免依赖 GORM 默认复数化规则带来的不确定性。
+func (MemoryItem) TableName() string {
+	return "memory_items"
+}
@@ -0,0 +1,24 @@
+package phase4router
+
+import (
+	phase4handler "agent_study/internal/handler/phase4"
+	"agent_study/internal/job"
+	phase4logic "agent_study/internal/logic/phase4"
+	"agent_study/internal/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InitRouter 注册 phase 4 的 API；与其它阶段不同，这里的处理器依赖运行时构造的 agent 组件，
+// 因此 registers 在调用时才组装。记忆管理 API 与所有运行共享同一个 MemoryManager；
+// jobs 为空（未启用后台任务）时不注册任务 API。
+func InitRouter(e *gin.Engine, baseUrl string, staticPath string, runs *phase4logic.RunManager, jobs *job.Queue) {
+	registers := []router.Register{
+		phase4handler.MemoryRegister(runs.Memory()),
+		phase4handler.RunRegister(runs),
+	}
+	if jobs != nil {
+		registers = append(registers, phase4handler.JobRegister(jobs))
+	}
+	router.InitRouter(e, registers, baseUrl, staticPath)
+}
@@ -56,6 +56,10 @@ func buildGenerateContentRequest(req model.ChatRequest) ([]*genai.Content, *gena
 	if toolConfig != nil {
 		cfg.ToolConfig = toolConfig
 	}
+	if req.ResponseFormat != nil {
+		cfg.ResponseMIMEType = "application/json"
+		cfg.ResponseJsonSchema = req.ResponseFormat.Schema
+	}
 
 	return contents, cfg, promptMessages, nil
 }
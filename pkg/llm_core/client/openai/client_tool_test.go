@@ -3,6 +3,7 @@ package openai
 import (
 	"agent_study/pkg/llm_core/model"
 	"agent_study/pkg/types"
+	"strings"
 	"testing"
 
 	goopenai "github.com/sashabaranov/go-openai"
@@ -163,3 +164,31 @@ func TestBuildChatCompletionStreamRequest_WithTools(t *testing.T) {
 		t.Fatalf("choice.Function.Name = %q, want %q", choice.Function.Name, "lookup_weather")
 	}
 }
+
+func TestBuildChatCompletionRequest_MapsResponseFormat(t *testing.T) {
+	req := model.ChatRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []model.Message{{Role: model.RoleUser, Content: "查下北京天气"}},
+		ResponseFormat: &model.ResponseFormat{
+			Name: "weather",
+			Schema: types.JSONSchema{
+				Type:       "object",
+				Properties: map[string]types.SchemaProperty{"condition": {Type: "string"}},
+				Required:   []string{"condition"},
+			},
+		},
+	}
+
+	oaiReq, err := buildChatCompletionRequest(req)
+	if err != nil {
+		t.Fatalf("buildChatCompletionRequest() error = %v", err)
+	}
+	format := oaiReq.ResponseFormat
+	if format == nil || format.Type != goopenai.ChatCompletionResponseFormatTypeJSONSchema || format.JSONSchema.Name != "weather" {
+		t.Fatalf("ResponseFormat = %#v, want json_schema named weather", format)
+	}
+	schema, err := format.JSONSchema.Schema.MarshalJSON()
+	if err != nil || !strings.Contains(string(schema), `"condition":{"type":"string"}`) {
+		t.Fatalf("schema = %s, %v, want derived properties", schema, err)
+	}
+}
@@ -3,6 +3,7 @@ package openai
 import (
 	"agent_study/pkg/llm_core/model"
 	"agent_study/pkg/types"
+	"encoding/json"
 	"errors"
 
 	"github.com/sashabaranov/go-openai"
@@ -53,6 +54,13 @@ func buildChatCompletionRequest(req model.ChatRequest) (openai.ChatCompletionReq
 	if toolChoice != nil {
 		oaiReq.ToolChoice = toolChoice
 	}
+	if req.ResponseFormat != nil {
+		responseFormat, err := modelResponseFormatToOpenAI(*req.ResponseFormat)
+		if err != nil {
+			return openai.ChatCompletionRequest{}, err
+		}
+		oaiReq.ResponseFormat = responseFormat
+	}
 
 	if req.Sampling.Temperature != nil {
 		oaiReq.Temperature = *req.Sampling.Temperature
@@ -135,6 +143,22 @@ func modelToolsToOpenAI(tools []types.Tool) []openai.Tool {
 	return result
 }
 
+// modelResponseFormatToOpenAI 把 structured output 的 schema 映射为 json_schema 类型的 response_format。
+func modelResponseFormatToOpenAI(format model.ResponseFormat) (*openai.ChatCompletionResponseFormat, error) {
+	schema, err := json.Marshal(format.Schema)
+	if err != nil {
+		return nil, err
+	}
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:   format.Name,
+			Schema: json.RawMessage(schema),
+			Strict: format.Strict,
+		},
+	}, nil
+}
+
 // modelToolChoiceToOpenAI 将内部 ToolChoice 映射为 OpenAI 可接受的 tool_choice。
 //
 // 返回值类型使用 any 是为了兼容 OpenAI 的三种形态：
@@ -38,6 +38,11 @@ func NewOpenAiOfficialClient(apiKey, baseURL string, requestTimeout time.Duratio
 	return &Client{api: &cli.Responses}
 }
 
+// SupportsStructuredOutput 表示 Responses API 可以在启用工具的同时按 ResponseFormat 约束最终回答。
+func (c *Client) SupportsStructuredOutput() bool {
+	return true
+}
+
 func (c *Client) Chat(ctx context.Context, req model.ChatRequest) (model.ChatResponse, error) {
 	start := time.Now()
 	params, err := buildResponseRequestParams(req)
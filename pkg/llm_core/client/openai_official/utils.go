@@ -46,6 +46,15 @@ func buildResponseRequestParams(req model.ChatRequest) (responses.ResponseNewPar
 	if toolChoice != nil {
 		params.ToolChoice = *toolChoice
 	}
+	if req.ResponseFormat != nil {
+		params.Text = responses.ResponseTextConfigParam{Format: responses.ResponseFormatTextConfigUnionParam{
+			OfJSONSchema: &responses.ResponseFormatTextJSONSchemaConfigParam{
+				Name:   req.ResponseFormat.Name,
+				Schema: responseToolSchemaParameters(req.ResponseFormat.Schema),
+				Strict: openai.Bool(req.ResponseFormat.Strict),
+			},
+		}}
+	}
 
 	return params, nil
 }
@@ -133,24 +142,46 @@ func responseToolSchemaParameters(schema types.JSONSchema) map[string]any {
 }
 
 func shouldUseStrictToolSchema(schema types.JSONSchema) bool {
-	if len(schema.Properties) != len(schema.Required) {
+	return allPropertiesRequired(schema.Properties, schema.Required)
+}
+
+// allPropertiesRequired 检查每一层 object 都把全部属性列为必填，且嵌套 object 显式关闭了
+// additionalProperties，这是 strict 模式对 schema 的要求。
+func allPropertiesRequired(properties map[string]types.SchemaProperty, requiredNames []string) bool {
+	if len(properties) != len(requiredNames) {
 		return false
 	}
 
-	required := make(map[string]struct{}, len(schema.Required))
-	for _, name := range schema.Required {
+	required := make(map[string]struct{}, len(requiredNames))
+	for _, name := range requiredNames {
 		required[name] = struct{}{}
 	}
 
-	for name := range schema.Properties {
+	for name, property := range properties {
 		if _, ok := required[name]; !ok {
 			return false
 		}
+		if !strictProperty(property) {
+			return false
+		}
 	}
 
 	return true
 }
 
+func strictProperty(property types.SchemaProperty) bool {
+	if property.Items != nil && !strictProperty(*property.Items) {
+		return false
+	}
+	if property.Type != "object" {
+		return true
+	}
+	if property.AdditionalProperties == nil || *property.AdditionalProperties {
+		return false
+	}
+	return allPropertiesRequired(property.Properties, property.Required)
+}
+
 func modelToolChoiceToResponse(choice types.ToolChoice) (*responses.ResponseNewParamsToolChoiceUnion, error) {
 	switch choice.Type {
 	case "":
@@ -475,3 +475,59 @@ func TestExtractChatResponse_PreservesReasoningItems(t *testing.T) {
 		t.Fatalf("reasoning item summary = %#v, want [plan first]", got.ReasoningItems[0].Summary)
 	}
 }
+
+func TestBuildResponseRequestParams_MapsResponseFormatAndNestedStrictness(t *testing.T) {
+	closed := false
+	nested := types.JSONSchema{
+		Type: "object",
+		Properties: map[string]types.SchemaProperty{
+			"city": {Type: "string"},
+			"days": {Type: "array", Items: &types.SchemaProperty{
+				Type:                 "object",
+				Properties:           map[string]types.SchemaProperty{"date": {Type: "string"}},
+				Required:             []string{"date"},
+				AdditionalProperties: &closed,
+			}},
+		},
+		Required: []string{"city", "days"},
+	}
+	req := model.ChatRequest{
+		Model:          "gpt-5.4",
+		Messages:       []model.Message{{Role: model.RoleUser, Content: "forecast"}},
+		ResponseFormat: &model.ResponseFormat{Name: "forecast", Schema: nested, Strict: true},
+	}
+
+	params, err := buildResponseRequestParams(req)
+	if err != nil {
+		t.Fatalf("buildResponseRequestParams() error = %v", err)
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("json.Marshal(params) error = %v", err)
+	}
+	var payload struct {
+		Text struct {
+			Format struct {
+				Type   string         `json:"type"`
+				Name   string         `json:"name"`
+				Strict bool           `json:"strict"`
+				Schema map[string]any `json:"schema"`
+			} `json:"format"`
+		} `json:"text"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatalf("json.Unmarshal(payload) error = %v", err)
+	}
+	format := payload.Text.Format
+	if format.Type != "json_schema" || format.Name != "forecast" || !format.Strict || format.Schema["additionalProperties"] != false {
+		t.Fatalf("text.format = %#v, want strict json_schema", format)
+	}
+
+	if !shouldUseStrictToolSchema(nested) {
+		t.Fatal("fully required nested schema should be strict")
+	}
+	nested.Properties["days"] = types.SchemaProperty{Type: "array", Items: &types.SchemaProperty{Type: "object", Properties: map[string]types.SchemaProperty{"date": {Type: "string"}}}}
+	if shouldUseStrictToolSchema(nested) {
+		t.Fatal("nested object without required fields and closed properties should not be strict")
+	}
+}
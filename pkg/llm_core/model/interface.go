@@ -8,3 +8,9 @@ type LlmClient interface {
 	Chat(ctx context.Context, req ChatRequest) (ChatResponse, error)
 	ChatStream(ctx context.Context, req ChatRequest) (Stream, error)
 }
+
+// StructuredOutputClient 由支持原生 structured output（按 ChatRequest.ResponseFormat
+// 约束最终回答）的客户端实现；未实现时上层应改用其他方式约束输出格式。
+type StructuredOutputClient interface {
+	SupportsStructuredOutput() bool
+}
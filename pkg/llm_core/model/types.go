@@ -57,9 +57,20 @@ type ChatRequest struct {
 	Tools      []types.Tool
 	ToolChoice types.ToolChoice
 
+	// ResponseFormat 非空时要求模型的文本回答符合给定 JSON Schema（structured output）。
+	ResponseFormat *ResponseFormat
+
 	TraceID string // 非模型参数，但很关键
 }
 
+// ResponseFormat 描述 structured output 的目标 schema；Name 只能包含字母、数字、下划线和连字符。
+type ResponseFormat struct {
+	Name   string
+	Schema types.JSONSchema
+	// Strict 要求 provider 严格按 schema 生成，只在 schema 满足 provider 的严格模式限制时开启。
+	Strict bool
+}
+
 type ChatResponse struct {
 	Content string
 	// Reasoning 是后端单独暴露出来的思考文本。
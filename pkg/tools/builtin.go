@@ -52,6 +52,25 @@ func NewBuiltinTools(options BuiltinOptions) ([]Tool, error) {
 
 }
 
+// BuiltinToolNames 是全部内置工具的名称，顺序与 NewBuiltinTools 返回的一致。
+var BuiltinToolNames = []string{"ls", "read_file", "write_file", "exec"}
+
+// NewBuiltinTool 按名称创建单个内置工具，供按需挑选工具的调用方使用。
+func NewBuiltinTool(name string, options BuiltinOptions) (Tool, error) {
+	switch name {
+	case "ls":
+		return NewLSTool(options)
+	case "read_file":
+		return NewReadFileTool(options)
+	case "write_file":
+		return NewWriteFileTool(options)
+	case "exec":
+		return NewExecTool(options)
+	default:
+		return Tool{}, fmt.Errorf("%w: builtin %s", ErrToolNotFound, name)
+	}
+}
+
 // NewLSTool 创建目录树浏览工具。
 func NewLSTool(options BuiltinOptions) (Tool, error) {
 	cfg, err := normalizeBuiltinOptions(options)
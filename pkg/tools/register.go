@@ -144,6 +144,23 @@ func (r *Registry) Execute(ctx context.Context, name string, arguments map[strin
 	return tool.Handler(ctx, arguments)
 }
 
+// Subset 返回只包含指定工具的新注册器，供子 agent 等场景限制可用工具；
+// 任一名称不存在时返回 ErrToolNotFound。
+func (r *Registry) Subset(names ...string) (*Registry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subset := NewRegistry()
+	for _, name := range names {
+		tool, ok := r.tools[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrToolNotFound, name)
+		}
+		subset.tools[name] = tool
+	}
+	return subset, nil
+}
+
 // Validate 校验工具定义是否合法。
 func (t Tool) Validate() error {
 	if t.Name == "" {
@@ -2,6 +2,7 @@ package tools
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -179,6 +180,28 @@ func TestRegistry_RegisterMCPClient(t *testing.T) {
 	}
 }
 
+func TestRegistry_SubsetKeepsOnlyNamedTools(t *testing.T) {
+	registry := NewRegistry()
+	handler := func(ctx context.Context, arguments map[string]interface{}) (string, error) { return "ok", nil }
+	if err := registry.Register(Tool{Name: "a", Handler: handler}, Tool{Name: "b", Handler: handler}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	subset, err := registry.Subset("b")
+	if err != nil {
+		t.Fatalf("Subset() error = %v", err)
+	}
+	if got := subset.List(); len(got) != 1 || got[0].Name != "b" {
+		t.Fatalf("subset List() = %#v, want only b", got)
+	}
+	if _, err := subset.Execute(context.Background(), "a", nil); !errors.Is(err, ErrToolNotFound) {
+		t.Fatalf("Execute(a) error = %v, want ErrToolNotFound", err)
+	}
+	if _, err := registry.Subset("missing"); !errors.Is(err, ErrToolNotFound) {
+		t.Fatalf("Subset(missing) error = %v, want ErrToolNotFound", err)
+	}
+}
+
 func TestReadFileTool_RejectsPathEscape(t *testing.T) {
 	root := t.TempDir()
 	outsidePath := filepath.Join(filepath.Dir(root), "outside.txt")
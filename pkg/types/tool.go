@@ -16,6 +16,11 @@ type SchemaProperty struct {
 	Type        string   `json:"type"`
 	Description string   `json:"description,omitempty"`
 	Enum        []string `json:"enum,omitempty"`
+	// Items 描述 array 类型的元素；Properties/Required/AdditionalProperties 描述嵌套的 object。
+	Items                *SchemaProperty           `json:"items,omitempty"`
+	Properties           map[string]SchemaProperty `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *bool                     `json:"additionalProperties,omitempty"`
 }
 
 type ToolCall struct {